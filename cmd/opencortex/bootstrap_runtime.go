@@ -51,7 +51,7 @@ func runBootstrap(ctx context.Context, cfgPath string, cfg config.Config, opts b
 			return state, err
 		}
 		store := repos.New(db)
-		memBroker := broker.NewMemory(cfg.Broker.ChannelBufferSize)
+		memBroker := newMemoryBroker(cfg)
 		app := service.New(cfg, store, memBroker)
 		if strings.TrimSpace(cfg.Auth.AdminKey) == "" {
 			name := strings.TrimSpace(opts.AdminName)
@@ -184,3 +184,14 @@ func saveConfig(path string, cfg config.Config) error {
 	}
 	return os.WriteFile(path, b, 0o600)
 }
+
+// newMemoryBroker builds the in-process broker with the configured buffer
+// size and overflow policy. The config is validated on load, so a policy it
+// cannot read falls back to the default.
+func newMemoryBroker(cfg config.Config) *broker.MemoryBroker {
+	mem := broker.NewMemory(cfg.Broker.ChannelBufferSize)
+	if overflow, err := broker.ParseOverflow(cfg.Broker.OverflowPolicy, cfg.Broker.OverflowTimeout); err == nil {
+		mem.WithOverflow(overflow)
+	}
+	return mem
+}
@@ -33,7 +33,6 @@ import (
 	"opencortex/internal/api/handlers"
 	ws "opencortex/internal/api/websocket"
 	"opencortex/internal/bootstrap"
-	"opencortex/internal/broker"
 	"opencortex/internal/config"
 	mcpbridge "opencortex/internal/mcp"
 	"opencortex/internal/model"
@@ -149,6 +148,76 @@ func (c *apiClient) do(method, path string, body any, out any) error {
 	return nil
 }
 
+// download copies the body of a GET response to w. Errors still come back
+// in the JSON envelope.
+func (c *apiClient) download(path string, w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.transferClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeEnvelopeError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// upload posts body as is and decodes the JSON envelope of the answer.
+func (c *apiClient) upload(path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.transferClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeEnvelopeError(resp)
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return err
+	}
+	if out != nil {
+		return json.Unmarshal(envelope.Data, out)
+	}
+	return nil
+}
+
+// transferClient is the API client without its request timeout, for bodies
+// that may take longer than that to move.
+func (c *apiClient) transferClient() *http.Client {
+	client := *c.client
+	client.Timeout = 0
+	return &client
+}
+
+func decodeEnvelopeError(resp *http.Response) error {
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || len(envelope.Error) == 0 {
+		return fmt.Errorf("request failed: status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("request failed: %s", envelope.Error)
+}
+
 func main() {
 	var (
 		cfgPath      string
@@ -203,6 +272,8 @@ func main() {
 	root.AddCommand(newKnowledgeCommand(&baseURL, &apiKey, &asJSON))
 	root.AddCommand(newSkillsCommand(&cfgPath, &baseURL, &apiKey, &asJSON))
 	root.AddCommand(newSyncCommand(&cfgPath, &baseURL, &apiKey, &asJSON))
+	root.AddCommand(newDLQCommand(&cfgPath, &baseURL, &apiKey, &asJSON))
+	root.AddCommand(newScheduleCommand(&cfgPath, &baseURL, &apiKey, &asJSON))
 	root.AddCommand(newAdminCommand(&cfgPath, &baseURL, &apiKey, &asJSON))
 
 	if err := root.Execute(); err != nil {
@@ -453,9 +524,11 @@ func newAgentsShortCommand(baseURL, apiKey *string, asJSON *bool) *cobra.Command
 // `opencortex send --topic <id> <message>`.
 func newSendCommand(cfgPath, baseURL, apiKey *string) *cobra.Command {
 	var (
-		toAgent string
-		toTopic string
-		replyTo bool
+		toAgent   string
+		toTopic   string
+		replyTo   bool
+		deliverAt string
+		deliverIn time.Duration
 	)
 	cmd := &cobra.Command{
 		Use:   "send <message>",
@@ -465,11 +538,24 @@ func newSendCommand(cfgPath, baseURL, apiKey *string) *cobra.Command {
 		Example: strings.TrimSpace(`
   opencortex send --to researcher "Please analyse src/auth.go"
   opencortex send --topic tasks.review "Review PR #142"
-  opencortex send --to codex@machine-2 "Deploy to staging" --reply-to-me`),
+  opencortex send --to codex@machine-2 "Deploy to staging" --reply-to-me
+  opencortex send --topic standup "Standup in 5 minutes" --in 25m
+  opencortex send --to reviewer "Morning triage" --at 2026-03-02T09:00:00Z`),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if toAgent == "" && toTopic == "" {
 				return errors.New("one of --to or --topic is required")
 			}
+			if deliverAt != "" && deliverIn > 0 {
+				return errors.New("--at and --in are mutually exclusive")
+			}
+			var at time.Time
+			if deliverAt != "" {
+				parsed, err := time.Parse(time.RFC3339, deliverAt)
+				if err != nil {
+					return fmt.Errorf("--at must be an RFC 3339 time: %w", err)
+				}
+				at = parsed
+			}
 			client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
 			if err != nil {
 				return err
@@ -492,11 +578,21 @@ func newSendCommand(cfgPath, baseURL, apiKey *string) *cobra.Command {
 			if replyTo {
 				body["metadata"] = map[string]any{"reply_to_me": true}
 			}
+			if !at.IsZero() {
+				body["deliver_at"] = at.UTC().Format(time.RFC3339)
+			}
+			if deliverIn > 0 {
+				body["deliver_in_seconds"] = int(deliverIn.Round(time.Second) / time.Second)
+			}
 			var out map[string]any
 			if err := client.do(http.MethodPost, "/api/v1/messages", body, &out); err != nil {
 				return err
 			}
 			if msg, ok := out["message"].(map[string]any); ok {
+				if due, ok := msg["deliver_at"]; ok {
+					fmt.Printf("scheduled message %s for %s\n", msg["id"], due)
+					return nil
+				}
 				fmt.Printf("sent message %s\n", msg["id"])
 			} else {
 				fmt.Println("sent")
@@ -507,6 +603,8 @@ func newSendCommand(cfgPath, baseURL, apiKey *string) *cobra.Command {
 	cmd.Flags().StringVar(&toAgent, "to", "", "Recipient agent name or partial name")
 	cmd.Flags().StringVar(&toTopic, "topic", "", "Topic ID to publish to")
 	cmd.Flags().BoolVar(&replyTo, "reply-to-me", false, "Request a reply back to this agent")
+	cmd.Flags().StringVar(&deliverAt, "at", "", "Deliver at this RFC 3339 time instead of now")
+	cmd.Flags().DurationVar(&deliverIn, "in", 0, "Deliver after this delay instead of now (e.g. 10m)")
 	return cmd
 }
 
@@ -832,12 +930,16 @@ MCP config (minimal):
 			if err := store.SeedRBAC(ctx); err != nil {
 				return err
 			}
-			memBroker := broker.NewMemory(cfg.Broker.ChannelBufferSize)
+			memBroker := newMemoryBroker(cfg)
 			app := service.New(cfg, store, memBroker)
 			if _, err := app.EnsureBroadcastSetup(ctx, ""); err != nil && !errors.Is(err, service.ErrNotFound) {
 				return err
 			}
 			syncEngine := syncer.NewEngine(db, store)
+			syncEngine.RemoteKey = app.RemoteAPIKey
+			syncEngine.Identity = app.NodeIdentity
+			syncEngine.Changes = app.WatchChanges
+			syncEngine.Publish = app.PublishSynced
 			handler := handlers.New(app, db, cfg, syncEngine)
 			hub := ws.NewHub(app, store)
 			apiRouter := api.NewRouter(handler, app, hub)
@@ -1037,32 +1139,54 @@ func newServerCommand(cfgPath *string) *cobra.Command {
 			if err := store.SeedRBAC(ctx); err != nil {
 				return err
 			}
-			memBroker := broker.NewMemory(cfg.Broker.ChannelBufferSize)
+			memBroker := newMemoryBroker(cfg)
 			app := service.New(cfg, store, memBroker)
 			if _, err := app.EnsureBroadcastSetup(ctx, ""); err != nil && !errors.Is(err, service.ErrNotFound) {
 				return err
 			}
 			syncEngine := syncer.NewEngine(db, store)
+			syncEngine.RemoteKey = app.RemoteAPIKey
+			syncEngine.Identity = app.NodeIdentity
+			syncEngine.Changes = app.WatchChanges
+			syncEngine.Publish = app.PublishSynced
 			handler := handlers.New(app, db, cfg, syncEngine)
 			hub := ws.NewHub(app, store)
 			hub.Start(ctx)
 
-			if cfg.Sync.Enabled && len(cfg.Sync.Remotes) > 0 {
+			msgScheduler := service.NewMessageScheduler(app)
+			if err := msgScheduler.Reload(ctx); err != nil {
+				return err
+			}
+			msgScheduler.Watch(ctx, app.SchedulesChanged)
+			msgScheduler.Start()
+			defer msgScheduler.Stop()
+
+			if cfg.Sync.Enabled {
 				for _, r := range cfg.Sync.Remotes {
-					_, _ = app.AddRemote(ctx, repos.CreateRemoteInput{
-						RemoteName: r.Name,
-						RemoteURL:  r.URL,
-						Direction:  model.SyncDirection(r.Sync.Direction),
-						Scope:      model.SyncScope(r.Sync.Scope),
-						ScopeIDs:   r.Sync.CollectionIDs,
-						Strategy:   r.Sync.ConflictStrategy,
-						Schedule:   valuePtr(r.Sync.Schedule),
-					}, r.Key)
+					f := r.Sync.Filter
+					if err := syncer.ValidateFilter(model.SyncFilter{ExcludeVisibilities: f.ExcludeVisibilities, RedactFields: f.RedactFields}); err != nil {
+						return fmt.Errorf("sync remote %s: %w", r.Name, err)
+					}
+				}
+				drift, err := app.ReconcileRemotes(ctx, cfg.Sync.Remotes)
+				if err != nil {
+					return fmt.Errorf("reconcile sync remotes: %w", err)
+				}
+				for _, d := range drift {
+					switch d.Action {
+					case "created":
+						log.Printf("sync remote %s: created from config", d.Remote)
+					case "updated":
+						log.Printf("sync remote %s: drifted from config in %s; updated", d.Remote, strings.Join(d.Fields, ", "))
+					case "unmanaged":
+						log.Printf("sync remote %s: not in config; keeping runtime remote", d.Remote)
+					}
 				}
 				scheduler := syncer.NewScheduler(syncEngine)
-				if err := scheduler.Register(cfg.Sync.Remotes); err != nil {
+				if err := scheduler.Reload(ctx); err != nil {
 					return err
 				}
+				scheduler.Watch(ctx, app.RemotesChanged)
 				scheduler.Start()
 				defer scheduler.Stop()
 			}
@@ -1170,12 +1294,16 @@ func newMCPServerCommand(cfgPath *string) *cobra.Command {
 			if err := store.SeedRBAC(ctx); err != nil {
 				return err
 			}
-			memBroker := broker.NewMemory(cfg.Broker.ChannelBufferSize)
+			memBroker := newMemoryBroker(cfg)
 			app := service.New(cfg, store, memBroker)
 			if _, err := app.EnsureBroadcastSetup(ctx, ""); err != nil && !errors.Is(err, service.ErrNotFound) {
 				return err
 			}
 			syncEngine := syncer.NewEngine(db, store)
+			syncEngine.RemoteKey = app.RemoteAPIKey
+			syncEngine.Identity = app.NodeIdentity
+			syncEngine.Changes = app.WatchChanges
+			syncEngine.Publish = app.PublishSynced
 			handler := handlers.New(app, db, cfg, syncEngine)
 			hub := ws.NewHub(app, store)
 			apiRouter := api.NewRouter(handler, app, hub)
@@ -2029,7 +2157,11 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
   opencortex sync remote add origin https://hub.example.com --key amk_remote_xxx --api-key <sync-key>
   opencortex sync diff origin --api-key <sync-key>
   opencortex sync push origin --key amk_remote_xxx --api-key <sync-key>
-  opencortex sync conflicts --api-key <sync-key>`),
+  opencortex sync pull origin --dry-run --api-key <sync-key>
+  opencortex sync conflicts --api-key <sync-key>
+  opencortex sync export --scope collections --ids <collection-id> -o bundle.ocx
+  opencortex sync import bundle.ocx
+  opencortex sync peers`),
 	}
 
 	remoteCmd := &cobra.Command{
@@ -2037,9 +2169,13 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
 		Short: "Remote management",
 		Example: strings.TrimSpace(`
   opencortex sync remote add origin https://hub.example.com --key amk_remote_xxx --api-key <sync-key>
+  opencortex sync remote add hub https://hub.example.com --key amk_remote_xxx --live --api-key <sync-key>
+  opencortex sync remote add partner https://partner.example.com --key amk_remote_xxx --exclude-tag internal --redact summary --api-key <sync-key>
   opencortex sync remote list --api-key <sync-key>`),
 	}
-	var remoteName, remoteURL, remoteKey string
+	var remoteName, remoteURL, remoteKey, peerKey string
+	var live bool
+	var excludeTags, excludeVisibilities, excludeMetadata, redact []string
 	addRemote := &cobra.Command{
 		Use:   "add <name> <url>",
 		Short: "Add a sync remote",
@@ -2051,9 +2187,17 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
 			}
 			var out map[string]any
 			err = client.do(http.MethodPost, "/api/v1/sync/remotes", map[string]any{
-				"name":    args[0],
-				"url":     args[1],
-				"api_key": remoteKey,
+				"name":            args[0],
+				"url":             args[1],
+				"api_key":         remoteKey,
+				"peer_public_key": peerKey,
+				"live":            live,
+				"filter": map[string]any{
+					"exclude_tags":          excludeTags,
+					"exclude_visibilities":  excludeVisibilities,
+					"exclude_metadata_keys": excludeMetadata,
+					"redact_fields":         redact,
+				},
 			}, &out)
 			if err != nil {
 				return err
@@ -2064,6 +2208,12 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
 	addRemote.Flags().StringVar(&remoteName, "name", "", "Remote name (alias)")
 	addRemote.Flags().StringVar(&remoteURL, "url", "", "Remote url")
 	addRemote.Flags().StringVar(&remoteKey, "key", "", "Remote API key")
+	addRemote.Flags().StringVar(&peerKey, "peer-key", "", "Pin the remote's public key, as shown by its 'sync identity'")
+	addRemote.Flags().BoolVar(&live, "live", false, "Keep a persistent link that forwards changes as they happen")
+	addRemote.Flags().StringSliceVar(&excludeTags, "exclude-tag", nil, "Never send knowledge with this tag (repeatable)")
+	addRemote.Flags().StringSliceVar(&excludeVisibilities, "exclude-visibility", nil, "Never send knowledge with this visibility (repeatable)")
+	addRemote.Flags().StringSliceVar(&excludeMetadata, "exclude-metadata", nil, "Strip this metadata key from sent knowledge (repeatable)")
+	addRemote.Flags().StringSliceVar(&redact, "redact", nil, "Redact this knowledge field in what is sent: title, content, summary, source, tags or metadata (repeatable)")
 	remoteCmd.AddCommand(addRemote)
 
 	remoteCmd.AddCommand(&cobra.Command{
@@ -2101,6 +2251,36 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
 			return printJSON(out)
 		},
 	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "identity",
+		Short: "Show this node's sync signing key, for peers to pin",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+			if err != nil {
+				return err
+			}
+			var out map[string]any
+			if err := client.do(http.MethodGet, "/api/v1/sync/identity", nil, &out); err != nil {
+				return err
+			}
+			return printJSON(out)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "peers",
+		Short: "Show the nodes this node has synced with and the versions each is known to hold",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+			if err != nil {
+				return err
+			}
+			var out map[string]any
+			if err := client.do(http.MethodGet, "/api/v1/sync/peers", nil, &out); err != nil {
+				return err
+			}
+			return printJSON(out)
+		},
+	})
 	cmd.AddCommand(&cobra.Command{
 		Use:   "diff <remote>",
 		Short: "Preview sync changes for a remote",
@@ -2132,7 +2312,80 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
 			return printJSON(out)
 		},
 	})
-	var strategy string
+	var exportScope, exportOut, exportRemote string
+	var exportIDs []string
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write a sync bundle for nodes that cannot reach this one",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+			if err != nil {
+				return err
+			}
+			q := url.Values{"scope": {exportScope}}
+			if len(exportIDs) > 0 {
+				// --ids names topics for the topic scopes, collections otherwise.
+				key := "collection_ids"
+				if exportScope == "topics" || exportScope == "messages" {
+					key = "topic_ids"
+				}
+				q.Set(key, strings.Join(exportIDs, ","))
+			}
+			if exportRemote != "" {
+				q.Set("remote", exportRemote)
+			}
+			f, err := os.Create(exportOut)
+			if err != nil {
+				return err
+			}
+			if err := client.download("/api/v1/sync/export?"+q.Encode(), f); err != nil {
+				_ = f.Close()
+				_ = os.Remove(exportOut)
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			fmt.Println("bundle written to " + exportOut)
+			return nil
+		},
+	}
+	exportCmd.Flags().StringVar(&exportScope, "scope", "full", "Sync scope: full, collections, topics or messages")
+	exportCmd.Flags().StringSliceVar(&exportIDs, "ids", nil, "Collection or topic ids the scope covers")
+	exportCmd.Flags().StringVarP(&exportOut, "out", "o", "bundle.ocx", "Bundle file to write")
+	exportCmd.Flags().StringVar(&exportRemote, "remote", "", "Remote whose filter the bundle passes; the strictest filter when unset")
+	cmd.AddCommand(exportCmd)
+
+	var importRemote string
+	importCmd := &cobra.Command{
+		Use:   "import <bundle>",
+		Short: "Apply a sync bundle exported by another node",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			path := "/api/v1/sync/import"
+			if importRemote != "" {
+				path += "?remote=" + url.QueryEscape(importRemote)
+			}
+			var out map[string]any
+			if err := client.upload(path, "application/gzip", f, &out); err != nil {
+				return err
+			}
+			return printJSON(out)
+		},
+	}
+	importCmd.Flags().StringVar(&importRemote, "remote", "", "Remote to record the import against (defaults to one kept for the exporting node)")
+	cmd.AddCommand(importCmd)
+
+	var strategy, note, resolveKey string
 	resolveCmd := &cobra.Command{
 		Use:   "resolve <conflict-id>",
 		Short: "Resolve a sync conflict",
@@ -2145,6 +2398,8 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
 			var out map[string]any
 			if err := client.do(http.MethodPost, "/api/v1/sync/conflicts/"+args[0]+"/resolve", map[string]any{
 				"strategy": strategy,
+				"note":     note,
+				"api_key":  resolveKey,
 			}, &out); err != nil {
 				return err
 			}
@@ -2152,6 +2407,8 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
 		},
 	}
 	resolveCmd.Flags().StringVar(&strategy, "strategy", "latest-wins", "Conflict strategy")
+	resolveCmd.Flags().StringVar(&note, "note", "", "Note recorded with the resolution")
+	resolveCmd.Flags().StringVar(&resolveKey, "key", "", "Remote API key, needed to fetch the remote copy (defaults to the key stored with the remote)")
 	cmd.AddCommand(resolveCmd)
 
 	_ = asJSON
@@ -2160,6 +2417,7 @@ func newSyncCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Comma
 
 func syncPushPullCommand(kind string, cfgPath, baseURL, apiKey *string) *cobra.Command {
 	var key string
+	var force, dryRun bool
 	short := "Push data to remote"
 	if kind == "pull" {
 		short = "Pull data from remote"
@@ -2178,6 +2436,8 @@ func syncPushPullCommand(kind string, cfgPath, baseURL, apiKey *string) *cobra.C
 				"remote":  args[0],
 				"scope":   "full",
 				"api_key": key,
+				"force":   force,
+				"dry_run": dryRun,
 			}
 			if err := client.do(http.MethodPost, "/api/v1/sync/"+kind, body, &out); err != nil {
 				return err
@@ -2185,7 +2445,214 @@ func syncPushPullCommand(kind string, cfgPath, baseURL, apiKey *string) *cobra.C
 			return printJSON(out)
 		},
 	}
-	cmd.Flags().StringVar(&key, "key", "", "Remote API key")
+	cmd.Flags().StringVar(&key, "key", "", "Remote API key (defaults to the key stored with the remote)")
+	cmd.Flags().BoolVar(&force, "force", false, "Compare full manifests instead of changes since the last sync")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be created, updated, deleted or conflict, without writing anything")
+	return cmd
+}
+
+func newDLQCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Command {
+	var topicID, groupID, fromAgentID, agentID, lastError string
+	cmd := &cobra.Command{
+		Use:   "dlq",
+		Short: "Inspect, replay or purge dead-lettered messages",
+		Example: strings.TrimSpace(`
+  opencortex dlq list --topic tasks.review
+  opencortex dlq list --error timeout --limit 20
+  opencortex dlq replay <receipt-id> <receipt-id>
+  opencortex dlq replay --from <agent-id>
+  opencortex dlq purge --all`),
+	}
+	cmd.PersistentFlags().StringVar(&topicID, "topic", "", "Only dead letters on this topic")
+	cmd.PersistentFlags().StringVar(&groupID, "group", "", "Only dead letters sent to this group")
+	cmd.PersistentFlags().StringVar(&fromAgentID, "from", "", "Only dead letters sent by this agent")
+	cmd.PersistentFlags().StringVar(&agentID, "agent", "", "Only dead letters addressed to this agent")
+	cmd.PersistentFlags().StringVar(&lastError, "error", "", "Only dead letters whose last error contains this text")
+
+	var page, limit int
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List dead-lettered messages",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+			if err != nil {
+				return err
+			}
+			query := url.Values{}
+			for key, value := range map[string]string{
+				"topic_id":      topicID,
+				"group_id":      groupID,
+				"from_agent_id": fromAgentID,
+				"agent_id":      agentID,
+				"last_error":    lastError,
+			} {
+				if value != "" {
+					query.Set(key, value)
+				}
+			}
+			query.Set("page", strconv.Itoa(page))
+			query.Set("limit", strconv.Itoa(limit))
+			var out map[string]any
+			if err := client.do(http.MethodGet, "/api/v1/messages/dead-letter?"+query.Encode(), nil, &out); err != nil {
+				return err
+			}
+			return printJSON(out)
+		},
+	}
+	list.Flags().IntVar(&page, "page", 1, "Page")
+	list.Flags().IntVar(&limit, "limit", 50, "Dead letters per page")
+	cmd.AddCommand(list)
+
+	var all bool
+	bulk := func(use, short, path string) *cobra.Command {
+		c := &cobra.Command{
+			Use:   use + " [receipt-id...]",
+			Short: short,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				body := map[string]any{
+					"receipt_ids":   args,
+					"topic_id":      topicID,
+					"group_id":      groupID,
+					"from_agent_id": fromAgentID,
+					"agent_id":      agentID,
+					"last_error":    lastError,
+					"all":           all,
+				}
+				if len(args) == 0 && !all && topicID == "" && groupID == "" && fromAgentID == "" && agentID == "" && lastError == "" {
+					return fmt.Errorf("pass receipt ids, a filter or --all")
+				}
+				client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+				if err != nil {
+					return err
+				}
+				var out map[string]any
+				if err := client.do(http.MethodPost, path, body, &out); err != nil {
+					return err
+				}
+				return printJSON(out)
+			},
+		}
+		c.Flags().BoolVar(&all, "all", false, "Apply to every dead letter matching the filters, or all of them")
+		return c
+	}
+	cmd.AddCommand(bulk("replay", "Requeue dead-lettered messages with their attempts reset", "/api/v1/messages/dead-letter/replay"))
+	cmd.AddCommand(bulk("purge", "Permanently discard dead-lettered messages", "/api/v1/messages/dead-letter/purge"))
+	_ = asJSON
+	return cmd
+}
+
+func newScheduleCommand(cfgPath, baseURL, apiKey *string, asJSON *bool) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Manage recurring messages sent on a cron schedule",
+		Example: strings.TrimSpace(`
+  opencortex schedule add --cron "0 9 * * 1-5" --topic standup --template "Standup for {{.Time.Format \"Mon Jan 2\"}}"
+  opencortex schedule add --cron "@hourly" --to reviewer --name triage "Check the review queue"
+  opencortex schedule list
+  opencortex schedule pause <schedule-id>
+  opencortex schedule runs <schedule-id>`),
+	}
+
+	var cronSpec, name, toAgent, toTopic, toGroup, priority string
+	var expiresIn int
+	var templated bool
+	add := &cobra.Command{
+		Use:   "add <content>",
+		Short: "Create a schedule sending content on each run",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if toAgent == "" && toTopic == "" && toGroup == "" {
+				return errors.New("one of --to, --topic or --group is required")
+			}
+			client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+			if err != nil {
+				return err
+			}
+			body := map[string]any{
+				"name":               name,
+				"cron":               cronSpec,
+				"content":            args[0],
+				"priority":           priority,
+				"expires_in_seconds": expiresIn,
+				"template":           templated,
+			}
+			if toTopic != "" {
+				body["topic_id"] = toTopic
+			}
+			if toGroup != "" {
+				body["to_group_id"] = toGroup
+			}
+			if toAgent != "" {
+				agentID, err := resolveAgentByName(client, toAgent)
+				if err != nil {
+					return err
+				}
+				body["to_agent_id"] = agentID
+			}
+			var out map[string]any
+			if err := client.do(http.MethodPost, "/api/v1/schedules", body, &out); err != nil {
+				return err
+			}
+			return printJSON(out)
+		},
+	}
+	add.Flags().StringVar(&cronSpec, "cron", "", "Cron spec, e.g. \"0 9 * * 1-5\" or @daily; prefix CRON_TZ=<zone> for a time zone")
+	add.Flags().StringVar(&name, "name", "", "Schedule name (defaults to the cron spec)")
+	add.Flags().StringVar(&toAgent, "to", "", "Recipient agent name or partial name")
+	add.Flags().StringVar(&toTopic, "topic", "", "Topic ID to publish to")
+	add.Flags().StringVar(&toGroup, "group", "", "Group ID to send to")
+	add.Flags().StringVar(&priority, "priority", "", "Message priority: low, normal, high or critical")
+	add.Flags().IntVar(&expiresIn, "expires-in", 0, "Seconds each message lives after it is sent")
+	add.Flags().BoolVar(&templated, "template", false, "Render content as a Go template with .Name and .Time on each run")
+	_ = add.MarkFlagRequired("cron")
+	cmd.AddCommand(add)
+
+	var page, limit int
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List schedules",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+			if err != nil {
+				return err
+			}
+			var out map[string]any
+			path := fmt.Sprintf("/api/v1/schedules?page=%d&per_page=%d", page, limit)
+			if err := client.do(http.MethodGet, path, nil, &out); err != nil {
+				return err
+			}
+			return printJSON(out)
+		},
+	}
+	list.Flags().IntVar(&page, "page", 1, "Page")
+	list.Flags().IntVar(&limit, "limit", 50, "Schedules per page")
+	cmd.AddCommand(list)
+
+	byID := func(use, short, method, suffix string) *cobra.Command {
+		return &cobra.Command{
+			Use:   use + " <schedule-id>",
+			Short: short,
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				client, err := newAutoClientWithEnsure(*baseURL, *apiKey, *cfgPath)
+				if err != nil {
+					return err
+				}
+				var out map[string]any
+				if err := client.do(method, "/api/v1/schedules/"+url.PathEscape(args[0])+suffix, nil, &out); err != nil {
+					return err
+				}
+				return printJSON(out)
+			},
+		}
+	}
+	cmd.AddCommand(byID("get", "Show a schedule", http.MethodGet, ""))
+	cmd.AddCommand(byID("pause", "Stop a schedule from firing", http.MethodPost, "/pause"))
+	cmd.AddCommand(byID("resume", "Let a paused schedule fire again", http.MethodPost, "/resume"))
+	cmd.AddCommand(byID("run", "Send a schedule's message now", http.MethodPost, "/run"))
+	cmd.AddCommand(byID("runs", "Show a schedule's recent runs", http.MethodGet, "/runs"))
+	cmd.AddCommand(byID("delete", "Delete a schedule", http.MethodDelete, ""))
+	_ = asJSON
 	return cmd
 }
 
@@ -2316,13 +2783,6 @@ func printAgentsTable(items []model.Agent) error {
 	return w.Flush()
 }
 
-func valuePtr(v string) *string {
-	if strings.TrimSpace(v) == "" {
-		return nil
-	}
-	return &v
-}
-
 type authAccount struct {
 	BaseURL   string    `json:"base_url"`
 	Profile   string    `json:"profile,omitempty"`
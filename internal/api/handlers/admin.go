@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"opencortex/internal/storage"
+	"opencortex/internal/storage/repos"
 )
 
 func (s *Server) AdminStats(w http.ResponseWriter, r *http.Request) {
@@ -12,7 +14,17 @@ func (s *Server) AdminStats(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"stats": stats}, nil)
+	brokerStats, err := s.App.Broker.Stats(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	lag, err := s.App.Store.PriorityLag(r.Context(), time.Now().UTC(), repos.PriorityLagFilters{})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"stats": stats, "broker": brokerStats, "priority_lag": lag}, nil)
 }
 
 func (s *Server) AdminConfig(w http.ResponseWriter, r *http.Request) {
@@ -43,12 +55,12 @@ func (s *Server) AdminVacuum(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) PurgeExpiredMessages(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.App.Store.PurgeExpired(r.Context())
+	report, err := s.App.CompactMessages(r.Context())
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"purged": rows}, nil)
+	writeJSON(w, http.StatusOK, map[string]any{"purged": report.Total(), "compaction": report}, nil)
 }
 
 func (s *Server) RBACRoles(w http.ResponseWriter, r *http.Request) {
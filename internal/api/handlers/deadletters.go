@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+
+	"opencortex/internal/service"
+	"opencortex/internal/storage/repos"
+)
+
+func deadLetterFiltersFromQuery(r *http.Request) repos.DeadLetterFilters {
+	q := r.URL.Query()
+	return repos.DeadLetterFilters{
+		TopicID:     q.Get("topic_id"),
+		GroupID:     q.Get("group_id"),
+		FromAgentID: q.Get("from_agent_id"),
+		AgentID:     q.Get("agent_id"),
+		LastError:   q.Get("last_error"),
+		Page:        parseInt(q.Get("page"), 1),
+		PerPage:     parseInt(q.Get("limit"), 50),
+	}
+}
+
+func (s *Server) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	f := deadLetterFiltersFromQuery(r)
+	letters, total, err := s.App.Store.ListDeadLetters(r.Context(), f)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	if letters == nil {
+		letters = []repos.DeadLetter{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"dead_letters": letters}, &pagination{
+		Page: f.Page, PerPage: f.PerPage, Total: total,
+	})
+}
+
+// decodeDeadLetterSelection reads which dead letters a bulk action applies
+// to. Acting on every dead letter takes an explicit all: true so an empty
+// body cannot wipe the queue.
+func decodeDeadLetterSelection(w http.ResponseWriter, r *http.Request) (repos.DeadLetterFilters, bool) {
+	var req struct {
+		ReceiptIDs  []string `json:"receipt_ids"`
+		TopicID     string   `json:"topic_id"`
+		GroupID     string   `json:"group_id"`
+		FromAgentID string   `json:"from_agent_id"`
+		AgentID     string   `json:"agent_id"`
+		LastError   string   `json:"last_error"`
+		All         bool     `json:"all"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
+		return repos.DeadLetterFilters{}, false
+	}
+	f := repos.DeadLetterFilters{
+		ReceiptIDs:  req.ReceiptIDs,
+		TopicID:     req.TopicID,
+		GroupID:     req.GroupID,
+		FromAgentID: req.FromAgentID,
+		AgentID:     req.AgentID,
+		LastError:   req.LastError,
+	}
+	if !req.All && len(f.ReceiptIDs) == 0 && f.TopicID == "" && f.GroupID == "" && f.FromAgentID == "" && f.AgentID == "" && f.LastError == "" {
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "receipt_ids, a filter or all: true is required")
+		return repos.DeadLetterFilters{}, false
+	}
+	return f, true
+}
+
+func (s *Server) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	f, ok := decodeDeadLetterSelection(w, r)
+	if !ok {
+		return
+	}
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	n, err := s.App.ReplayDeadLetters(r.Context(), authCtx.Agent.ID, f)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"replayed": n}, nil)
+}
+
+func (s *Server) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	f, ok := decodeDeadLetterSelection(w, r)
+	if !ok {
+		return
+	}
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	n, err := s.App.PurgeDeadLetters(r.Context(), authCtx.Agent.ID, f)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"purged": n}, nil)
+}
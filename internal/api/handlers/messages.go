@@ -34,11 +34,17 @@ func (s *Server) CreateMessage(w http.ResponseWriter, r *http.Request) {
 		Tags             []string       `json:"tags"`
 		Metadata         map[string]any `json:"metadata"`
 		ExpiresInSeconds int            `json:"expires_in_seconds"`
+		DeliverAt        *time.Time     `json:"deliver_at"`
+		DeliverInSeconds int            `json:"deliver_in_seconds"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
 		return
 	}
+	deliverAt, ok := parseDeliverAt(w, req.DeliverAt, req.DeliverInSeconds)
+	if !ok {
+		return
+	}
 	if req.Content == "" {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "content is required")
 		return
@@ -62,6 +68,7 @@ func (s *Server) CreateMessage(w http.ResponseWriter, r *http.Request) {
 		Tags:        req.Tags,
 		Metadata:    req.Metadata,
 		ExpiresAt:   expiresAt,
+		DeliverAt:   deliverAt,
 	})
 	if err != nil {
 		if mapServiceErr(w, err) {
@@ -87,11 +94,17 @@ func (s *Server) BroadcastMessage(w http.ResponseWriter, r *http.Request) {
 		Metadata         map[string]any `json:"metadata"`
 		Type             string         `json:"type"`
 		ExpiresInSeconds int            `json:"expires_in_seconds"`
+		DeliverAt        *time.Time     `json:"deliver_at"`
+		DeliverInSeconds int            `json:"deliver_in_seconds"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
 		return
 	}
+	deliverAt, ok := parseDeliverAt(w, req.DeliverAt, req.DeliverInSeconds)
+	if !ok {
+		return
+	}
 	if strings.TrimSpace(req.Content) == "" {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "content is required")
 		return
@@ -116,6 +129,7 @@ func (s *Server) BroadcastMessage(w http.ResponseWriter, r *http.Request) {
 		Tags:        req.Tags,
 		Metadata:    req.Metadata,
 		ExpiresAt:   expiresAt,
+		DeliverAt:   deliverAt,
 	})
 	if err != nil {
 		if mapServiceErr(w, err) {
@@ -127,6 +141,41 @@ func (s *Server) BroadcastMessage(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, map[string]any{"message": msg}, nil)
 }
 
+// parseDeliverAt reads when a message should be delivered, given either as a
+// time or as a delay in seconds. Nil means deliver now.
+func parseDeliverAt(w http.ResponseWriter, at *time.Time, inSeconds int) (*time.Time, bool) {
+	switch {
+	case at != nil && inSeconds != 0:
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "deliver_at and deliver_in_seconds are mutually exclusive")
+		return nil, false
+	case inSeconds < 0:
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "deliver_in_seconds must not be negative")
+		return nil, false
+	case inSeconds > 0:
+		t := time.Now().UTC().Add(time.Duration(inSeconds) * time.Second)
+		return &t, true
+	}
+	return at, true
+}
+
+// CancelScheduledMessage withdraws a scheduled message before it is
+// delivered.
+func (s *Server) CancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	if err := s.App.CancelScheduledMessage(r.Context(), chi.URLParam(r, "id"), authCtx.Agent.ID); err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"cancelled": true}, nil)
+}
+
 func (s *Server) Inbox(w http.ResponseWriter, r *http.Request) {
 	authCtx, ok := service.AuthFromContext(r.Context())
 	if !ok {
@@ -267,12 +316,13 @@ func (s *Server) ClaimMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	claims, err := s.App.Store.ClaimMessages(r.Context(), repos.ClaimMessagesInput{
-		AgentID:      authCtx.Agent.ID,
-		Limit:        req.Limit,
-		TopicID:      req.TopicID,
-		FromAgentID:  req.FromAgentID,
-		Priority:     req.Priority,
-		LeaseSeconds: s.normalizeLease(req.LeaseSeconds),
+		AgentID:       authCtx.Agent.ID,
+		Limit:         req.Limit,
+		TopicID:       req.TopicID,
+		FromAgentID:   req.FromAgentID,
+		Priority:      req.Priority,
+		LeaseSeconds:  s.normalizeLease(req.LeaseSeconds),
+		PriorityAging: s.App.PriorityAging(),
 	})
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
@@ -376,6 +426,20 @@ func (s *Server) RenewMessageClaim(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"id": messageID, "claim_expires_at": expiresAt}, nil)
 }
 
+// MessageLag reports the pending backlog and oldest wait per priority,
+// optionally for one topic_id or group_id.
+func (s *Server) MessageLag(w http.ResponseWriter, r *http.Request) {
+	lag, err := s.App.Store.PriorityLag(r.Context(), time.Now().UTC(), repos.PriorityLagFilters{
+		TopicID: r.URL.Query().Get("topic_id"),
+		GroupID: r.URL.Query().Get("group_id"),
+	})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"lag": lag, "priority_aging_seconds": s.App.PriorityAging().Seconds()}, nil)
+}
+
 func (s *Server) normalizeLease(in int) int {
 	lease := in
 	if lease <= 0 {
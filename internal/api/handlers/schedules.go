@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"opencortex/internal/model"
+	"opencortex/internal/service"
+	"opencortex/internal/storage/repos"
+)
+
+func (s *Server) CreateMessageSchedule(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	var req struct {
+		Name             string         `json:"name"`
+		Cron             string         `json:"cron"`
+		ToAgentID        *string        `json:"to_agent_id"`
+		TopicID          *string        `json:"topic_id"`
+		ToGroupID        *string        `json:"to_group_id"`
+		ContentType      string         `json:"content_type"`
+		Content          string         `json:"content"`
+		Template         bool           `json:"template"`
+		Priority         string         `json:"priority"`
+		Tags             []string       `json:"tags"`
+		Metadata         map[string]any `json:"metadata"`
+		ExpiresInSeconds int            `json:"expires_in_seconds"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
+		return
+	}
+	sched, err := s.App.CreateMessageSchedule(r.Context(), repos.CreateMessageScheduleInput{
+		Name:             req.Name,
+		Cron:             req.Cron,
+		CreatedBy:        authCtx.Agent.ID,
+		ToAgentID:        req.ToAgentID,
+		TopicID:          req.TopicID,
+		ToGroupID:        req.ToGroupID,
+		ContentType:      req.ContentType,
+		Content:          req.Content,
+		Template:         req.Template,
+		Priority:         model.MessagePriority(req.Priority),
+		Tags:             req.Tags,
+		Metadata:         req.Metadata,
+		ExpiresInSeconds: req.ExpiresInSeconds,
+	})
+	if err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"schedule": sched}, nil)
+}
+
+func (s *Server) ListMessageSchedules(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	page := parseInt(r.URL.Query().Get("page"), 1)
+	perPage := parseInt(r.URL.Query().Get("per_page"), 50)
+	schedules, total, err := s.App.ListMessageSchedules(r.Context(), authCtx, page, perPage)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	if schedules == nil {
+		schedules = []model.MessageSchedule{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": schedules}, &pagination{Page: page, PerPage: perPage, Total: total})
+}
+
+func (s *Server) GetMessageSchedule(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	sched, err := s.App.OwnedMessageSchedule(r.Context(), authCtx, chi.URLParam(r, "id"))
+	if err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedule": sched}, nil)
+}
+
+func (s *Server) PauseMessageSchedule(w http.ResponseWriter, r *http.Request) {
+	s.setMessageSchedulePaused(w, r, s.App.PauseMessageSchedule)
+}
+
+func (s *Server) ResumeMessageSchedule(w http.ResponseWriter, r *http.Request) {
+	s.setMessageSchedulePaused(w, r, s.App.ResumeMessageSchedule)
+}
+
+func (s *Server) setMessageSchedulePaused(w http.ResponseWriter, r *http.Request, set func(ctx context.Context, authCtx service.AuthContext, id string) (model.MessageSchedule, error)) {
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	sched, err := set(r.Context(), authCtx, chi.URLParam(r, "id"))
+	if err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedule": sched}, nil)
+}
+
+func (s *Server) DeleteMessageSchedule(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	if err := s.App.DeleteMessageSchedule(r.Context(), authCtx, chi.URLParam(r, "id")); err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deleted": true}, nil)
+}
+
+// RunMessageSchedule fires a schedule now, paused or not, outside its cron
+// spec.
+func (s *Server) RunMessageSchedule(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if _, err := s.App.OwnedMessageSchedule(r.Context(), authCtx, id); err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	run, err := s.App.RunMessageSchedule(r.Context(), id, time.Now())
+	if err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"run": run}, nil)
+}
+
+func (s *Server) ListMessageScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := service.AuthFromContext(r.Context())
+	if !ok {
+		writeErr(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+		return
+	}
+	runs, err := s.App.ListMessageScheduleRuns(r.Context(), authCtx, chi.URLParam(r, "id"), parseInt(r.URL.Query().Get("limit"), 20))
+	if err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	if runs == nil {
+		runs = []model.MessageScheduleRun{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"runs": runs}, nil)
+}
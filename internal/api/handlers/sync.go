@@ -1,12 +1,22 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 
+	"opencortex/internal/auth"
 	"opencortex/internal/model"
+	"opencortex/internal/service"
 	"opencortex/internal/storage/repos"
 	syncer "opencortex/internal/sync"
 )
@@ -22,28 +32,46 @@ func (s *Server) ListRemotes(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) AddRemote(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Name             string   `json:"name"`
-		URL              string   `json:"url"`
-		APIKey           string   `json:"api_key"`
-		Direction        string   `json:"direction"`
-		Scope            string   `json:"scope"`
-		ScopeIDs         []string `json:"scope_ids"`
-		ConflictStrategy string   `json:"conflict_strategy"`
-		Schedule         *string  `json:"schedule"`
+		Name             string           `json:"name"`
+		URL              string           `json:"url"`
+		APIKey           string           `json:"api_key"`
+		Direction        string           `json:"direction"`
+		Scope            string           `json:"scope"`
+		ScopeIDs         []string         `json:"scope_ids"`
+		TopicIDs         []string         `json:"topic_ids"`
+		ConflictStrategy string           `json:"conflict_strategy"`
+		Schedule         *string          `json:"schedule"`
+		PeerPublicKey    string           `json:"peer_public_key"`
+		Live             bool             `json:"live"`
+		Filter           model.SyncFilter `json:"filter"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
 		return
 	}
+	if err := syncer.ValidateFilter(req.Filter); err != nil {
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid filter: "+err.Error())
+		return
+	}
+	if req.PeerPublicKey != "" {
+		if _, err := auth.ParsePublicKey(req.PeerPublicKey); err != nil {
+			writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid peer_public_key")
+			return
+		}
+	}
 	manifest, err := s.App.AddRemote(r.Context(), repos.CreateRemoteInput{
-		ID:         uuid.NewString(),
-		RemoteURL:  req.URL,
-		RemoteName: req.Name,
-		Direction:  model.SyncDirection(req.Direction),
-		Scope:      model.SyncScope(req.Scope),
-		ScopeIDs:   req.ScopeIDs,
-		Strategy:   req.ConflictStrategy,
-		Schedule:   req.Schedule,
+		ID:            uuid.NewString(),
+		RemoteURL:     req.URL,
+		RemoteName:    req.Name,
+		Direction:     model.SyncDirection(req.Direction),
+		Scope:         model.SyncScope(req.Scope),
+		ScopeIDs:      req.ScopeIDs,
+		TopicIDs:      req.TopicIDs,
+		Strategy:      req.ConflictStrategy,
+		Schedule:      req.Schedule,
+		PeerPublicKey: req.PeerPublicKey,
+		Live:          req.Live,
+		Filter:        req.Filter,
 	}, req.APIKey)
 	if err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
@@ -54,7 +82,7 @@ func (s *Server) AddRemote(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) DeleteRemote(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
-	if err := s.App.Store.DeleteRemote(r.Context(), name); err != nil {
+	if err := s.App.DeleteRemote(r.Context(), name); err != nil {
 		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
 		return
 	}
@@ -63,28 +91,40 @@ func (s *Server) DeleteRemote(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) Push(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Remote        string   `json:"remote"`
-		Scope         string   `json:"scope"`
-		CollectionIDs []string `json:"collection_ids"`
-		TopicIDs      []string `json:"topic_ids"`
-		Force         bool     `json:"force"`
-		APIKey        string   `json:"api_key"`
-		Items         []any    `json:"items"`
+		Remote        string          `json:"remote"`
+		Scope         string          `json:"scope"`
+		CollectionIDs []string        `json:"collection_ids"`
+		TopicIDs      []string        `json:"topic_ids"`
+		Force         bool            `json:"force"`
+		DryRun        bool            `json:"dry_run"`
+		APIKey        string          `json:"api_key"`
+		Items         []syncer.Entity `json:"items"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
 		return
 	}
 	if req.Remote == "" && len(req.Items) > 0 {
-		// Peer node push payload received.
-		writeJSON(w, http.StatusOK, map[string]any{"received": true, "count": len(req.Items)}, nil)
+		// Peer node push payload received, from a node too old to sign it.
+		if !s.verifyLegacyInbound(w, r, "push") {
+			return
+		}
+		s.applyInbound(w, r, req.Items, nil)
 		return
 	}
-	ids := req.CollectionIDs
-	if len(ids) == 0 {
-		ids = req.TopicIDs
+	opts := syncer.SyncOptions{
+		Scope: model.SyncScope(req.Scope),
+		Selection: syncer.Selection{
+			CollectionIDs: req.CollectionIDs,
+			TopicIDs:      req.TopicIDs,
+		},
+		Force: req.Force,
+	}
+	if req.DryRun {
+		s.previewSync(w, r, model.SyncDirectionPush, req.Remote, req.APIKey, opts)
+		return
 	}
-	log, err := s.SyncEngine.Push(r.Context(), req.Remote, req.APIKey, model.SyncScope(req.Scope), ids)
+	log, err := s.SyncEngine.Push(r.Context(), req.Remote, req.APIKey, opts)
 	if err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
 		return
@@ -94,36 +134,40 @@ func (s *Server) Push(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) Pull(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Remote        string   `json:"remote"`
-		Scope         string   `json:"scope"`
-		CollectionIDs []string `json:"collection_ids"`
-		TopicIDs      []string `json:"topic_ids"`
-		Force         bool     `json:"force"`
-		APIKey        string   `json:"api_key"`
-		Items         []any    `json:"items"`
+		Remote        string                `json:"remote"`
+		Scope         string                `json:"scope"`
+		CollectionIDs []string              `json:"collection_ids"`
+		TopicIDs      []string              `json:"topic_ids"`
+		Force         bool                  `json:"force"`
+		DryRun        bool                  `json:"dry_run"`
+		APIKey        string                `json:"api_key"`
+		Items         []syncer.ManifestItem `json:"items"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
 		return
 	}
 	if req.Remote == "" && len(req.Items) > 0 {
-		scope := model.SyncScope(req.Scope)
-		if scope == "" {
-			scope = model.SyncScopeFull
-		}
-		items, err := syncer.BuildManifest(r.Context(), s.DB, scope, nil)
-		if err != nil {
-			writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		// Peer node asking for entity bodies, from a node too old to sign.
+		if !s.verifyLegacyInbound(w, r, "pull") {
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"items": items}, nil)
+		s.serveEntities(w, r, model.SyncScope(req.Scope), syncer.Selection{CollectionIDs: req.CollectionIDs, TopicIDs: req.TopicIDs}, req.Items)
 		return
 	}
-	ids := req.CollectionIDs
-	if len(ids) == 0 {
-		ids = req.TopicIDs
+	opts := syncer.SyncOptions{
+		Scope: model.SyncScope(req.Scope),
+		Selection: syncer.Selection{
+			CollectionIDs: req.CollectionIDs,
+			TopicIDs:      req.TopicIDs,
+		},
+		Force: req.Force,
+	}
+	if req.DryRun {
+		s.previewSync(w, r, model.SyncDirectionPull, req.Remote, req.APIKey, opts)
+		return
 	}
-	log, err := s.SyncEngine.Pull(r.Context(), req.Remote, req.APIKey, model.SyncScope(req.Scope), ids)
+	log, err := s.SyncEngine.Pull(r.Context(), req.Remote, req.APIKey, opts)
 	if err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
 		return
@@ -131,6 +175,17 @@ func (s *Server) Pull(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"sync_log": log}, nil)
 }
 
+// previewSync answers a dry run: what the push or pull would change, with
+// nothing written.
+func (s *Server) previewSync(w http.ResponseWriter, r *http.Request, direction model.SyncDirection, remote, apiKey string, opts syncer.SyncOptions) {
+	preview, err := s.SyncEngine.Preview(r.Context(), direction, remote, apiKey, opts)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"dry_run": true, "preview": preview}, nil)
+}
+
 func (s *Server) SyncStatus(w http.ResponseWriter, r *http.Request) {
 	logs, err := s.App.Store.ListSyncLogs(r.Context(), 1)
 	if err != nil {
@@ -141,8 +196,22 @@ func (s *Server) SyncStatus(w http.ResponseWriter, r *http.Request) {
 	if len(logs) > 0 {
 		latest = logs[0]
 	}
+	remotes, err := s.App.Store.ListRemotes(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	// next_retry_at is the earliest pending retry of any failing remote.
+	var nextRetryAt *time.Time
+	for _, m := range remotes {
+		if m.NextRetryAt != nil && (nextRetryAt == nil || m.NextRetryAt.Before(*nextRetryAt)) {
+			nextRetryAt = m.NextRetryAt
+		}
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
-		"latest": latest,
+		"latest":        latest,
+		"next_retry_at": nextRetryAt,
+		"remotes":       remotes,
 	}, nil)
 }
 
@@ -156,23 +225,79 @@ func (s *Server) SyncLogs(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"logs": logs}, nil)
 }
 
+// SyncIdentity shows the key this node signs its sync payloads with, for
+// peers to pin.
+func (s *Server) SyncIdentity(w http.ResponseWriter, r *http.Request) {
+	id, err := s.App.NodeIdentity(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"node_id":    id.NodeID,
+		"public_key": id.PublicKey(),
+		"key_id":     id.KeyID(),
+	}, nil)
+}
+
+// SyncPeers shows what this node has seen and the nodes it has exchanged
+// with, each with the highest change per origin node it is known to hold.
+func (s *Server) SyncPeers(w http.ResponseWriter, r *http.Request) {
+	node, clock, err := s.SyncEngine.Clock(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	peers, err := s.App.Store.ListPeers(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"node_id": node,
+		"clock":   clock,
+		"peers":   peers,
+	}, nil)
+}
+
 func (s *Server) SyncDiff(w http.ResponseWriter, r *http.Request) {
 	// Inbound diff for peer nodes.
 	if r.Method == http.MethodPost {
-		var req struct {
-			Scope string                `json:"scope"`
-			Items []syncer.ManifestItem `json:"items"`
+		var req syncer.DiffRequest
+		if !s.decodeSync(w, r, "diff", &req) {
+			return
+		}
+		s.notePeer(r, req.Items)
+		// Take the cursor first so changes made during the diff are not lost.
+		cursor, err := syncer.ChangeCursor(r.Context(), s.DB)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+			return
 		}
-		if err := decodeJSON(r, &req); err != nil {
-			writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
+		filter, err := s.callerFilter(r)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+			return
+		}
+		sel := syncer.Selection{CollectionIDs: req.CollectionIDs, TopicIDs: req.TopicIDs, Filter: filter}
+		var need, have []syncer.ManifestItem
+		var since int64
+		if req.Since != nil {
+			since = *req.Since
+			need, have, err = s.SyncEngine.DiffSince(r.Context(), model.SyncScope(req.Scope), sel, req.Items, since)
+		} else {
+			need, have, err = s.SyncEngine.Diff(r.Context(), model.SyncScope(req.Scope), sel, req.Items)
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
 			return
 		}
-		need, have, err := s.SyncEngine.Diff(r.Context(), model.SyncScope(req.Scope), nil, req.Items)
+		tombstones, err := s.App.Store.ListTombstones(r.Context(), since, syncer.ScopeEntityTypes(model.SyncScope(req.Scope), sel))
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"need": need, "have": have}, nil)
+		s.writeSync(w, r, "diff", syncer.DiffResponse{Need: need, Have: have, Tombstones: tombstones, Cursor: cursor})
 		return
 	}
 
@@ -187,7 +312,7 @@ func (s *Server) SyncDiff(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "remote not found")
 		return
 	}
-	items, err := syncer.BuildManifest(r.Context(), s.DB, scope, manifest.ScopeIDs)
+	items, err := syncer.BuildManifest(r.Context(), s.DB, scope, syncer.Selection{CollectionIDs: manifest.ScopeIDs, TopicIDs: manifest.TopicIDs})
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
 		return
@@ -195,6 +320,59 @@ func (s *Server) SyncDiff(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"items": items}, nil)
 }
 
+// SyncExport streams a bundle of everything in scope, for carrying to nodes
+// that cannot reach this one. The bundle passes the filter of the remote it
+// is named for or, when none is named, the strictest of them.
+func (s *Server) SyncExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	scope := model.SyncScope(q.Get("scope"))
+	switch scope {
+	case "":
+		scope = model.SyncScopeFull
+	case model.SyncScopeFull, model.SyncScopeCollections, model.SyncScopeTopics, model.SyncScopeMessages:
+	default:
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid scope")
+		return
+	}
+	sel := syncer.Selection{CollectionIDs: splitCSV(q.Get("collection_ids")), TopicIDs: splitCSV(q.Get("topic_ids"))}
+	if name := q.Get("remote"); name != "" {
+		remote, err := s.App.Store.GetRemote(r.Context(), name)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "remote not found")
+			return
+		}
+		sel.Filter = remote.Filter
+	} else {
+		remotes, err := s.App.Store.ListRemotes(r.Context())
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+			return
+		}
+		sel.Filter = strictestFilter(remotes)
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="bundle.ocx"`)
+	// Once the bundle has started, failures end it with an error frame.
+	_ = s.SyncEngine.ExportBundle(r.Context(), w, scope, sel)
+}
+
+// SyncImport applies a bundle exported by another node.
+func (s *Server) SyncImport(w http.ResponseWriter, r *http.Request) {
+	log, err := s.SyncEngine.ImportBundle(r.Context(), r.Body, syncer.ImportOptions{
+		Remote:           r.URL.Query().Get("remote"),
+		RequireSignature: s.Config.Sync.RequireSignatures,
+	})
+	if errors.Is(err, syncer.ErrBadSignature) {
+		writeErr(w, http.StatusUnauthorized, "SIGNATURE_INVALID", err.Error())
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sync_log": log}, nil)
+}
+
 func (s *Server) ListConflicts(w http.ResponseWriter, r *http.Request) {
 	conflicts, err := s.App.Store.ListOpenConflicts(r.Context())
 	if err != nil {
@@ -209,43 +387,465 @@ func (s *Server) ResolveConflict(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Strategy string `json:"strategy"`
 		Note     string `json:"note"`
+		APIKey   string `json:"api_key"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
 		return
 	}
-	if err := s.SyncEngine.ResolveConflict(r.Context(), id, req.Strategy, req.Note); err != nil {
+	conflict, err := s.SyncEngine.ResolveConflict(r.Context(), id, req.Strategy, req.Note, req.APIKey)
+	if err != nil {
 		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"resolved": true}, nil)
+	writeJSON(w, http.StatusOK, map[string]any{"resolved": true, "conflict": conflict}, nil)
 }
 
 func (s *Server) InboundPush(w http.ResponseWriter, r *http.Request) {
-	var req map[string]any
-	if err := decodeJSON(r, &req); err != nil {
-		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
+	var req syncer.PushRequest
+	if !s.decodeSync(w, r, "push", &req) {
 		return
 	}
-	// For strict v1 the receiving endpoint acknowledges and lets importer path process payloads in follow-up version.
-	writeJSON(w, http.StatusOK, map[string]any{"received": true, "items": req["items"]}, nil)
+	s.applyInbound(w, r, req.Items, req.Tombstones)
+}
+
+// applyInbound materializes entities and deletions pushed by a peer node,
+// settling conflicts by the strategy this node keeps for it.
+func (s *Server) applyInbound(w http.ResponseWriter, r *http.Request, items []syncer.Entity, tombstones []model.Tombstone) {
+	strategy, err := s.callerStrategy(r)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	source := "peer"
+	if authCtx, ok := service.AuthFromContext(r.Context()); ok {
+		source = "peer " + authCtx.Agent.Name
+	}
+	filter, err := s.callerFilter(r)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	kept, err := s.SyncEngine.WithoutProtected(r.Context(), filter, items)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	deleted, skipped, err := s.SyncEngine.ApplyTombstones(r.Context(), tombstones)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	res, err := s.SyncEngine.Apply(r.Context(), source, strategy, kept)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	res.Deleted = deleted
+	res.Skipped += skipped + len(items) - len(kept)
+	held := make([]syncer.ManifestItem, 0, len(items))
+	for _, ent := range items {
+		held = append(held, ent.ManifestItem)
+	}
+	s.notePeer(r, held)
+	// Echoing the batch number acknowledges the batch to the sender.
+	if n, _, ok := strings.Cut(r.Header.Get(syncer.BatchHeader), "/"); ok {
+		if batch, err := strconv.Atoi(n); err == nil {
+			res.Batch = batch
+		}
+	}
+	s.writeSync(w, r, "push", res)
 }
 
 func (s *Server) InboundPull(w http.ResponseWriter, r *http.Request) {
-	var req map[string]any
-	if err := decodeJSON(r, &req); err != nil {
-		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
+	var req syncer.PullRequest
+	if !s.decodeSync(w, r, "pull", &req) {
 		return
 	}
-	// For strict v1 this endpoint returns current local manifest subset.
-	scope := model.SyncScope("full")
-	if raw, ok := req["scope"].(string); ok && raw != "" {
-		scope = model.SyncScope(raw)
+	s.serveEntities(w, r, model.SyncScope(req.Scope), syncer.Selection{CollectionIDs: req.CollectionIDs, TopicIDs: req.TopicIDs}, req.Items)
+}
+
+// serveEntities returns full records for the manifest items a peer asked for,
+// as the filter of the remote it comes from lets them out. An empty request
+// means everything in scope.
+func (s *Server) serveEntities(w http.ResponseWriter, r *http.Request, scope model.SyncScope, sel syncer.Selection, items []syncer.ManifestItem) {
+	filter, err := s.callerFilter(r)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
 	}
-	items, err := syncer.BuildManifest(r.Context(), s.DB, scope, nil)
+	sel.Filter = filter
+	if len(items) == 0 {
+		if scope == "" {
+			scope = model.SyncScopeFull
+		}
+		items, err = syncer.BuildManifest(r.Context(), s.DB, scope, sel)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+			return
+		}
+	}
+	if !syncer.AcceptsStream(r.Header) {
+		entities, err := s.SyncEngine.LoadEntities(r.Context(), items)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+			return
+		}
+		s.writeSync(w, r, "pull", syncer.PullResponse{Items: syncer.FilterEntities(sel.Filter, entities)})
+		return
+	}
+	// Streamed, each body is written as soon as it is loaded.
+	stream := s.openSyncStream(w, r, "pull")
+	if err := stream.Head(syncer.PullResponse{}); err != nil {
+		return
+	}
+	err = s.SyncEngine.EachEntity(r.Context(), items, func(ent syncer.Entity) error {
+		if keep, _ := syncer.FilterEntity(sel.Filter, &ent); !keep {
+			return nil
+		}
+		return stream.Elem("items", ent)
+	})
 	if err != nil {
+		_ = stream.Fail(err.Error())
+		return
+	}
+	_ = stream.Close()
+}
+
+// liveUpgrader accepts live links. Peers dial them directly rather than from
+// a browser, so no Origin is expected.
+var liveUpgrader = websocket.Upgrader{}
+
+// SyncLive serves a live link dialled by a peer. Once the peer's hello is
+// checked, the changes it sends are applied as inbound pushes and, unless it
+// only pushes, this node's changes after the peer's cursor are sent back as
+// they are written.
+func (s *Server) SyncLive(w http.ResponseWriter, r *http.Request) {
+	header := http.Header{}
+	if node, err := s.App.Store.NodeID(r.Context()); err == nil {
+		header.Set(syncer.NodeHeader, node)
+	}
+	conn, err := liveUpgrader.Upgrade(w, r, header)
+	if err != nil {
+		return
+	}
+	conn.SetReadLimit(syncer.MaxFrameBytes)
+	defer conn.Close()
+	hello, signed, err := syncer.ReadLiveHello(conn)
+	if err == nil {
+		err = s.checkInbound(r, "live", signed)
+	}
+	var filter model.SyncFilter
+	if err == nil {
+		filter, err = s.callerFilter(r)
+	}
+	var strategy syncer.Strategy
+	if err == nil {
+		strategy, err = s.callerStrategy(r)
+	}
+	if err != nil {
+		_ = conn.WriteJSON(syncer.LiveFrame{Type: syncer.LiveErrorFrame, Error: err.Error()})
+		return
+	}
+	scope := model.SyncScope(hello.Scope)
+	if scope == "" {
+		scope = model.SyncScopeFull
+	}
+	source := "peer"
+	if authCtx, ok := service.AuthFromContext(r.Context()); ok {
+		source = "peer " + authCtx.Agent.Name
+	}
+	session := &syncer.LiveSession{
+		Engine: s.SyncEngine,
+		Conn:   conn,
+		Options: syncer.SyncOptions{
+			Scope:     scope,
+			Selection: syncer.Selection{CollectionIDs: hello.CollectionIDs, TopicIDs: hello.TopicIDs, Filter: filter},
+		},
+		Send:     hello.Direction != model.SyncDirectionPush,
+		Since:    hello.Since,
+		Strategy: strategy,
+		Source:   source,
+		PeerNode: r.Header.Get(syncer.NodeHeader),
+		Verify: func(signed syncer.Signed) error {
+			return s.checkInbound(r, "push", signed)
+		},
+	}
+	_ = session.Run(r.Context())
+}
+
+// decodeSync reads a peer request of kind sent as JSON or, by peers that
+// know this node streams, as compressed NDJSON, and checks its signature.
+// It answers the peer itself and returns false when the request is refused.
+func (s *Server) decodeSync(w http.ResponseWriter, r *http.Request, kind string, dst any) bool {
+	signed, err := readSync(r, dst)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
+		return false
+	}
+	return s.verifyInbound(w, r, kind, signed)
+}
+
+func readSync(r *http.Request, dst any) (syncer.Signed, error) {
+	body, err := syncer.OpenBody(r.Header, r.Body)
+	if err != nil {
+		return syncer.Signed{}, err
+	}
+	defer body.Close()
+	keyID := r.Header.Get(syncer.KeyIDHeader)
+	if syncer.IsStream(r.Header) {
+		signed, err := syncer.ReadStream(body, dst)
+		signed.KeyID = keyID
+		return signed, err
+	}
+	dec := json.NewDecoder(io.LimitReader(body, syncer.MaxFrameBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return syncer.Signed{}, err
+	}
+	return syncer.JSONSigned(keyID, r.Header.Get(syncer.SignatureHeader), dst)
+}
+
+// verifyInbound checks a peer payload with checkInbound, answering the peer
+// itself and returning false when the payload is refused: 401 for a bad
+// signature.
+func (s *Server) verifyInbound(w http.ResponseWriter, r *http.Request, kind string, signed syncer.Signed) bool {
+	err := s.checkInbound(r, kind, signed)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, syncer.ErrBadSignature):
+		writeErr(w, http.StatusUnauthorized, "SIGNATURE_INVALID", err.Error())
+	default:
 		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+	}
+	return false
+}
+
+// verifyLegacyInbound checks an unsigned payload from a node too old to sign
+// it. Once any remote pins a key these are refused outright, since nothing
+// tells them apart from a signed payload whose signature was stripped.
+func (s *Server) verifyLegacyInbound(w http.ResponseWriter, r *http.Request, kind string) bool {
+	remotes, err := s.App.Store.ListRemotes(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return false
+	}
+	if anyPinned(remotes) {
+		err := fmt.Errorf("%w: unsigned %s requests are refused while remote keys are pinned", syncer.ErrBadSignature, kind)
+		s.auditRejected(r, "", nil, err)
+		writeErr(w, http.StatusUnauthorized, "SIGNATURE_INVALID", err.Error())
+		return false
+	}
+	return s.verifyInbound(w, r, kind, syncer.Signed{})
+}
+
+// checkInbound checks a peer payload against the key pinned for the remote
+// it comes from. That remote is found from the calling node where it is
+// known, so stripping a signature does not get a payload past its pin. An
+// unsigned payload from a node that cannot be placed is refused once any
+// key is pinned, and always when signatures are required. A refused
+// payload is audited.
+func (s *Server) checkInbound(r *http.Request, kind string, signed syncer.Signed) error {
+	remotes, err := s.App.Store.ListRemotes(r.Context())
+	if err != nil {
+		return err
+	}
+	caller, err := s.callerRemote(r, remotes, signed.KeyID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case caller != nil && caller.PeerPublicKey != "":
+		err = syncer.VerifyPayload(caller.PeerPublicKey, kind+" request", signed)
+	case s.Config.Sync.RequireSignatures:
+		err = fmt.Errorf("%w: not signed by a pinned remote key", syncer.ErrBadSignature)
+	case caller == nil && anyPinned(remotes):
+		err = fmt.Errorf("%w: unsigned payload from an unknown node while remote keys are pinned", syncer.ErrBadSignature)
+	default:
+		return nil
+	}
+	if err != nil {
+		s.auditRejected(r, signed.KeyID, caller, err)
+		return err
+	}
+	// Only a payload verified against a pin gets here. Its node is held to
+	// that pin from now on, however its later payloads arrive.
+	if node := r.Header.Get(syncer.NodeHeader); node != "" {
+		_ = s.App.Store.NotePeer(r.Context(), node, caller.RemoteName, nil)
+	}
+	return nil
+}
+
+func (s *Server) auditRejected(r *http.Request, keyID string, remote *model.SyncManifest, err error) {
+	metadata := map[string]any{"endpoint": r.URL.Path, "key_id": keyID, "direction": "inbound", "error": err.Error()}
+	var agentID, manifestID *string
+	if authCtx, ok := service.AuthFromContext(r.Context()); ok {
+		agentID = &authCtx.Agent.ID
+	}
+	if remote != nil {
+		manifestID = &remote.ID
+		metadata["remote"] = remote.RemoteName
+	}
+	s.App.AuditSync(r.Context(), agentID, "sync.signature_rejected", manifestID, metadata)
+}
+
+// callerRemote finds the remote a peer request comes from: the remote the
+// calling node is known as, from an earlier sync or verified payload, or
+// else the remote pinning the key the payload names. It returns nil for a
+// caller it cannot place.
+func (s *Server) callerRemote(r *http.Request, remotes []model.SyncManifest, keyID string) (*model.SyncManifest, error) {
+	if node := r.Header.Get(syncer.NodeHeader); node != "" {
+		peers, err := s.App.Store.ListPeers(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range peers {
+			if p.NodeID != node || p.RemoteName == nil {
+				continue
+			}
+			for i, m := range remotes {
+				if m.RemoteName == *p.RemoteName {
+					return &remotes[i], nil
+				}
+			}
+		}
+	}
+	if keyID != "" {
+		for i, m := range remotes {
+			if pub, err := auth.ParsePublicKey(m.PeerPublicKey); err == nil && auth.KeyID(pub) == keyID {
+				return &remotes[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func anyPinned(remotes []model.SyncManifest) bool {
+	for _, m := range remotes {
+		if m.PeerPublicKey != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSync answers a peer's request of kind in the format it accepts: a
+// stream when it asked for one, the usual JSON envelope otherwise. Either
+// way the answer is signed with the node's identity.
+func (s *Server) writeSync(w http.ResponseWriter, r *http.Request, kind string, data any) {
+	s.nodeHeader(w, r)
+	if !syncer.AcceptsStream(r.Header) {
+		if id, ok := s.identity(r); ok {
+			sig, err := syncer.SignPayload(id, kind+" response", data)
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+				return
+			}
+			w.Header().Set(syncer.KeyIDHeader, id.KeyID())
+			w.Header().Set(syncer.SignatureHeader, sig)
+		}
+		writeJSON(w, http.StatusOK, data, nil)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items}, nil)
+	stream := s.openSyncStream(w, r, kind)
+	if err := stream.Write(data); err != nil {
+		_ = stream.Fail(err.Error())
+		return
+	}
+	_ = stream.Close()
+}
+
+func (s *Server) openSyncStream(w http.ResponseWriter, r *http.Request, kind string) *syncer.StreamWriter {
+	compress := syncer.AcceptsGzip(r.Header)
+	s.nodeHeader(w, r)
+	w.Header().Set("Content-Type", syncer.StreamContentType)
+	w.Header().Add("Vary", "Accept, Accept-Encoding")
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	id, signed := s.identity(r)
+	if signed {
+		w.Header().Set(syncer.KeyIDHeader, id.KeyID())
+	}
+	w.WriteHeader(http.StatusOK)
+	stream := syncer.NewStreamWriter(w, compress)
+	if signed {
+		stream.Sign(id, kind+" response")
+	}
+	return stream
+}
+
+// nodeHeader names this node in an answer to a peer, telling it this node
+// reads version vectors.
+func (s *Server) nodeHeader(w http.ResponseWriter, r *http.Request) {
+	if node, err := s.App.Store.NodeID(r.Context()); err == nil {
+		w.Header().Set(syncer.NodeHeader, node)
+	}
+}
+
+// notePeer records what the calling node is known to hold, when it names
+// itself.
+func (s *Server) notePeer(r *http.Request, items []syncer.ManifestItem) {
+	node := r.Header.Get(syncer.NodeHeader)
+	if node == "" {
+		return
+	}
+	_ = s.App.Store.NotePeer(r.Context(), node, "", syncer.ClockOf(items))
+}
+
+// callerFilter returns the filter of the remote a peer request comes from,
+// placed as callerRemote places it. A caller it cannot place gets the
+// strictest of the configured filters, so nothing held back from any remote
+// leaks to an unknown node.
+func (s *Server) callerFilter(r *http.Request) (model.SyncFilter, error) {
+	remotes, err := s.App.Store.ListRemotes(r.Context())
+	if err != nil {
+		return model.SyncFilter{}, err
+	}
+	caller, err := s.callerRemote(r, remotes, r.Header.Get(syncer.KeyIDHeader))
+	if err != nil {
+		return model.SyncFilter{}, err
+	}
+	if caller != nil {
+		return caller.Filter, nil
+	}
+	return strictestFilter(remotes), nil
+}
+
+// callerStrategy returns the conflict strategy this node keeps for the
+// remote a peer request comes from, placed as callerRemote places it. The
+// strategy the peer asks for is only advisory. A caller it cannot place gets
+// manual, so its changes never overwrite local edits unreviewed.
+func (s *Server) callerStrategy(r *http.Request) (syncer.Strategy, error) {
+	remotes, err := s.App.Store.ListRemotes(r.Context())
+	if err != nil {
+		return "", err
+	}
+	caller, err := s.callerRemote(r, remotes, r.Header.Get(syncer.KeyIDHeader))
+	if err != nil {
+		return "", err
+	}
+	if caller == nil {
+		return syncer.StrategyManual, nil
+	}
+	if caller.Strategy == "" {
+		return syncer.StrategyLatestWins, nil
+	}
+	return syncer.Strategy(caller.Strategy), nil
+}
+
+func strictestFilter(remotes []model.SyncManifest) model.SyncFilter {
+	filters := make([]model.SyncFilter, 0, len(remotes))
+	for _, m := range remotes {
+		filters = append(filters, m.Filter)
+	}
+	return syncer.StrictestFilter(filters...)
+}
+
+// identity returns the node's signing key. Without one, answers go out
+// unsigned and peers that pinned this node's key refuse them.
+func (s *Server) identity(r *http.Request) (auth.Identity, bool) {
+	id, err := s.App.NodeIdentity(r.Context())
+	return id, err == nil
 }
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -120,12 +122,17 @@ func (s *Server) SubscribeTopic(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Filter map[string]any `json:"filter"`
 	}
-	_ = decodeJSON(r, &req)
-	if err := s.App.Store.Subscribe(r.Context(), authCtx.Agent.ID, id, req.Filter); err != nil {
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeErr(w, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body")
+		return
+	}
+	if _, err := s.App.SubscribeTopic(r.Context(), authCtx.Agent.ID, id, req.Filter, nil); err != nil {
+		if mapServiceErr(w, err) {
+			return
+		}
 		writeErr(w, http.StatusInternalServerError, "INTERNAL", err.Error())
 		return
 	}
-	_, _ = s.App.Broker.Subscribe(r.Context(), authCtx.Agent.ID, id)
 	writeJSON(w, http.StatusOK, map[string]any{"subscribed": true}, nil)
 }
 
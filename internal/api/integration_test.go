@@ -510,6 +510,155 @@ func TestMessageClaimAckAndRedelivery(t *testing.T) {
 	}
 }
 
+func TestDeadLetterListReplayAndPurge(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(tmp, "dlq.db")
+	cfg.Auth.Enabled = true
+
+	ctx := context.Background()
+	db, err := storage.Open(ctx, cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := storage.Migrate(ctx, db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	store := repos.New(db)
+	mem := broker.NewMemory(64)
+	app := service.New(cfg, store, mem)
+	_, adminKey, err := app.BootstrapInit(ctx, "admin")
+	if err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	worker, workerKey, err := app.CreateAgent(ctx, repos.CreateAgentInput{
+		Name:   "dlq-worker",
+		Type:   model.AgentTypeAI,
+		Status: model.AgentStatusActive,
+	}, "live", "agent")
+	if err != nil {
+		t.Fatalf("create worker: %v", err)
+	}
+
+	syncEngine := syncer.NewEngine(db, store)
+	handler := handlers.New(app, db, cfg, syncEngine)
+	hub := ws.NewHub(app, store)
+	router := api.NewRouter(handler, app, hub)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	timedOut := publishDirectMessage(t, ts.URL, adminKey, worker.ID, "timed-out")
+	rejected := publishDirectMessage(t, ts.URL, adminKey, worker.ID, "rejected")
+	for id, lastErr := range map[string]string{timedOut: "handler timeout after 30s", rejected: "schema rejected"} {
+		if _, err := db.ExecContext(ctx, `
+UPDATE message_receipts SET status = 'dead_letter', attempt = max_attempts, claim_attempts = 3, last_error = ?
+WHERE message_id = ?`, lastErr, id); err != nil {
+			t.Fatalf("dead-letter %s: %v", id, err)
+		}
+	}
+	if claims := claimMessages(t, ts.URL, workerKey, 10); len(claims) != 0 {
+		t.Fatalf("expected no claimable messages, got %d", len(claims))
+	}
+
+	type deadLetter struct {
+		ReceiptID     string `json:"receipt_id"`
+		Attempt       int    `json:"attempt"`
+		ClaimAttempts int    `json:"claim_attempts"`
+		LastError     string `json:"last_error"`
+		Message       struct {
+			ID string `json:"id"`
+		} `json:"message"`
+	}
+	listDeadLetters := func(query string) []deadLetter {
+		t.Helper()
+		resp := doJSON(t, http.MethodGet, ts.URL+"/api/v1/messages/dead-letter"+query, adminKey, nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("list dead letters status: %d", resp.StatusCode)
+		}
+		var env struct {
+			Data struct {
+				DeadLetters []deadLetter `json:"dead_letters"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+			t.Fatalf("decode dead letters: %v", err)
+		}
+		return env.Data.DeadLetters
+	}
+
+	if got := listDeadLetters("?agent_id=" + worker.ID); len(got) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(got))
+	}
+	byError := listDeadLetters("?last_error=timeout")
+	if len(byError) != 1 || byError[0].Message.ID != timedOut || byError[0].ClaimAttempts != 3 {
+		t.Fatalf("expected the timed out message only, got %+v", byError)
+	}
+
+	emptyResp := doJSON(t, http.MethodPost, ts.URL+"/api/v1/messages/dead-letter/purge", adminKey, map[string]any{})
+	emptyResp.Body.Close()
+	if emptyResp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an empty selection, got %d", emptyResp.StatusCode)
+	}
+
+	mailbox, _ := mem.GetMailbox(ctx, worker.ID, nil)
+	for len(mailbox) > 0 {
+		<-mailbox
+	}
+	replayResp := doJSON(t, http.MethodPost, ts.URL+"/api/v1/messages/dead-letter/replay", adminKey, map[string]any{
+		"receipt_ids": []string{byError[0].ReceiptID},
+	})
+	defer replayResp.Body.Close()
+	var replayed struct {
+		Data struct {
+			Replayed int `json:"replayed"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(replayResp.Body).Decode(&replayed); err != nil || replayed.Data.Replayed != 1 {
+		t.Fatalf("expected one replayed receipt, got %+v (%v)", replayed, err)
+	}
+	select {
+	case msg := <-mailbox:
+		if msg.ID != timedOut {
+			t.Fatalf("expected the replayed message pushed to the worker, got %s", msg.ID)
+		}
+	default:
+		t.Fatalf("expected the replayed message pushed to the worker's mailbox")
+	}
+	claims := claimMessages(t, ts.URL, workerKey, 10)
+	if len(claims) != 1 || claims[0].Message.ID != timedOut {
+		t.Fatalf("expected the replayed message to be claimable, got %+v", claims)
+	}
+
+	purgeResp := doJSON(t, http.MethodPost, ts.URL+"/api/v1/messages/dead-letter/purge", adminKey, map[string]any{"all": true})
+	defer purgeResp.Body.Close()
+	var purged struct {
+		Data struct {
+			Purged int `json:"purged"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(purgeResp.Body).Decode(&purged); err != nil || purged.Data.Purged != 1 {
+		t.Fatalf("expected one purged receipt, got %+v (%v)", purged, err)
+	}
+	if got := listDeadLetters(""); len(got) != 0 {
+		t.Fatalf("expected an empty dead-letter queue, got %d", len(got))
+	}
+	if _, err := store.GetMessageByID(ctx, rejected); err == nil {
+		t.Fatalf("expected purged message %s to be gone", rejected)
+	}
+	if _, err := store.GetMessageByID(ctx, timedOut); err != nil {
+		t.Fatalf("replayed message should survive the purge: %v", err)
+	}
+	for _, action := range []string{"messages.dead_letter_replay", "messages.dead_letter_purge"} {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_logs WHERE action = ?", action).Scan(&n); err != nil || n != 1 {
+			t.Fatalf("expected one %s audit entry, got %d (%v)", action, n, err)
+		}
+	}
+}
+
 func TestBroadcastMessageDelivery(t *testing.T) {
 	tmp := t.TempDir()
 	cfg := config.Default()
@@ -677,6 +826,134 @@ func TestGroupFanoutAndQueueSemantics(t *testing.T) {
 	}
 }
 
+func TestTopicSubscriptionFiltersSelectRecipients(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(tmp, "filters.db")
+	cfg.Auth.Enabled = true
+
+	ctx := context.Background()
+	db, err := storage.Open(ctx, cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := storage.Migrate(ctx, db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	store := repos.New(db)
+	app := service.New(cfg, store, broker.NewMemory(64))
+	_, adminKey, err := app.BootstrapInit(ctx, "admin")
+	if err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	keys, ids := map[string]string{}, map[string]string{}
+	for _, name := range []string{"review-bot", "release-bot", "watcher", "legacy"} {
+		agent, key, err := app.CreateAgent(ctx, repos.CreateAgentInput{
+			Name:   name,
+			Type:   model.AgentTypeAI,
+			Status: model.AgentStatusActive,
+		}, "live", "agent")
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		keys[name] = key
+		ids[name] = agent.ID
+	}
+
+	syncEngine := syncer.NewEngine(db, store)
+	router := api.NewRouter(handlers.New(app, db, cfg, syncEngine), app, ws.NewHub(app, store))
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp := doJSON(t, http.MethodPost, ts.URL+"/api/v1/topics", adminKey, map[string]any{"name": "repo-events", "is_public": true})
+	var created struct {
+		Data struct {
+			Topic model.Topic `json:"topic"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create topic status=%d err=%v", resp.StatusCode, err)
+	}
+	resp.Body.Close()
+	topicID := created.Data.Topic.ID
+
+	subscribe := func(name string, filter map[string]any) int {
+		t.Helper()
+		resp := doJSON(t, http.MethodPost, ts.URL+"/api/v1/topics/"+topicID+"/subscribe", keys[name], map[string]any{"filter": filter})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := subscribe("review-bot", map[string]any{"min_priority": "urgent"}); status != http.StatusBadRequest {
+		t.Fatalf("expected an unknown priority to be rejected, got status=%d", status)
+	}
+	if status := subscribe("review-bot", map[string]any{"tagz": []string{"review"}}); status != http.StatusBadRequest {
+		t.Fatalf("expected an unknown condition to be rejected, got status=%d", status)
+	}
+	resp = doJSON(t, http.MethodPost, ts.URL+"/api/v1/topics/"+topicID+"/subscribe", keys["review-bot"], map[string]any{"filter": "review"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a filter that is not an object to be rejected, got status=%d", resp.StatusCode)
+	}
+	// A filter stored without validation that cannot be read matches nothing.
+	if err := store.Subscribe(ctx, ids["legacy"], topicID, map[string]any{"tagz": []string{"review"}}); err != nil {
+		t.Fatalf("store unreadable filter: %v", err)
+	}
+	for name, filter := range map[string]map[string]any{
+		"review-bot":  {"tags": []string{"review"}, "min_priority": "high"},
+		"release-bot": {"metadata_prefix": map[string]any{"branch": "release/"}, "content_types": []string{"application/json"}},
+		"watcher":     {},
+	} {
+		if status := subscribe(name, filter); status != http.StatusOK {
+			t.Fatalf("subscribe %s status=%d", name, status)
+		}
+	}
+
+	publish := func(body map[string]any) string {
+		t.Helper()
+		body["topic_id"] = topicID
+		resp := doJSON(t, http.MethodPost, ts.URL+"/api/v1/messages", adminKey, body)
+		defer resp.Body.Close()
+		var env struct {
+			Data struct {
+				Message model.Message `json:"message"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&env); err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("publish status=%d err=%v", resp.StatusCode, err)
+		}
+		return env.Data.Message.ID
+	}
+	urgentReview := publish(map[string]any{"content": "review #12", "tags": []string{"review"}, "priority": "critical"})
+	lowReview := publish(map[string]any{"content": "review #13", "tags": []string{"review"}, "priority": "low"})
+	release := publish(map[string]any{
+		"content":      `{"tag":"v1.2.0"}`,
+		"content_type": "application/json",
+		"metadata":     map[string]any{"branch": "release/1.2"},
+	})
+	mainPush := publish(map[string]any{
+		"content":      `{"ref":"main"}`,
+		"content_type": "application/json",
+		"metadata":     map[string]any{"branch": "main"},
+	})
+
+	for name, want := range map[string][]string{
+		"review-bot":  {urgentReview},
+		"release-bot": {release},
+		"watcher":     {urgentReview, lowReview, release, mainPush},
+		"legacy":      nil,
+	} {
+		claims := claimMessages(t, ts.URL, keys[name], 10)
+		if len(claims) != len(want) {
+			t.Fatalf("%s expected %d messages, got %+v", name, len(want), claims)
+		}
+		for _, id := range want {
+			assertClaimIncludes(t, claims, id)
+		}
+	}
+}
+
 type claimEnvelope struct {
 	OK   bool `json:"ok"`
 	Data struct {
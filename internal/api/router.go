@@ -72,16 +72,31 @@ func NewRouter(server *handlers.Server, app *service.App, hub *ws.Hub) http.Hand
 			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/messages/broadcast", server.BroadcastMessage)
 			protected.With(apimw.RequirePermission(app, "messages", "read")).Get("/messages/inbox", server.Inbox)
 			protected.With(apimw.RequirePermission(app, "messages", "read")).Get("/messages", server.Inbox)
+			protected.With(apimw.RequirePermission(app, "messages", "manage")).Get("/messages/dead-letter", server.ListDeadLetters)
+			protected.With(apimw.RequirePermission(app, "messages", "manage")).Post("/messages/dead-letter/replay", server.ReplayDeadLetters)
+			protected.With(apimw.RequirePermission(app, "messages", "manage")).Post("/messages/dead-letter/purge", server.PurgeDeadLetters)
 			protected.With(apimw.RequirePermission(app, "messages", "read")).Get("/messages/{id}", server.GetMessage)
 			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/messages/ack", server.Ack)
 			protected.With(apimw.RequirePermission(app, "messages", "read")).Post("/messages/claim", server.ClaimMessages)
+			protected.With(apimw.RequirePermission(app, "messages", "read")).Get("/messages/lag", server.MessageLag)
 			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/messages/{id}/ack", server.AckMessageClaim)
 			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/messages/{id}/nack", server.NackMessageClaim)
 			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/messages/{id}/renew", server.RenewMessageClaim)
 			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/messages/{id}/read", server.MarkRead)
+			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/messages/{id}/cancel", server.CancelScheduledMessage)
 			protected.With(apimw.RequirePermission(app, "messages", "read")).Get("/messages/{id}/thread", server.MessageThread)
 			protected.With(apimw.RequirePermission(app, "messages", "write")).Delete("/messages/{id}", server.DeleteMessage)
 
+			// Message schedules
+			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/schedules", server.CreateMessageSchedule)
+			protected.With(apimw.RequirePermission(app, "messages", "read")).Get("/schedules", server.ListMessageSchedules)
+			protected.With(apimw.RequirePermission(app, "messages", "read")).Get("/schedules/{id}", server.GetMessageSchedule)
+			protected.With(apimw.RequirePermission(app, "messages", "write")).Delete("/schedules/{id}", server.DeleteMessageSchedule)
+			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/schedules/{id}/pause", server.PauseMessageSchedule)
+			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/schedules/{id}/resume", server.ResumeMessageSchedule)
+			protected.With(apimw.RequirePermission(app, "messages", "write")).Post("/schedules/{id}/run", server.RunMessageSchedule)
+			protected.With(apimw.RequirePermission(app, "messages", "read")).Get("/schedules/{id}/runs", server.ListMessageScheduleRuns)
+
 			// Knowledge
 			protected.With(apimw.RequirePermission(app, "knowledge", "write")).Post("/knowledge", server.CreateKnowledge)
 			protected.With(apimw.RequirePermission(app, "knowledge", "read")).Get("/knowledge", server.ListKnowledge)
@@ -125,10 +140,15 @@ func NewRouter(server *handlers.Server, app *service.App, hub *ws.Hub) http.Hand
 			protected.With(apimw.RequirePermission(app, "sync", "write")).Post("/sync/pull", server.Pull)
 			protected.With(apimw.RequirePermission(app, "sync", "read")).Get("/sync/status", server.SyncStatus)
 			protected.With(apimw.RequirePermission(app, "sync", "read")).Get("/sync/logs", server.SyncLogs)
+			protected.With(apimw.RequirePermission(app, "sync", "read")).Get("/sync/identity", server.SyncIdentity)
+			protected.With(apimw.RequirePermission(app, "sync", "read")).Get("/sync/peers", server.SyncPeers)
 			protected.With(apimw.RequirePermission(app, "sync", "read")).Get("/sync/diff", server.SyncDiff)
+			protected.With(apimw.RequirePermission(app, "sync", "read")).Get("/sync/export", server.SyncExport)
+			protected.With(apimw.RequirePermission(app, "sync", "write")).Post("/sync/import", server.SyncImport)
 			protected.With(apimw.RequirePermission(app, "sync", "write")).Post("/sync/diff", server.SyncDiff)
 			protected.With(apimw.RequirePermission(app, "sync", "write")).Post("/sync/push/inbound", server.InboundPush)
 			protected.With(apimw.RequirePermission(app, "sync", "write")).Post("/sync/pull/inbound", server.InboundPull)
+			protected.With(apimw.RequirePermission(app, "sync", "write")).Get("/sync/live", server.SyncLive)
 			protected.With(apimw.RequirePermission(app, "sync", "read")).Get("/sync/conflicts", server.ListConflicts)
 			protected.With(apimw.RequirePermission(app, "sync", "write")).Post("/sync/conflicts/{id}/resolve", server.ResolveConflict)
 
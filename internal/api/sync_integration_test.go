@@ -0,0 +1,1721 @@
+package api_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"opencortex/internal/api"
+	"opencortex/internal/api/handlers"
+	ws "opencortex/internal/api/websocket"
+	"opencortex/internal/broker"
+	"opencortex/internal/config"
+	"opencortex/internal/model"
+	"opencortex/internal/service"
+	"opencortex/internal/storage"
+	"opencortex/internal/storage/repos"
+	syncer "opencortex/internal/sync"
+)
+
+type syncNode struct {
+	URL    string
+	Key    string
+	App    *service.App
+	Store  *repos.Store
+	Engine *syncer.Engine
+}
+
+func startSyncNode(t *testing.T, name string) *syncNode {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(t.TempDir(), name+".db")
+	cfg.Auth.Enabled = true
+
+	ctx := context.Background()
+	db, err := storage.Open(ctx, cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := storage.Migrate(ctx, db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	store := repos.New(db)
+	app := service.New(cfg, store, broker.NewMemory(64))
+	_, adminKey, err := app.BootstrapInit(ctx, "admin")
+	if err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	engine := syncer.NewEngine(db, store)
+	engine.RemoteKey = app.RemoteAPIKey
+	engine.Identity = app.NodeIdentity
+	engine.Changes = app.WatchChanges
+	engine.Publish = app.PublishSynced
+	router := api.NewRouter(handlers.New(app, db, cfg, engine), app, ws.NewHub(app, store))
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return &syncNode{URL: ts.URL, Key: adminKey, App: app, Store: store, Engine: engine}
+}
+
+func addSyncRemote(t *testing.T, from, to *syncNode, name string) {
+	t.Helper()
+	addSyncRemoteWithStrategy(t, from, to, name, "")
+}
+
+func addSyncRemoteWithStrategy(t *testing.T, from, to *syncNode, name, strategy string) {
+	t.Helper()
+	resp := doJSON(t, http.MethodPost, from.URL+"/api/v1/sync/remotes", from.Key, map[string]any{
+		"name":              name,
+		"url":               to.URL,
+		"api_key":           to.Key,
+		"conflict_strategy": strategy,
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add remote status=%d", resp.StatusCode)
+	}
+}
+
+func syncPush(t *testing.T, node *syncNode, remote, remoteKey string) map[string]any {
+	t.Helper()
+	return runSync(t, node, "push", remote, remoteKey)
+}
+
+func syncPull(t *testing.T, node *syncNode, remote, remoteKey string) map[string]any {
+	t.Helper()
+	return runSync(t, node, "pull", remote, remoteKey)
+}
+
+func runSync(t *testing.T, node *syncNode, direction, remote, remoteKey string) map[string]any {
+	t.Helper()
+	resp := doJSON(t, http.MethodPost, node.URL+"/api/v1/sync/"+direction, node.Key, map[string]any{
+		"remote":  remote,
+		"scope":   "full",
+		"api_key": remoteKey,
+	})
+	defer resp.Body.Close()
+	var env struct {
+		OK   bool `json:"ok"`
+		Data struct {
+			SyncLog map[string]any `json:"sync_log"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		t.Fatalf("decode %s: %v", direction, err)
+	}
+	if resp.StatusCode != http.StatusOK || !env.OK {
+		t.Fatalf("%s status=%d body=%+v", direction, resp.StatusCode, env)
+	}
+	return env.Data.SyncLog
+}
+
+func createKnowledgeOn(t *testing.T, node *syncNode, title, content string) string {
+	t.Helper()
+	resp := doJSON(t, http.MethodPost, node.URL+"/api/v1/knowledge", node.Key, map[string]any{
+		"title":   title,
+		"content": content,
+		"tags":    []string{"sync"},
+	})
+	defer resp.Body.Close()
+	var env struct {
+		Data struct {
+			Knowledge struct {
+				ID string `json:"id"`
+			} `json:"knowledge"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		t.Fatalf("decode knowledge: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated || env.Data.Knowledge.ID == "" {
+		t.Fatalf("create knowledge status=%d", resp.StatusCode)
+	}
+	return env.Data.Knowledge.ID
+}
+
+// createOn posts body to path on node and returns the id of the created
+// record found under field in the response.
+func createOn(t *testing.T, node *syncNode, path, field string, body map[string]any) string {
+	t.Helper()
+	resp := doJSON(t, http.MethodPost, node.URL+path, node.Key, body)
+	defer resp.Body.Close()
+	var env struct {
+		Data map[string]struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	if resp.StatusCode != http.StatusCreated || env.Data[field].ID == "" {
+		t.Fatalf("create %s status=%d", path, resp.StatusCode)
+	}
+	return env.Data[field].ID
+}
+
+func TestSyncPushMaterializesEntitiesOnPeer(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemote(t, nodeA, nodeB, "node-b")
+
+	knowledgeID := createKnowledgeOn(t, nodeA, "Runbook", "restart the quokka service")
+	topicResp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/topics", nodeA.Key, map[string]any{"name": "ops"})
+	var topicEnv struct {
+		Data struct {
+			Topic struct {
+				ID string `json:"id"`
+			} `json:"topic"`
+		} `json:"data"`
+	}
+	_ = json.NewDecoder(topicResp.Body).Decode(&topicEnv)
+	_ = topicResp.Body.Close()
+	msgResp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/messages", nodeA.Key, map[string]any{
+		"topic_id": topicEnv.Data.Topic.ID,
+		"content":  "deploy finished",
+	})
+	var msgEnv struct {
+		Data struct {
+			Message struct {
+				ID string `json:"id"`
+			} `json:"message"`
+		} `json:"data"`
+	}
+	_ = json.NewDecoder(msgResp.Body).Decode(&msgEnv)
+	_ = msgResp.Body.Close()
+
+	log := syncPush(t, nodeA, "node-b", nodeB.Key)
+	if log["status"] != "success" {
+		t.Fatalf("expected success, got %+v", log)
+	}
+	if pushed, _ := log["items_pushed"].(float64); pushed < 3 {
+		t.Fatalf("expected at least 3 items pushed, got %+v", log)
+	}
+
+	entry, err := nodeB.Store.GetKnowledge(ctx, knowledgeID)
+	if err != nil {
+		t.Fatalf("knowledge not materialized on peer: %v", err)
+	}
+	if entry.Content != "restart the quokka service" || entry.Title != "Runbook" {
+		t.Fatalf("unexpected knowledge on peer: %+v", entry)
+	}
+	history, err := nodeB.Store.KnowledgeHistory(ctx, knowledgeID)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("expected one version row on peer, got %d (%v)", len(history), err)
+	}
+	found, _, err := nodeB.Store.SearchKnowledge(ctx, repos.KnowledgeFilters{Query: "quokka"})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("expected synced entry to be searchable, got %d (%v)", len(found), err)
+	}
+	if _, err := nodeB.Store.GetTopicByID(ctx, topicEnv.Data.Topic.ID); err != nil {
+		t.Fatalf("topic not materialized on peer: %v", err)
+	}
+	if _, err := nodeB.Store.GetMessageByID(ctx, msgEnv.Data.Message.ID); err != nil {
+		t.Fatalf("message not materialized on peer: %v", err)
+	}
+
+	// A newer edit on A updates B and records a new version there.
+	time.Sleep(5 * time.Millisecond)
+	resp := doJSON(t, http.MethodPut, nodeA.URL+"/api/v1/knowledge/"+knowledgeID, nodeA.Key, map[string]any{
+		"content": "restart the quokka service twice",
+	})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update knowledge status=%d", resp.StatusCode)
+	}
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+	entry, _ = nodeB.Store.GetKnowledge(ctx, knowledgeID)
+	if entry.Content != "restart the quokka service twice" || entry.Version != 2 {
+		t.Fatalf("expected updated entry at version 2 on peer, got %+v", entry)
+	}
+
+	// A newer edit on B wins under the default latest-wins strategy.
+	time.Sleep(5 * time.Millisecond)
+	if _, err := nodeB.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: knowledgeID, Content: "peer edit", UpdatedBy: entry.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update on peer: %v", err)
+	}
+	log = syncPush(t, nodeA, "node-b", nodeB.Key)
+	if log["status"] != "success" || log["conflicts"].(float64) != 0 {
+		t.Fatalf("expected latest-wins to settle the conflict, got %+v", log)
+	}
+	entry, _ = nodeB.Store.GetKnowledge(ctx, knowledgeID)
+	if entry.Content != "peer edit" {
+		t.Fatalf("peer copy should be untouched, got %q", entry.Content)
+	}
+	conflicts, err := nodeA.Store.ListOpenConflicts(ctx)
+	if err != nil || len(conflicts) != 0 {
+		t.Fatalf("expected no open conflicts, got %+v (%v)", conflicts, err)
+	}
+}
+
+func TestSyncedMessagesReachLocalSubscribers(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemote(t, nodeA, nodeB, "node-b")
+
+	topicID := createOn(t, nodeA, "/api/v1/topics", "topic", map[string]any{"name": "ops"})
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+
+	subscribe := func(name string, filter map[string]any) string {
+		agent, err := nodeB.Store.CreateAgent(ctx, repos.CreateAgentInput{ID: "agent-" + name, Name: name, Type: model.AgentTypeAI, APIKeyHash: "hash-" + name})
+		if err != nil {
+			t.Fatalf("create agent: %v", err)
+		}
+		if err := nodeB.Store.Subscribe(ctx, agent.ID, topicID, filter); err != nil {
+			t.Fatalf("subscribe: %v", err)
+		}
+		return agent.ID
+	}
+	everything := subscribe("everything", nil)
+	urgentOnly := subscribe("urgent-only", map[string]any{"min_priority": "high"})
+
+	messageID := createOn(t, nodeA, "/api/v1/messages", "message", map[string]any{"topic_id": topicID, "content": "deploy finished"})
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+
+	inbox, _, err := nodeB.Store.ListInbox(ctx, everything, repos.MessageFilters{})
+	if err != nil || len(inbox) != 1 || inbox[0].ID != messageID {
+		t.Fatalf("expected the synced message in the subscriber's inbox, got %+v (%v)", inbox, err)
+	}
+	if inbox, _, _ := nodeB.Store.ListInbox(ctx, urgentOnly, repos.MessageFilters{}); len(inbox) != 0 {
+		t.Fatalf("expected the subscriber's filter to hold back the synced message, got %+v", inbox)
+	}
+}
+
+func TestSyncConflictStrategies(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemoteWithStrategy(t, nodeA, nodeB, "manual", "manual")
+	addSyncRemoteWithStrategy(t, nodeA, nodeB, "remote", "remote-wins")
+	addSyncRemoteWithStrategy(t, nodeA, nodeB, "fork", "fork")
+
+	id := createKnowledgeOn(t, nodeB, "Policy", "v1")
+	syncPull(t, nodeA, "manual", nodeB.Key)
+	local, err := nodeA.Store.GetKnowledge(ctx, id)
+	if err != nil {
+		t.Fatalf("initial pull: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := nodeB.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: "remote v2", UpdatedBy: local.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update on peer: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := nodeA.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: "local v2", UpdatedBy: local.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update locally: %v", err)
+	}
+
+	// Manual leaves the conflict open and the local copy untouched.
+	log := syncPull(t, nodeA, "manual", nodeB.Key)
+	if log["status"] != "partial" || log["conflicts"].(float64) != 1 {
+		t.Fatalf("expected an open conflict under manual, got %+v", log)
+	}
+	if conflicts, _ := nodeA.Store.ListOpenConflicts(ctx); len(conflicts) != 1 || conflicts[0].Strategy != "manual" {
+		t.Fatalf("expected one open manual conflict, got %+v", conflicts)
+	}
+
+	// Fork keeps the local entry and adds the remote copy as a sibling, once.
+	syncPull(t, nodeA, "fork", nodeB.Key)
+	syncPull(t, nodeA, "fork", nodeB.Key)
+	if entry, _ := nodeA.Store.GetKnowledge(ctx, id); entry.Content != "local v2" {
+		t.Fatalf("fork must keep the local copy, got %q", entry.Content)
+	}
+	found, _, err := nodeA.Store.SearchKnowledge(ctx, repos.KnowledgeFilters{Query: "remote"})
+	if err != nil || len(found) != 1 || found[0].ID == id || found[0].Metadata["forked_from"] != id {
+		t.Fatalf("expected a single forked sibling, got %+v (%v)", found, err)
+	}
+
+	// Remote-wins overwrites even though the local edit is newer.
+	log = syncPull(t, nodeA, "remote", nodeB.Key)
+	if log["status"] != "success" {
+		t.Fatalf("expected remote-wins to settle the conflict, got %+v", log)
+	}
+	if entry, _ := nodeA.Store.GetKnowledge(ctx, id); entry.Content != "remote v2" {
+		t.Fatalf("expected remote copy to win, got %q", entry.Content)
+	}
+}
+
+func TestSyncInboundConflictsFollowTheReceiversStrategy(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	// node-a asks node-b to let node-a's copies win.
+	addSyncRemoteWithStrategy(t, nodeA, nodeB, "node-b", "local-wins")
+
+	id := createKnowledgeOn(t, nodeA, "Policy", "v1")
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+	diverge := func(peer, origin string) {
+		t.Helper()
+		entry, err := nodeA.Store.GetKnowledge(ctx, id)
+		if err != nil {
+			t.Fatalf("get knowledge: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if _, err := nodeB.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{ID: id, Content: peer, UpdatedBy: entry.UpdatedBy}); err != nil {
+			t.Fatalf("update on peer: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if _, err := nodeA.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{ID: id, Content: origin, UpdatedBy: entry.UpdatedBy}); err != nil {
+			t.Fatalf("update locally: %v", err)
+		}
+	}
+
+	// node-b cannot place the caller, so the conflict is left for review.
+	diverge("peer v2", "origin v2")
+	log := syncPush(t, nodeA, "node-b", nodeB.Key)
+	if log["conflicts"].(float64) != 1 {
+		t.Fatalf("expected the conflict left open for an unknown caller, got %+v", log)
+	}
+	if entry, _ := nodeB.Store.GetKnowledge(ctx, id); entry.Content != "peer v2" {
+		t.Fatalf("an unknown caller must not overwrite local edits, got %q", entry.Content)
+	}
+
+	// Once node-b pins node-a, its own strategy for node-a applies.
+	resp := doJSON(t, http.MethodPost, nodeB.URL+"/api/v1/sync/remotes", nodeB.Key, map[string]any{
+		"name":              "node-a",
+		"url":               nodeA.URL,
+		"peer_public_key":   syncIdentityOf(t, nodeA),
+		"conflict_strategy": "local-wins",
+	})
+	_ = resp.Body.Close()
+	diverge("peer v3", "origin v3")
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+	if entry, _ := nodeB.Store.GetKnowledge(ctx, id); entry.Content != "peer v3" {
+		t.Fatalf("expected node-b's local-wins to keep its copy, got %q", entry.Content)
+	}
+}
+
+func TestSyncPullAppliesRemoteEntities(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemote(t, nodeA, nodeB, "node-b")
+
+	first := createKnowledgeOn(t, nodeB, "Onboarding", "read the handbook")
+	second := createKnowledgeOn(t, nodeB, "Escalation", "page the on-call")
+
+	log := syncPull(t, nodeA, "node-b", nodeB.Key)
+	if log["status"] != "success" {
+		t.Fatalf("expected success, got %+v", log)
+	}
+	if pulled, _ := log["items_pulled"].(float64); pulled < 2 {
+		t.Fatalf("expected items_pulled to count applied entities, got %+v", log)
+	}
+	for _, id := range []string{first, second} {
+		if _, err := nodeA.Store.GetKnowledge(ctx, id); err != nil {
+			t.Fatalf("knowledge %s not pulled: %v", id, err)
+		}
+	}
+	if conflicts, _ := nodeA.Store.ListOpenConflicts(ctx); len(conflicts) != 0 {
+		t.Fatalf("pull should not record conflicts for new entities, got %+v", conflicts)
+	}
+
+	// Nothing changed remotely, so a second pull writes nothing.
+	log = syncPull(t, nodeA, "node-b", nodeB.Key)
+	if pulled, _ := log["items_pulled"].(float64); pulled != 0 {
+		t.Fatalf("expected nothing pulled on second run, got %+v", log)
+	}
+}
+
+func TestSyncResolveConflictWritesChosenVersion(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemoteWithStrategy(t, nodeA, nodeB, "node-b", "manual")
+
+	id := createKnowledgeOn(t, nodeB, "Guide", "line one\nline two\n")
+	syncPull(t, nodeA, "node-b", nodeB.Key)
+	local, _ := nodeA.Store.GetKnowledge(ctx, id)
+	if _, err := nodeB.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: "line one\nline two from b\n", UpdatedBy: local.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update on peer: %v", err)
+	}
+	if _, err := nodeA.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: "line one\nline two from a\n", UpdatedBy: local.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update locally: %v", err)
+	}
+	syncPull(t, nodeA, "node-b", nodeB.Key)
+	conflicts, _ := nodeA.Store.ListOpenConflicts(ctx)
+	if len(conflicts) != 1 {
+		t.Fatalf("expected one open conflict, got %+v", conflicts)
+	}
+	conflict := conflicts[0]
+	if !strings.Contains(conflict.Diff, "-line two from a") || !strings.Contains(conflict.Diff, "+line two from b") {
+		t.Fatalf("expected a content diff on the conflict, got %q", conflict.Diff)
+	}
+
+	// Without a key on the request the remote copy is fetched with the key
+	// stored for the remote.
+	resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/conflicts/"+conflict.ID+"/resolve", nodeA.Key, map[string]any{
+		"strategy": "remote-wins",
+		"note":     "b has the reviewed text",
+	})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("resolve status=%d", resp.StatusCode)
+	}
+	entry, _ := nodeA.Store.GetKnowledge(ctx, id)
+	if entry.Content != "line one\nline two from b\n" {
+		t.Fatalf("expected remote content after resolution, got %q", entry.Content)
+	}
+	history, _ := nodeA.Store.KnowledgeHistory(ctx, id)
+	latest := history[0]
+	for _, v := range history {
+		if v.Version > latest.Version {
+			latest = v
+		}
+	}
+	if latest.ChangeNote == nil || !strings.Contains(*latest.ChangeNote, conflict.ID) {
+		t.Fatalf("expected change note naming the conflict, got %+v", latest)
+	}
+	resolved, err := nodeA.Store.GetSyncConflict(ctx, conflict.ID)
+	if err != nil || resolved.Status != "resolved" || resolved.RemotePayload["content"] != "line one\nline two from b\n" {
+		t.Fatalf("expected resolved conflict with remote payload, got %+v (%v)", resolved, err)
+	}
+}
+
+func TestSyncMergeStrategyCombinesOfflineEdits(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemoteWithStrategy(t, nodeA, nodeB, "node-b", "merge")
+
+	base := "# Handbook\n\nintro\n\n## Setup\ninstall\n\n## Usage\nrun\n"
+	id := createKnowledgeOn(t, nodeB, "Handbook", base)
+	syncPull(t, nodeA, "node-b", nodeB.Key)
+	local, err := nodeA.Store.GetKnowledge(ctx, id)
+	if err != nil {
+		t.Fatalf("initial pull: %v", err)
+	}
+
+	if _, err := nodeA.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: strings.Replace(base, "intro", "intro written on a", 1), UpdatedBy: local.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update locally: %v", err)
+	}
+	if _, err := nodeB.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: strings.Replace(base, "run\n", "run\nrun again\n", 1), UpdatedBy: local.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update on peer: %v", err)
+	}
+
+	want := "# Handbook\n\nintro written on a\n\n## Setup\ninstall\n\n## Usage\nrun\nrun again\n"
+	log := syncPull(t, nodeA, "node-b", nodeB.Key)
+	if log["status"] != "success" {
+		t.Fatalf("expected clean merge on pull, got %+v", log)
+	}
+	if entry, _ := nodeA.Store.GetKnowledge(ctx, id); entry.Content != want {
+		t.Fatalf("unexpected merged content on a:\n%s", entry.Content)
+	}
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+	if entry, _ := nodeB.Store.GetKnowledge(ctx, id); entry.Content != want {
+		t.Fatalf("expected peer to converge on the merge, got:\n%s", entry.Content)
+	}
+
+	// Edits to the same line cannot be merged and stay open for a human.
+	syncPull(t, nodeA, "node-b", nodeB.Key)
+	if _, err := nodeA.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: strings.Replace(want, "install", "install with make", 1), UpdatedBy: local.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update locally: %v", err)
+	}
+	if _, err := nodeB.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: strings.Replace(want, "install", "install with go", 1), UpdatedBy: local.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update on peer: %v", err)
+	}
+	log = syncPull(t, nodeA, "node-b", nodeB.Key)
+	if log["status"] != "partial" || log["conflicts"].(float64) != 1 {
+		t.Fatalf("expected overlapping edits to raise a conflict, got %+v", log)
+	}
+}
+
+func TestSyncExchangesOnlyChangesSinceCursor(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemote(t, nodeA, nodeB, "node-b")
+
+	createKnowledgeOn(t, nodeA, "First", "first entry")
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+	manifest, err := nodeA.Store.GetRemote(ctx, "node-b")
+	if err != nil {
+		t.Fatalf("get remote: %v", err)
+	}
+	if manifest.PushCursor == 0 {
+		t.Fatalf("expected push cursor to be recorded, got %+v", manifest)
+	}
+
+	secondID := createKnowledgeOn(t, nodeA, "Second", "second entry")
+	changed, err := syncer.BuildManifestSince(ctx, nodeA.Engine.DB, "full", syncer.Selection{}, manifest.PushCursor)
+	if err != nil {
+		t.Fatalf("build manifest: %v", err)
+	}
+	if len(changed) != 1 || changed[0].ID != secondID {
+		t.Fatalf("expected only the new entry since the cursor, got %+v", changed)
+	}
+	log := syncPush(t, nodeA, "node-b", nodeB.Key)
+	if pushed, _ := log["items_pushed"].(float64); pushed != 1 {
+		t.Fatalf("expected one item pushed, got %+v", log)
+	}
+	log = syncPush(t, nodeA, "node-b", nodeB.Key)
+	if pushed, _ := log["items_pushed"].(float64); pushed != 0 {
+		t.Fatalf("expected nothing to push, got %+v", log)
+	}
+
+	// Entries A pushed are not pulled back; only B's own edits come over.
+	thirdID := createKnowledgeOn(t, nodeB, "Third", "written on b")
+	log = syncPull(t, nodeA, "node-b", nodeB.Key)
+	if pulled, _ := log["items_pulled"].(float64); pulled != 1 {
+		t.Fatalf("expected one item pulled, got %+v", log)
+	}
+	if _, err := nodeA.Store.GetKnowledge(ctx, thirdID); err != nil {
+		t.Fatalf("entry from b not pulled: %v", err)
+	}
+	log = syncPull(t, nodeA, "node-b", nodeB.Key)
+	if pulled, _ := log["items_pulled"].(float64); pulled != 0 {
+		t.Fatalf("expected nothing to pull, got %+v", log)
+	}
+	manifest, err = nodeA.Store.GetRemote(ctx, "node-b")
+	if err != nil || manifest.PullCursor == 0 {
+		t.Fatalf("expected pull cursor to be recorded, got %+v (%v)", manifest, err)
+	}
+
+	// A forced sync compares full manifests but still finds nothing new.
+	resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/push", nodeA.Key, map[string]any{
+		"remote":  "node-b",
+		"scope":   "full",
+		"api_key": nodeB.Key,
+		"force":   true,
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("forced push status=%d", resp.StatusCode)
+	}
+}
+
+func TestSyncPropagatesDeletionsWithTombstones(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemote(t, nodeA, nodeB, "node-b")
+
+	keptID := createKnowledgeOn(t, nodeA, "Kept", "stays")
+	goneID := createKnowledgeOn(t, nodeA, "Gone", "deleted on a")
+	pulledID := createKnowledgeOn(t, nodeA, "Pulled", "deleted on b")
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+
+	// A deletes one entry; pulling before pushing must not bring it back.
+	if err := nodeA.Store.DeleteKnowledge(ctx, goneID); err != nil {
+		t.Fatalf("delete on a: %v", err)
+	}
+	syncPull(t, nodeA, "node-b", nodeB.Key)
+	if _, err := nodeA.Store.GetKnowledge(ctx, goneID); err == nil {
+		t.Fatalf("deleted entry was resurrected by pull")
+	}
+	log := syncPush(t, nodeA, "node-b", nodeB.Key)
+	if pushed, _ := log["items_pushed"].(float64); pushed != 1 {
+		t.Fatalf("expected the deletion to be pushed, got %+v", log)
+	}
+	if _, err := nodeB.Store.GetKnowledge(ctx, goneID); err == nil {
+		t.Fatalf("deletion did not reach b")
+	}
+	nodeAID, err := nodeA.Store.NodeID(ctx)
+	if err != nil {
+		t.Fatalf("node id: %v", err)
+	}
+	tombstones, err := nodeB.Store.ListTombstones(ctx, 0, nil)
+	if err != nil || len(tombstones) != 1 {
+		t.Fatalf("expected one tombstone on b, got %+v (%v)", tombstones, err)
+	}
+	if tombstones[0].EntityID != goneID || tombstones[0].OriginNode != nodeAID {
+		t.Fatalf("tombstone should carry a's node as origin, got %+v", tombstones[0])
+	}
+
+	// A deletion on B reaches A through a pull.
+	if err := nodeB.Store.DeleteKnowledge(ctx, pulledID); err != nil {
+		t.Fatalf("delete on b: %v", err)
+	}
+	syncPull(t, nodeA, "node-b", nodeB.Key)
+	if _, err := nodeA.Store.GetKnowledge(ctx, pulledID); err == nil {
+		t.Fatalf("deletion on b did not reach a")
+	}
+	if _, err := nodeA.Store.GetKnowledge(ctx, keptID); err != nil {
+		t.Fatalf("unrelated entry removed: %v", err)
+	}
+
+	purged, err := nodeA.Store.PurgeTombstones(ctx, time.Now().Add(time.Hour))
+	if err != nil || purged != 2 {
+		t.Fatalf("expected both tombstones purged on a, got %d (%v)", purged, err)
+	}
+}
+
+func TestSyncHonorsTopicAndCollectionSelection(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemote(t, nodeA, nodeB, "node-b")
+
+	wantedTopic := createOn(t, nodeA, "/api/v1/topics", "topic", map[string]any{"name": "wanted"})
+	otherTopic := createOn(t, nodeA, "/api/v1/topics", "topic", map[string]any{"name": "other"})
+	wantedMsg := createOn(t, nodeA, "/api/v1/messages", "message", map[string]any{"topic_id": wantedTopic, "content": "in scope"})
+	otherMsg := createOn(t, nodeA, "/api/v1/messages", "message", map[string]any{"topic_id": otherTopic, "content": "out of scope"})
+	collection := createOn(t, nodeA, "/api/v1/collections", "collection", map[string]any{"name": "runbooks"})
+	wantedEntry := createOn(t, nodeA, "/api/v1/knowledge", "knowledge", map[string]any{
+		"title": "Scoped", "content": "in a synced collection", "collection_id": collection,
+	})
+	otherEntry := createKnowledgeOn(t, nodeA, "Loose", "outside any collection")
+
+	resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/push", nodeA.Key, map[string]any{
+		"remote":         "node-b",
+		"scope":          "topics",
+		"topic_ids":      []string{wantedTopic},
+		"collection_ids": []string{collection},
+		"api_key":        nodeB.Key,
+	})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("push status=%d", resp.StatusCode)
+	}
+
+	if _, err := nodeB.Store.GetTopicByID(ctx, wantedTopic); err != nil {
+		t.Fatalf("selected topic not synced: %v", err)
+	}
+	if _, err := nodeB.Store.GetMessageByID(ctx, wantedMsg); err != nil {
+		t.Fatalf("message of selected topic not synced: %v", err)
+	}
+	if _, err := nodeB.Store.GetKnowledge(ctx, wantedEntry); err != nil {
+		t.Fatalf("knowledge of selected collection not synced: %v", err)
+	}
+	if _, err := nodeB.Store.GetTopicByID(ctx, otherTopic); err == nil {
+		t.Fatalf("unselected topic was synced")
+	}
+	if _, err := nodeB.Store.GetMessageByID(ctx, otherMsg); err == nil {
+		t.Fatalf("message of unselected topic was synced")
+	}
+	if _, err := nodeB.Store.GetKnowledge(ctx, otherEntry); err == nil {
+		t.Fatalf("knowledge outside the selected collections was synced")
+	}
+}
+
+func TestSyncCollectionScopeIncludesDescendants(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemote(t, nodeA, nodeB, "node-b")
+
+	parent := createOn(t, nodeA, "/api/v1/collections", "collection", map[string]any{
+		"name": "engineering", "metadata": map[string]any{"team": "platform"},
+	})
+	child := createOn(t, nodeA, "/api/v1/collections", "collection", map[string]any{"name": "runbooks", "parent_id": parent})
+	other := createOn(t, nodeA, "/api/v1/collections", "collection", map[string]any{"name": "personal"})
+	nested := createOn(t, nodeA, "/api/v1/knowledge", "knowledge", map[string]any{
+		"title": "Restart", "content": "restart the service", "collection_id": child,
+	})
+	unrelated := createOn(t, nodeA, "/api/v1/knowledge", "knowledge", map[string]any{
+		"title": "Notes", "content": "private notes", "collection_id": other,
+	})
+
+	resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/push", nodeA.Key, map[string]any{
+		"remote":         "node-b",
+		"scope":          "collections",
+		"collection_ids": []string{parent},
+		"api_key":        nodeB.Key,
+	})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("push status=%d", resp.StatusCode)
+	}
+
+	gotParent, err := nodeB.Store.GetCollection(ctx, parent)
+	if err != nil || gotParent.Name != "engineering" || gotParent.Metadata["team"] != "platform" {
+		t.Fatalf("parent collection not synced: %+v (%v)", gotParent, err)
+	}
+	gotChild, err := nodeB.Store.GetCollection(ctx, child)
+	if err != nil || gotChild.ParentID == nil || *gotChild.ParentID != parent {
+		t.Fatalf("child collection not synced under its parent: %+v (%v)", gotChild, err)
+	}
+	entry, err := nodeB.Store.GetKnowledge(ctx, nested)
+	if err != nil || entry.CollectionID == nil || *entry.CollectionID != child {
+		t.Fatalf("knowledge in descendant collection not synced with its collection: %+v (%v)", entry, err)
+	}
+	if _, err := nodeB.Store.GetCollection(ctx, other); err == nil {
+		t.Fatalf("collection outside the scope was synced")
+	}
+	if _, err := nodeB.Store.GetKnowledge(ctx, unrelated); err == nil {
+		t.Fatalf("knowledge outside the scope was synced")
+	}
+
+	// Renaming the child on A reaches B on the next sync.
+	resp = doJSON(t, http.MethodPatch, nodeA.URL+"/api/v1/collections/"+child, nodeA.Key, map[string]any{"name": "playbooks"})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename status=%d", resp.StatusCode)
+	}
+	resp = doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/push", nodeA.Key, map[string]any{
+		"remote":         "node-b",
+		"scope":          "collections",
+		"collection_ids": []string{parent},
+		"api_key":        nodeB.Key,
+	})
+	_ = resp.Body.Close()
+	if gotChild, err = nodeB.Store.GetCollection(ctx, child); err != nil || gotChild.Name != "playbooks" {
+		t.Fatalf("collection rename not synced: %+v (%v)", gotChild, err)
+	}
+}
+
+func TestSyncSchedulesRuntimeRemotesWithStoredKey(t *testing.T) {
+	nodeA := startSyncNode(t, "node-a")
+	nodeB := startSyncNode(t, "node-b")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scheduler := syncer.NewScheduler(nodeA.Engine)
+	if err := scheduler.Reload(ctx); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	scheduler.Watch(ctx, nodeA.App.RemotesChanged)
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	first := createKnowledgeOn(t, nodeA, "Scheduled", "picked up without a restart")
+	resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/remotes", nodeA.Key, map[string]any{
+		"name":      "node-b",
+		"url":       nodeB.URL,
+		"api_key":   nodeB.Key,
+		"direction": "push",
+		"schedule":  "@every 1s",
+	})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add remote status=%d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := nodeB.Store.GetKnowledge(ctx, first); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("scheduled push for runtime remote did not run")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	<-scheduler.Stop().Done()
+
+	// A manual push by name authenticates with the stored key.
+	second := createKnowledgeOn(t, nodeA, "Manual", "no key on the request")
+	syncPush(t, nodeA, "node-b", "")
+	if _, err := nodeB.Store.GetKnowledge(ctx, second); err != nil {
+		t.Fatalf("manual push without key not applied: %v", err)
+	}
+}
+
+func TestSyncResumesAfterLastAcknowledgedBatch(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "node-a")
+	nodeB := startSyncNode(t, "node-b")
+	nodeA.Engine.BatchSize = 2
+	nodeA.Engine.Transport.Retry = syncer.RetryPolicy{Attempts: 3, Base: time.Millisecond, Max: 5 * time.Millisecond}
+
+	// The proxy drops the first attempt of every batch and, while down, every
+	// attempt of the second and later batches.
+	target, _ := url.Parse(nodeB.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var (
+		mu      sync.Mutex
+		down    = true
+		seen    = map[string]bool{}
+		batches []string
+	)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		batch := r.Header.Get(syncer.BatchHeader)
+		if batch != "" {
+			mu.Lock()
+			first := !seen[batch]
+			seen[batch] = true
+			fail := first || (down && !strings.HasPrefix(batch, "1/"))
+			if !fail {
+				batches = append(batches, batch)
+			}
+			mu.Unlock()
+			if fail {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/remotes", nodeA.Key, map[string]any{
+		"name":    "node-b",
+		"url":     flaky.URL,
+		"api_key": nodeB.Key,
+	})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add remote status=%d", resp.StatusCode)
+	}
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, createKnowledgeOn(t, nodeA, "Entry", "batch content "+string(rune('a'+i))))
+	}
+
+	resp = doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/push", nodeA.Key, map[string]any{"remote": "node-b"})
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("expected push to fail while the peer is down")
+	}
+	present := 0
+	for _, id := range ids {
+		if _, err := nodeB.Store.GetKnowledge(ctx, id); err == nil {
+			present++
+		}
+	}
+	if present != 2 {
+		t.Fatalf("expected the first batch of 2 on the peer, got %d", present)
+	}
+
+	resp = doJSON(t, http.MethodGet, nodeA.URL+"/api/v1/sync/status", nodeA.Key, nil)
+	var status struct {
+		Data struct {
+			NextRetryAt *time.Time `json:"next_retry_at"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	_ = resp.Body.Close()
+	if status.Data.NextRetryAt == nil || !status.Data.NextRetryAt.After(time.Now()) {
+		t.Fatalf("expected a future next_retry_at, got %v", status.Data.NextRetryAt)
+	}
+
+	mu.Lock()
+	down = false
+	batches = nil
+	mu.Unlock()
+	syncPush(t, nodeA, "node-b", "")
+	mu.Lock()
+	resumed := strings.Join(batches, ",")
+	mu.Unlock()
+	if resumed != "2/3,3/3" {
+		t.Fatalf("expected the push to resume after batch 1, sent %q", resumed)
+	}
+	for _, id := range ids {
+		if _, err := nodeB.Store.GetKnowledge(ctx, id); err != nil {
+			t.Fatalf("entry %s missing after resumed push: %v", id, err)
+		}
+	}
+	remote, _ := nodeA.Store.GetRemote(ctx, "node-b")
+	if remote.NextRetryAt != nil || remote.Failures != 0 {
+		t.Fatalf("expected backoff cleared after success, got %+v", remote)
+	}
+}
+
+func TestSyncStreamsToStreamingPeersAndFallsBackForOldOnes(t *testing.T) {
+	ctx := context.Background()
+	nodeB := startSyncNode(t, "node-b")
+	target, _ := url.Parse(nodeB.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	// The recording proxy notes how each sync request was encoded. As an old
+	// peer it drops the stream negotiation, as a node that predates
+	// streaming would ignore it.
+	var (
+		mu     sync.Mutex
+		legacy bool
+		sent   []string
+	)
+	recorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/sync/") && r.Method == http.MethodPost {
+			mu.Lock()
+			sent = append(sent, r.Header.Get("Content-Type")+";"+r.Header.Get("Content-Encoding"))
+			if legacy {
+				r.Header.Del("Accept")
+				r.Header.Del("Accept-Encoding")
+			}
+			mu.Unlock()
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer recorder.Close()
+
+	run := func(node *syncNode) []string {
+		t.Helper()
+		mu.Lock()
+		sent = nil
+		mu.Unlock()
+		resp := doJSON(t, http.MethodPost, node.URL+"/api/v1/sync/remotes", node.Key, map[string]any{
+			"name":    "node-b",
+			"url":     recorder.URL,
+			"api_key": nodeB.Key,
+		})
+		_ = resp.Body.Close()
+		id := createKnowledgeOn(t, node, "Streamed", "pushed through the recorder")
+		syncPush(t, node, "node-b", "")
+		if _, err := nodeB.Store.GetKnowledge(ctx, id); err != nil {
+			t.Fatalf("pushed entry missing on peer: %v", err)
+		}
+		syncPull(t, node, "node-b", "")
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), sent...)
+	}
+
+	modern := run(startSyncNode(t, "node-a"))
+	if len(modern) < 2 || modern[0] != "application/json;" {
+		t.Fatalf("expected the first request as plain JSON, got %v", modern)
+	}
+	for _, enc := range modern[1:] {
+		if enc != syncer.StreamContentType+";gzip" {
+			t.Fatalf("expected later requests streamed and compressed, got %v", modern)
+		}
+	}
+
+	mu.Lock()
+	legacy = true
+	mu.Unlock()
+	old := run(startSyncNode(t, "node-c"))
+	for _, enc := range old {
+		if enc != "application/json;" {
+			t.Fatalf("expected only plain JSON towards an old peer, got %v", old)
+		}
+	}
+}
+
+func syncIdentityOf(t *testing.T, node *syncNode) string {
+	t.Helper()
+	resp := doJSON(t, http.MethodGet, node.URL+"/api/v1/sync/identity", node.Key, nil)
+	defer resp.Body.Close()
+	var out struct {
+		Data struct {
+			PublicKey string `json:"public_key"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || out.Data.PublicKey == "" {
+		t.Fatalf("sync identity: status=%d err=%v", resp.StatusCode, err)
+	}
+	return out.Data.PublicKey
+}
+
+// rewriteBody replaces old with new in a possibly gzip-compressed body.
+func rewriteBody(t *testing.T, h http.Header, body io.ReadCloser, old, new string) (io.ReadCloser, int64) {
+	t.Helper()
+	raw, err := io.ReadAll(body)
+	_ = body.Close()
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	gzipped := h.Get("Content-Encoding") == "gzip"
+	if gzipped {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("gunzip: %v", err)
+		}
+		if raw, err = io.ReadAll(zr); err != nil {
+			t.Fatalf("gunzip: %v", err)
+		}
+	}
+	raw = bytes.ReplaceAll(raw, []byte(old), []byte(new))
+	if gzipped {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(raw)
+		_ = zw.Close()
+		raw = buf.Bytes()
+	}
+	h.Del("Content-Length")
+	return io.NopCloser(bytes.NewReader(raw)), int64(len(raw))
+}
+
+func TestSyncRejectsPayloadsTamperedInTransit(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "node-a")
+	nodeB := startSyncNode(t, "node-b")
+
+	// Each node pins the other's key.
+	resp := doJSON(t, http.MethodPost, nodeB.URL+"/api/v1/sync/remotes", nodeB.Key, map[string]any{
+		"name":            "node-a",
+		"url":             nodeA.URL,
+		"peer_public_key": syncIdentityOf(t, nodeA),
+	})
+	_ = resp.Body.Close()
+
+	// The relay between them rewrites payloads while tampering is on.
+	target, _ := url.Parse(nodeB.URL)
+	relay := httputil.NewSingleHostReverseProxy(target)
+	var tamper atomic.Bool
+	relay.ModifyResponse = func(resp *http.Response) error {
+		if tamper.Load() {
+			resp.Body, resp.ContentLength = rewriteBody(t, resp.Header, resp.Body, "written on b", "forged by relay")
+		}
+		return nil
+	}
+	relayServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tamper.Load() && r.Method == http.MethodPost {
+			r.Body, r.ContentLength = rewriteBody(t, r.Header, r.Body, "written on a", "forged by relay")
+		}
+		relay.ServeHTTP(w, r)
+	}))
+	defer relayServer.Close()
+	resp = doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/remotes", nodeA.Key, map[string]any{
+		"name":            "node-b",
+		"url":             relayServer.URL,
+		"api_key":         nodeB.Key,
+		"peer_public_key": syncIdentityOf(t, nodeB),
+	})
+	_ = resp.Body.Close()
+
+	firstID := createKnowledgeOn(t, nodeA, "First", "written on a")
+	syncPush(t, nodeA, "node-b", "")
+	if _, err := nodeB.Store.GetKnowledge(ctx, firstID); err != nil {
+		t.Fatalf("signed push not applied: %v", err)
+	}
+
+	tamper.Store(true)
+	secondID := createKnowledgeOn(t, nodeA, "Second", "written on a")
+	resp = doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/push", nodeA.Key, map[string]any{"remote": "node-b"})
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("expected the tampered push to be rejected")
+	}
+	if _, err := nodeB.Store.GetKnowledge(ctx, secondID); err == nil {
+		t.Fatal("tampered push was applied")
+	}
+
+	thirdID := createKnowledgeOn(t, nodeB, "Third", "written on b")
+	resp = doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/pull", nodeA.Key, map[string]any{"remote": "node-b"})
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("expected the tampered pull to be rejected")
+	}
+	if _, err := nodeA.Store.GetKnowledge(ctx, thirdID); err == nil {
+		t.Fatal("tampered pull was applied")
+	}
+
+	for name, node := range map[string]*syncNode{"receiver": nodeB, "puller": nodeA} {
+		var rejected int
+		if err := node.Store.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_logs WHERE action = 'sync.signature_rejected'").Scan(&rejected); err != nil {
+			t.Fatalf("count audit logs: %v", err)
+		}
+		if rejected == 0 {
+			t.Fatalf("expected the %s to audit the rejected payload", name)
+		}
+	}
+
+	tamper.Store(false)
+	syncPush(t, nodeA, "node-b", "")
+	syncPull(t, nodeA, "node-b", "")
+	if _, err := nodeA.Store.GetKnowledge(ctx, thirdID); err != nil {
+		t.Fatalf("untampered pull not applied: %v", err)
+	}
+}
+
+func TestSyncRefusesUnsignedPayloadsOnceKeysArePinned(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "node-a")
+	nodeB := startSyncNode(t, "node-b")
+
+	resp := doJSON(t, http.MethodPost, nodeB.URL+"/api/v1/sync/remotes", nodeB.Key, map[string]any{
+		"name":            "node-a",
+		"url":             nodeA.URL,
+		"peer_public_key": syncIdentityOf(t, nodeA),
+	})
+	_ = resp.Body.Close()
+	addSyncRemote(t, nodeA, nodeB, "node-b")
+
+	// A signed push goes through and ties node-a's node id to its pin.
+	firstID := createKnowledgeOn(t, nodeA, "First", "signed")
+	syncPush(t, nodeA, "node-b", "")
+	if _, err := nodeB.Store.GetKnowledge(ctx, firstID); err != nil {
+		t.Fatalf("signed push not applied: %v", err)
+	}
+
+	forgedID := createKnowledgeOn(t, nodeA, "Forged", "unsigned")
+	entry, err := nodeA.Store.GetKnowledge(ctx, forgedID)
+	if err != nil {
+		t.Fatalf("get knowledge: %v", err)
+	}
+	items := []syncer.Entity{{
+		ManifestItem: syncer.ManifestItem{EntityType: "knowledge", ID: entry.ID, Checksum: entry.Checksum, UpdatedAt: entry.UpdatedAt.Format(time.RFC3339Nano)},
+		Knowledge:    &entry,
+	}}
+	nodeID, err := nodeA.Store.NodeID(ctx)
+	if err != nil {
+		t.Fatalf("node id: %v", err)
+	}
+	send := func(path string, body any, node string) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, nodeB.URL+path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", "Bearer "+nodeB.Key)
+		req.Header.Set("Content-Type", "application/json")
+		if node != "" {
+			req.Header.Set(syncer.NodeHeader, node)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	for name, status := range map[string]int{
+		"stripped signature":     send("/api/v1/sync/push/inbound", map[string]any{"items": items}, nodeID),
+		"stripped node and keys": send("/api/v1/sync/push/inbound", map[string]any{"items": items}, ""),
+		"legacy push":            send("/api/v1/sync/push", map[string]any{"items": items}, ""),
+	} {
+		if status != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", name, status)
+		}
+	}
+	if _, err := nodeB.Store.GetKnowledge(ctx, forgedID); err == nil {
+		t.Fatal("unsigned payload was applied")
+	}
+}
+
+// exportBundle downloads a bundle of node's full scope.
+func exportBundle(t *testing.T, node *syncNode) []byte {
+	t.Helper()
+	resp := doJSON(t, http.MethodGet, node.URL+"/api/v1/sync/export?scope=full", node.Key, nil)
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("export status=%d err=%v", resp.StatusCode, err)
+	}
+	return raw
+}
+
+// importBundle uploads a bundle to node and returns the response.
+func importBundle(t *testing.T, node *syncNode, remote string, bundle []byte) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, node.URL+"/api/v1/sync/import?remote="+url.QueryEscape(remote), bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("Authorization", "Bearer "+node.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	return resp
+}
+
+func TestSyncBundlesCarryHistoryAndConflictsOffline(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "node-a")
+	nodeB := startSyncNode(t, "node-b")
+
+	id := createKnowledgeOn(t, nodeA, "Runbook", "draft from a\n")
+	entry, _ := nodeA.Store.GetKnowledge(ctx, id)
+	if _, err := nodeA.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+		ID: id, Content: "reviewed on a\n", UpdatedBy: entry.UpdatedBy,
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	// Without a named remote the import is recorded against one kept for
+	// the exporting node.
+	resp := importBundle(t, nodeB, "", exportBundle(t, nodeA))
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("import status=%d", resp.StatusCode)
+	}
+	got, err := nodeB.Store.GetKnowledge(ctx, id)
+	if err != nil || got.Content != "reviewed on a\n" {
+		t.Fatalf("expected the bundle applied, got %+v (%v)", got, err)
+	}
+	history, _ := nodeB.Store.KnowledgeHistory(ctx, id)
+	if len(history) != 2 || history[1].Content != "draft from a\n" {
+		t.Fatalf("expected both versions imported, got %+v", history)
+	}
+	remotes, _ := nodeB.Store.ListRemotes(ctx)
+	if len(remotes) != 1 || !syncer.IsBundleRemote(remotes[0]) {
+		t.Fatalf("expected a bundle remote for node a, got %+v", remotes)
+	}
+
+	// A remote pinning a's key settles conflicts by hand and refuses
+	// bundles that were altered.
+	resp = doJSON(t, http.MethodPost, nodeB.URL+"/api/v1/sync/remotes", nodeB.Key, map[string]any{
+		"name":              "laptop",
+		"url":               "bundle:laptop",
+		"conflict_strategy": "manual",
+		"peer_public_key":   syncIdentityOf(t, nodeA),
+	})
+	_ = resp.Body.Close()
+	current, _ := nodeA.Store.GetKnowledge(ctx, id)
+	for node, content := range map[*syncNode]string{nodeA: "final from a\n", nodeB: "final from b\n"} {
+		if _, err := node.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+			ID: id, Content: content, UpdatedBy: current.UpdatedBy,
+		}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+	bundle := exportBundle(t, nodeA)
+
+	h := http.Header{"Content-Encoding": {"gzip"}}
+	forged, _ := rewriteBody(t, h, io.NopCloser(bytes.NewReader(bundle)), "final from a", "forged by hand")
+	raw, _ := io.ReadAll(forged)
+	resp = importBundle(t, nodeB, "laptop", raw)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the altered bundle to be rejected, status=%d", resp.StatusCode)
+	}
+
+	resp = importBundle(t, nodeB, "laptop", bundle)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("import status=%d", resp.StatusCode)
+	}
+	conflicts, _ := nodeB.Store.ListOpenConflicts(ctx)
+	if len(conflicts) != 1 || conflicts[0].EntityID != id {
+		t.Fatalf("expected one open conflict, got %+v", conflicts)
+	}
+
+	// The bundle's copy cannot be fetched again; resolving uses the one kept
+	// on the conflict.
+	resp = doJSON(t, http.MethodPost, nodeB.URL+"/api/v1/sync/conflicts/"+conflicts[0].ID+"/resolve", nodeB.Key, map[string]any{
+		"strategy": "remote-wins",
+	})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("resolve status=%d", resp.StatusCode)
+	}
+	if got, _ := nodeB.Store.GetKnowledge(ctx, id); got.Content != "final from a\n" {
+		t.Fatalf("expected the bundle's copy after resolution, got %q", got.Content)
+	}
+}
+
+type syncPeers struct {
+	NodeID string           `json:"node_id"`
+	Clock  map[string]int64 `json:"clock"`
+	Peers  []struct {
+		NodeID     string           `json:"node_id"`
+		RemoteName *string          `json:"remote_name"`
+		Clock      map[string]int64 `json:"clock"`
+	} `json:"peers"`
+}
+
+func syncPeersOf(t *testing.T, node *syncNode) syncPeers {
+	t.Helper()
+	resp := doJSON(t, http.MethodGet, node.URL+"/api/v1/sync/peers", node.Key, nil)
+	defer resp.Body.Close()
+	var out struct {
+		Data syncPeers `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("sync peers: status=%d err=%v", resp.StatusCode, err)
+	}
+	return out.Data
+}
+
+func TestSyncMeshSkipsVersionsAlreadySeen(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "node-a")
+	nodeB := startSyncNode(t, "node-b")
+	nodeC := startSyncNode(t, "node-c")
+	// A ring, so every change comes back around to where it started. Manual
+	// strategies make any edit taken for a conflict show.
+	addSyncRemoteWithStrategy(t, nodeA, nodeB, "b", "manual")
+	addSyncRemoteWithStrategy(t, nodeB, nodeC, "c", "manual")
+	addSyncRemoteWithStrategy(t, nodeC, nodeA, "a", "manual")
+	edit := func(node *syncNode, id, content string) {
+		t.Helper()
+		entry, err := node.Store.GetKnowledge(ctx, id)
+		if err != nil {
+			t.Fatalf("get knowledge: %v", err)
+		}
+		if _, err := node.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+			ID: id, Content: content, UpdatedBy: entry.UpdatedBy,
+		}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+	push := func(node *syncNode, remote string, peer *syncNode) {
+		t.Helper()
+		if log := syncPush(t, node, remote, peer.Key); log["status"] != "success" || log["conflicts"].(float64) != 0 {
+			t.Fatalf("push to %s: expected no conflicts, got %+v", remote, log)
+		}
+	}
+
+	id := createKnowledgeOn(t, nodeA, "Runbook", "v1")
+	push(nodeA, "b", nodeB)
+	push(nodeB, "c", nodeC)
+
+	// A newer version replaces the copies it descends from on each hop.
+	edit(nodeA, id, "v2")
+	push(nodeA, "b", nodeB)
+	push(nodeB, "c", nodeC)
+	edit(nodeC, id, "v3")
+	push(nodeC, "a", nodeA)
+
+	// B still holds v2, which C has already seen: nothing goes back.
+	push(nodeB, "c", nodeC)
+	if log := syncPull(t, nodeB, "c", nodeC.Key); log["status"] != "success" || log["conflicts"].(float64) != 0 {
+		t.Fatalf("pull from c: expected no conflicts, got %+v", log)
+	}
+	push(nodeA, "b", nodeB)
+	for name, node := range map[string]*syncNode{"a": nodeA, "b": nodeB, "c": nodeC} {
+		if entry, _ := node.Store.GetKnowledge(ctx, id); entry.Content != "v3" {
+			t.Fatalf("node %s: expected v3, got %q", name, entry.Content)
+		}
+		if conflicts, _ := node.Store.ListOpenConflicts(ctx); len(conflicts) != 0 {
+			t.Fatalf("node %s: expected no conflicts, got %+v", name, conflicts)
+		}
+	}
+
+	// B knows C through its remote and A by A's pushes, and what each holds.
+	a, c := syncPeersOf(t, nodeA), syncPeersOf(t, nodeC)
+	peers := syncPeersOf(t, nodeB)
+	if len(peers.Peers) != 2 {
+		t.Fatalf("expected b to know two peers, got %+v", peers.Peers)
+	}
+	for _, p := range peers.Peers {
+		switch p.NodeID {
+		case c.NodeID:
+			if p.RemoteName == nil || *p.RemoteName != "c" || p.Clock[c.NodeID] == 0 || p.Clock[a.NodeID] == 0 {
+				t.Fatalf("unexpected entry for c: %+v", p)
+			}
+		case a.NodeID:
+			if p.RemoteName != nil || p.Clock[a.NodeID] == 0 {
+				t.Fatalf("unexpected entry for a: %+v", p)
+			}
+		default:
+			t.Fatalf("unexpected peer %+v", p)
+		}
+	}
+	if peers.Clock[a.NodeID] == 0 || peers.Clock[c.NodeID] == 0 || peers.Clock[peers.NodeID] == 0 {
+		t.Fatalf("expected b's clock to cover a, c and itself, got %+v", peers.Clock)
+	}
+}
+
+func TestSyncDryRunPreviewsChangesWithoutWriting(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addSyncRemoteWithStrategy(t, nodeA, nodeB, "node-b", "manual")
+
+	updated := createKnowledgeOn(t, nodeA, "Updated", "v1")
+	disputed := createKnowledgeOn(t, nodeA, "Disputed", "v1")
+	gone := createKnowledgeOn(t, nodeA, "Gone", "v1")
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+
+	created := createKnowledgeOn(t, nodeA, "Created", "new on a")
+	for node, edits := range map[*syncNode]map[string]string{
+		nodeA: {updated: "v2", disputed: "a's edit"},
+		nodeB: {disputed: "b's edit"},
+	} {
+		for id, content := range edits {
+			entry, _ := node.Store.GetKnowledge(ctx, id)
+			if _, err := node.App.UpdateKnowledgeContent(ctx, repos.UpdateKnowledgeContentInput{
+				ID: id, Content: content, UpdatedBy: entry.UpdatedBy,
+			}); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+		}
+	}
+	if err := nodeA.Store.DeleteKnowledge(ctx, gone); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	logsBefore, _ := nodeA.Store.ListSyncLogs(ctx, 100)
+
+	preview := func(direction string) map[string]any {
+		t.Helper()
+		resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/"+direction, nodeA.Key, map[string]any{
+			"remote":  "node-b",
+			"api_key": nodeB.Key,
+			"dry_run": true,
+		})
+		defer resp.Body.Close()
+		var env struct {
+			Data struct {
+				DryRun  bool           `json:"dry_run"`
+				Preview map[string]any `json:"preview"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&env); err != nil || resp.StatusCode != http.StatusOK || !env.Data.DryRun {
+			t.Fatalf("%s dry run: status=%d err=%v", direction, resp.StatusCode, err)
+		}
+		return env.Data.Preview
+	}
+	push := preview("push")
+	for field, want := range map[string]float64{"creates": 1, "updates": 1, "deletes": 1, "conflicts": 1} {
+		if push[field] != want {
+			t.Fatalf("expected %s=%v, got %+v", field, want, push)
+		}
+	}
+	changes := map[string]map[string]any{}
+	for _, c := range push["changes"].([]any) {
+		change := c.(map[string]any)
+		changes[change["id"].(string)] = change
+	}
+	for id, want := range map[string][2]string{
+		created:  {"create", "Created"},
+		updated:  {"update", "Updated"},
+		gone:     {"delete", "Gone"},
+		disputed: {"conflict", "Disputed"},
+	} {
+		if c := changes[id]; c == nil || c["action"] != want[0] || c["title"] != want[1] {
+			t.Fatalf("expected %s of %q, got %+v", want[0], want[1], c)
+		}
+	}
+	if changes[disputed]["resolution"] != "manual" {
+		t.Fatalf("expected the conflict left open under manual, got %+v", changes[disputed])
+	}
+	if pull := preview("pull"); pull["conflicts"] != 1.0 || pull["creates"] != 0.0 {
+		t.Fatalf("expected the pull to predict one conflict, got %+v", pull)
+	}
+
+	// Nothing was written on either side.
+	if _, err := nodeB.Store.GetKnowledge(ctx, created); err == nil {
+		t.Fatalf("dry run created the entry on b")
+	}
+	if entry, _ := nodeB.Store.GetKnowledge(ctx, gone); entry.Content != "v1" {
+		t.Fatalf("dry run touched b's copy: %+v", entry)
+	}
+	if entry, _ := nodeA.Store.GetKnowledge(ctx, disputed); entry.Content != "a's edit" {
+		t.Fatalf("dry run touched a's copy: %+v", entry)
+	}
+	if logs, _ := nodeA.Store.ListSyncLogs(ctx, 100); len(logs) != len(logsBefore) {
+		t.Fatalf("dry run recorded a sync log")
+	}
+	if conflicts, _ := nodeA.Store.ListOpenConflicts(ctx); len(conflicts) != 0 {
+		t.Fatalf("dry run recorded conflicts: %+v", conflicts)
+	}
+}
+
+func TestSyncLiveLinkForwardsChangesAsTheyHappen(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/remotes", nodeA.Key, map[string]any{
+		"name":    "node-b",
+		"url":     nodeB.URL,
+		"api_key": nodeB.Key,
+		"live":    true,
+	})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add live remote status=%d", resp.StatusCode)
+	}
+	before := createKnowledgeOn(t, nodeA, "Before", "written before the link")
+
+	scheduler := syncer.NewScheduler(nodeA.Engine)
+	if err := scheduler.Reload(ctx); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	t.Cleanup(func() { scheduler.Stop() })
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	held := func(node *syncNode, id string) func() bool {
+		return func() bool {
+			_, err := node.Store.GetKnowledge(ctx, id)
+			return err == nil
+		}
+	}
+	// The link catches up with a batch sync before going live.
+	waitFor("the catch-up push", held(nodeB, before))
+
+	fromA := createKnowledgeOn(t, nodeA, "From A", "live from a")
+	waitFor("a's entry on b", held(nodeB, fromA))
+	fromB := createKnowledgeOn(t, nodeB, "From B", "live from b")
+	waitFor("b's entry on a", held(nodeA, fromB))
+
+	topicID := createOn(t, nodeA, "/api/v1/topics", "topic", map[string]any{"name": "live"})
+	waitFor("the topic on b", func() bool {
+		_, err := nodeB.Store.GetTopicByID(ctx, topicID)
+		return err == nil
+	})
+	received, err := nodeB.App.Broker.Subscribe(ctx, "listener", topicID, broker.Filter{}, nil)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	msgID := createOn(t, nodeA, "/api/v1/messages", "message", map[string]any{"topic_id": topicID, "content": "hello b"})
+	select {
+	case msg := <-received:
+		if msg.ID != msgID || msg.Content != "hello b" {
+			t.Fatalf("expected the message from a, got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message from a was not published on b")
+	}
+
+	remote, err := nodeA.Store.GetRemote(ctx, "node-b")
+	if err != nil || !remote.Live {
+		t.Fatalf("expected the remote stored as live, got %+v err=%v", remote, err)
+	}
+	if remote.PushCursor == 0 || remote.PullCursor == 0 {
+		t.Fatalf("expected the live exchange to move both cursors, got push=%d pull=%d", remote.PushCursor, remote.PullCursor)
+	}
+}
+
+func TestSyncFiltersHoldBackAndRedactKnowledge(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	addRemote := func(filter map[string]any) *http.Response {
+		return doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/remotes", nodeA.Key, map[string]any{
+			"name":    "node-b",
+			"url":     nodeB.URL,
+			"api_key": nodeB.Key,
+			"filter":  filter,
+		})
+	}
+	resp := addRemote(map[string]any{"redact_fields": []string{"body"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an unknown redact field to be rejected, got status=%d", resp.StatusCode)
+	}
+	resp = addRemote(map[string]any{
+		"exclude_tags":          []string{"internal"},
+		"exclude_visibilities":  []string{"restricted"},
+		"exclude_metadata_keys": []string{"secret"},
+		"redact_fields":         []string{"summary"},
+	})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add remote status=%d", resp.StatusCode)
+	}
+
+	shared := createOn(t, nodeA, "/api/v1/knowledge", "knowledge", map[string]any{
+		"title":    "Shared",
+		"content":  "for everyone",
+		"summary":  "private notes",
+		"tags":     []string{"sync"},
+		"metadata": map[string]any{"secret": "s3cret", "owner": "team-a"},
+	})
+	internal := createOn(t, nodeA, "/api/v1/knowledge", "knowledge", map[string]any{
+		"title": "Internal", "content": "not for b", "tags": []string{"internal"},
+	})
+	restricted := createOn(t, nodeA, "/api/v1/knowledge", "knowledge", map[string]any{
+		"title": "Restricted", "content": "not for b", "visibility": "restricted",
+	})
+
+	resp = doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/push", nodeA.Key, map[string]any{
+		"remote":  "node-b",
+		"api_key": nodeB.Key,
+		"dry_run": true,
+	})
+	var env struct {
+		Data struct {
+			Preview map[string]any `json:"preview"`
+		} `json:"data"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&env)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("dry run: status=%d err=%v", resp.StatusCode, err)
+	}
+	if env.Data.Preview["excluded"] != 2.0 {
+		t.Fatalf("expected two entries held back, got %+v", env.Data.Preview)
+	}
+	changes := map[string]map[string]any{}
+	for _, c := range env.Data.Preview["changes"].([]any) {
+		change := c.(map[string]any)
+		changes[change["id"].(string)] = change
+	}
+	for _, id := range []string{internal, restricted} {
+		if changes[id]["action"] != "excluded" {
+			t.Fatalf("expected %s excluded, got %+v", id, changes[id])
+		}
+	}
+	if c := changes[shared]; c["action"] != "create" || fmt.Sprint(c["redacted"]) != "[metadata.secret summary]" {
+		t.Fatalf("expected the shared entry created with redactions, got %+v", c)
+	}
+
+	syncPush(t, nodeA, "node-b", nodeB.Key)
+	for _, id := range []string{internal, restricted} {
+		if _, err := nodeB.Store.GetKnowledge(ctx, id); err == nil {
+			t.Fatalf("filtered entry %s reached b", id)
+		}
+	}
+	entry, err := nodeB.Store.GetKnowledge(ctx, shared)
+	if err != nil {
+		t.Fatalf("shared entry missing on b: %v", err)
+	}
+	if entry.Content != "for everyone" || entry.Summary == nil || *entry.Summary != "[redacted]" {
+		t.Fatalf("expected the summary redacted on b, got %+v", entry)
+	}
+	if _, ok := entry.Metadata["secret"]; ok || entry.Metadata["owner"] != "team-a" {
+		t.Fatalf("expected only the secret metadata stripped, got %+v", entry.Metadata)
+	}
+	if entry, _ := nodeA.Store.GetKnowledge(ctx, shared); *entry.Summary != "private notes" {
+		t.Fatalf("push touched a's copy: %+v", entry)
+	}
+}
+
+func TestSyncUnknownCallersGetTheStrictestFilter(t *testing.T) {
+	ctx := context.Background()
+	nodeA := startSyncNode(t, "a")
+	nodeB := startSyncNode(t, "b")
+	nodeC := startSyncNode(t, "c")
+	resp := doJSON(t, http.MethodPost, nodeA.URL+"/api/v1/sync/remotes", nodeA.Key, map[string]any{
+		"name":    "node-b",
+		"url":     nodeB.URL,
+		"api_key": nodeB.Key,
+		"filter":  map[string]any{"exclude_tags": []string{"internal"}},
+	})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add remote status=%d", resp.StatusCode)
+	}
+	shared := createKnowledgeOn(t, nodeA, "Shared", "for everyone")
+	internal := createOn(t, nodeA, "/api/v1/knowledge", "knowledge", map[string]any{
+		"title": "Internal", "content": "not for b", "tags": []string{"internal"},
+	})
+
+	// c is no remote of a's, so a holds back what it holds back from any.
+	addSyncRemote(t, nodeC, nodeA, "node-a")
+	syncPull(t, nodeC, "node-a", nodeA.Key)
+	if _, err := nodeC.Store.GetKnowledge(ctx, shared); err != nil {
+		t.Fatalf("shared entry missing on c: %v", err)
+	}
+	if _, err := nodeC.Store.GetKnowledge(ctx, internal); err == nil {
+		t.Fatalf("entry held back from b reached an unknown node")
+	}
+
+	nodeD := startSyncNode(t, "d")
+	resp = importBundle(t, nodeD, "", exportBundle(t, nodeA))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("import status=%d", resp.StatusCode)
+	}
+	if _, err := nodeD.Store.GetKnowledge(ctx, shared); err != nil {
+		t.Fatalf("shared entry missing from the bundle: %v", err)
+	}
+	if _, err := nodeD.Store.GetKnowledge(ctx, internal); err == nil {
+		t.Fatalf("a bundle for no named remote carried the held back entry")
+	}
+}
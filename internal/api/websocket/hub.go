@@ -9,6 +9,7 @@ import (
 
 	gws "github.com/gorilla/websocket"
 
+	"opencortex/internal/broker"
 	"opencortex/internal/model"
 	"opencortex/internal/service"
 	"opencortex/internal/storage/repos"
@@ -24,13 +25,17 @@ type Hub struct {
 }
 
 type client struct {
-	conn        *gws.Conn
-	app         *service.App
-	store       *repos.Store
-	auth        service.AuthContext
+	conn  *gws.Conn
+	app   *service.App
+	store *repos.Store
+	auth  service.AuthContext
+	// overflow is the ?overflow= policy of the channels this connection
+	// reads, nil for the broker's default.
+	overflow    *broker.Overflow
 	writeMu     sync.Mutex
 	topicCancel map[string]context.CancelFunc
 	mailboxStop context.CancelFunc
+	lagStop     func()
 }
 
 func NewHub(app *service.App, store *repos.Store) *Hub {
@@ -78,6 +83,19 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	// A client may pick how its messages overflow when it falls behind,
+	// e.g. ?overflow=disconnect to be cut off and resync instead of
+	// silently missing messages. The policy is set on the channels the
+	// connection reads and lasts as long as the connection.
+	var overflow *broker.Overflow
+	if policy := r.URL.Query().Get("overflow"); policy != "" {
+		o, err := broker.ParseOverflow(policy, r.URL.Query().Get("overflow_timeout"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		overflow = &o
+	}
 
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -88,6 +106,7 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 		app:         h.App,
 		store:       h.Store,
 		auth:        authCtx,
+		overflow:    overflow,
 		topicCancel: map[string]context.CancelFunc{},
 	}
 	h.register(c)
@@ -95,6 +114,7 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 	defer conn.Close()
 
 	_ = c.write(map[string]any{"type": "ack", "ok": true, "ref_id": "connected"})
+	c.watchLag()
 	c.startMailbox()
 	_ = c.subscribeTopic(service.SystemBroadcastTopicID, "")
 	for {
@@ -123,6 +143,10 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 			_ = c.write(map[string]any{"type": "ack", "ok": true, "ref_id": topicID})
+		case "resync":
+			topicID, _ := req["topic_id"].(string)
+			cursor, _ := req["cursor"].(string)
+			c.writeInitialImage(context.Background(), topicID, cursor)
 		case "unsubscribe":
 			topicID, _ := req["topic_id"].(string)
 			c.unsubscribeTopic(topicID)
@@ -152,11 +176,15 @@ func (h *Hub) register(c *client) {
 }
 
 func (h *Hub) unregister(c *client) {
+	c.releaseOverflow()
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if c.mailboxStop != nil {
 		c.mailboxStop()
 	}
+	if c.lagStop != nil {
+		c.lagStop()
+	}
 	for _, cancel := range c.topicCancel {
 		cancel()
 	}
@@ -182,26 +210,13 @@ func (c *client) subscribeTopic(topicID, cursor string) error {
 	if _, exists := c.topicCancel[topicID]; exists {
 		return nil
 	}
-	if err := c.store.Subscribe(context.Background(), c.auth.Agent.ID, topicID, nil); err != nil {
-		return err
-	}
-	ch, err := c.app.Broker.Subscribe(context.Background(), c.auth.Agent.ID, topicID)
+	ch, err := c.app.SubscribeTopic(context.Background(), c.auth.Agent.ID, topicID, nil, c.overflow)
 	if err != nil {
 		return err
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	c.topicCancel[topicID] = cancel
-
-	msgs, newCursor, _ := c.app.GetInboxAsync(ctx, c.auth.Agent.ID, cursor, repos.GetInboxFilters{TopicID: topicID, Limit: 100})
-	if msgs == nil {
-		msgs = []model.Message{}
-	}
-	_ = c.write(map[string]any{
-		"type":     "initial_image",
-		"topic_id": topicID,
-		"cursor":   newCursor,
-		"messages": msgs,
-	})
+	c.writeInitialImage(ctx, topicID, cursor)
 
 	go func() {
 		for {
@@ -233,6 +248,24 @@ func (c *client) unsubscribeTopic(topicID string) {
 	_ = c.app.Broker.Unsubscribe(context.Background(), c.auth.Agent.ID, topicID)
 }
 
+// releaseOverflow puts the channels this connection chose a policy for back
+// on the broker's default once it goes away. The agent's other connections
+// share those channels, so the one that subscribes last sets their policy.
+func (c *client) releaseOverflow() {
+	if c.overflow == nil {
+		return
+	}
+	ctx := context.Background()
+	for topicID := range c.topicCancel {
+		if _, err := c.store.GetSubscription(ctx, c.auth.Agent.ID, topicID); err == nil {
+			_, _ = c.app.SubscribeTopic(ctx, c.auth.Agent.ID, topicID, nil, nil)
+		}
+	}
+	if c.mailboxStop != nil {
+		_, _ = c.app.Broker.GetMailbox(ctx, c.auth.Agent.ID, nil)
+	}
+}
+
 func (c *client) send(payload map[string]any) (model.Message, error) {
 	var (
 		toAgentID *string
@@ -273,22 +306,13 @@ func (c *client) startMailbox() {
 	if c.mailboxStop != nil {
 		return
 	}
-	ch, err := c.app.Broker.GetMailbox(context.Background(), c.auth.Agent.ID)
+	ch, err := c.app.Broker.GetMailbox(context.Background(), c.auth.Agent.ID, c.overflow)
 	if err != nil {
 		return
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	c.mailboxStop = cancel
-	msgs, newCursor, _ := c.app.GetInboxAsync(ctx, c.auth.Agent.ID, "", repos.GetInboxFilters{Limit: 100})
-	if msgs == nil {
-		msgs = []model.Message{}
-	}
-	_ = c.write(map[string]any{
-		"type":     "initial_image",
-		"topic_id": "",
-		"cursor":   newCursor,
-		"messages": msgs,
-	})
+	c.writeInitialImage(ctx, "", "")
 
 	go func() {
 		for {
@@ -322,3 +346,40 @@ func messageHint(msg model.Message) map[string]any {
 		"created_at":    msg.CreatedAt,
 	}
 }
+
+// writeInitialImage sends the messages after cursor on the topic, or in the
+// mailbox when topicID is empty, with the cursor to continue from.
+func (c *client) writeInitialImage(ctx context.Context, topicID, cursor string) {
+	msgs, newCursor, _ := c.app.GetInboxAsync(ctx, c.auth.Agent.ID, cursor, repos.GetInboxFilters{TopicID: topicID, Limit: 100})
+	if msgs == nil {
+		msgs = []model.Message{}
+	}
+	_ = c.write(map[string]any{
+		"type":     "initial_image",
+		"topic_id": topicID,
+		"cursor":   newCursor,
+		"messages": msgs,
+	})
+}
+
+// watchLag tells the client when it lost messages to overflow, so it sends a
+// resync frame with its last cursor. A client cut off as a slow consumer is
+// disconnected and resyncs when it reconnects.
+func (c *client) watchLag() {
+	events, stop := c.app.Broker.WatchLag(context.Background(), c.auth.Agent.ID)
+	c.lagStop = stop
+	go func() {
+		for ev := range events {
+			_ = c.write(map[string]any{
+				"type":         "lagged",
+				"topic_id":     ev.TopicID,
+				"dropped":      ev.Dropped,
+				"disconnected": ev.Disconnected,
+			})
+			if ev.Disconnected {
+				_ = c.conn.Close()
+				return
+			}
+		}
+	}()
+}
@@ -2,6 +2,7 @@ package websocket_test
 
 import (
 	"context"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"path/filepath"
@@ -117,6 +118,44 @@ func TestHubTopicAndDirectDelivery(t *testing.T) {
 	}
 }
 
+func TestHubOverflowPolicyAndResync(t *testing.T) {
+	env := setupHubTestEnv(t)
+	defer env.cleanup()
+
+	_, resp, err := websocket.DefaultDialer.Dial(env.wsURL+"&overflow=drop-everything", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an unknown overflow policy to be rejected, got err=%v resp=%v", err, resp)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(env.wsURL+"&overflow=block&overflow_timeout=50ms", nil)
+	if err != nil {
+		t.Fatalf("dial ws: %v", err)
+	}
+	defer conn.Close()
+	_ = readFrame(t, conn) // connected ack
+	first := readMailboxImage(t, conn)
+
+	msg, err := env.app.CreateMessage(context.Background(), repos.CreateMessageInput{
+		FromAgentID: env.admin.ID,
+		ToAgentID:   &env.worker.ID,
+		Content:     "missed while behind",
+	})
+	if err != nil {
+		t.Fatalf("create direct message: %v", err)
+	}
+	_ = readType(t, conn, "message")
+
+	// A client told it lagged resyncs its mailbox from the cursor it had.
+	if err := conn.WriteJSON(map[string]any{"type": "resync", "cursor": first["cursor"]}); err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	image := readMailboxImage(t, conn)
+	msgs, _ := image["messages"].([]any)
+	if len(msgs) != 1 || nestedString(msgs[0].(map[string]any), "id") != msg.ID {
+		t.Fatalf("expected the resync to replay the message, got %+v", image)
+	}
+}
+
 type hubTestEnv struct {
 	app       *service.App
 	admin     model.Agent
@@ -209,6 +248,18 @@ func readType(t *testing.T, conn *websocket.Conn, want string) map[string]any {
 	return nil
 }
 
+// readMailboxImage reads up to the mailbox's initial image, skipping those of
+// topics, which arrive in no set order with it.
+func readMailboxImage(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	for {
+		image := readType(t, conn, "initial_image")
+		if topicID, _ := image["topic_id"].(string); topicID == "" {
+			return image
+		}
+	}
+}
+
 func readFrame(t *testing.T, conn *websocket.Conn) map[string]any {
 	t.Helper()
 	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
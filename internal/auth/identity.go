@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Identity is the key a node signs its sync payloads with.
+type Identity struct {
+	NodeID     string
+	PrivateKey ed25519.PrivateKey
+}
+
+// PublicKey returns the encoded public half of the identity, the form peers
+// pin.
+func (id Identity) PublicKey() string {
+	return EncodePublicKey(id.PrivateKey.Public().(ed25519.PublicKey))
+}
+
+// KeyID returns the short fingerprint of the identity's public key.
+func (id Identity) KeyID() string {
+	return KeyID(id.PrivateKey.Public().(ed25519.PublicKey))
+}
+
+// GenerateIdentityKey returns a fresh Ed25519 private key.
+func GenerateIdentityKey() (ed25519.PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("random: %w", err)
+	}
+	return priv, nil
+}
+
+// EncodeIdentityKey encodes a private key as its base64 seed, ready to be
+// sealed for storage.
+func EncodeIdentityKey(priv ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(priv.Seed())
+}
+
+// ParseIdentityKey decodes a key produced by EncodeIdentityKey.
+func ParseIdentityKey(encoded string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid identity key")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey decodes a public key produced by EncodePublicKey.
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// KeyID fingerprints a public key so a signed payload can name the key it
+// was signed with without carrying it.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
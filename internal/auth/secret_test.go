@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSealAndOpenSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.key")
+	key, err := LoadOrCreateSecret(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateSecret failed: %v", err)
+	}
+	again, err := LoadOrCreateSecret(path)
+	if err != nil || string(again) != string(key) {
+		t.Fatalf("expected the stored key to be reused, err=%v", err)
+	}
+
+	sealed, err := SealSecret(key, "amk_remote_abc")
+	if err != nil {
+		t.Fatalf("SealSecret failed: %v", err)
+	}
+	if sealed == "amk_remote_abc" {
+		t.Fatal("secret stored in plain text")
+	}
+	plain, err := OpenSecret(key, sealed)
+	if err != nil || plain != "amk_remote_abc" {
+		t.Fatalf("OpenSecret = %q, %v", plain, err)
+	}
+
+	other, err := LoadOrCreateSecret(filepath.Join(t.TempDir(), "other.key"))
+	if err != nil {
+		t.Fatalf("LoadOrCreateSecret failed: %v", err)
+	}
+	if _, err := OpenSecret(other, sealed); err == nil {
+		t.Fatal("expected OpenSecret to fail under another key")
+	}
+}
@@ -13,13 +13,34 @@ type TopicStats struct {
 	DroppedMsgs  int64  `json:"dropped_messages"`
 }
 
+// Stats sums up the broker's channels and what they lost to overflow.
+type Stats struct {
+	Topics            int   `json:"topics"`
+	Subscribers       int   `json:"subscribers"`
+	Mailboxes         int   `json:"mailboxes"`
+	BufferedMsgs      int   `json:"buffered_messages"`
+	DroppedTopicMsgs  int64 `json:"dropped_topic_messages"`
+	DroppedDirectMsgs int64 `json:"dropped_direct_messages"`
+	Disconnected      int64 `json:"disconnected_subscribers"`
+}
+
 type Broker interface {
 	CreateTopic(ctx context.Context, topic model.Topic) error
 	DeleteTopic(ctx context.Context, topicID string) error
-	Subscribe(ctx context.Context, agentID, topicID string) (<-chan model.Message, error)
+	// Subscribe returns the agent's channel for the topic, which receives
+	// the published messages passing filter and overflows by the given
+	// policy, or the broker's default when it is nil. Subscribing again
+	// replaces both and returns the same channel.
+	Subscribe(ctx context.Context, agentID, topicID string, filter Filter, overflow *Overflow) (<-chan model.Message, error)
 	Unsubscribe(ctx context.Context, agentID, topicID string) error
 	Publish(ctx context.Context, msg model.Message) error
 	SendDirect(ctx context.Context, msg model.Message) error
-	GetMailbox(ctx context.Context, agentID string) (<-chan model.Message, error)
+	// GetMailbox returns the agent's direct channel, which overflows like
+	// a topic channel.
+	GetMailbox(ctx context.Context, agentID string, overflow *Overflow) (<-chan model.Message, error)
 	TopicStats(ctx context.Context, topicID string) (TopicStats, error)
+	Stats(ctx context.Context) (Stats, error)
+	// WatchLag returns a channel told whenever the agent loses messages to
+	// overflow and must resync from its cursor. stop releases it.
+	WatchLag(ctx context.Context, agentID string) (events <-chan LagEvent, stop func())
 }
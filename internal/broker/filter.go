@@ -0,0 +1,113 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"opencortex/internal/model"
+)
+
+// Filter selects the topic messages a subscriber receives. It is stored as
+// the subscription's filter object, for example
+//
+//	{"tags": ["review"], "min_priority": "high", "metadata_prefix": {"branch": "release/"}}
+//
+// Every condition given must hold; a list matches when any of its values
+// does. The zero Filter matches every message.
+type Filter struct {
+	// Tags matches messages carrying any of these tags.
+	Tags []string `json:"tags,omitempty"`
+	// Priorities matches messages with one of these priorities.
+	Priorities []model.MessagePriority `json:"priorities,omitempty"`
+	// MinPriority matches messages at this priority or above.
+	MinPriority model.MessagePriority `json:"min_priority,omitempty"`
+	// Senders matches messages from one of these agent ids.
+	Senders []string `json:"senders,omitempty"`
+	// ContentTypes matches messages with one of these content types.
+	ContentTypes []string `json:"content_types,omitempty"`
+	// Metadata matches messages whose metadata holds each key with the
+	// given value.
+	Metadata map[string]any `json:"metadata,omitempty"`
+	// MetadataPrefix matches messages whose metadata holds each key with a
+	// string value starting with the given prefix.
+	MetadataPrefix map[string]string `json:"metadata_prefix,omitempty"`
+
+	nothing bool
+}
+
+// MatchNothing is a filter no message passes, for subscriptions whose stored
+// filter cannot be read.
+var MatchNothing = Filter{nothing: true}
+
+var priorityRank = map[model.MessagePriority]int{
+	model.MessagePriorityLow:      1,
+	model.MessagePriorityNormal:   2,
+	model.MessagePriorityHigh:     3,
+	model.MessagePriorityCritical: 4,
+}
+
+// ParseFilter reads a subscription filter object, rejecting unknown
+// conditions and priorities so a typo does not silently match everything.
+func ParseFilter(raw map[string]any) (Filter, error) {
+	var f Filter
+	if len(raw) == 0 {
+		return f, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return f, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return Filter{}, fmt.Errorf("invalid filter: %w", err)
+	}
+	for _, p := range append(slices.Clone(f.Priorities), f.MinPriority) {
+		if _, ok := priorityRank[p]; p != "" && !ok {
+			return Filter{}, fmt.Errorf("invalid filter: unknown priority %q", p)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether msg passes the filter.
+func (f Filter) Match(msg model.Message) bool {
+	if f.nothing {
+		return false
+	}
+	priority := msg.Priority
+	if priority == "" {
+		priority = model.MessagePriorityNormal
+	}
+	if len(f.Tags) > 0 && !slices.ContainsFunc(msg.Tags, func(tag string) bool { return slices.Contains(f.Tags, tag) }) {
+		return false
+	}
+	if len(f.Priorities) > 0 && !slices.Contains(f.Priorities, priority) {
+		return false
+	}
+	if f.MinPriority != "" && priorityRank[priority] < priorityRank[f.MinPriority] {
+		return false
+	}
+	if len(f.Senders) > 0 && !slices.Contains(f.Senders, msg.FromAgentID) {
+		return false
+	}
+	if len(f.ContentTypes) > 0 && !slices.Contains(f.ContentTypes, msg.ContentType) {
+		return false
+	}
+	for key, want := range f.Metadata {
+		got, ok := msg.Metadata[key]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+	for key, prefix := range f.MetadataPrefix {
+		got, ok := msg.Metadata[key].(string)
+		if !ok || !strings.HasPrefix(got, prefix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"opencortex/internal/model"
+)
+
+func TestFilterMatch(t *testing.T) {
+	msg := model.Message{
+		FromAgentID: "ci",
+		ContentType: "application/json",
+		Priority:    model.MessagePriorityHigh,
+		Tags:        []string{"review", "backend"},
+		Metadata:    map[string]any{"branch": "release/1.2", "pr": float64(42)},
+	}
+	for _, tc := range []struct {
+		filter map[string]any
+		want   bool
+	}{
+		{nil, true},
+		{map[string]any{"tags": []string{"frontend", "review"}}, true},
+		{map[string]any{"tags": []string{"frontend"}}, false},
+		{map[string]any{"priorities": []string{"low", "normal"}}, false},
+		{map[string]any{"min_priority": "high"}, true},
+		{map[string]any{"min_priority": "critical"}, false},
+		{map[string]any{"senders": []string{"ci"}}, true},
+		{map[string]any{"senders": []string{"someone-else"}}, false},
+		{map[string]any{"content_types": []string{"text/plain"}}, false},
+		{map[string]any{"metadata": map[string]any{"pr": 42}}, true},
+		{map[string]any{"metadata": map[string]any{"pr": 43}}, false},
+		{map[string]any{"metadata_prefix": map[string]any{"branch": "release/"}}, true},
+		{map[string]any{"metadata_prefix": map[string]any{"pr": "4"}}, false},
+		{map[string]any{"tags": []string{"review"}, "min_priority": "critical"}, false},
+	} {
+		f, err := ParseFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("parse %v: %v", tc.filter, err)
+		}
+		if got := f.Match(msg); got != tc.want {
+			t.Fatalf("filter %v matched=%v, want %v", tc.filter, got, tc.want)
+		}
+	}
+	if MatchNothing.Match(msg) {
+		t.Fatalf("expected MatchNothing to match nothing")
+	}
+	for _, bad := range []map[string]any{
+		{"tag": "review"},
+		{"min_priority": "urgent"},
+		{"priorities": []string{"normal", "asap"}},
+	} {
+		if _, err := ParseFilter(bad); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestPublishSkipsFilteredSubscribers(t *testing.T) {
+	b := NewMemory(4)
+	topicID := "topic-1"
+	all, _ := b.Subscribe(context.Background(), "agent-1", topicID, Filter{}, nil)
+	urgent, _ := b.Subscribe(context.Background(), "agent-2", topicID, Filter{MinPriority: model.MessagePriorityHigh}, nil)
+	if err := b.Publish(context.Background(), model.Message{ID: "m1", TopicID: &topicID}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if len(all) != 1 || len(urgent) != 0 {
+		t.Fatalf("expected only the unfiltered subscriber to get the message, got %d and %d", len(all), len(urgent))
+	}
+}
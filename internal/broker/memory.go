@@ -5,22 +5,172 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"opencortex/internal/model"
 )
 
 type topicState struct {
 	id      string
-	subs    map[string]chan model.Message
+	subs    map[string]*subscriber
 	dropped atomic.Int64
 }
 
+type subscriber struct {
+	mu       sync.Mutex
+	ch       chan model.Message
+	filter   Filter
+	overflow Overflow
+	closed   bool
+	// done is closed with the subscriber, releasing a waiting flush.
+	done chan struct{}
+	// waiting holds, in order, the messages an OverflowBlock subscriber had
+	// no room for. A flush goroutine hands them over as room frees up, so
+	// the publisher never waits on a slow consumer.
+	waiting  []model.Message
+	flushing bool
+	// lost records messages the flush gave up on.
+	lost func(dropped int64)
+}
+
+func newSubscriber(size int, filter Filter, overflow Overflow, lost func(int64)) *subscriber {
+	return &subscriber{
+		ch:       make(chan model.Message, size),
+		filter:   filter,
+		overflow: overflow,
+		done:     make(chan struct{}),
+		lost:     lost,
+	}
+}
+
+// deliver hands msg to the subscriber if it passes the filter, applying the
+// overflow policy when the channel is full. It reports how many messages
+// were lost and whether the subscriber was cut off. It never blocks: a
+// blocking policy queues the message for the subscriber's flush.
+func (s *subscriber) deliver(msg model.Message) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.filter.Match(msg) {
+		return 0, false
+	}
+	if len(s.waiting) == 0 {
+		select {
+		case s.ch <- msg:
+			return 0, false
+		default:
+		}
+	}
+	switch s.overflow.Policy {
+	case OverflowDropOldest:
+		var dropped int64
+		select {
+		case <-s.ch:
+			dropped++
+		default:
+		}
+		select {
+		case s.ch <- msg:
+		default:
+			dropped++
+		}
+		return dropped, false
+	case OverflowBlock:
+		if len(s.waiting) >= cap(s.ch) {
+			return 1, false
+		}
+		s.waiting = append(s.waiting, msg)
+		if !s.flushing {
+			s.flushing = true
+			go s.flush()
+		}
+		return 0, false
+	case OverflowDisconnect:
+		s.shut()
+		return 1, true
+	default:
+		return 1, false
+	}
+}
+
+// flush hands the waiting messages over in order, giving each up to the
+// overflow timeout to find room.
+func (s *subscriber) flush() {
+	for {
+		s.mu.Lock()
+		if s.closed || len(s.waiting) == 0 {
+			s.waiting = nil
+			s.flushing = false
+			if s.closed {
+				close(s.ch)
+			}
+			s.mu.Unlock()
+			return
+		}
+		msg, timeout := s.waiting[0], s.overflow.Timeout
+		s.mu.Unlock()
+
+		timer := time.NewTimer(timeout)
+		timedOut := false
+		select {
+		case s.ch <- msg:
+		case <-timer.C:
+			timedOut = true
+		case <-s.done:
+		}
+		timer.Stop()
+
+		s.mu.Lock()
+		if len(s.waiting) > 0 {
+			s.waiting = s.waiting[1:]
+		}
+		s.mu.Unlock()
+		if timedOut && s.lost != nil {
+			s.lost(1)
+		}
+	}
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shut()
+}
+
+// shut marks the subscriber closed and closes its channel, or leaves that to
+// a running flush, the channel's only other sender. s.mu must be held.
+func (s *subscriber) shut() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if !s.flushing {
+		close(s.ch)
+	}
+}
+
+func (s *subscriber) set(filter *Filter, overflow Overflow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if filter != nil {
+		s.filter = *filter
+	}
+	s.overflow = overflow
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
 type MemoryBroker struct {
 	mu          sync.RWMutex
 	topics      map[string]*topicState
-	direct      map[string]chan model.Message
+	direct      map[string]*subscriber
+	overflow    Overflow
+	lagWatchers map[string]map[chan LagEvent]struct{}
 	bufferSize  int
 	defaultMail int
+
+	droppedDirect atomic.Int64
+	disconnected  atomic.Int64
 }
 
 func NewMemory(bufferSize int) *MemoryBroker {
@@ -29,12 +179,32 @@ func NewMemory(bufferSize int) *MemoryBroker {
 	}
 	return &MemoryBroker{
 		topics:      map[string]*topicState{},
-		direct:      map[string]chan model.Message{},
+		direct:      map[string]*subscriber{},
+		overflow:    Overflow{Policy: OverflowDropNewest, Timeout: defaultOverflowTimeout},
+		lagWatchers: map[string]map[chan LagEvent]struct{}{},
 		bufferSize:  bufferSize,
 		defaultMail: bufferSize,
 	}
 }
 
+// WithOverflow sets the overflow policy of subscribers that did not choose
+// their own.
+func (b *MemoryBroker) WithOverflow(o Overflow) *MemoryBroker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.overflow = o
+	return b
+}
+
+// overflowOr returns o, or the broker's default when o is nil. b.mu must be
+// held.
+func (b *MemoryBroker) overflowOr(o *Overflow) Overflow {
+	if o == nil {
+		return b.overflow
+	}
+	return *o
+}
+
 func (b *MemoryBroker) CreateTopic(_ context.Context, topic model.Topic) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -43,52 +213,63 @@ func (b *MemoryBroker) CreateTopic(_ context.Context, topic model.Topic) error {
 	}
 	b.topics[topic.ID] = &topicState{
 		id:   topic.ID,
-		subs: map[string]chan model.Message{},
+		subs: map[string]*subscriber{},
 	}
 	return nil
 }
 
+// DeleteTopic drops the topic and closes its subscribers' channels. They are
+// closed after b.mu is released, as closing waits out a blocked delivery.
 func (b *MemoryBroker) DeleteTopic(_ context.Context, topicID string) error {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	t, ok := b.topics[topicID]
+	if ok {
+		delete(b.topics, topicID)
+	}
+	b.mu.Unlock()
 	if !ok {
 		return nil
 	}
-	for _, ch := range t.subs {
-		close(ch)
+	for _, sub := range t.subs {
+		sub.close()
 	}
-	delete(b.topics, topicID)
 	return nil
 }
 
-func (b *MemoryBroker) Subscribe(_ context.Context, agentID, topicID string) (<-chan model.Message, error) {
+func (b *MemoryBroker) Subscribe(_ context.Context, agentID, topicID string, filter Filter, overflow *Overflow) (<-chan model.Message, error) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	t, ok := b.topics[topicID]
 	if !ok {
-		t = &topicState{id: topicID, subs: map[string]chan model.Message{}}
+		t = &topicState{id: topicID, subs: map[string]*subscriber{}}
 		b.topics[topicID] = t
 	}
-	if ch, ok := t.subs[agentID]; ok {
-		return ch, nil
+	o := b.overflowOr(overflow)
+	sub, ok := t.subs[agentID]
+	if !ok {
+		sub = newSubscriber(b.bufferSize, filter, o, func(dropped int64) {
+			t.dropped.Add(dropped)
+			b.lagged(agentID, t.id, sub, dropped, false)
+		})
+		t.subs[agentID] = sub
+	}
+	b.mu.Unlock()
+	if ok {
+		sub.set(&filter, o)
 	}
-	ch := make(chan model.Message, b.bufferSize)
-	t.subs[agentID] = ch
-	return ch, nil
+	return sub.ch, nil
 }
 
 func (b *MemoryBroker) Unsubscribe(_ context.Context, agentID, topicID string) error {
 	b.mu.Lock()
-	defer b.mu.Unlock()
-	t, ok := b.topics[topicID]
-	if !ok {
-		return nil
-	}
-	if ch, ok := t.subs[agentID]; ok {
-		close(ch)
+	var sub *subscriber
+	if t, ok := b.topics[topicID]; ok {
+		sub = t.subs[agentID]
 		delete(t.subs, agentID)
 	}
+	b.mu.Unlock()
+	if sub != nil {
+		sub.close()
+	}
 	return nil
 }
 
@@ -98,15 +279,21 @@ func (b *MemoryBroker) Publish(_ context.Context, msg model.Message) error {
 	}
 	b.mu.RLock()
 	t, ok := b.topics[*msg.TopicID]
-	b.mu.RUnlock()
-	if !ok {
-		return nil
+	var subs map[string]*subscriber
+	if ok {
+		subs = make(map[string]*subscriber, len(t.subs))
+		for agentID, sub := range t.subs {
+			subs[agentID] = sub
+		}
 	}
-	for _, ch := range t.subs {
-		select {
-		case ch <- msg:
-		default:
-			t.dropped.Add(1)
+	b.mu.RUnlock()
+	// Deliveries happen outside the lock: a blocking policy must not hold
+	// up other topics.
+	for agentID, sub := range subs {
+		dropped, cut := sub.deliver(msg)
+		if dropped > 0 {
+			t.dropped.Add(dropped)
+			b.lagged(agentID, t.id, sub, dropped, cut)
 		}
 	}
 	return nil
@@ -116,29 +303,97 @@ func (b *MemoryBroker) SendDirect(_ context.Context, msg model.Message) error {
 	if msg.ToAgentID == nil || *msg.ToAgentID == "" {
 		return fmt.Errorf("missing to_agent_id")
 	}
+	sub := b.mailbox(*msg.ToAgentID)
+	if dropped, cut := sub.deliver(msg); dropped > 0 {
+		b.droppedDirect.Add(dropped)
+		b.lagged(*msg.ToAgentID, "", sub, dropped, cut)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) GetMailbox(_ context.Context, agentID string, overflow *Overflow) (<-chan model.Message, error) {
 	b.mu.Lock()
-	ch, ok := b.direct[*msg.ToAgentID]
+	o := b.overflowOr(overflow)
+	sub, ok := b.direct[agentID]
 	if !ok {
-		ch = make(chan model.Message, b.defaultMail)
-		b.direct[*msg.ToAgentID] = ch
+		sub = b.newMailbox(agentID, o)
 	}
 	b.mu.Unlock()
-	select {
-	case ch <- msg:
-	default:
+	if ok {
+		sub.set(nil, o)
 	}
-	return nil
+	return sub.ch, nil
+}
+
+// mailbox returns the agent's mailbox, opening one on the default policy
+// for an agent that has none.
+func (b *MemoryBroker) mailbox(agentID string) *subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sub, ok := b.direct[agentID]; ok {
+		return sub
+	}
+	return b.newMailbox(agentID, b.overflow)
 }
 
-func (b *MemoryBroker) GetMailbox(_ context.Context, agentID string) (<-chan model.Message, error) {
+// newMailbox opens the agent's mailbox. b.mu must be held.
+func (b *MemoryBroker) newMailbox(agentID string, o Overflow) *subscriber {
+	var sub *subscriber
+	sub = newSubscriber(b.defaultMail, Filter{}, o, func(dropped int64) {
+		b.droppedDirect.Add(dropped)
+		b.lagged(agentID, "", sub, dropped, false)
+	})
+	b.direct[agentID] = sub
+	return sub
+}
+
+// lagged records that the agent lost messages on a topic, or on its mailbox
+// when topicID is empty, and tells its lag watchers. A subscriber that was
+// cut off is forgotten so the agent can subscribe afresh.
+func (b *MemoryBroker) lagged(agentID, topicID string, sub *subscriber, dropped int64, cut bool) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	if ch, ok := b.direct[agentID]; ok {
-		return ch, nil
+	if cut {
+		b.disconnected.Add(1)
+		if topicID == "" {
+			if b.direct[agentID] == sub {
+				delete(b.direct, agentID)
+			}
+		} else if t, ok := b.topics[topicID]; ok && t.subs[agentID] == sub {
+			delete(t.subs, agentID)
+		}
+	}
+	ev := LagEvent{TopicID: topicID, Dropped: dropped, Disconnected: cut}
+	for ch := range b.lagWatchers[agentID] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// WatchLag returns a channel told whenever the agent loses messages to
+// overflow. stop releases and closes it.
+func (b *MemoryBroker) WatchLag(_ context.Context, agentID string) (<-chan LagEvent, func()) {
+	ch := make(chan LagEvent, 16)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.lagWatchers[agentID] == nil {
+		b.lagWatchers[agentID] = map[chan LagEvent]struct{}{}
+	}
+	b.lagWatchers[agentID][ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.lagWatchers[agentID][ch]; !ok {
+			return
+		}
+		delete(b.lagWatchers[agentID], ch)
+		if len(b.lagWatchers[agentID]) == 0 {
+			delete(b.lagWatchers, agentID)
+		}
+		close(ch)
 	}
-	ch := make(chan model.Message, b.defaultMail)
-	b.direct[agentID] = ch
-	return ch, nil
 }
 
 func (b *MemoryBroker) TopicStats(_ context.Context, topicID string) (TopicStats, error) {
@@ -149,8 +404,8 @@ func (b *MemoryBroker) TopicStats(_ context.Context, topicID string) (TopicStats
 		return TopicStats{TopicID: topicID}, nil
 	}
 	buffered := 0
-	for _, ch := range t.subs {
-		buffered += len(ch)
+	for _, sub := range t.subs {
+		buffered += len(sub.ch)
 	}
 	return TopicStats{
 		TopicID:      topicID,
@@ -159,3 +414,25 @@ func (b *MemoryBroker) TopicStats(_ context.Context, topicID string) (TopicStats
 		DroppedMsgs:  t.dropped.Load(),
 	}, nil
 }
+
+func (b *MemoryBroker) Stats(_ context.Context) (Stats, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := Stats{
+		Topics:            len(b.topics),
+		Mailboxes:         len(b.direct),
+		DroppedDirectMsgs: b.droppedDirect.Load(),
+		Disconnected:      b.disconnected.Load(),
+	}
+	for _, t := range b.topics {
+		out.Subscribers += len(t.subs)
+		out.DroppedTopicMsgs += t.dropped.Load()
+		for _, sub := range t.subs {
+			out.BufferedMsgs += len(sub.ch)
+		}
+	}
+	for _, sub := range b.direct {
+		out.BufferedMsgs += len(sub.ch)
+	}
+	return out, nil
+}
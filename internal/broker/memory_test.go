@@ -14,7 +14,7 @@ func TestPublishSubscribe(t *testing.T) {
 	if err := b.CreateTopic(context.Background(), topic); err != nil {
 		t.Fatalf("create topic: %v", err)
 	}
-	ch, err := b.Subscribe(context.Background(), "agent-1", topic.ID)
+	ch, err := b.Subscribe(context.Background(), "agent-1", topic.ID, Filter{}, nil)
 	if err != nil {
 		t.Fatalf("subscribe: %v", err)
 	}
@@ -0,0 +1,65 @@
+package broker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OverflowPolicy decides what happens to a message for a subscriber whose
+// channel is full.
+type OverflowPolicy string
+
+const (
+	// OverflowDropNewest drops the message being delivered.
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowDropOldest drops the oldest buffered message to make room.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowBlock holds the message for up to the timeout until there is
+	// room, then drops it. The publisher does not wait, and no more than a
+	// channel's worth of messages are held.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDisconnect closes the subscriber's channel, cutting off the
+	// slow consumer.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+const defaultOverflowTimeout = time.Second
+
+// Overflow is a subscriber's overflow policy. Timeout only applies to
+// OverflowBlock.
+type Overflow struct {
+	Policy  OverflowPolicy
+	Timeout time.Duration
+}
+
+// ParseOverflow reads an overflow policy and its block timeout as written in
+// configuration. An empty policy is drop-newest and an empty timeout one
+// second.
+func ParseOverflow(policy, timeout string) (Overflow, error) {
+	o := Overflow{Policy: OverflowPolicy(strings.TrimSpace(policy)), Timeout: defaultOverflowTimeout}
+	switch o.Policy {
+	case "":
+		o.Policy = OverflowDropNewest
+	case OverflowDropNewest, OverflowDropOldest, OverflowBlock, OverflowDisconnect:
+	default:
+		return Overflow{}, fmt.Errorf("unknown overflow policy %q", policy)
+	}
+	if v := strings.TrimSpace(timeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Overflow{}, fmt.Errorf("overflow timeout must be a positive duration, got %q", timeout)
+		}
+		o.Timeout = d
+	}
+	return o, nil
+}
+
+// LagEvent tells an agent that messages meant for it were lost to overflow,
+// so it must resync from its cursor. TopicID is empty for the direct
+// mailbox. Disconnected is set when the subscription was cut off.
+type LagEvent struct {
+	TopicID      string `json:"topic_id,omitempty"`
+	Dropped      int64  `json:"dropped"`
+	Disconnected bool   `json:"disconnected"`
+}
@@ -0,0 +1,194 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"opencortex/internal/model"
+)
+
+func TestOverflowPolicies(t *testing.T) {
+	ctx := context.Background()
+	topicID := "topic-1"
+	publish := func(b *MemoryBroker, ids ...string) {
+		for _, id := range ids {
+			if err := b.Publish(ctx, model.Message{ID: id, TopicID: &topicID}); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+		}
+	}
+	drain := func(ch <-chan model.Message) []string {
+		var out []string
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return append(out, "closed")
+				}
+				out = append(out, msg.ID)
+			default:
+				return out
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		overflow Overflow
+		want     string
+	}{
+		{Overflow{Policy: OverflowDropNewest}, "[m1 m2]"},
+		{Overflow{Policy: OverflowDropOldest}, "[m2 m3]"},
+		{Overflow{Policy: OverflowBlock, Timeout: 10 * time.Millisecond}, "[m1 m2]"},
+		{Overflow{Policy: OverflowDisconnect}, "[m1 m2 closed]"},
+	} {
+		b := NewMemory(2)
+		lag, stop := b.WatchLag(ctx, "agent-1")
+		ch, _ := b.Subscribe(ctx, "agent-1", topicID, Filter{}, &tc.overflow)
+		publish(b, "m1", "m2", "m3")
+		var ev LagEvent
+		select {
+		case ev = <-lag:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected a lag event", tc.overflow.Policy)
+		}
+		if ev.TopicID != topicID || ev.Dropped != 1 || ev.Disconnected != (tc.overflow.Policy == OverflowDisconnect) {
+			t.Fatalf("%s: unexpected lag event %+v", tc.overflow.Policy, ev)
+		}
+		if got := drain(ch); fmt.Sprint(got) != tc.want {
+			t.Fatalf("%s: got %v, want %s", tc.overflow.Policy, got, tc.want)
+		}
+		stop()
+		if stats, _ := b.TopicStats(ctx, topicID); stats.DroppedMsgs != 1 {
+			t.Fatalf("%s: expected one dropped message, got %+v", tc.overflow.Policy, stats)
+		}
+	}
+}
+
+func TestOverflowPolicyBelongsToTheSubscriber(t *testing.T) {
+	ctx := context.Background()
+	topicID := "topic-1"
+	b := NewMemory(1)
+	strict, _ := b.Subscribe(ctx, "agent-1", topicID, Filter{}, &Overflow{Policy: OverflowDisconnect})
+	lenient, _ := b.Subscribe(ctx, "agent-2", topicID, Filter{}, nil)
+	for _, id := range []string{"m1", "m2"} {
+		_ = b.Publish(ctx, model.Message{ID: id, TopicID: &topicID})
+	}
+	<-strict
+	if _, ok := <-strict; ok {
+		t.Fatalf("expected the disconnect subscriber to be cut off")
+	}
+	if got := <-lenient; got.ID != "m1" {
+		t.Fatalf("expected the default subscriber to keep m1, got %s", got.ID)
+	}
+	if stats, _ := b.TopicStats(ctx, topicID); stats.Subscribers != 1 {
+		t.Fatalf("expected only the default subscriber left, got %+v", stats)
+	}
+}
+
+func TestSendDirectCountsDropsAndHonorsMailboxOverflow(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemory(1)
+	agentID := "agent-1"
+	send := func(id string) {
+		if err := b.SendDirect(ctx, model.Message{ID: id, ToAgentID: &agentID}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	mailbox, _ := b.GetMailbox(ctx, agentID, nil)
+	send("m1")
+	send("m2")
+	if stats, _ := b.Stats(ctx); stats.DroppedDirectMsgs != 1 {
+		t.Fatalf("expected one dropped direct message, got %+v", stats)
+	}
+	if got := <-mailbox; got.ID != "m1" {
+		t.Fatalf("drop-newest kept %s", got.ID)
+	}
+
+	_, _ = b.GetMailbox(ctx, agentID, &Overflow{Policy: OverflowDisconnect})
+	send("m3")
+	send("m4")
+	<-mailbox
+	if _, ok := <-mailbox; ok {
+		t.Fatalf("expected the slow mailbox to be cut off")
+	}
+	fresh, _ := b.GetMailbox(ctx, agentID, nil)
+	send("m5")
+	if got := <-fresh; got.ID != "m5" {
+		t.Fatalf("expected a fresh mailbox after the cut, got %s", got.ID)
+	}
+	if stats, _ := b.Stats(ctx); stats.DroppedDirectMsgs != 2 || stats.Disconnected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSubscribingWithoutAPolicyRestoresTheDefault(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemory(1)
+	agentID := "agent-1"
+	_, _ = b.GetMailbox(ctx, agentID, &Overflow{Policy: OverflowDisconnect})
+	mailbox, _ := b.GetMailbox(ctx, agentID, nil)
+	for _, id := range []string{"m1", "m2"} {
+		_ = b.SendDirect(ctx, model.Message{ID: id, ToAgentID: &agentID})
+	}
+	if got, ok := <-mailbox; !ok || got.ID != "m1" {
+		t.Fatalf("expected drop-newest once the policy was reset, got %v (open=%v)", got.ID, ok)
+	}
+}
+
+func TestBlockPolicyDoesNotHoldUpPublishers(t *testing.T) {
+	ctx := context.Background()
+	topicID := "topic-1"
+	b := NewMemory(2)
+	ch, _ := b.Subscribe(ctx, "agent-1", topicID, Filter{}, &Overflow{Policy: OverflowBlock, Timeout: time.Second})
+	started := time.Now()
+	for _, id := range []string{"m1", "m2", "m3"} {
+		_ = b.Publish(ctx, model.Message{ID: id, TopicID: &topicID})
+	}
+	if waited := time.Since(started); waited > 500*time.Millisecond {
+		t.Fatalf("publishing waited %s on a full subscriber", waited)
+	}
+	var got []string
+	for len(got) < 3 {
+		select {
+		case msg := <-ch:
+			got = append(got, msg.ID)
+		case <-time.After(500 * time.Millisecond):
+			t.Fatalf("expected the waiting messages once there was room, got %v", got)
+		}
+	}
+	if fmt.Sprint(got) != "[m1 m2 m3]" {
+		t.Fatalf("expected the messages in order, got %v", got)
+	}
+
+	for _, id := range []string{"m4", "m5", "m6"} {
+		_ = b.Publish(ctx, model.Message{ID: id, TopicID: &topicID})
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = b.Unsubscribe(ctx, "agent-1", topicID)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("unsubscribing waited on a blocked delivery")
+	}
+	for range ch {
+	}
+}
+
+func TestParseOverflow(t *testing.T) {
+	if o, err := ParseOverflow("", ""); err != nil || o.Policy != OverflowDropNewest || o.Timeout != time.Second {
+		t.Fatalf("unexpected default %+v (%v)", o, err)
+	}
+	if o, err := ParseOverflow("block", "250ms"); err != nil || o.Timeout != 250*time.Millisecond {
+		t.Fatalf("unexpected block policy %+v (%v)", o, err)
+	}
+	for _, bad := range [][2]string{{"drop-all", ""}, {"block", "soon"}, {"block", "-1s"}} {
+		if _, err := ParseOverflow(bad[0], bad[1]); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
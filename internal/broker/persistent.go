@@ -10,6 +10,8 @@ type MessagePersister interface {
 	PersistMessage(ctx context.Context, msg model.Message) error
 }
 
+var _ Broker = (*PersistentBroker)(nil)
+
 type PersistentBroker struct {
 	base      Broker
 	persister MessagePersister
@@ -27,8 +29,8 @@ func (p *PersistentBroker) DeleteTopic(ctx context.Context, topicID string) erro
 	return p.base.DeleteTopic(ctx, topicID)
 }
 
-func (p *PersistentBroker) Subscribe(ctx context.Context, agentID, topicID string) (<-chan model.Message, error) {
-	return p.base.Subscribe(ctx, agentID, topicID)
+func (p *PersistentBroker) Subscribe(ctx context.Context, agentID, topicID string, filter Filter, overflow *Overflow) (<-chan model.Message, error) {
+	return p.base.Subscribe(ctx, agentID, topicID, filter, overflow)
 }
 
 func (p *PersistentBroker) Unsubscribe(ctx context.Context, agentID, topicID string) error {
@@ -53,10 +55,18 @@ func (p *PersistentBroker) SendDirect(ctx context.Context, msg model.Message) er
 	return p.base.SendDirect(ctx, msg)
 }
 
-func (p *PersistentBroker) GetMailbox(ctx context.Context, agentID string) (<-chan model.Message, error) {
-	return p.base.GetMailbox(ctx, agentID)
+func (p *PersistentBroker) GetMailbox(ctx context.Context, agentID string, overflow *Overflow) (<-chan model.Message, error) {
+	return p.base.GetMailbox(ctx, agentID, overflow)
 }
 
 func (p *PersistentBroker) TopicStats(ctx context.Context, topicID string) (TopicStats, error) {
 	return p.base.TopicStats(ctx, topicID)
 }
+
+func (p *PersistentBroker) Stats(ctx context.Context) (Stats, error) {
+	return p.base.Stats(ctx)
+}
+
+func (p *PersistentBroker) WatchLag(ctx context.Context, agentID string) (<-chan LagEvent, func()) {
+	return p.base.WatchLag(ctx, agentID)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -51,6 +52,22 @@ type Config struct {
 		ChannelBufferSize int    `yaml:"channel_buffer_size"`
 		MessageTTLDefault string `yaml:"message_ttl_default"`
 		MaxMessageSizeKB  int    `yaml:"max_message_size_kb"`
+		// OverflowPolicy is what happens to a message for a subscriber
+		// whose channel is full: drop-newest, drop-oldest, block (for up
+		// to OverflowTimeout) or disconnect. WebSocket clients can pick
+		// their own.
+		OverflowPolicy  string `yaml:"overflow_policy"`
+		OverflowTimeout string `yaml:"overflow_timeout"`
+		// PriorityAging is how long a pending message waits before claims
+		// and inbox listings treat it as one priority higher, up to high, so
+		// a steady stream of urgent work cannot starve the rest. 0/off
+		// disables it.
+		PriorityAging string `yaml:"priority_aging"`
+		// ScheduleRunRetention is how long the run history of recurring
+		// message schedules is kept; the latest 10 runs of each schedule
+		// are kept regardless. 0/off keeps the last 100 runs of each
+		// schedule only.
+		ScheduleRunRetention string `yaml:"schedule_run_retention"`
 	} `yaml:"broker"`
 	Knowledge struct {
 		MaxEntrySizeKB  int  `yaml:"max_entry_size_kb"`
@@ -64,6 +81,18 @@ type Config struct {
 	Sync struct {
 		Enabled bool     `yaml:"enabled"`
 		Remotes []Remote `yaml:"remotes"`
+		// TombstoneRetention is how long deletions are remembered for peers
+		// that have not synced yet. A peer offline for longer may bring
+		// deleted entities back.
+		TombstoneRetention string `yaml:"tombstone_retention"`
+		// KeyFile holds the key that encrypts stored remote API keys. It
+		// defaults to sync.key next to the database.
+		KeyFile string `yaml:"key_file"`
+		// RequireSignatures rejects inbound payloads that are not signed by
+		// a remote's pinned key. Without it, unsigned payloads are still
+		// refused from pinned remotes and, once any key is pinned, from
+		// nodes that cannot be tied to a remote.
+		RequireSignatures bool `yaml:"require_signatures"`
 	} `yaml:"sync"`
 	UI struct {
 		Enabled bool   `yaml:"enabled"`
@@ -81,13 +110,26 @@ type Remote struct {
 	Name string `yaml:"name"`
 	URL  string `yaml:"url"`
 	Key  string `yaml:"api_key"`
-	Sync struct {
+	// PeerKey pins the public key the remote signs its payloads with, as
+	// shown by its sync identity.
+	PeerKey string `yaml:"peer_key"`
+	Sync    struct {
 		Direction        string   `yaml:"direction"`
 		Scope            string   `yaml:"scope"`
 		CollectionIDs    []string `yaml:"collection_ids"`
 		TopicIDs         []string `yaml:"topic_ids"`
 		ConflictStrategy string   `yaml:"conflict_strategy"`
 		Schedule         string   `yaml:"schedule"`
+		// Live keeps a persistent link that forwards changes as they happen.
+		Live bool `yaml:"live"`
+		// Filter keeps knowledge, or parts of it, from being sent to the
+		// remote.
+		Filter struct {
+			ExcludeTags         []string `yaml:"exclude_tags"`
+			ExcludeVisibilities []string `yaml:"exclude_visibilities"`
+			ExcludeMetadataKeys []string `yaml:"exclude_metadata_keys"`
+			RedactFields        []string `yaml:"redact_fields"`
+		} `yaml:"filter"`
 	} `yaml:"sync"`
 }
 
@@ -117,12 +159,17 @@ func Default() Config {
 	cfg.Broker.ChannelBufferSize = 256
 	cfg.Broker.MessageTTLDefault = "7d"
 	cfg.Broker.MaxMessageSizeKB = 512
+	cfg.Broker.OverflowPolicy = "drop-newest"
+	cfg.Broker.OverflowTimeout = "1s"
+	cfg.Broker.PriorityAging = "5m"
+	cfg.Broker.ScheduleRunRetention = "720h"
 	cfg.Knowledge.MaxEntrySizeKB = 1024
 	cfg.Knowledge.FTSEnabled = true
 	cfg.Knowledge.VersionHistory = true
 	cfg.Knowledge.MaxVersionsKept = 100
 	cfg.Agents.AutoDeactivateAfter = "168h"
 	cfg.Sync.Enabled = false
+	cfg.Sync.TombstoneRetention = "720h"
 	cfg.UI.Enabled = true
 	cfg.UI.Title = "Opencortex"
 	cfg.UI.Theme = "auto"
@@ -153,6 +200,14 @@ func Addr(cfg Config) string {
 	return cfg.Server.Host + ":" + strconv.Itoa(cfg.Server.Port)
 }
 
+// SyncKeyFile returns the path of the key that encrypts stored remote API keys.
+func SyncKeyFile(cfg Config) string {
+	if cfg.Sync.KeyFile != "" {
+		return cfg.Sync.KeyFile
+	}
+	return filepath.Join(filepath.Dir(cfg.Database.Path), "sync.key")
+}
+
 func ReadTimeout(cfg Config) time.Duration {
 	d, _ := time.ParseDuration(cfg.Server.ReadTimeout)
 	if d == 0 {
@@ -199,6 +254,21 @@ func overrideFromEnv(cfg *Config) {
 	if v := os.Getenv("OPENCORTEX_AGENTS_AUTO_DEACTIVATE_AFTER"); v != "" {
 		cfg.Agents.AutoDeactivateAfter = v
 	}
+	if v := os.Getenv("OPENCORTEX_BROKER_OVERFLOW_POLICY"); v != "" {
+		cfg.Broker.OverflowPolicy = v
+	}
+	if v := os.Getenv("OPENCORTEX_BROKER_PRIORITY_AGING"); v != "" {
+		cfg.Broker.PriorityAging = v
+	}
+	if v := os.Getenv("OPENCORTEX_BROKER_SCHEDULE_RUN_RETENTION"); v != "" {
+		cfg.Broker.ScheduleRunRetention = v
+	}
+	if v := os.Getenv("OPENCORTEX_SYNC_TOMBSTONE_RETENTION"); v != "" {
+		cfg.Sync.TombstoneRetention = v
+	}
+	if v := os.Getenv("OPENCORTEX_SYNC_KEY_FILE"); v != "" {
+		cfg.Sync.KeyFile = v
+	}
 	if v := os.Getenv("AGENTMESH_ADMIN_KEY"); v != "" {
 		cfg.Auth.AdminKey = v
 	}
@@ -234,8 +304,46 @@ func validate(cfg Config) error {
 			return errors.New("agents.auto_deactivate_after must be a positive duration or 0/off")
 		}
 	}
+	if v := strings.TrimSpace(cfg.Sync.TombstoneRetention); v != "" &&
+		v != "0" &&
+		!strings.EqualFold(v, "off") &&
+		!strings.EqualFold(v, "disabled") {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return errors.New("sync.tombstone_retention must be a positive duration or 0/off")
+		}
+	}
 	if cfg.Broker.ChannelBufferSize <= 0 {
 		return errors.New("broker.channel_buffer_size must be > 0")
 	}
+	switch strings.TrimSpace(cfg.Broker.OverflowPolicy) {
+	case "", "drop-newest", "drop-oldest", "block", "disconnect":
+	default:
+		return fmt.Errorf("invalid broker.overflow_policy: %s", cfg.Broker.OverflowPolicy)
+	}
+	if v := strings.TrimSpace(cfg.Broker.OverflowTimeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return errors.New("broker.overflow_timeout must be a positive duration")
+		}
+	}
+	if v := strings.TrimSpace(cfg.Broker.PriorityAging); v != "" &&
+		v != "0" &&
+		!strings.EqualFold(v, "off") &&
+		!strings.EqualFold(v, "disabled") {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return errors.New("broker.priority_aging must be a positive duration or 0/off")
+		}
+	}
+	if v := strings.TrimSpace(cfg.Broker.ScheduleRunRetention); v != "" &&
+		v != "0" &&
+		!strings.EqualFold(v, "off") &&
+		!strings.EqualFold(v, "disabled") {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return errors.New("broker.schedule_run_retention must be a positive duration or 0/off")
+		}
+	}
 	return nil
 }
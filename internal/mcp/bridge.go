@@ -121,7 +121,7 @@ func (b *Bridge) toolSpecs() []ToolSpec {
 		{Name: "topics_delete", Description: "Delete a topic", Method: http.MethodDelete, Path: "/api/v1/topics/{id}", Resource: "topics", Action: "manage"},
 		{Name: "topics_subscribers", Description: "List topic subscribers", Method: http.MethodGet, Path: "/api/v1/topics/{id}/subscribers", Resource: "topics", Action: "read"},
 		{Name: "topics_messages", Description: "List topic messages", Method: http.MethodGet, Path: "/api/v1/topics/{id}/messages", Resource: "messages", Action: "read", HasQuery: true},
-		{Name: "topics_subscribe", Description: "Subscribe current agent to topic", Method: http.MethodPost, Path: "/api/v1/topics/{id}/subscribe", Resource: "topics", Action: "write", HasPayload: true},
+		{Name: "topics_subscribe", Description: "Subscribe current agent to topic, optionally with a filter (tags, priorities, min_priority, senders, content_types, metadata, metadata_prefix)", Method: http.MethodPost, Path: "/api/v1/topics/{id}/subscribe", Resource: "topics", Action: "write", HasPayload: true},
 		{Name: "topics_unsubscribe", Description: "Unsubscribe current agent from topic", Method: http.MethodDelete, Path: "/api/v1/topics/{id}/subscribe", Resource: "topics", Action: "write"},
 		{Name: "topics_members_add", Description: "Add topic member", Method: http.MethodPost, Path: "/api/v1/topics/{id}/members", Resource: "topics", Action: "manage", HasPayload: true},
 		{Name: "topics_members_remove", Description: "Remove topic member", Method: http.MethodDelete, Path: "/api/v1/topics/{id}/members/{agent_id}", Resource: "topics", Action: "manage"},
@@ -138,17 +138,32 @@ func (b *Bridge) toolSpecs() []ToolSpec {
 		{Name: "groups_members_list", Description: "List group members", Method: http.MethodGet, Path: "/api/v1/groups/{id}/members", Resource: "groups", Action: "read"},
 
 		// Messages
-		{Name: "messages_publish", Description: "Publish a message to topic or direct recipient", Method: http.MethodPost, Path: "/api/v1/messages", Resource: "messages", Action: "write", HasPayload: true},
+		{Name: "messages_publish", Description: "Publish a message to topic or direct recipient, optionally held back until deliver_at or deliver_in_seconds", Method: http.MethodPost, Path: "/api/v1/messages", Resource: "messages", Action: "write", HasPayload: true},
 		{Name: "messages_broadcast", Description: "Broadcast a message to all agents", Method: http.MethodPost, Path: "/api/v1/messages/broadcast", Resource: "messages", Action: "write", HasPayload: true},
 		{Name: "messages_inbox", Description: "Read current agent inbox", Method: http.MethodGet, Path: "/api/v1/messages", Resource: "messages", Action: "read", HasQuery: true},
 		{Name: "messages_get", Description: "Get message by id", Method: http.MethodGet, Path: "/api/v1/messages/{id}", Resource: "messages", Action: "read"},
-		{Name: "messages_claim", Description: "Claim pending messages with a lease", Method: http.MethodPost, Path: "/api/v1/messages/claim", Resource: "messages", Action: "read", HasPayload: true},
+		{Name: "messages_claim", Description: "Claim pending messages with a lease, most urgent first (aged by wait time)", Method: http.MethodPost, Path: "/api/v1/messages/claim", Resource: "messages", Action: "read", HasPayload: true},
 		{Name: "messages_ack", Description: "Acknowledge a claimed message", Method: http.MethodPost, Path: "/api/v1/messages/{id}/ack", Resource: "messages", Action: "write", HasPayload: true},
 		{Name: "messages_nack", Description: "Negative-acknowledge a claimed message", Method: http.MethodPost, Path: "/api/v1/messages/{id}/nack", Resource: "messages", Action: "write", HasPayload: true},
 		{Name: "messages_renew", Description: "Renew a claim lease", Method: http.MethodPost, Path: "/api/v1/messages/{id}/renew", Resource: "messages", Action: "write", HasPayload: true},
 		{Name: "messages_read", Description: "Mark a message as read", Method: http.MethodPost, Path: "/api/v1/messages/{id}/read", Resource: "messages", Action: "write", HasPayload: true},
 		{Name: "messages_thread", Description: "Get a message thread", Method: http.MethodGet, Path: "/api/v1/messages/{id}/thread", Resource: "messages", Action: "read"},
 		{Name: "messages_delete", Description: "Delete a message", Method: http.MethodDelete, Path: "/api/v1/messages/{id}", Resource: "messages", Action: "write"},
+		{Name: "messages_cancel", Description: "Cancel a scheduled message before it is delivered", Method: http.MethodPost, Path: "/api/v1/messages/{id}/cancel", Resource: "messages", Action: "write"},
+
+		// Message schedules
+		{Name: "schedules_create", Description: "Create a recurring message on a cron spec; set template to render content with .Name and .Time", Method: http.MethodPost, Path: "/api/v1/schedules", Resource: "messages", Action: "write", HasPayload: true},
+		{Name: "schedules_list", Description: "List message schedules", Method: http.MethodGet, Path: "/api/v1/schedules", Resource: "messages", Action: "read", HasQuery: true},
+		{Name: "schedules_get", Description: "Get a message schedule", Method: http.MethodGet, Path: "/api/v1/schedules/{id}", Resource: "messages", Action: "read"},
+		{Name: "schedules_pause", Description: "Pause a message schedule", Method: http.MethodPost, Path: "/api/v1/schedules/{id}/pause", Resource: "messages", Action: "write"},
+		{Name: "schedules_resume", Description: "Resume a paused message schedule", Method: http.MethodPost, Path: "/api/v1/schedules/{id}/resume", Resource: "messages", Action: "write"},
+		{Name: "schedules_run", Description: "Send a schedule's message now", Method: http.MethodPost, Path: "/api/v1/schedules/{id}/run", Resource: "messages", Action: "write"},
+		{Name: "schedules_runs", Description: "List a schedule's recent runs", Method: http.MethodGet, Path: "/api/v1/schedules/{id}/runs", Resource: "messages", Action: "read", HasQuery: true},
+		{Name: "schedules_delete", Description: "Delete a message schedule", Method: http.MethodDelete, Path: "/api/v1/schedules/{id}", Resource: "messages", Action: "write"},
+		{Name: "messages_lag", Description: "Pending backlog and oldest wait per priority, optionally by topic_id or group_id", Method: http.MethodGet, Path: "/api/v1/messages/lag", Resource: "messages", Action: "read", HasQuery: true},
+		{Name: "messages_dlq_list", Description: "List dead-lettered deliveries, filtered by topic_id, group_id, from_agent_id, agent_id or last_error", Method: http.MethodGet, Path: "/api/v1/messages/dead-letter", Resource: "messages", Action: "manage", HasQuery: true},
+		{Name: "messages_dlq_replay", Description: "Requeue dead-lettered deliveries with their attempts reset, by receipt_ids, filters or all", Method: http.MethodPost, Path: "/api/v1/messages/dead-letter/replay", Resource: "messages", Action: "manage", HasPayload: true},
+		{Name: "messages_dlq_purge", Description: "Permanently discard dead-lettered deliveries, by receipt_ids, filters or all", Method: http.MethodPost, Path: "/api/v1/messages/dead-letter/purge", Resource: "messages", Action: "manage", HasPayload: true},
 
 		// Knowledge
 		{Name: "knowledge_create", Description: "Create knowledge entry", Method: http.MethodPost, Path: "/api/v1/knowledge", Resource: "knowledge", Action: "write", HasPayload: true},
@@ -188,14 +203,16 @@ func (b *Bridge) toolSpecs() []ToolSpec {
 		{Name: "sync_remotes_list", Description: "List sync remotes", Method: http.MethodGet, Path: "/api/v1/sync/remotes", Resource: "sync", Action: "read"},
 		{Name: "sync_remotes_add", Description: "Add sync remote", Method: http.MethodPost, Path: "/api/v1/sync/remotes", Resource: "sync", Action: "write", HasPayload: true},
 		{Name: "sync_remotes_delete", Description: "Delete sync remote", Method: http.MethodDelete, Path: "/api/v1/sync/remotes/{name}", Resource: "sync", Action: "write"},
-		{Name: "sync_push", Description: "Push sync payload", Method: http.MethodPost, Path: "/api/v1/sync/push", Resource: "sync", Action: "write", HasPayload: true},
-		{Name: "sync_pull", Description: "Pull sync payload", Method: http.MethodPost, Path: "/api/v1/sync/pull", Resource: "sync", Action: "write", HasPayload: true},
+		{Name: "sync_push", Description: "Push sync payload; set dry_run to preview the changes without writing", Method: http.MethodPost, Path: "/api/v1/sync/push", Resource: "sync", Action: "write", HasPayload: true},
+		{Name: "sync_pull", Description: "Pull sync payload; set dry_run to preview the changes without writing", Method: http.MethodPost, Path: "/api/v1/sync/pull", Resource: "sync", Action: "write", HasPayload: true},
 		{Name: "sync_status", Description: "Get sync status", Method: http.MethodGet, Path: "/api/v1/sync/status", Resource: "sync", Action: "read", HasQuery: true},
 		{Name: "sync_logs", Description: "Get sync logs", Method: http.MethodGet, Path: "/api/v1/sync/logs", Resource: "sync", Action: "read", HasQuery: true},
 		{Name: "sync_diff_get", Description: "Get sync diff", Method: http.MethodGet, Path: "/api/v1/sync/diff", Resource: "sync", Action: "read", HasQuery: true},
 		{Name: "sync_diff_post", Description: "Post sync diff request", Method: http.MethodPost, Path: "/api/v1/sync/diff", Resource: "sync", Action: "write", HasPayload: true},
 		{Name: "sync_inbound_push", Description: "Inbound push endpoint", Method: http.MethodPost, Path: "/api/v1/sync/push/inbound", Resource: "sync", Action: "write", HasPayload: true},
 		{Name: "sync_inbound_pull", Description: "Inbound pull endpoint", Method: http.MethodPost, Path: "/api/v1/sync/pull/inbound", Resource: "sync", Action: "write", HasPayload: true},
+		{Name: "sync_identity", Description: "Get this node's sync signing identity", Method: http.MethodGet, Path: "/api/v1/sync/identity", Resource: "sync", Action: "read"},
+		{Name: "sync_peers", Description: "List the nodes this node has synced with and the versions each is known to hold", Method: http.MethodGet, Path: "/api/v1/sync/peers", Resource: "sync", Action: "read"},
 		{Name: "sync_conflicts_list", Description: "List sync conflicts", Method: http.MethodGet, Path: "/api/v1/sync/conflicts", Resource: "sync", Action: "read", HasQuery: true},
 		{Name: "sync_conflicts_resolve", Description: "Resolve sync conflict", Method: http.MethodPost, Path: "/api/v1/sync/conflicts/{id}/resolve", Resource: "sync", Action: "write", HasPayload: true},
 
@@ -204,7 +221,7 @@ func (b *Bridge) toolSpecs() []ToolSpec {
 		{Name: "admin_config", Description: "Get admin config", Method: http.MethodGet, Path: "/api/v1/admin/config", Resource: "admin", Action: "read"},
 		{Name: "admin_backup", Description: "Run backup", Method: http.MethodPost, Path: "/api/v1/admin/backup", Resource: "admin", Action: "write", HasPayload: true},
 		{Name: "admin_vacuum", Description: "Run vacuum", Method: http.MethodPost, Path: "/api/v1/admin/vacuum", Resource: "admin", Action: "write", HasPayload: true},
-		{Name: "admin_messages_purge_expired", Description: "Purge expired messages", Method: http.MethodDelete, Path: "/api/v1/admin/messages/expired", Resource: "admin", Action: "write"},
+		{Name: "admin_messages_purge_expired", Description: "Purge messages per topic retention policy (expired, ttl and delivered none-topic messages)", Method: http.MethodDelete, Path: "/api/v1/admin/messages/expired", Resource: "admin", Action: "write"},
 		{Name: "admin_rbac_roles", Description: "List RBAC roles", Method: http.MethodGet, Path: "/api/v1/admin/rbac/roles", Resource: "admin", Action: "manage"},
 		{Name: "admin_rbac_assign", Description: "Assign RBAC role", Method: http.MethodPost, Path: "/api/v1/admin/rbac/assign", Resource: "admin", Action: "manage", HasPayload: true},
 		{Name: "admin_rbac_revoke", Description: "Revoke RBAC role", Method: http.MethodDelete, Path: "/api/v1/admin/rbac/assign", Resource: "admin", Action: "manage", HasPayload: true},
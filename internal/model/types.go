@@ -95,6 +95,42 @@ type Message struct {
 	ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
 	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
 	ReadAt      *time.Time      `json:"read_at,omitempty"`
+	// DeliverAt holds the message back from its recipients until then.
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+}
+
+// MessageSchedule sends a message each time its cron spec fires. Content is
+// sent verbatim, or rendered as a Go template with .Name and .Time when
+// Template is set.
+type MessageSchedule struct {
+	ID               string          `json:"id"`
+	Name             string          `json:"name"`
+	Cron             string          `json:"cron"`
+	CreatedBy        string          `json:"created_by"`
+	ToAgentID        *string         `json:"to_agent_id,omitempty"`
+	TopicID          *string         `json:"topic_id,omitempty"`
+	ToGroupID        *string         `json:"to_group_id,omitempty"`
+	ContentType      string          `json:"content_type"`
+	Content          string          `json:"content"`
+	Template         bool            `json:"template"`
+	Priority         MessagePriority `json:"priority"`
+	Tags             []string        `json:"tags"`
+	Metadata         map[string]any  `json:"metadata"`
+	ExpiresInSeconds int             `json:"expires_in_seconds,omitempty"`
+	Paused           bool            `json:"paused"`
+	LastRunAt        *time.Time      `json:"last_run_at,omitempty"`
+	NextRunAt        *time.Time      `json:"next_run_at,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+}
+
+type MessageScheduleRun struct {
+	ID           string    `json:"id"`
+	ScheduleID   string    `json:"schedule_id"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	RanAt        time.Time `json:"ran_at"`
+	MessageID    *string   `json:"message_id,omitempty"`
+	Error        *string   `json:"error,omitempty"`
 }
 
 type Subscription struct {
@@ -202,10 +238,61 @@ type SyncManifest struct {
 	RemoteName string        `json:"remote_name"`
 	Direction  SyncDirection `json:"direction"`
 	Scope      SyncScope     `json:"scope"`
-	ScopeIDs   []string      `json:"scope_ids"`
-	LastSyncAt *time.Time    `json:"last_sync_at,omitempty"`
-	LastSyncOK *bool         `json:"last_sync_ok,omitempty"`
-	CreatedAt  time.Time     `json:"created_at"`
+	// ScopeIDs lists the synced collections and TopicIDs the synced topics.
+	ScopeIDs   []string   `json:"scope_ids"`
+	TopicIDs   []string   `json:"topic_ids"`
+	Strategy   string     `json:"strategy"`
+	Schedule   *string    `json:"schedule,omitempty"`
+	LastSyncAt *time.Time `json:"last_sync_at,omitempty"`
+	LastSyncOK *bool      `json:"last_sync_ok,omitempty"`
+	// PushCursor and PullCursor are the change-log high-water marks of the
+	// last successful push and pull, valid for the scope in CursorScope.
+	PushCursor  int64  `json:"push_cursor"`
+	PullCursor  int64  `json:"pull_cursor"`
+	CursorScope string `json:"cursor_scope,omitempty"`
+	// Failures counts consecutive failed syncs; NextRetryAt is when the
+	// scheduler retries after the last of them.
+	Failures    int        `json:"failures"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+	LastError   *string    `json:"last_error,omitempty"`
+	// PeerPublicKey is the pinned key the remote signs its payloads with.
+	PeerPublicKey string `json:"peer_public_key,omitempty"`
+	// Live keeps a persistent link to the remote that forwards changes as
+	// they happen, on top of scheduled and manual syncs.
+	Live bool `json:"live"`
+	// Filter keeps knowledge, or parts of it, from being sent to the remote.
+	Filter    SyncFilter `json:"filter"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// SyncFilter holds what a remote must never receive. Knowledge entries
+// carrying an excluded tag or at an excluded visibility are not sent at all;
+// of those that are, the excluded metadata keys are stripped and the
+// redacted fields blanked out.
+type SyncFilter struct {
+	ExcludeTags         []string `json:"exclude_tags,omitempty"`
+	ExcludeVisibilities []string `json:"exclude_visibilities,omitempty"`
+	ExcludeMetadataKeys []string `json:"exclude_metadata_keys,omitempty"`
+	RedactFields        []string `json:"redact_fields,omitempty"`
+}
+
+// RemoteDrift describes how a remote declared in the config file differed
+// from the stored remote of the same name. Action is "created", "updated",
+// "unchanged" or "unmanaged" for stored remotes absent from the config.
+type RemoteDrift struct {
+	Remote string   `json:"remote"`
+	Action string   `json:"action"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// SyncPeer is a node this node has exchanged sync payloads with. Clock holds,
+// per origin node, the highest change counter the peer is known to have
+// seen; RemoteName is the remote it was reached through, if any.
+type SyncPeer struct {
+	NodeID     string           `json:"node_id"`
+	RemoteName *string          `json:"remote_name,omitempty"`
+	Clock      map[string]int64 `json:"clock"`
+	LastSeenAt time.Time        `json:"last_seen_at"`
 }
 
 type SyncStatus string
@@ -231,16 +318,29 @@ type SyncLog struct {
 }
 
 type SyncConflict struct {
-	ID             string     `json:"id"`
-	ManifestID     string     `json:"manifest_id"`
-	EntityType     string     `json:"entity_type"`
-	EntityID       string     `json:"entity_id"`
-	LocalChecksum  string     `json:"local_checksum"`
-	RemoteChecksum string     `json:"remote_checksum"`
-	Strategy       string     `json:"strategy"`
-	Status         string     `json:"status"`
-	CreatedAt      time.Time  `json:"created_at"`
-	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ID             string         `json:"id"`
+	ManifestID     string         `json:"manifest_id"`
+	EntityType     string         `json:"entity_type"`
+	EntityID       string         `json:"entity_id"`
+	LocalChecksum  string         `json:"local_checksum"`
+	RemoteChecksum string         `json:"remote_checksum"`
+	Strategy       string         `json:"strategy"`
+	Status         string         `json:"status"`
+	LocalPayload   map[string]any `json:"local_payload,omitempty"`
+	RemotePayload  map[string]any `json:"remote_payload,omitempty"`
+	Diff           string         `json:"diff,omitempty"`
+	Note           *string        `json:"note,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	ResolvedAt     *time.Time     `json:"resolved_at,omitempty"`
+}
+
+// Tombstone records the deletion of a synced entity so peers delete it too
+// instead of resurrecting it on their next sync.
+type Tombstone struct {
+	EntityType string    `json:"entity_type"`
+	EntityID   string    `json:"id"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	OriginNode string    `json:"origin_node,omitempty"`
 }
 
 type Permission struct {
@@ -0,0 +1,110 @@
+// Package schedule runs keyed cron jobs whose specs are reloaded from
+// storage at runtime.
+package schedule
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Jobs runs one cron job per key. Set takes the full set of keys that
+// should be scheduled, so callers reload their specs from storage and hand
+// them all over at once.
+type Jobs struct {
+	cron    *cron.Cron
+	label   string
+	run     func(key string)
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	id   cron.EntryID
+	spec string
+}
+
+// New returns Jobs that call run with the key of each job that fires.
+// label names the jobs in log lines.
+func New(label string, run func(key string)) *Jobs {
+	return &Jobs{
+		cron:    cron.New(),
+		label:   label,
+		run:     run,
+		entries: map[string]entry{},
+	}
+}
+
+// Set schedules each key in want on its spec, replacing the jobs whose
+// spec changed and dropping those of keys no longer wanted. A spec cron
+// rejects is logged and left unscheduled.
+func (j *Jobs) Set(want map[string]string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for key, e := range j.entries {
+		if want[key] != e.spec {
+			j.cron.Remove(e.id)
+			delete(j.entries, key)
+		}
+	}
+	for key, spec := range want {
+		if _, ok := j.entries[key]; ok {
+			continue
+		}
+		k := key
+		id, err := j.cron.AddFunc(spec, func() {
+			j.run(k)
+		})
+		if err != nil {
+			log.Printf("%s %q for %s rejected: %v", j.label, spec, key, err)
+			continue
+		}
+		j.entries[key] = entry{id: id, spec: spec}
+	}
+}
+
+// Len returns how many jobs are scheduled.
+func (j *Jobs) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.entries)
+}
+
+// Prev returns when the job for key last fired, zero when it has not yet.
+// ok is false when key is not scheduled.
+func (j *Jobs) Prev(key string) (prev time.Time, ok bool) {
+	j.mu.Lock()
+	e, ok := j.entries[key]
+	j.mu.Unlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	return j.cron.Entry(e.id).Prev, true
+}
+
+func (j *Jobs) Start() {
+	j.cron.Start()
+}
+
+func (j *Jobs) Stop() context.Context {
+	return j.cron.Stop()
+}
+
+// Watch calls reload whenever changed fires, until ctx is done.
+func (j *Jobs) Watch(ctx context.Context, changed <-chan struct{}, reload func(context.Context) error) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				if err := reload(ctx); err != nil {
+					log.Printf("%s reload failed: %v", j.label, err)
+				}
+			}
+		}
+	}()
+}
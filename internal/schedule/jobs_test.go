@@ -0,0 +1,26 @@
+package schedule
+
+import "testing"
+
+func TestSetReplacesChangedAndDropsUnwantedJobs(t *testing.T) {
+	jobs := New("test job", func(string) {})
+	jobs.Set(map[string]string{"a": "@every 1h", "b": "@every 2h", "bad": "not a spec"})
+	if jobs.Len() != 2 {
+		t.Fatalf("expected the two valid jobs scheduled, got %d", jobs.Len())
+	}
+	first := jobs.entries["a"].id
+
+	jobs.Set(map[string]string{"a": "@every 3h"})
+	if jobs.Len() != 1 {
+		t.Fatalf("expected the unwanted jobs dropped, got %d", jobs.Len())
+	}
+	if e := jobs.entries["a"]; e.id == first || e.spec != "@every 3h" {
+		t.Fatalf("expected the changed job rescheduled, got %+v", e)
+	}
+	if _, ok := jobs.Prev("b"); ok {
+		t.Fatal("expected no job for a dropped key")
+	}
+	if prev, ok := jobs.Prev("a"); !ok || !prev.IsZero() {
+		t.Fatalf("expected a scheduled job that has not fired, got %v %v", prev, ok)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -43,30 +45,68 @@ type App struct {
 	Broker        broker.Broker
 	KnowledgeSink chan model.KnowledgeEntry
 	AgentSink     chan model.Agent
+	// RemotesChanged is signalled when a sync remote is added, changed or
+	// removed so the scheduler can reload.
+	RemotesChanged chan struct{}
+	// SchedulesChanged is signalled when a message schedule is added,
+	// paused, resumed or removed so the message scheduler can reload.
+	SchedulesChanged chan struct{}
+
+	secretOnce sync.Once
+	secret     []byte
+	secretErr  error
+
+	identityMu sync.Mutex
+	identity   auth.Identity
+
+	watchMu  sync.Mutex
+	watchers map[chan struct{}]struct{}
 }
 
 func New(cfg config.Config, store *repos.Store, broker broker.Broker) *App {
 	a := &App{
-		Config:        cfg,
-		Store:         store,
-		Broker:        broker,
-		KnowledgeSink: make(chan model.KnowledgeEntry, 256),
-		AgentSink:     make(chan model.Agent, 256),
+		Config:           cfg,
+		Store:            store,
+		Broker:           broker,
+		KnowledgeSink:    make(chan model.KnowledgeEntry, 256),
+		AgentSink:        make(chan model.Agent, 256),
+		RemotesChanged:   make(chan struct{}, 1),
+		SchedulesChanged: make(chan struct{}, 1),
 	}
 	go a.sweepLoop()
+	go a.scheduleLoop()
 	return a
 }
 
 func (a *App) sweepLoop() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
-	agentTTL := parseAutoDeactivateAfter(a.Config.Agents.AutoDeactivateAfter)
+	agentTTL := parseDurationSetting(a.Config.Agents.AutoDeactivateAfter)
+	tombstoneTTL := parseDurationSetting(a.Config.Sync.TombstoneRetention)
+	scheduleRunTTL := parseDurationSetting(a.Config.Broker.ScheduleRunRetention)
 	for {
 		<-ticker.C
 		_, _, _ = a.Store.SweepDeliveries(context.Background())
 		if agentTTL > 0 {
 			_, _ = a.Store.DeleteInactiveAutoAgentsCascade(context.Background(), nowUTC().Add(-agentTTL))
 		}
+		if tombstoneTTL > 0 {
+			_, _ = a.Store.PurgeTombstones(context.Background(), nowUTC().Add(-tombstoneTTL))
+		}
+		_, _ = a.CompactMessages(context.Background())
+		if scheduleRunTTL > 0 {
+			_, _ = a.Store.PruneMessageScheduleRuns(context.Background(), nowUTC().Add(-scheduleRunTTL))
+		}
+	}
+}
+
+// scheduleLoop pushes scheduled messages to the broker as they become due.
+func (a *App) scheduleLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		_, _ = a.DispatchDueMessages(context.Background())
 	}
 }
 
@@ -104,6 +144,9 @@ func (a *App) BootstrapInit(ctx context.Context, adminName string) (model.Agent,
 	if _, err := a.EnsureBroadcastSetup(ctx, agent.ID); err != nil {
 		return model.Agent{}, "", err
 	}
+	if _, err := a.NodeIdentity(ctx); err != nil {
+		return model.Agent{}, "", err
+	}
 	return agent, raw, nil
 }
 
@@ -123,6 +166,16 @@ func (a *App) Authenticate(ctx context.Context, rawKey string) (AuthContext, err
 	if agent.Status != model.AgentStatusActive {
 		return AuthContext{}, ErrForbidden
 	}
+	authCtx, err := a.authContextFor(ctx, agent)
+	if err != nil {
+		return AuthContext{}, err
+	}
+	_ = a.Store.UpdateLastSeen(ctx, agent.ID)
+	return authCtx, nil
+}
+
+// authContextFor loads the roles and permissions the agent acts with.
+func (a *App) authContextFor(ctx context.Context, agent model.Agent) (AuthContext, error) {
 	roles, err := a.Store.AgentRoles(ctx, agent.ID)
 	if err != nil {
 		return AuthContext{}, err
@@ -135,7 +188,6 @@ func (a *App) Authenticate(ctx context.Context, rawKey string) (AuthContext, err
 	if err != nil {
 		return AuthContext{}, err
 	}
-	_ = a.Store.UpdateLastSeen(ctx, agent.ID)
 	return AuthContext{
 		Agent:      agent,
 		Roles:      roles,
@@ -267,7 +319,13 @@ func (a *App) AutoRegisterLocal(ctx context.Context, name, fingerprint string) (
 	return model.Agent{}, "", fmt.Errorf("%w: could not allocate unique auto-register name", ErrConflict)
 }
 
-func parseAutoDeactivateAfter(raw string) time.Duration {
+// PriorityAging is how long a pending message waits before it is ordered as
+// one priority higher.
+func (a *App) PriorityAging() time.Duration {
+	return parseDurationSetting(a.Config.Broker.PriorityAging)
+}
+
+func parseDurationSetting(raw string) time.Duration {
 	raw = strings.TrimSpace(raw)
 	if raw == "" || raw == "0" || strings.EqualFold(raw, "off") || strings.EqualFold(raw, "disabled") {
 		return 0
@@ -288,6 +346,7 @@ func (a *App) CreateTopic(ctx context.Context, in repos.CreateTopicInput) (model
 		return model.Topic{}, err
 	}
 	_ = a.Broker.CreateTopic(ctx, topic)
+	a.notifyChanged()
 	return topic, nil
 }
 
@@ -295,6 +354,29 @@ func (a *App) CreateMessage(ctx context.Context, in repos.CreateMessageInput) (m
 	if in.ID == "" {
 		in.ID = uuid.NewString()
 	}
+	// A delivery time that has already passed means deliver now.
+	if in.DeliverAt != nil && !in.DeliverAt.After(nowUTC()) {
+		in.DeliverAt = nil
+	}
+	visibleAt := nowUTC()
+	if in.DeliverAt != nil {
+		visibleAt = in.DeliverAt.UTC()
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(visibleAt) {
+		return model.Message{}, fmt.Errorf("%w: message would expire before it is delivered", ErrValidation)
+	}
+	if in.TopicID != nil {
+		topic, err := a.Store.GetTopicByID(ctx, *in.TopicID)
+		if err != nil && err != sql.ErrNoRows {
+			return model.Message{}, err
+		}
+		if topic.Retention == model.TopicRetentionTTL && topic.TTLSeconds != nil && *topic.TTLSeconds > 0 {
+			expires := visibleAt.Add(time.Duration(*topic.TTLSeconds) * time.Second)
+			if in.ExpiresAt == nil || in.ExpiresAt.After(expires) {
+				in.ExpiresAt = &expires
+			}
+		}
+	}
 	if in.QueueMode && in.ToGroupID == nil {
 		return model.Message{}, fmt.Errorf("%w: queue_mode requires to_group_id", ErrValidation)
 	}
@@ -308,11 +390,26 @@ func (a *App) CreateMessage(ctx context.Context, in repos.CreateMessageInput) (m
 		recipients = append(recipients, *in.ToAgentID)
 	}
 	if in.TopicID != nil {
-		topicRecipients, err := a.Store.RecipientsForTopic(ctx, *in.TopicID)
+		subs, err := a.Store.TopicSubscriptions(ctx, *in.TopicID)
 		if err != nil {
 			return model.Message{}, err
 		}
-		recipients = append(recipients, topicRecipients...)
+		candidate := model.Message{
+			FromAgentID: in.FromAgentID,
+			TopicID:     in.TopicID,
+			ContentType: in.ContentType,
+			Priority:    in.Priority,
+			Tags:        in.Tags,
+			Metadata:    in.Metadata,
+		}
+		if candidate.ContentType == "" {
+			candidate.ContentType = "text/plain"
+		}
+		for _, sub := range subs {
+			if subscriptionFilter(sub).Match(candidate) {
+				recipients = append(recipients, sub.AgentID)
+			}
+		}
 	}
 	if in.ToGroupID != nil {
 		group, err := a.Store.GetGroupByID(ctx, *in.ToGroupID)
@@ -343,6 +440,17 @@ func (a *App) CreateMessage(ctx context.Context, in repos.CreateMessageInput) (m
 	if err != nil {
 		return model.Message{}, err
 	}
+	// A scheduled message is pushed to the broker by the scheduler once it
+	// is due.
+	if msg.DeliverAt == nil {
+		a.dispatch(ctx, msg, groupMembers)
+	}
+	a.notifyChanged()
+	return msg, nil
+}
+
+// dispatch pushes a message to the broker channels of its recipients.
+func (a *App) dispatch(ctx context.Context, msg model.Message, groupMembers []string) {
 	if msg.TopicID != nil {
 		_ = a.Broker.Publish(ctx, msg)
 	}
@@ -357,7 +465,141 @@ func (a *App) CreateMessage(ctx context.Context, in repos.CreateMessageInput) (m
 			_ = a.Broker.SendDirect(ctx, direct)
 		}
 	}
-	return msg, nil
+}
+
+// DispatchDueMessages pushes the scheduled messages that have become due to
+// the broker, and so to WebSocket clients, and returns how many it sent.
+func (a *App) DispatchDueMessages(ctx context.Context) (int, error) {
+	msgs, err := a.Store.DispatchDueMessages(ctx, nowUTC(), 100)
+	for _, msg := range msgs {
+		var groupMembers []string
+		if msg.ToGroupID != nil {
+			groupMembers, _ = a.Store.ListGroupMemberIDs(ctx, *msg.ToGroupID)
+		}
+		a.dispatch(ctx, msg, groupMembers)
+	}
+	if len(msgs) > 0 {
+		a.notifyChanged()
+	}
+	return len(msgs), err
+}
+
+// CancelScheduledMessage withdraws a scheduled message before it is
+// delivered. Only its sender may cancel it.
+func (a *App) CancelScheduledMessage(ctx context.Context, messageID, agentID string) error {
+	msg, err := a.Store.GetMessageByID(ctx, messageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: message not found", ErrNotFound)
+		}
+		return err
+	}
+	if msg.FromAgentID != agentID {
+		return fmt.Errorf("%w: sender only", ErrForbidden)
+	}
+	cancelled, err := a.Store.CancelScheduledMessage(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return fmt.Errorf("%w: message is not scheduled or has already been delivered", ErrConflict)
+	}
+	return nil
+}
+
+// SubscribeTopic subscribes the agent to the topic and returns its broker
+// channel, which only receives the messages passing the subscription's
+// filter. A nil filter keeps the filter of an existing subscription; a nil
+// overflow puts the channel on the broker's default policy.
+func (a *App) SubscribeTopic(ctx context.Context, agentID, topicID string, filter map[string]any, overflow *broker.Overflow) (<-chan model.Message, error) {
+	if _, err := broker.ParseFilter(filter); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrValidation, err)
+	}
+	if err := a.Store.Subscribe(ctx, agentID, topicID, filter); err != nil {
+		return nil, err
+	}
+	sub, err := a.Store.GetSubscription(ctx, agentID, topicID)
+	if err != nil {
+		return nil, err
+	}
+	return a.Broker.Subscribe(ctx, agentID, topicID, subscriptionFilter(sub), overflow)
+}
+
+// subscriptionFilter reads the filter of a subscription. A stored filter it
+// cannot read matches nothing, rather than letting every message through,
+// until the agent subscribes again with a valid one.
+func subscriptionFilter(sub model.Subscription) broker.Filter {
+	f, err := broker.ParseFilter(sub.Filter)
+	if err != nil {
+		log.Printf("subscription of %s to topic %s has an unreadable filter; delivering nothing: %v", sub.AgentID, sub.TopicID, err)
+		return broker.MatchNothing
+	}
+	return f
+}
+
+// CompactMessages purges messages according to each topic's retention
+// policy and records what it removed in the audit log.
+func (a *App) CompactMessages(ctx context.Context) (repos.CompactionReport, error) {
+	report, err := a.Store.CompactMessages(ctx, nowUTC())
+	if report.Total() > 0 {
+		_ = a.Store.AddAuditLog(ctx, model.AuditLog{
+			ID:       uuid.NewString(),
+			Action:   "messages.compact",
+			Resource: "messages",
+			Metadata: map[string]any{
+				"expired":   report.Expired,
+				"ttl":       report.TTL,
+				"delivered": report.Delivered,
+			},
+			CreatedAt: nowUTC(),
+		})
+	}
+	return report, err
+}
+
+// PublishSynced hands a message received from a sync peer to the agents
+// listening here, as if it had been sent on this node. Messages scheduled
+// for later are left to the scheduler.
+func (a *App) PublishSynced(ctx context.Context, msg model.Message) {
+	if msg.DeliverAt != nil && msg.DeliverAt.After(nowUTC()) {
+		return
+	}
+	if msg.TopicID != nil {
+		_ = a.Broker.Publish(ctx, msg)
+	}
+	if msg.ToAgentID != nil {
+		_ = a.Broker.SendDirect(ctx, msg)
+	}
+}
+
+// WatchChanges returns a channel signalled after messages, knowledge, topics
+// or collections are written on this node, for live sync links to forward
+// them. Signals are coalesced; other writes go out with the next one. stop
+// releases the channel.
+func (a *App) WatchChanges() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	a.watchMu.Lock()
+	if a.watchers == nil {
+		a.watchers = map[chan struct{}]struct{}{}
+	}
+	a.watchers[ch] = struct{}{}
+	a.watchMu.Unlock()
+	return ch, func() {
+		a.watchMu.Lock()
+		delete(a.watchers, ch)
+		a.watchMu.Unlock()
+	}
+}
+
+func (a *App) notifyChanged() {
+	a.watchMu.Lock()
+	defer a.watchMu.Unlock()
+	for ch := range a.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
 }
 
 func (a *App) CreateBroadcastMessage(ctx context.Context, in repos.CreateMessageInput) (model.Message, error) {
@@ -390,6 +632,7 @@ func (a *App) CreateKnowledge(ctx context.Context, in repos.CreateKnowledgeInput
 	case a.KnowledgeSink <- entry:
 	default:
 	}
+	a.notifyChanged()
 	return entry, nil
 }
 
@@ -408,6 +651,7 @@ func (a *App) UpdateKnowledgeContent(ctx context.Context, in repos.UpdateKnowled
 	case a.KnowledgeSink <- entry:
 	default:
 	}
+	a.notifyChanged()
 	return entry, nil
 }
 
@@ -415,17 +659,35 @@ func (a *App) CreateCollection(ctx context.Context, in repos.CreateCollectionInp
 	if in.ID == "" {
 		in.ID = uuid.NewString()
 	}
-	return a.Store.CreateCollection(ctx, in)
+	collection, err := a.Store.CreateCollection(ctx, in)
+	if err != nil {
+		return model.Collection{}, err
+	}
+	a.notifyChanged()
+	return collection, nil
 }
 
 func (a *App) AddRemote(ctx context.Context, in repos.CreateRemoteInput, rawAPIKey string) (model.SyncManifest, error) {
 	if in.ID == "" {
 		in.ID = uuid.NewString()
 	}
-	if rawAPIKey != "" {
-		in.APIKeyHash = auth.HashKey(rawAPIKey)
+	if err := a.sealRemoteKey(&in, rawAPIKey); err != nil {
+		return model.SyncManifest{}, err
+	}
+	manifest, err := a.Store.CreateRemote(ctx, in)
+	if err != nil {
+		return model.SyncManifest{}, err
+	}
+	a.notifyRemotesChanged()
+	return manifest, nil
+}
+
+func (a *App) DeleteRemote(ctx context.Context, name string) error {
+	if err := a.Store.DeleteRemote(ctx, name); err != nil {
+		return err
 	}
-	return a.Store.CreateRemote(ctx, in)
+	a.notifyRemotesChanged()
+	return nil
 }
 
 func (a *App) EnsureBroadcastSetup(ctx context.Context, createdBy string) (model.Topic, error) {
@@ -490,6 +752,7 @@ func (a *App) GetInboxAsync(ctx context.Context, agentID, cursor string, filters
 		cursor = c
 	}
 	filters.CursorID = cursor
+	filters.PriorityAging = a.PriorityAging()
 	msgs, err := a.Store.GetInboxMessagesAsync(ctx, agentID, filters)
 	return msgs, cursor, err
 }
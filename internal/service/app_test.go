@@ -2,12 +2,17 @@ package service
 
 import (
 	"context"
+	"errors"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 
 	"opencortex/internal/broker"
 	"opencortex/internal/config"
+	"opencortex/internal/model"
 	"opencortex/internal/storage"
 	"opencortex/internal/storage/repos"
 )
@@ -109,3 +114,392 @@ func TestAutoRegisterLocalReactivatesInactiveFingerprintAgent(t *testing.T) {
 		t.Fatalf("expected reactivated status active, got %s", a2.Status)
 	}
 }
+
+func TestReconcileRemotesCreatesUpdatesAndReportsDrift(t *testing.T) {
+	app := setupServiceTestApp(t)
+	ctx := context.Background()
+
+	var origin config.Remote
+	origin.Name = "origin"
+	origin.URL = "http://hub.example"
+	origin.Key = "amk_remote_first"
+	origin.Sync.ConflictStrategy = "remote-wins"
+	origin.Sync.Schedule = "@every 5m"
+
+	report, err := app.ReconcileRemotes(ctx, []config.Remote{origin})
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(report) != 1 || report[0].Action != "created" {
+		t.Fatalf("expected origin to be created, got %+v", report)
+	}
+	key, err := app.RemoteAPIKey(ctx, "origin")
+	if err != nil || key != "amk_remote_first" {
+		t.Fatalf("stored key = %q, %v", key, err)
+	}
+	manifest, err := app.Store.GetRemote(ctx, "origin")
+	if err != nil {
+		t.Fatalf("get remote: %v", err)
+	}
+	if manifest.Strategy != "remote-wins" || manifest.Schedule == nil || *manifest.Schedule != "@every 5m" {
+		t.Fatalf("unexpected stored remote: %+v", manifest)
+	}
+
+	if report, err = app.ReconcileRemotes(ctx, []config.Remote{origin}); err != nil {
+		t.Fatalf("reconcile again: %v", err)
+	}
+	if len(report) != 1 || report[0].Action != "unchanged" {
+		t.Fatalf("expected origin to be unchanged, got %+v", report)
+	}
+
+	if _, err := app.AddRemote(ctx, repos.CreateRemoteInput{RemoteName: "runtime", RemoteURL: "http://peer.example"}, ""); err != nil {
+		t.Fatalf("add runtime remote: %v", err)
+	}
+	origin.URL = "http://hub2.example"
+	origin.Key = "amk_remote_second"
+	if report, err = app.ReconcileRemotes(ctx, []config.Remote{origin}); err != nil {
+		t.Fatalf("reconcile drifted: %v", err)
+	}
+	byName := map[string]string{}
+	for _, d := range report {
+		byName[d.Remote] = d.Action + ":" + strings.Join(d.Fields, ",")
+	}
+	if byName["origin"] != "updated:url,api_key" || byName["runtime"] != "unmanaged:" {
+		t.Fatalf("unexpected drift report: %+v", report)
+	}
+	if key, _ := app.RemoteAPIKey(ctx, "origin"); key != "amk_remote_second" {
+		t.Fatalf("expected rotated key, got %q", key)
+	}
+	if _, err := app.Store.GetRemote(ctx, "runtime"); err != nil {
+		t.Fatalf("runtime remote should be kept: %v", err)
+	}
+}
+
+func TestCompactMessagesFollowsTopicRetention(t *testing.T) {
+	app := setupServiceTestApp(t)
+	ctx := context.Background()
+
+	sender, _, err := app.AutoRegisterLocal(ctx, "sender", "fp-sender")
+	if err != nil {
+		t.Fatalf("register sender: %v", err)
+	}
+	reader, _, err := app.AutoRegisterLocal(ctx, "reader", "fp-reader")
+	if err != nil {
+		t.Fatalf("register reader: %v", err)
+	}
+	ttl := 60
+	topics := map[model.TopicRetention]string{}
+	for _, retention := range []model.TopicRetention{model.TopicRetentionNone, model.TopicRetentionPersistent, model.TopicRetentionTTL} {
+		in := repos.CreateTopicInput{Name: "retention-" + string(retention), Retention: retention, CreatedBy: sender.ID}
+		if retention == model.TopicRetentionTTL {
+			in.TTLSeconds = &ttl
+		}
+		topic, err := app.CreateTopic(ctx, in)
+		if err != nil {
+			t.Fatalf("create %s topic: %v", retention, err)
+		}
+		if err := app.Store.Subscribe(ctx, reader.ID, topic.ID, nil); err != nil {
+			t.Fatalf("subscribe: %v", err)
+		}
+		topics[retention] = topic.ID
+	}
+	send := func(retention model.TopicRetention) model.Message {
+		t.Helper()
+		topicID := topics[retention]
+		msg, err := app.CreateMessage(ctx, repos.CreateMessageInput{FromAgentID: sender.ID, TopicID: &topicID, Content: string(retention)})
+		if err != nil {
+			t.Fatalf("send to %s topic: %v", retention, err)
+		}
+		return msg
+	}
+
+	timed := send(model.TopicRetentionTTL)
+	if timed.ExpiresAt == nil || timed.ExpiresAt.Sub(timed.CreatedAt) < 59*time.Second || timed.ExpiresAt.Sub(timed.CreatedAt) > 61*time.Second {
+		t.Fatalf("expected the ttl topic to stamp a 60s expiry, got %+v", timed.ExpiresAt)
+	}
+	deliverAt := time.Now().Add(time.Hour)
+	deferred, err := app.CreateMessage(ctx, repos.CreateMessageInput{FromAgentID: sender.ID, TopicID: timed.TopicID, Content: "later", DeliverAt: &deliverAt})
+	if err != nil {
+		t.Fatalf("schedule to ttl topic: %v", err)
+	}
+	kept := send(model.TopicRetentionPersistent)
+	transient := send(model.TopicRetentionNone)
+	unheard, err := app.CreateTopic(ctx, repos.CreateTopicInput{Name: "retention-none-unheard", Retention: model.TopicRetentionNone, CreatedBy: sender.ID})
+	if err != nil {
+		t.Fatalf("create unheard topic: %v", err)
+	}
+	unread, err := app.CreateMessage(ctx, repos.CreateMessageInput{FromAgentID: sender.ID, TopicID: &unheard.ID, Content: "nobody listens"})
+	if err != nil {
+		t.Fatalf("send to unheard topic: %v", err)
+	}
+	undue, err := app.CreateMessage(ctx, repos.CreateMessageInput{FromAgentID: sender.ID, TopicID: &unheard.ID, Content: "not yet", DeliverAt: &deliverAt})
+	if err != nil {
+		t.Fatalf("schedule to unheard topic: %v", err)
+	}
+	dead := send(model.TopicRetentionNone)
+	if _, err := app.Store.DB.ExecContext(ctx, "UPDATE message_receipts SET status = 'dead_letter' WHERE message_id = ?", dead.ID); err != nil {
+		t.Fatalf("dead-letter: %v", err)
+	}
+
+	report, err := app.CompactMessages(ctx)
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if report.Total() != 1 || report.Delivered != 1 {
+		t.Fatalf("expected only the message nobody received purged while the reader has not acked, got %+v", report)
+	}
+	if _, err := app.Store.AckMessages(ctx, reader.ID, []string{transient.ID, kept.ID}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if report, err = app.CompactMessages(ctx); err != nil || report.Delivered != 1 {
+		t.Fatalf("expected the acked none-topic message purged, got %+v (%v)", report, err)
+	}
+	if report, err = app.Store.CompactMessages(ctx, time.Now().Add(2*time.Minute)); err != nil || report.TTL != 1 {
+		t.Fatalf("expected the ttl message purged once its ttl passed, got %+v (%v)", report, err)
+	}
+	for id, want := range map[string]bool{kept.ID: true, transient.ID: false, timed.ID: false, deferred.ID: true, unread.ID: false, undue.ID: true, dead.ID: true} {
+		if _, err := app.Store.GetMessageByID(ctx, id); (err == nil) != want {
+			t.Fatalf("message %s present=%v, want %v", id, err == nil, want)
+		}
+	}
+	if report, err = app.Store.CompactMessages(ctx, deliverAt.Add(2*time.Minute)); err != nil || report.TTL != 1 {
+		t.Fatalf("expected the scheduled ttl message purged once its ttl passed after delivery, got %+v (%v)", report, err)
+	}
+}
+
+func TestScheduledMessagesStayHiddenUntilDue(t *testing.T) {
+	app := setupServiceTestApp(t)
+	ctx := context.Background()
+
+	sender, _, err := app.AutoRegisterLocal(ctx, "scheduler", "fp-scheduler")
+	if err != nil {
+		t.Fatalf("register sender: %v", err)
+	}
+	reader, _, err := app.AutoRegisterLocal(ctx, "sleeper", "fp-sleeper")
+	if err != nil {
+		t.Fatalf("register reader: %v", err)
+	}
+	mailbox, err := app.Broker.GetMailbox(ctx, reader.ID, nil)
+	if err != nil {
+		t.Fatalf("mailbox: %v", err)
+	}
+	schedule := func(content string, in time.Duration) model.Message {
+		t.Helper()
+		at := time.Now().UTC().Add(in)
+		msg, err := app.CreateMessage(ctx, repos.CreateMessageInput{FromAgentID: sender.ID, ToAgentID: &reader.ID, Content: content, DeliverAt: &at})
+		if err != nil {
+			t.Fatalf("schedule %s: %v", content, err)
+		}
+		return msg
+	}
+
+	later := schedule("later", time.Hour)
+	if later.DeliverAt == nil {
+		t.Fatalf("expected deliver_at on the scheduled message")
+	}
+	if now := schedule("now", -time.Minute); now.DeliverAt != nil {
+		t.Fatalf("expected a past deliver_at to mean deliver now, got %v", now.DeliverAt)
+	}
+	if msg := <-mailbox; msg.Content != "now" {
+		t.Fatalf("expected only the immediate message on the broker, got %q", msg.Content)
+	}
+	inbox, _, err := app.Store.ListInbox(ctx, reader.ID, repos.MessageFilters{})
+	if err != nil || len(inbox) != 1 || inbox[0].Content != "now" {
+		t.Fatalf("expected the scheduled message hidden from the inbox, got %d (%v)", len(inbox), err)
+	}
+	claims, err := app.Store.ClaimMessages(ctx, repos.ClaimMessagesInput{AgentID: reader.ID, Limit: 10})
+	if err != nil || len(claims) != 1 {
+		t.Fatalf("expected only the immediate message claimable, got %d (%v)", len(claims), err)
+	}
+
+	if _, err := app.CreateMessage(ctx, repos.CreateMessageInput{
+		FromAgentID: sender.ID, ToAgentID: &reader.ID, Content: "too late",
+		DeliverAt: timePtr(time.Now().Add(time.Hour)), ExpiresAt: timePtr(time.Now().Add(time.Minute)),
+	}); err == nil || !strings.Contains(err.Error(), "expire before") {
+		t.Fatalf("expected a message expiring before delivery to be rejected, got %v", err)
+	}
+
+	// Make the scheduled message due.
+	if _, err := app.Store.DB.ExecContext(ctx, "UPDATE messages SET deliver_at = ? WHERE id = ?",
+		time.Now().UTC().Add(-time.Second).Format(time.RFC3339Nano), later.ID); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+	if _, err := app.DispatchDueMessages(ctx); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	select {
+	case msg := <-mailbox:
+		if msg.ID != later.ID {
+			t.Fatalf("expected the due message on the broker, got %s", msg.ID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the due message pushed to the broker")
+	}
+	if n, err := app.DispatchDueMessages(ctx); err != nil || n != 0 {
+		t.Fatalf("expected a due message dispatched once, got %d (%v)", n, err)
+	}
+	if inbox, _, _ = app.Store.ListInbox(ctx, reader.ID, repos.MessageFilters{}); len(inbox) != 2 {
+		t.Fatalf("expected the due message in the inbox, got %d", len(inbox))
+	}
+	if err := app.CancelScheduledMessage(ctx, later.ID, sender.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a delivered message not to be cancellable, got %v", err)
+	}
+
+	pending := schedule("cancel me", time.Hour)
+	if err := app.CancelScheduledMessage(ctx, pending.ID, reader.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected only the sender to cancel, got %v", err)
+	}
+	if err := app.CancelScheduledMessage(ctx, pending.ID, sender.ID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if _, err := app.Store.GetMessageByID(ctx, pending.ID); err == nil {
+		t.Fatalf("expected the cancelled message to be gone")
+	}
+	if err := app.CancelScheduledMessage(ctx, pending.ID, sender.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a cancelled message to be not found, got %v", err)
+	}
+}
+
+func TestMessageSchedulesRunPauseAndHistory(t *testing.T) {
+	app := setupServiceTestApp(t)
+	ctx := context.Background()
+
+	owner, _, err := app.AutoRegisterLocal(ctx, "planner", "fp-planner")
+	if err != nil {
+		t.Fatalf("register owner: %v", err)
+	}
+	other, _, err := app.AutoRegisterLocal(ctx, "bystander", "fp-bystander")
+	if err != nil {
+		t.Fatalf("register other: %v", err)
+	}
+	ownerAuth := AuthContext{Agent: owner, Roles: []string{"agent"}}
+	otherAuth := AuthContext{Agent: other, Roles: []string{"agent"}}
+
+	if _, err := app.CreateMessageSchedule(ctx, repos.CreateMessageScheduleInput{
+		Cron: "61 * * * *", CreatedBy: owner.ID, ToAgentID: &other.ID, Content: "x",
+	}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected an invalid cron spec to be rejected, got %v", err)
+	}
+	if _, err := app.CreateMessageSchedule(ctx, repos.CreateMessageScheduleInput{
+		Cron: "@daily", CreatedBy: owner.ID, ToAgentID: &other.ID, Content: "{{.Missing", Template: true,
+	}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected an invalid template to be rejected, got %v", err)
+	}
+	verbatim, err := app.CreateMessageSchedule(ctx, repos.CreateMessageScheduleInput{
+		Cron: "@daily", CreatedBy: owner.ID, ToAgentID: &other.ID, Content: "use {{.Name}} in templates",
+	})
+	if err != nil {
+		t.Fatalf("plain content must not be parsed as a template: %v", err)
+	}
+	if run, err := app.RunMessageSchedule(ctx, verbatim.ID, time.Now()); err != nil || run.MessageID == nil {
+		t.Fatalf("expected the plain schedule to run, got %+v (%v)", run, err)
+	} else if msg, _ := app.Store.GetMessageByID(ctx, *run.MessageID); msg.Content != "use {{.Name}} in templates" {
+		t.Fatalf("expected plain content sent verbatim, got %q", msg.Content)
+	}
+	if err := app.DeleteMessageSchedule(ctx, ownerAuth, verbatim.ID); err != nil {
+		t.Fatalf("delete plain schedule: %v", err)
+	}
+
+	sched, err := app.CreateMessageSchedule(ctx, repos.CreateMessageScheduleInput{
+		Name: "standup", Cron: "0 9 * * 1-5", CreatedBy: owner.ID, ToAgentID: &other.ID,
+		Content: `{{.Name}} for {{.Time.Format "2006-01-02"}}`, Template: true,
+	})
+	if err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+	if sched.NextRunAt == nil || sched.Priority != model.MessagePriorityNormal {
+		t.Fatalf("expected next_run_at and default priority, got %+v", sched)
+	}
+	scheduler := NewMessageScheduler(app)
+	if err := scheduler.Reload(ctx); err != nil || scheduler.jobs.Len() != 1 {
+		t.Fatalf("expected the schedule registered, got %d entries (%v)", scheduler.jobs.Len(), err)
+	}
+
+	at := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	run, err := app.RunMessageSchedule(ctx, sched.ID, at)
+	if err != nil || run.MessageID == nil || run.Error != nil {
+		t.Fatalf("expected a successful run, got %+v (%v)", run, err)
+	}
+	msg, err := app.Store.GetMessageByID(ctx, *run.MessageID)
+	if err != nil {
+		t.Fatalf("get message: %v", err)
+	}
+	if msg.Content != "standup for 2026-03-02" || msg.Metadata["schedule_id"] != sched.ID || msg.FromAgentID != owner.ID {
+		t.Fatalf("unexpected scheduled message: %+v", msg)
+	}
+	runs, err := app.Store.ListMessageScheduleRuns(ctx, sched.ID, 10)
+	if err != nil || len(runs) != 1 || !runs[0].ScheduledFor.Equal(at) {
+		t.Fatalf("expected one recorded run, got %+v (%v)", runs, err)
+	}
+	if got, _ := app.Store.GetMessageSchedule(ctx, sched.ID); got.LastRunAt == nil {
+		t.Fatalf("expected last_run_at set after a run")
+	}
+	if listed, _, err := app.ListMessageSchedules(ctx, otherAuth, 1, 50); err != nil || len(listed) != 0 {
+		t.Fatalf("expected another agent's schedules hidden, got %+v (%v)", listed, err)
+	}
+	if _, err := app.ListMessageScheduleRuns(ctx, otherAuth, sched.ID, 10); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected another agent's run history refused, got %v", err)
+	}
+	if listed, _, err := app.ListMessageSchedules(ctx, ownerAuth, 1, 50); err != nil || len(listed) != 1 {
+		t.Fatalf("expected the owner to see its schedule, got %+v (%v)", listed, err)
+	}
+
+	// The message goes out as its creator, who must still be allowed to send.
+	if err := app.Store.DeactivateAgent(ctx, owner.ID); err != nil {
+		t.Fatalf("deactivate owner: %v", err)
+	}
+	if run, err := app.RunMessageSchedule(ctx, sched.ID, at); err != nil || run.Error == nil || run.MessageID != nil {
+		t.Fatalf("expected a failed run for an inactive creator, got %+v (%v)", run, err)
+	}
+	active := string(model.AgentStatusActive)
+	if _, err := app.Store.UpdateAgent(ctx, owner.ID, nil, nil, nil, &active); err != nil {
+		t.Fatalf("reactivate owner: %v", err)
+	}
+	// The age prune keeps each schedule's latest runs however old they are.
+	if n, err := app.Store.PruneMessageScheduleRuns(ctx, time.Now().Add(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("expected a short history kept, got %d pruned (%v)", n, err)
+	}
+	for i := 1; i <= 10; i++ {
+		old := time.Now().Add(-time.Duration(i) * time.Hour)
+		if err := app.Store.RecordMessageScheduleRun(ctx, model.MessageScheduleRun{
+			ID: uuid.NewString(), ScheduleID: sched.ID, ScheduledFor: old, RanAt: old,
+		}, nil); err != nil {
+			t.Fatalf("record run: %v", err)
+		}
+	}
+	if n, err := app.Store.PruneMessageScheduleRuns(ctx, time.Now().Add(time.Minute)); err != nil || n != 2 {
+		t.Fatalf("expected the runs past the latest 10 pruned, got %d (%v)", n, err)
+	}
+	if runs, err := app.Store.ListMessageScheduleRuns(ctx, sched.ID, 0); err != nil || len(runs) != 10 || runs[9].RanAt.Before(time.Now().Add(-9*time.Hour-time.Minute)) {
+		t.Fatalf("expected the latest 10 runs kept, got %d (%v)", len(runs), err)
+	}
+
+	if _, err := app.PauseMessageSchedule(ctx, otherAuth, sched.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected only the owner to pause, got %v", err)
+	}
+	paused, err := app.PauseMessageSchedule(ctx, ownerAuth, sched.ID)
+	if err != nil || !paused.Paused || paused.NextRunAt != nil {
+		t.Fatalf("expected a paused schedule without next_run_at, got %+v (%v)", paused, err)
+	}
+	if err := scheduler.Reload(ctx); err != nil || scheduler.jobs.Len() != 0 {
+		t.Fatalf("expected the paused schedule dropped, got %d entries (%v)", scheduler.jobs.Len(), err)
+	}
+	resumed, err := app.ResumeMessageSchedule(ctx, ownerAuth, sched.ID)
+	if err != nil || resumed.Paused || resumed.NextRunAt == nil {
+		t.Fatalf("expected a resumed schedule, got %+v (%v)", resumed, err)
+	}
+
+	if err := app.DeleteMessageSchedule(ctx, ownerAuth, sched.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if runs, _ := app.Store.ListMessageScheduleRuns(ctx, sched.ID, 10); len(runs) != 0 {
+		t.Fatalf("expected the run history deleted with the schedule, got %d", len(runs))
+	}
+	if _, err := app.RunMessageSchedule(ctx, sched.ID, time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a deleted schedule to be not found, got %v", err)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
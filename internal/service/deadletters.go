@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"opencortex/internal/model"
+	"opencortex/internal/storage/repos"
+)
+
+// ReplayDeadLetters requeues the selected dead-lettered receipts and pushes
+// their messages to the recipients' mailboxes again, so connected agents
+// see them without polling. The replay is recorded in the audit log.
+func (a *App) ReplayDeadLetters(ctx context.Context, actorID string, f repos.DeadLetterFilters) (int, error) {
+	replayed, err := a.Store.ReplayDeadLetters(ctx, f)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range replayed {
+		if d.AgentID == nil {
+			continue
+		}
+		msg := d.Message
+		msg.ToAgentID = d.AgentID
+		_ = a.Broker.SendDirect(ctx, msg)
+	}
+	if len(replayed) > 0 {
+		a.auditDeadLetters(ctx, actorID, "messages.dead_letter_replay", f, len(replayed))
+	}
+	return len(replayed), nil
+}
+
+// PurgeDeadLetters discards the selected dead-lettered receipts for good and
+// records the purge in the audit log.
+func (a *App) PurgeDeadLetters(ctx context.Context, actorID string, f repos.DeadLetterFilters) (int64, error) {
+	n, err := a.Store.PurgeDeadLetters(ctx, f)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		a.auditDeadLetters(ctx, actorID, "messages.dead_letter_purge", f, int(n))
+	}
+	return n, nil
+}
+
+func (a *App) auditDeadLetters(ctx context.Context, actorID, action string, f repos.DeadLetterFilters, count int) {
+	metadata := map[string]any{"count": count}
+	for key, value := range map[string]string{
+		"topic_id":      f.TopicID,
+		"group_id":      f.GroupID,
+		"from_agent_id": f.FromAgentID,
+		"agent_id":      f.AgentID,
+		"last_error":    f.LastError,
+	} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	if len(f.ReceiptIDs) > 0 {
+		metadata["receipt_ids"] = f.ReceiptIDs
+	}
+	_ = a.Store.AddAuditLog(ctx, model.AuditLog{
+		ID:        uuid.NewString(),
+		AgentID:   &actorID,
+		Action:    action,
+		Resource:  "messages",
+		Metadata:  metadata,
+		CreatedAt: nowUTC(),
+	})
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"opencortex/internal/auth"
+	"opencortex/internal/model"
+)
+
+// NodeIdentity returns the key this node signs its sync payloads with,
+// generating and storing it on first use.
+func (a *App) NodeIdentity(ctx context.Context) (auth.Identity, error) {
+	a.identityMu.Lock()
+	defer a.identityMu.Unlock()
+	if a.identity.PrivateKey != nil {
+		return a.identity, nil
+	}
+	secret, err := a.syncSecret()
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	nodeID, _, enc, err := a.Store.NodeKey(ctx)
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	if enc == "" {
+		priv, err := auth.GenerateIdentityKey()
+		if err != nil {
+			return auth.Identity{}, err
+		}
+		sealed, err := auth.SealSecret(secret, auth.EncodeIdentityKey(priv))
+		if err != nil {
+			return auth.Identity{}, err
+		}
+		id := auth.Identity{NodeID: nodeID, PrivateKey: priv}
+		if err := a.Store.SetNodeKey(ctx, id.PublicKey(), sealed); err != nil {
+			return auth.Identity{}, err
+		}
+		// Another process may have stored its key first; use whichever won.
+		if nodeID, _, enc, err = a.Store.NodeKey(ctx); err != nil {
+			return auth.Identity{}, err
+		}
+	}
+	seed, err := auth.OpenSecret(secret, enc)
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	priv, err := auth.ParseIdentityKey(seed)
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	a.identity = auth.Identity{NodeID: nodeID, PrivateKey: priv}
+	return a.identity, nil
+}
+
+// AuditSync records a sync security event, such as a payload rejected for
+// its signature, against the remote it concerns when one is known.
+func (a *App) AuditSync(ctx context.Context, agentID *string, action string, manifestID *string, metadata map[string]any) {
+	_ = a.Store.AddAuditLog(ctx, model.AuditLog{
+		ID:         uuid.NewString(),
+		AgentID:    agentID,
+		Action:     action,
+		Resource:   "sync_manifest",
+		ResourceID: manifestID,
+		Metadata:   metadata,
+		CreatedAt:  nowUTC(),
+	})
+}
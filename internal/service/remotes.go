@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"opencortex/internal/auth"
+	"opencortex/internal/config"
+	"opencortex/internal/model"
+	"opencortex/internal/storage/repos"
+)
+
+// RemoteAPIKey returns the API key stored for a remote, or an empty string
+// when none was stored.
+func (a *App) RemoteAPIKey(ctx context.Context, name string) (string, error) {
+	_, enc, err := a.Store.GetRemoteAPIKey(ctx, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil || enc == "" {
+		return "", err
+	}
+	secret, err := a.syncSecret()
+	if err != nil {
+		return "", err
+	}
+	return auth.OpenSecret(secret, enc)
+}
+
+// ReconcileRemotes makes the stored remotes match those declared in the config
+// file, creating missing ones and overwriting settings that drifted. Stored
+// remotes the config does not mention were added at runtime and are left
+// alone. The returned report lists every remote and what was done to it.
+func (a *App) ReconcileRemotes(ctx context.Context, remotes []config.Remote) ([]model.RemoteDrift, error) {
+	stored, err := a.Store.ListRemotes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]model.SyncManifest, len(stored))
+	for _, m := range stored {
+		byName[m.RemoteName] = m
+	}
+
+	var report []model.RemoteDrift
+	changed := false
+	declared := make(map[string]bool, len(remotes))
+	for _, r := range remotes {
+		declared[r.Name] = true
+		in := remoteInput(r)
+		current, ok := byName[r.Name]
+		if !ok {
+			in.ID = uuid.NewString()
+			if err := a.sealRemoteKey(&in, r.Key); err != nil {
+				return report, err
+			}
+			if _, err := a.Store.CreateRemote(ctx, in); err != nil {
+				return report, err
+			}
+			changed = true
+			report = append(report, model.RemoteDrift{Remote: r.Name, Action: "created"})
+			continue
+		}
+
+		fields := remoteDrift(current, in)
+		if r.Key != "" {
+			hash, enc, err := a.Store.GetRemoteAPIKey(ctx, r.Name)
+			if err != nil {
+				return report, err
+			}
+			if enc == "" || !auth.VerifyKey(r.Key, hash) {
+				fields = append(fields, "api_key")
+				if err := a.sealRemoteKey(&in, r.Key); err != nil {
+					return report, err
+				}
+			}
+		}
+		if len(fields) == 0 {
+			report = append(report, model.RemoteDrift{Remote: r.Name, Action: "unchanged"})
+			continue
+		}
+		if _, err := a.Store.UpdateRemote(ctx, in); err != nil {
+			return report, err
+		}
+		changed = true
+		report = append(report, model.RemoteDrift{Remote: r.Name, Action: "updated", Fields: fields})
+	}
+	for _, m := range stored {
+		if !declared[m.RemoteName] {
+			report = append(report, model.RemoteDrift{Remote: m.RemoteName, Action: "unmanaged"})
+		}
+	}
+	if changed {
+		a.notifyRemotesChanged()
+	}
+	return report, nil
+}
+
+func remoteInput(r config.Remote) repos.CreateRemoteInput {
+	in := repos.CreateRemoteInput{
+		RemoteName:    r.Name,
+		RemoteURL:     r.URL,
+		Direction:     model.SyncDirection(r.Sync.Direction),
+		Scope:         model.SyncScope(r.Sync.Scope),
+		ScopeIDs:      r.Sync.CollectionIDs,
+		TopicIDs:      r.Sync.TopicIDs,
+		Strategy:      r.Sync.ConflictStrategy,
+		PeerPublicKey: r.PeerKey,
+		Live:          r.Sync.Live,
+		Filter: model.SyncFilter{
+			ExcludeTags:         r.Sync.Filter.ExcludeTags,
+			ExcludeVisibilities: r.Sync.Filter.ExcludeVisibilities,
+			ExcludeMetadataKeys: r.Sync.Filter.ExcludeMetadataKeys,
+			RedactFields:        r.Sync.Filter.RedactFields,
+		},
+	}
+	if r.Sync.Schedule != "" {
+		schedule := r.Sync.Schedule
+		in.Schedule = &schedule
+	}
+	if in.Direction == "" {
+		in.Direction = model.SyncDirectionBidirectional
+	}
+	if in.Scope == "" {
+		in.Scope = model.SyncScopeFull
+	}
+	if in.Strategy == "" {
+		in.Strategy = "latest-wins"
+	}
+	return in
+}
+
+// remoteDrift lists the settings in which a stored remote differs from in.
+func remoteDrift(current model.SyncManifest, in repos.CreateRemoteInput) []string {
+	var fields []string
+	if current.RemoteURL != in.RemoteURL {
+		fields = append(fields, "url")
+	}
+	if current.Direction != in.Direction {
+		fields = append(fields, "direction")
+	}
+	if current.Scope != in.Scope {
+		fields = append(fields, "scope")
+	}
+	if !slices.Equal(current.ScopeIDs, in.ScopeIDs) {
+		fields = append(fields, "collection_ids")
+	}
+	if !slices.Equal(current.TopicIDs, in.TopicIDs) {
+		fields = append(fields, "topic_ids")
+	}
+	if current.Strategy != in.Strategy {
+		fields = append(fields, "conflict_strategy")
+	}
+	if valueOrEmpty(current.Schedule) != valueOrEmpty(in.Schedule) {
+		fields = append(fields, "schedule")
+	}
+	if current.PeerPublicKey != in.PeerPublicKey {
+		fields = append(fields, "peer_key")
+	}
+	if current.Live != in.Live {
+		fields = append(fields, "live")
+	}
+	if !reflect.DeepEqual(normalFilter(current.Filter), normalFilter(in.Filter)) {
+		fields = append(fields, "filter")
+	}
+	return fields
+}
+
+// normalFilter drops empty lists so a filter read back from storage compares
+// equal to the same filter from the config file.
+func normalFilter(f model.SyncFilter) model.SyncFilter {
+	for _, list := range []*[]string{&f.ExcludeTags, &f.ExcludeVisibilities, &f.ExcludeMetadataKeys, &f.RedactFields} {
+		if len(*list) == 0 {
+			*list = nil
+		}
+	}
+	return f
+}
+
+func (a *App) sealRemoteKey(in *repos.CreateRemoteInput, rawAPIKey string) error {
+	if rawAPIKey == "" {
+		return nil
+	}
+	secret, err := a.syncSecret()
+	if err != nil {
+		return err
+	}
+	enc, err := auth.SealSecret(secret, rawAPIKey)
+	if err != nil {
+		return err
+	}
+	in.APIKeyHash = auth.HashKey(rawAPIKey)
+	in.APIKeyEnc = enc
+	return nil
+}
+
+// syncSecret loads the key that encrypts stored remote API keys.
+func (a *App) syncSecret() ([]byte, error) {
+	a.secretOnce.Do(func() {
+		a.secret, a.secretErr = auth.LoadOrCreateSecret(config.SyncKeyFile(a.Config))
+	})
+	return a.secret, a.secretErr
+}
+
+func (a *App) notifyRemotesChanged() {
+	select {
+	case a.RemotesChanged <- struct{}{}:
+	default:
+	}
+}
+
+func valueOrEmpty(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
@@ -0,0 +1,356 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+
+	"opencortex/internal/model"
+	"opencortex/internal/schedule"
+	"opencortex/internal/storage/repos"
+)
+
+// scheduleTemplateData is what the content of a templated schedule can
+// refer to, as in "Standup for {{.Time.Format \"Mon Jan 2\"}}".
+type scheduleTemplateData struct {
+	Name string
+	Time time.Time
+}
+
+func parseScheduleTemplate(content string) (*template.Template, error) {
+	return template.New("schedule").Option("missingkey=error").Parse(content)
+}
+
+// CreateMessageSchedule validates and stores a message schedule. The cron
+// spec takes five fields or a descriptor such as @hourly, optionally
+// prefixed with CRON_TZ=<zone>.
+func (a *App) CreateMessageSchedule(ctx context.Context, in repos.CreateMessageScheduleInput) (model.MessageSchedule, error) {
+	in.Cron = strings.TrimSpace(in.Cron)
+	if in.Cron == "" {
+		return model.MessageSchedule{}, fmt.Errorf("%w: cron is required", ErrValidation)
+	}
+	spec, err := cron.ParseStandard(in.Cron)
+	if err != nil {
+		return model.MessageSchedule{}, fmt.Errorf("%w: invalid cron spec: %v", ErrValidation, err)
+	}
+	if strings.TrimSpace(in.Content) == "" {
+		return model.MessageSchedule{}, fmt.Errorf("%w: content is required", ErrValidation)
+	}
+	if in.Template {
+		if _, err := parseScheduleTemplate(in.Content); err != nil {
+			return model.MessageSchedule{}, fmt.Errorf("%w: invalid content template: %v", ErrValidation, err)
+		}
+	}
+	switch in.Priority {
+	case "", model.MessagePriorityLow, model.MessagePriorityNormal, model.MessagePriorityHigh, model.MessagePriorityCritical:
+	default:
+		return model.MessageSchedule{}, fmt.Errorf("%w: unknown priority %q", ErrValidation, in.Priority)
+	}
+	if in.ExpiresInSeconds < 0 {
+		return model.MessageSchedule{}, fmt.Errorf("%w: expires_in_seconds must not be negative", ErrValidation)
+	}
+	if in.ToAgentID == nil && in.TopicID == nil && in.ToGroupID == nil {
+		return model.MessageSchedule{}, fmt.Errorf("%w: to_agent_id or topic_id or to_group_id required", ErrValidation)
+	}
+	if in.ToAgentID != nil {
+		if _, err := a.Store.GetAgentByID(ctx, *in.ToAgentID); errors.Is(err, sql.ErrNoRows) {
+			return model.MessageSchedule{}, fmt.Errorf("%w: agent not found", ErrValidation)
+		} else if err != nil {
+			return model.MessageSchedule{}, err
+		}
+	}
+	if in.TopicID != nil {
+		if _, err := a.Store.GetTopicByID(ctx, *in.TopicID); errors.Is(err, sql.ErrNoRows) {
+			return model.MessageSchedule{}, fmt.Errorf("%w: topic not found", ErrValidation)
+		} else if err != nil {
+			return model.MessageSchedule{}, err
+		}
+	}
+	if in.ToGroupID != nil {
+		if _, err := a.Store.GetGroupByID(ctx, *in.ToGroupID); errors.Is(err, sql.ErrNoRows) {
+			return model.MessageSchedule{}, fmt.Errorf("%w: group not found", ErrValidation)
+		} else if err != nil {
+			return model.MessageSchedule{}, err
+		}
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		in.Name = in.Cron
+	}
+	if in.ID == "" {
+		in.ID = uuid.NewString()
+	}
+	next := spec.Next(time.Now()).UTC()
+	in.NextRunAt = &next
+	sched, err := a.Store.CreateMessageSchedule(ctx, in)
+	if err != nil {
+		return model.MessageSchedule{}, err
+	}
+	a.notifySchedulesChanged()
+	return sched, nil
+}
+
+// OwnedMessageSchedule loads a schedule the caller may see and change: one
+// it created, or any when it can manage messages.
+func (a *App) OwnedMessageSchedule(ctx context.Context, authCtx AuthContext, id string) (model.MessageSchedule, error) {
+	sched, err := a.Store.GetMessageSchedule(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.MessageSchedule{}, fmt.Errorf("%w: schedule not found", ErrNotFound)
+	}
+	if err != nil {
+		return model.MessageSchedule{}, err
+	}
+	if sched.CreatedBy != authCtx.Agent.ID && a.Authorize(authCtx, "messages", "manage") != nil {
+		return model.MessageSchedule{}, ErrForbidden
+	}
+	return sched, nil
+}
+
+// ListMessageSchedules lists the schedules the caller created, or every
+// schedule when it can manage messages.
+func (a *App) ListMessageSchedules(ctx context.Context, authCtx AuthContext, page, perPage int) ([]model.MessageSchedule, int, error) {
+	createdBy := authCtx.Agent.ID
+	if a.Authorize(authCtx, "messages", "manage") == nil {
+		createdBy = ""
+	}
+	return a.Store.ListMessageSchedules(ctx, createdBy, page, perPage)
+}
+
+// ListMessageScheduleRuns lists the latest runs of a schedule the caller
+// owns, newest first.
+func (a *App) ListMessageScheduleRuns(ctx context.Context, authCtx AuthContext, id string, limit int) ([]model.MessageScheduleRun, error) {
+	if _, err := a.OwnedMessageSchedule(ctx, authCtx, id); err != nil {
+		return nil, err
+	}
+	return a.Store.ListMessageScheduleRuns(ctx, id, limit)
+}
+
+// PauseMessageSchedule stops a schedule from firing until it is resumed.
+func (a *App) PauseMessageSchedule(ctx context.Context, authCtx AuthContext, id string) (model.MessageSchedule, error) {
+	if _, err := a.OwnedMessageSchedule(ctx, authCtx, id); err != nil {
+		return model.MessageSchedule{}, err
+	}
+	sched, err := a.Store.SetMessageSchedulePaused(ctx, id, true, nil)
+	if err != nil {
+		return model.MessageSchedule{}, err
+	}
+	a.notifySchedulesChanged()
+	return sched, nil
+}
+
+// ResumeMessageSchedule lets a paused schedule fire again from its next
+// occurrence. Runs missed while it was paused are not made up.
+func (a *App) ResumeMessageSchedule(ctx context.Context, authCtx AuthContext, id string) (model.MessageSchedule, error) {
+	sched, err := a.OwnedMessageSchedule(ctx, authCtx, id)
+	if err != nil {
+		return model.MessageSchedule{}, err
+	}
+	if sched, err = a.Store.SetMessageSchedulePaused(ctx, id, false, nextScheduleRun(sched.Cron)); err != nil {
+		return model.MessageSchedule{}, err
+	}
+	a.notifySchedulesChanged()
+	return sched, nil
+}
+
+func (a *App) DeleteMessageSchedule(ctx context.Context, authCtx AuthContext, id string) error {
+	if _, err := a.OwnedMessageSchedule(ctx, authCtx, id); err != nil {
+		return err
+	}
+	if err := a.Store.DeleteMessageSchedule(ctx, id); err != nil {
+		return err
+	}
+	a.notifySchedulesChanged()
+	return nil
+}
+
+// RunMessageSchedule sends the schedule's message for the run due at
+// scheduledFor and records the run. The message goes out as its creator, so
+// a creator no longer active or allowed to write messages fails the run. A
+// message that could not be sent is recorded as a failed run rather than
+// returned as an error.
+func (a *App) RunMessageSchedule(ctx context.Context, id string, scheduledFor time.Time) (model.MessageScheduleRun, error) {
+	sched, err := a.Store.GetMessageSchedule(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.MessageScheduleRun{}, fmt.Errorf("%w: schedule not found", ErrNotFound)
+	}
+	if err != nil {
+		return model.MessageScheduleRun{}, err
+	}
+	run := model.MessageScheduleRun{
+		ID:           uuid.NewString(),
+		ScheduleID:   sched.ID,
+		ScheduledFor: scheduledFor.UTC(),
+	}
+	var msg model.Message
+	sendErr := a.checkScheduleCreator(ctx, sched)
+	if sendErr == nil {
+		msg, sendErr = a.sendScheduledMessage(ctx, sched, scheduledFor)
+	}
+	run.RanAt = nowUTC()
+	if sendErr != nil {
+		reason := sendErr.Error()
+		run.Error = &reason
+	} else {
+		run.MessageID = &msg.ID
+	}
+	if err := a.Store.RecordMessageScheduleRun(ctx, run, nextScheduleRun(sched.Cron)); err != nil {
+		return run, err
+	}
+	return run, nil
+}
+
+// checkScheduleCreator reports why the schedule's creator may not send its
+// message now, if it may not.
+func (a *App) checkScheduleCreator(ctx context.Context, sched model.MessageSchedule) error {
+	creator, err := a.Store.GetAgentByID(ctx, sched.CreatedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: creator %s no longer exists", ErrForbidden, sched.CreatedBy)
+	}
+	if err != nil {
+		return err
+	}
+	if creator.Status != model.AgentStatusActive {
+		return fmt.Errorf("%w: creator %s is %s", ErrForbidden, creator.Name, creator.Status)
+	}
+	authCtx, err := a.authContextFor(ctx, creator)
+	if err != nil {
+		return err
+	}
+	if a.Authorize(authCtx, "messages", "write") != nil {
+		return fmt.Errorf("%w: creator %s may no longer write messages", ErrForbidden, creator.Name)
+	}
+	return nil
+}
+
+func (a *App) sendScheduledMessage(ctx context.Context, sched model.MessageSchedule, at time.Time) (model.Message, error) {
+	content, err := scheduleContent(sched, at)
+	if err != nil {
+		return model.Message{}, err
+	}
+	metadata := maps.Clone(sched.Metadata)
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	metadata["schedule_id"] = sched.ID
+	return a.CreateMessage(ctx, repos.CreateMessageInput{
+		FromAgentID: sched.CreatedBy,
+		ToAgentID:   sched.ToAgentID,
+		TopicID:     sched.TopicID,
+		ToGroupID:   sched.ToGroupID,
+		ContentType: sched.ContentType,
+		Content:     content,
+		Priority:    sched.Priority,
+		Tags:        sched.Tags,
+		Metadata:    metadata,
+		ExpiresAt:   parseExpiresIn(sched.ExpiresInSeconds),
+	})
+}
+
+// scheduleContent returns the content of the schedule's message for the run
+// due at: rendered when the schedule is templated, verbatim otherwise.
+func scheduleContent(sched model.MessageSchedule, at time.Time) (string, error) {
+	if !sched.Template {
+		return sched.Content, nil
+	}
+	tmpl, err := parseScheduleTemplate(sched.Content)
+	if err != nil {
+		return "", err
+	}
+	var content strings.Builder
+	if err := tmpl.Execute(&content, scheduleTemplateData{Name: sched.Name, Time: at}); err != nil {
+		return "", err
+	}
+	return content.String(), nil
+}
+
+// nextScheduleRun returns when a cron spec next fires, or nil when it
+// cannot be parsed.
+func nextScheduleRun(spec string) *time.Time {
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil
+	}
+	next := s.Next(time.Now()).UTC()
+	return &next
+}
+
+func (a *App) notifySchedulesChanged() {
+	select {
+	case a.SchedulesChanged <- struct{}{}:
+	default:
+	}
+}
+
+// MessageScheduler fires every schedule that is not paused. Schedules come
+// from message_schedules, so Reload picks up those added, changed, paused,
+// resumed or removed at runtime.
+type MessageScheduler struct {
+	jobs *schedule.Jobs
+	app  *App
+}
+
+func NewMessageScheduler(app *App) *MessageScheduler {
+	s := &MessageScheduler{app: app}
+	s.jobs = schedule.New("message schedule", s.run)
+	return s
+}
+
+// Reload schedules the active schedules, replacing entries whose cron
+// changed and dropping those of schedules that are paused or gone.
+func (s *MessageScheduler) Reload(ctx context.Context) error {
+	active, err := s.app.Store.ActiveMessageSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	want := make(map[string]string, len(active))
+	for _, sched := range active {
+		want[sched.ID] = sched.Cron
+	}
+	s.jobs.Set(want)
+	return nil
+}
+
+// Watch reloads whenever changed fires, until ctx is done.
+func (s *MessageScheduler) Watch(ctx context.Context, changed <-chan struct{}) {
+	s.jobs.Watch(ctx, changed, s.Reload)
+}
+
+func (s *MessageScheduler) Start() {
+	s.jobs.Start()
+}
+
+func (s *MessageScheduler) Stop() context.Context {
+	return s.jobs.Stop()
+}
+
+func (s *MessageScheduler) run(id string) {
+	scheduledFor, ok := s.jobs.Prev(id)
+	if !ok {
+		return
+	}
+	if scheduledFor.IsZero() {
+		scheduledFor = time.Now()
+	}
+	ctx := context.Background()
+	// A schedule paused since the last reload must not fire.
+	if sched, err := s.app.Store.GetMessageSchedule(ctx, id); err != nil || sched.Paused {
+		return
+	}
+	run, err := s.app.RunMessageSchedule(ctx, id, scheduledFor)
+	if err != nil {
+		log.Printf("message schedule %s: %v", id, err)
+		return
+	}
+	if run.Error != nil {
+		log.Printf("message schedule %s: send failed: %s", id, *run.Error)
+	}
+}
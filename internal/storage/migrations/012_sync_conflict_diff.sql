@@ -0,0 +1,2 @@
+-- Migration 012: keep a content diff on sync conflicts for reviewers
+ALTER TABLE sync_conflicts ADD COLUMN diff TEXT;
@@ -0,0 +1,47 @@
+-- Migration 013: merge conflict strategy and last-agreed checksums per remote
+PRAGMA foreign_keys = OFF;
+
+ALTER TABLE sync_conflicts RENAME TO sync_conflicts_old;
+
+CREATE TABLE sync_conflicts (
+  id              TEXT PRIMARY KEY,
+  manifest_id     TEXT NOT NULL REFERENCES sync_manifests(id) ON DELETE CASCADE,
+  entity_type     TEXT NOT NULL,
+  entity_id       TEXT NOT NULL,
+  local_checksum  TEXT NOT NULL,
+  remote_checksum TEXT NOT NULL,
+  strategy        TEXT NOT NULL DEFAULT 'manual' CHECK(strategy IN ('local-wins','remote-wins','latest-wins','manual','fork','merge')),
+  status          TEXT NOT NULL DEFAULT 'open' CHECK(status IN ('open','resolved')),
+  local_payload   TEXT DEFAULT '{}',
+  remote_payload  TEXT DEFAULT '{}',
+  note            TEXT,
+  diff            TEXT,
+  created_at      TEXT NOT NULL,
+  resolved_at     TEXT
+);
+
+INSERT INTO sync_conflicts(
+  id, manifest_id, entity_type, entity_id, local_checksum, remote_checksum, strategy, status,
+  local_payload, remote_payload, note, diff, created_at, resolved_at
+)
+SELECT
+  id, manifest_id, entity_type, entity_id, local_checksum, remote_checksum, strategy, status,
+  local_payload, remote_payload, note, diff, created_at, resolved_at
+FROM sync_conflicts_old;
+
+DROP TABLE sync_conflicts_old;
+
+CREATE INDEX IF NOT EXISTS idx_sync_conflicts_manifest ON sync_conflicts(manifest_id, status);
+
+-- Checksum both sides held after the last sync with a remote; the matching
+-- knowledge version is the common ancestor for three-way merges.
+CREATE TABLE IF NOT EXISTS sync_entity_state (
+  manifest_id TEXT NOT NULL REFERENCES sync_manifests(id) ON DELETE CASCADE,
+  entity_type TEXT NOT NULL,
+  entity_id   TEXT NOT NULL,
+  checksum    TEXT NOT NULL,
+  synced_at   TEXT NOT NULL,
+  PRIMARY KEY (manifest_id, entity_type, entity_id)
+);
+
+PRAGMA foreign_keys = ON;
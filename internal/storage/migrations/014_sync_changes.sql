@@ -0,0 +1,71 @@
+-- Migration 014: change log and per-remote cursors for incremental sync
+PRAGMA foreign_keys = ON;
+
+-- One row per entity holding the sequence number of its latest change.
+CREATE TABLE IF NOT EXISTS sync_changes (
+  entity_type TEXT NOT NULL,
+  entity_id   TEXT NOT NULL,
+  seq         INTEGER NOT NULL,
+  op          TEXT NOT NULL DEFAULT 'upsert' CHECK(op IN ('upsert','delete')),
+  changed_at  TEXT NOT NULL,
+  PRIMARY KEY (entity_type, entity_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_changes_seq ON sync_changes(seq);
+
+INSERT OR IGNORE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+SELECT 'topics', id, ROW_NUMBER() OVER (ORDER BY created_at, id), 'upsert', created_at
+FROM topics;
+
+INSERT OR IGNORE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+SELECT 'knowledge', id, (SELECT COUNT(*) FROM topics) + ROW_NUMBER() OVER (ORDER BY updated_at, id), 'upsert', updated_at
+FROM knowledge_entries;
+
+INSERT OR IGNORE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+SELECT 'messages', id, (SELECT COUNT(*) FROM topics) + (SELECT COUNT(*) FROM knowledge_entries) + ROW_NUMBER() OVER (ORDER BY created_at, id), 'upsert', created_at
+FROM messages;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_knowledge_ai AFTER INSERT ON knowledge_entries BEGIN
+  INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('knowledge', new.id, (SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_changes), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_knowledge_au AFTER UPDATE ON knowledge_entries BEGIN
+  INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('knowledge', new.id, (SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_changes), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_knowledge_ad AFTER DELETE ON knowledge_entries BEGIN
+  INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('knowledge', old.id, (SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_changes), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_topics_ai AFTER INSERT ON topics BEGIN
+  INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('topics', new.id, (SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_changes), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_topics_au AFTER UPDATE ON topics BEGIN
+  INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('topics', new.id, (SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_changes), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_topics_ad AFTER DELETE ON topics BEGIN
+  INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('topics', old.id, (SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_changes), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+-- Messages are immutable once written; status updates are local delivery state.
+CREATE TRIGGER IF NOT EXISTS sync_changes_messages_ai AFTER INSERT ON messages BEGIN
+  INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('messages', new.id, (SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_changes), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_messages_ad AFTER DELETE ON messages BEGIN
+  INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('messages', old.id, (SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_changes), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+ALTER TABLE sync_manifests ADD COLUMN push_cursor INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sync_manifests ADD COLUMN pull_cursor INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sync_manifests ADD COLUMN cursor_scope TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,79 @@
+-- Migration 015: monotonic change sequence for the sync change log
+PRAGMA foreign_keys = ON;
+
+-- MAX(seq) goes backwards once the rows holding the highest sequence numbers
+-- leave the log, so changes could land at or below a peer's cursor. The
+-- counter only grows. The triggers also replace rows with DELETE then INSERT
+-- rather than INSERT OR REPLACE, whose conflict handling is overridden when
+-- the change comes from an UPSERT.
+CREATE TABLE IF NOT EXISTS sync_seq (
+  id    INTEGER PRIMARY KEY CHECK(id = 1),
+  value INTEGER NOT NULL
+);
+
+INSERT OR IGNORE INTO sync_seq(id, value)
+SELECT 1, COALESCE(MAX(seq), 0) FROM sync_changes;
+
+DROP TRIGGER IF EXISTS sync_changes_knowledge_ai;
+CREATE TRIGGER IF NOT EXISTS sync_changes_knowledge_ai AFTER INSERT ON knowledge_entries BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'knowledge' AND entity_id = new.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('knowledge', new.id, (SELECT value FROM sync_seq WHERE id = 1), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_knowledge_au;
+CREATE TRIGGER IF NOT EXISTS sync_changes_knowledge_au AFTER UPDATE ON knowledge_entries BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'knowledge' AND entity_id = new.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('knowledge', new.id, (SELECT value FROM sync_seq WHERE id = 1), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_knowledge_ad;
+CREATE TRIGGER IF NOT EXISTS sync_changes_knowledge_ad AFTER DELETE ON knowledge_entries BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'knowledge' AND entity_id = old.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('knowledge', old.id, (SELECT value FROM sync_seq WHERE id = 1), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_topics_ai;
+CREATE TRIGGER IF NOT EXISTS sync_changes_topics_ai AFTER INSERT ON topics BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'topics' AND entity_id = new.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('topics', new.id, (SELECT value FROM sync_seq WHERE id = 1), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_topics_au;
+CREATE TRIGGER IF NOT EXISTS sync_changes_topics_au AFTER UPDATE ON topics BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'topics' AND entity_id = new.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('topics', new.id, (SELECT value FROM sync_seq WHERE id = 1), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_topics_ad;
+CREATE TRIGGER IF NOT EXISTS sync_changes_topics_ad AFTER DELETE ON topics BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'topics' AND entity_id = old.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('topics', old.id, (SELECT value FROM sync_seq WHERE id = 1), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_messages_ai;
+CREATE TRIGGER IF NOT EXISTS sync_changes_messages_ai AFTER INSERT ON messages BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'messages' AND entity_id = new.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('messages', new.id, (SELECT value FROM sync_seq WHERE id = 1), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_messages_ad;
+CREATE TRIGGER IF NOT EXISTS sync_changes_messages_ad AFTER DELETE ON messages BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'messages' AND entity_id = old.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+  VALUES ('messages', old.id, (SELECT value FROM sync_seq WHERE id = 1), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
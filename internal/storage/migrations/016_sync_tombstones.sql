@@ -0,0 +1,42 @@
+-- Migration 016: tombstones for synced deletions
+PRAGMA foreign_keys = ON;
+
+-- Identifies this node as the origin of its own deletions.
+CREATE TABLE IF NOT EXISTS sync_node (
+  id         INTEGER PRIMARY KEY CHECK(id = 1),
+  node_id    TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+INSERT OR IGNORE INTO sync_node(id, node_id, created_at)
+VALUES (1, lower(hex(randomblob(16))), strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+
+-- A 'delete' row in sync_changes is the tombstone: changed_at is the deletion
+-- time and origin_node the node where the entity was deleted.
+ALTER TABLE sync_changes ADD COLUMN origin_node TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_sync_changes_tombstones ON sync_changes(op, changed_at);
+
+DROP TRIGGER IF EXISTS sync_changes_knowledge_ad;
+CREATE TRIGGER IF NOT EXISTS sync_changes_knowledge_ad AFTER DELETE ON knowledge_entries BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'knowledge' AND entity_id = old.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at, origin_node)
+  VALUES ('knowledge', old.id, (SELECT value FROM sync_seq WHERE id = 1), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'), (SELECT node_id FROM sync_node WHERE id = 1));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_topics_ad;
+CREATE TRIGGER IF NOT EXISTS sync_changes_topics_ad AFTER DELETE ON topics BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'topics' AND entity_id = old.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at, origin_node)
+  VALUES ('topics', old.id, (SELECT value FROM sync_seq WHERE id = 1), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'), (SELECT node_id FROM sync_node WHERE id = 1));
+END;
+
+DROP TRIGGER IF EXISTS sync_changes_messages_ad;
+CREATE TRIGGER IF NOT EXISTS sync_changes_messages_ad AFTER DELETE ON messages BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'messages' AND entity_id = old.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at, origin_node)
+  VALUES ('messages', old.id, (SELECT value FROM sync_seq WHERE id = 1), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'), (SELECT node_id FROM sync_node WHERE id = 1));
+END;
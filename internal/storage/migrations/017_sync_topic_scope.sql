@@ -0,0 +1,5 @@
+-- Migration 017: topic ids for topic-scoped and combined sync scopes
+PRAGMA foreign_keys = ON;
+
+-- scope_ids keeps the collection ids; topic_ids lists the synced topics.
+ALTER TABLE sync_manifests ADD COLUMN topic_ids TEXT DEFAULT '[]';
@@ -0,0 +1,29 @@
+-- Migration 018: collections as synced entities
+PRAGMA foreign_keys = ON;
+
+INSERT OR IGNORE INTO sync_changes(entity_type, entity_id, seq, op, changed_at)
+SELECT 'collections', id, (SELECT value FROM sync_seq WHERE id = 1) + ROW_NUMBER() OVER (ORDER BY created_at, id), 'upsert', updated_at
+FROM collections;
+
+UPDATE sync_seq SET value = value + (SELECT COUNT(*) FROM collections) WHERE id = 1;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_collections_ai AFTER INSERT ON collections BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'collections' AND entity_id = new.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at, origin_node)
+  VALUES ('collections', new.id, (SELECT value FROM sync_seq WHERE id = 1), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'), NULL);
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_collections_au AFTER UPDATE ON collections BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'collections' AND entity_id = new.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at, origin_node)
+  VALUES ('collections', new.id, (SELECT value FROM sync_seq WHERE id = 1), 'upsert', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'), NULL);
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_changes_collections_ad AFTER DELETE ON collections BEGIN
+  UPDATE sync_seq SET value = value + 1 WHERE id = 1;
+  DELETE FROM sync_changes WHERE entity_type = 'collections' AND entity_id = old.id;
+  INSERT INTO sync_changes(entity_type, entity_id, seq, op, changed_at, origin_node)
+  VALUES ('collections', old.id, (SELECT value FROM sync_seq WHERE id = 1), 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'), (SELECT node_id FROM sync_node WHERE id = 1));
+END;
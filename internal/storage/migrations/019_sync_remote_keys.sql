@@ -0,0 +1,6 @@
+-- Migration 019: encrypted remote API keys
+PRAGMA foreign_keys = ON;
+
+-- api_key_hash verifies a key; api_key_enc keeps the key itself, encrypted
+-- with the node's sync key, so scheduled syncs can authenticate to the peer.
+ALTER TABLE sync_manifests ADD COLUMN api_key_enc TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,24 @@
+-- Migration 020: resumable sync runs and retry backoff
+PRAGMA foreign_keys = ON;
+
+-- Consecutive failed syncs drive the backoff; next_retry_at is when the
+-- scheduler tries again.
+ALTER TABLE sync_manifests ADD COLUMN failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sync_manifests ADD COLUMN next_retry_at TEXT;
+ALTER TABLE sync_manifests ADD COLUMN last_error TEXT;
+
+-- The plan of a push or pull still in progress. Batches are sent in order and
+-- acked counts those the peer acknowledged, so an interrupted run resumes
+-- after the last of them.
+CREATE TABLE IF NOT EXISTS sync_runs (
+  manifest_id TEXT NOT NULL REFERENCES sync_manifests(id) ON DELETE CASCADE,
+  direction   TEXT NOT NULL CHECK(direction IN ('push','pull')),
+  scope_key   TEXT NOT NULL,
+  plan        TEXT NOT NULL,
+  cursor      INTEGER NOT NULL DEFAULT 0,
+  batch_size  INTEGER NOT NULL,
+  acked       INTEGER NOT NULL DEFAULT 0,
+  started_at  TEXT NOT NULL,
+  updated_at  TEXT NOT NULL,
+  PRIMARY KEY (manifest_id, direction)
+);
@@ -0,0 +1,10 @@
+-- Migration 021: node identities and pinned peer keys
+PRAGMA foreign_keys = ON;
+
+-- The node signs what it sends with an Ed25519 key. The private key is kept
+-- encrypted with the node's sync key; both are filled in on first use.
+ALTER TABLE sync_node ADD COLUMN public_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE sync_node ADD COLUMN private_key_enc TEXT NOT NULL DEFAULT '';
+
+-- The public key a remote's payloads must be signed with.
+ALTER TABLE sync_manifests ADD COLUMN peer_public_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,25 @@
+-- Migration 022: version vectors and known peers for mesh sync
+PRAGMA foreign_keys = ON;
+
+-- The version vector of a synced entity counts, per node id, the latest
+-- change of that node the entity includes; a node's counter is its change
+-- sequence at the time of the change. origin_node created the entity. seq is
+-- the local change the vector accounts for: a later local change adds this
+-- node's own counter.
+CREATE TABLE IF NOT EXISTS sync_versions (
+  entity_type TEXT NOT NULL,
+  entity_id   TEXT NOT NULL,
+  origin_node TEXT NOT NULL,
+  vector      TEXT NOT NULL DEFAULT '{}',
+  seq         INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (entity_type, entity_id)
+);
+
+-- Nodes this node has exchanged with. clock holds, per origin node, the
+-- highest counter the peer is known to have seen.
+CREATE TABLE IF NOT EXISTS sync_peers (
+  node_id      TEXT PRIMARY KEY,
+  remote_name  TEXT,
+  clock        TEXT NOT NULL DEFAULT '{}',
+  last_seen_at TEXT NOT NULL
+);
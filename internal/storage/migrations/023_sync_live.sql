@@ -0,0 +1,6 @@
+-- Migration 023: live sync links
+PRAGMA foreign_keys = ON;
+
+-- A live remote is also kept in sync over a persistent connection that
+-- forwards changes as they happen, between its scheduled syncs.
+ALTER TABLE sync_manifests ADD COLUMN live INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- Migration 024: per-remote filter and redaction rules
+PRAGMA foreign_keys = ON;
+
+-- filter holds the remote's SyncFilter as JSON: knowledge excluded by tag or
+-- visibility, metadata keys stripped and fields redacted before sending.
+ALTER TABLE sync_manifests ADD COLUMN filter TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,10 @@
+-- Migration 025: delayed and scheduled message delivery
+PRAGMA foreign_keys = ON;
+
+-- deliver_at holds a message back from inboxes, claims and broker channels
+-- until it is due. dispatched_at is set once the scheduler has pushed a due
+-- message to the broker; a scheduled message can only be cancelled before.
+ALTER TABLE messages ADD COLUMN deliver_at TEXT;
+ALTER TABLE messages ADD COLUMN dispatched_at TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_messages_due ON messages(deliver_at) WHERE deliver_at IS NOT NULL AND dispatched_at IS NULL;
@@ -0,0 +1,40 @@
+-- Migration 026: recurring messages on cron schedules
+PRAGMA foreign_keys = ON;
+
+-- A message schedule sends a message built from its template every time its
+-- cron spec fires, as the agent that created it. next_run_at is NULL while
+-- the schedule is paused.
+CREATE TABLE IF NOT EXISTS message_schedules (
+  id                 TEXT PRIMARY KEY,
+  name               TEXT NOT NULL,
+  cron               TEXT NOT NULL,
+  created_by         TEXT NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+  to_agent_id        TEXT REFERENCES agents(id) ON DELETE CASCADE,
+  topic_id           TEXT REFERENCES topics(id) ON DELETE CASCADE,
+  to_group_id        TEXT REFERENCES groups(id) ON DELETE CASCADE,
+  content_type       TEXT NOT NULL DEFAULT 'text/plain',
+  content            TEXT NOT NULL,
+  priority           TEXT NOT NULL DEFAULT 'normal' CHECK(priority IN ('low','normal','high','critical')),
+  tags               TEXT NOT NULL DEFAULT '[]',
+  metadata           TEXT NOT NULL DEFAULT '{}',
+  expires_in_seconds INTEGER NOT NULL DEFAULT 0,
+  paused             INTEGER NOT NULL DEFAULT 0,
+  last_run_at        TEXT,
+  next_run_at        TEXT,
+  created_at         TEXT NOT NULL,
+  updated_at         TEXT NOT NULL,
+  CHECK (to_agent_id IS NOT NULL OR topic_id IS NOT NULL OR to_group_id IS NOT NULL)
+);
+
+-- One row per firing. message_id is not a foreign key: the message may be
+-- purged long before the run history is.
+CREATE TABLE IF NOT EXISTS message_schedule_runs (
+  id            TEXT PRIMARY KEY,
+  schedule_id   TEXT NOT NULL REFERENCES message_schedules(id) ON DELETE CASCADE,
+  scheduled_for TEXT NOT NULL,
+  ran_at        TEXT NOT NULL,
+  message_id    TEXT,
+  error         TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_schedule_runs_schedule ON message_schedule_runs(schedule_id, ran_at DESC);
@@ -0,0 +1,6 @@
+-- Migration 027: opt-in templating of schedule content
+PRAGMA foreign_keys = ON;
+
+-- A schedule's content is sent verbatim unless template is set, in which
+-- case it is a Go template rendered with the schedule's name and run time.
+ALTER TABLE message_schedules ADD COLUMN template INTEGER NOT NULL DEFAULT 0;
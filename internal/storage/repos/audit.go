@@ -29,6 +29,7 @@ func (s *Store) Stats(ctx context.Context) (map[string]int, error) {
 		"group_members",
 		"messages",
 		"message_receipts",
+		"message_schedules",
 		"knowledge_entries",
 		"collections",
 		"sync_manifests",
@@ -0,0 +1,222 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"opencortex/internal/model"
+)
+
+// DeadLetterFilters selects dead-lettered receipts. ReceiptIDs, when set,
+// narrows the selection to those receipts.
+type DeadLetterFilters struct {
+	ReceiptIDs  []string
+	TopicID     string
+	GroupID     string
+	FromAgentID string
+	AgentID     string
+	// LastError matches receipts whose last error contains it.
+	LastError string
+	Page      int
+	PerPage   int
+}
+
+// DeadLetter is a receipt that ran out of delivery attempts, with its
+// message.
+type DeadLetter struct {
+	ReceiptID     string        `json:"receipt_id"`
+	AgentID       *string       `json:"agent_id,omitempty"`
+	Attempt       int           `json:"attempt"`
+	MaxAttempts   int           `json:"max_attempts"`
+	ClaimAttempts int           `json:"claim_attempts"`
+	LastError     *string       `json:"last_error,omitempty"`
+	DeliveredAt   *time.Time    `json:"delivered_at,omitempty"`
+	Message       model.Message `json:"message"`
+}
+
+func (f DeadLetterFilters) where() (string, []any) {
+	clauses := []string{"mr.status = 'dead_letter'"}
+	var args []any
+	if len(f.ReceiptIDs) > 0 {
+		clauses = append(clauses, "mr.id IN ("+strings.TrimSuffix(strings.Repeat("?,", len(f.ReceiptIDs)), ",")+")")
+		for _, id := range f.ReceiptIDs {
+			args = append(args, id)
+		}
+	}
+	for _, c := range []struct {
+		clause, value string
+	}{
+		{"m.topic_id = ?", f.TopicID},
+		{"m.to_group_id = ?", f.GroupID},
+		{"m.from_agent_id = ?", f.FromAgentID},
+		{"mr.agent_id = ?", f.AgentID},
+		{"instr(COALESCE(mr.last_error, ''), ?) > 0", f.LastError},
+	} {
+		if c.value != "" {
+			clauses = append(clauses, c.clause)
+			args = append(args, c.value)
+		}
+	}
+	return "WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// ListDeadLetters lists dead-lettered receipts, newest message first.
+func (s *Store) ListDeadLetters(ctx context.Context, f DeadLetterFilters) ([]DeadLetter, int, error) {
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	if f.PerPage <= 0 {
+		f.PerPage = 50
+	}
+	where, args := f.where()
+	var total int
+	if err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM message_receipts mr JOIN messages m ON m.id = mr.message_id "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.DB.QueryContext(ctx, deadLetterSelect+where+`
+ORDER BY m.created_at DESC, mr.id
+LIMIT ? OFFSET ?`, append(args, f.PerPage, (f.Page-1)*f.PerPage)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	out, err := scanDeadLetters(rows)
+	return out, total, err
+}
+
+const deadLetterSelect = `
+SELECT m.id, m.from_agent_id, m.to_agent_id, m.topic_id, m.to_group_id, m.queue_mode, m.reply_to_id, m.content_type, m.content,
+       m.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, m.delivered_at, m.read_at, m.deliver_at,
+       mr.id, mr.agent_id, mr.attempt, mr.max_attempts, mr.claim_attempts, mr.last_error, mr.delivered_at
+FROM message_receipts mr
+JOIN messages m ON m.id = mr.message_id
+`
+
+func scanDeadLetters(rows *sql.Rows) ([]DeadLetter, error) {
+	defer rows.Close()
+	var out []DeadLetter
+	for rows.Next() {
+		var (
+			d         DeadLetter
+			agentID   sql.NullString
+			lastError sql.NullString
+			delivered sql.NullString
+		)
+		msg, err := scanMessage(extraScanner{rows, []any{&d.ReceiptID, &agentID, &d.Attempt, &d.MaxAttempts, &d.ClaimAttempts, &lastError, &delivered}})
+		if err != nil {
+			return nil, err
+		}
+		d.Message = msg
+		if agentID.Valid {
+			d.AgentID = &agentID.String
+		}
+		if lastError.Valid {
+			d.LastError = &lastError.String
+		}
+		d.DeliveredAt = parseTSPtr(delivered)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// ReplayDeadLetters puts the selected dead-lettered receipts back in the
+// queue with their attempt counters reset, and returns them as they were
+// before. The last error is kept until the next failure overwrites it.
+func (s *Store) ReplayDeadLetters(ctx context.Context, f DeadLetterFilters) ([]DeadLetter, error) {
+	where, args := f.where()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, deadLetterSelect+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := scanDeadLetters(rows)
+	if err != nil || len(replayed) == 0 {
+		return nil, err
+	}
+	for start := 0; start < len(replayed); start += 500 {
+		end := min(start+500, len(replayed))
+		ids := make([]any, 0, end-start)
+		for _, d := range replayed[start:end] {
+			ids = append(ids, d.ReceiptID)
+		}
+		marks := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+		if _, err := tx.ExecContext(ctx, `
+UPDATE message_receipts
+SET status = 'pending',
+    attempt = 0,
+    claim_attempts = 0,
+    claim_token = NULL,
+    claim_expires_at = NULL,
+    ack_deadline_at = NULL,
+    delivered_at = NULL
+WHERE id IN (`+marks+`)`, ids...); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return replayed, nil
+}
+
+// PurgeDeadLetters discards the selected dead-lettered receipts for good,
+// along with the messages no other receipt still refers to.
+func (s *Store) PurgeDeadLetters(ctx context.Context, f DeadLetterFilters) (int64, error) {
+	where, args := f.where()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, "SELECT mr.id, mr.message_id FROM message_receipts mr JOIN messages m ON m.id = mr.message_id "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	var receiptIDs, messageIDs []any
+	for rows.Next() {
+		var receiptID, messageID string
+		if err := rows.Scan(&receiptID, &messageID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		receiptIDs = append(receiptIDs, receiptID)
+		messageIDs = append(messageIDs, messageID)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(receiptIDs) == 0 {
+		return 0, err
+	}
+	for start := 0; start < len(receiptIDs); start += 500 {
+		end := min(start+500, len(receiptIDs))
+		marks := strings.TrimSuffix(strings.Repeat("?,", end-start), ",")
+		if _, err := tx.ExecContext(ctx, "DELETE FROM message_receipts WHERE id IN ("+marks+")", receiptIDs[start:end]...); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+DELETE FROM messages
+WHERE id IN (`+marks+`)
+  AND NOT EXISTS (SELECT 1 FROM message_receipts mr WHERE mr.message_id = messages.id)`, messageIDs[start:end]...); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int64(len(receiptIDs)), nil
+}
+
+// extraScanner scans a row holding a message followed by extra columns.
+type extraScanner struct {
+	rows  *sql.Rows
+	extra []any
+}
+
+func (e extraScanner) Scan(dest ...any) error {
+	return e.rows.Scan(append(dest, e.extra...)...)
+}
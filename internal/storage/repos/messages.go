@@ -26,6 +26,7 @@ type CreateMessageInput struct {
 	Tags        []string
 	Metadata    map[string]any
 	ExpiresAt   *time.Time
+	DeliverAt   *time.Time
 }
 
 type MessageFilters struct {
@@ -36,6 +37,9 @@ type MessageFilters struct {
 	Since       string
 	Page        int
 	PerPage     int
+	// PriorityAging raises a message one priority for every interval it
+	// has waited; 0 orders by priority alone.
+	PriorityAging time.Duration
 }
 
 var ErrClaimNotFound = errors.New("claim_not_found")
@@ -47,6 +51,9 @@ type ClaimMessagesInput struct {
 	FromAgentID  string
 	Priority     string
 	LeaseSeconds int
+	// PriorityAging raises a message one priority for every interval it
+	// has waited; 0 orders by priority alone.
+	PriorityAging time.Duration
 }
 
 type ClaimedMessage struct {
@@ -73,17 +80,21 @@ func (s *Store) CreateMessageWithRecipients(ctx context.Context, in CreateMessag
 		return model.Message{}, err
 	}
 
-	var expires sql.NullString
+	var expires, deliverAt sql.NullString
 	if in.ExpiresAt != nil {
 		expires.Valid = true
 		expires.String = in.ExpiresAt.UTC().Format(timeFormat)
 	}
+	if in.DeliverAt != nil {
+		deliverAt.Valid = true
+		deliverAt.String = in.DeliverAt.UTC().Format(timeFormat)
+	}
 
 	_, err = tx.ExecContext(ctx, `
 INSERT INTO messages(
   id, from_agent_id, to_agent_id, topic_id, to_group_id, queue_mode, reply_to_id, content_type, content, status, priority,
-  tags, metadata, created_at, expires_at
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+  tags, metadata, created_at, expires_at, deliver_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		in.ID,
 		in.FromAgentID,
 		in.ToAgentID,
@@ -99,12 +110,27 @@ INSERT INTO messages(
 		toJSON(in.Metadata),
 		now.Format(timeFormat),
 		expires,
+		deliverAt,
 	)
 	if err != nil {
 		_ = tx.Rollback()
 		return model.Message{}, err
 	}
 
+	if err := insertReceiptsTx(ctx, tx, in.ID, recipients, in.QueueMode && in.ToGroupID != nil, now); err != nil {
+		_ = tx.Rollback()
+		return model.Message{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.Message{}, err
+	}
+	return s.GetMessageByID(ctx, in.ID)
+}
+
+// insertReceiptsTx records a pending receipt for each recipient, once, and
+// for a queued group message the single receipt its members claim.
+func insertReceiptsTx(ctx context.Context, tx *sql.Tx, messageID string, recipients []string, queued bool, now time.Time) error {
 	seen := map[string]struct{}{}
 	for _, recipient := range recipients {
 		if recipient == "" {
@@ -117,33 +143,27 @@ INSERT INTO messages(
 		_, err := tx.ExecContext(ctx, `
 INSERT INTO message_receipts(id, message_id, agent_id, status, created_at)
 VALUES (?, ?, ?, 'pending', ?)`,
-			newID(), in.ID, recipient, now.Format(timeFormat))
+			newID(), messageID, recipient, now.Format(timeFormat))
 		if err != nil {
-			_ = tx.Rollback()
-			return model.Message{}, err
+			return err
 		}
 	}
-	if in.QueueMode && in.ToGroupID != nil {
+	if queued {
 		_, err := tx.ExecContext(ctx, `
 INSERT INTO message_receipts(id, message_id, agent_id, status, created_at)
 VALUES (?, ?, NULL, 'pending', ?)`,
-			newID(), in.ID, now.Format(timeFormat))
+			newID(), messageID, now.Format(timeFormat))
 		if err != nil {
-			_ = tx.Rollback()
-			return model.Message{}, err
+			return err
 		}
 	}
-
-	if err := tx.Commit(); err != nil {
-		return model.Message{}, err
-	}
-	return s.GetMessageByID(ctx, in.ID)
+	return nil
 }
 
 func (s *Store) GetMessageByID(ctx context.Context, id string) (model.Message, error) {
 	row := s.DB.QueryRowContext(ctx, `
 SELECT id, from_agent_id, to_agent_id, topic_id, to_group_id, queue_mode, reply_to_id, content_type, content,
-       status, priority, tags, metadata, created_at, expires_at, delivered_at, read_at
+       status, priority, tags, metadata, created_at, expires_at, delivered_at, read_at, deliver_at
 FROM messages
 WHERE id = ?`, id)
 	return scanMessage(row)
@@ -161,7 +181,20 @@ func (s *Store) DeleteMessage(ctx context.Context, id, requesterAgentID string)
 	return err
 }
 
+// ListInbox lists the agent's messages the way claims take them: the most
+// urgent first, aged by how long they have waited, and the oldest first
+// within a priority.
 func (s *Store) ListInbox(ctx context.Context, agentID string, f MessageFilters) ([]model.Message, int, error) {
+	order, orderArgs := priorityOrder(nowUTC(), f.PriorityAging)
+	return s.listReceived(ctx, agentID, f, order+", COALESCE(m.deliver_at, m.created_at) ASC", orderArgs)
+}
+
+// ListAgentMessages lists the messages an agent received, newest first.
+func (s *Store) ListAgentMessages(ctx context.Context, agentID string, page, perPage int) ([]model.Message, int, error) {
+	return s.listReceived(ctx, agentID, MessageFilters{Page: page, PerPage: perPage}, "m.created_at DESC", nil)
+}
+
+func (s *Store) listReceived(ctx context.Context, agentID string, f MessageFilters, order string, orderArgs []any) ([]model.Message, int, error) {
 	if f.Page <= 0 {
 		f.Page = 1
 	}
@@ -169,8 +202,8 @@ func (s *Store) ListInbox(ctx context.Context, agentID string, f MessageFilters)
 		f.PerPage = 50
 	}
 
-	where := "WHERE mr.agent_id = ?"
-	args := []any{agentID}
+	where := "WHERE mr.agent_id = ? AND (m.deliver_at IS NULL OR m.deliver_at <= ?)"
+	args := []any{agentID, nowUTC().Format(timeFormat)}
 	if f.Status != "" {
 		where += " AND mr.status = ?"
 		args = append(args, f.Status)
@@ -204,13 +237,13 @@ JOIN messages m ON m.id = mr.message_id
 
 	query := `
 SELECT m.id, m.from_agent_id, m.to_agent_id, m.topic_id, m.to_group_id, m.queue_mode, m.reply_to_id, m.content_type, m.content,
-       mr.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, mr.delivered_at, mr.read_at
+       mr.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, mr.delivered_at, mr.read_at, m.deliver_at
 FROM message_receipts mr
 JOIN messages m ON m.id = mr.message_id
 ` + where + `
-ORDER BY m.created_at DESC
+ORDER BY ` + order + `
 LIMIT ? OFFSET ?`
-	args = append(args, f.PerPage, (f.Page-1)*f.PerPage)
+	args = append(append(args, orderArgs...), f.PerPage, (f.Page-1)*f.PerPage)
 	rows, err := s.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, err
@@ -235,17 +268,18 @@ func (s *Store) ListMessagesByTopic(ctx context.Context, topicID string, page, p
 	if perPage <= 0 {
 		perPage = 50
 	}
+	now := nowUTC().Format(timeFormat)
 	var total int
-	if err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM messages WHERE topic_id = ?", topicID).Scan(&total); err != nil {
+	if err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM messages WHERE topic_id = ? AND (deliver_at IS NULL OR deliver_at <= ?)", topicID, now).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 	rows, err := s.DB.QueryContext(ctx, `
 SELECT id, from_agent_id, to_agent_id, topic_id, to_group_id, queue_mode, reply_to_id, content_type, content,
-       status, priority, tags, metadata, created_at, expires_at, delivered_at, read_at
+       status, priority, tags, metadata, created_at, expires_at, delivered_at, read_at, deliver_at
 FROM messages
-WHERE topic_id = ?
+WHERE topic_id = ? AND (deliver_at IS NULL OR deliver_at <= ?)
 ORDER BY created_at DESC
-LIMIT ? OFFSET ?`, topicID, perPage, (page-1)*perPage)
+LIMIT ? OFFSET ?`, topicID, now, perPage, (page-1)*perPage)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -261,10 +295,6 @@ LIMIT ? OFFSET ?`, topicID, perPage, (page-1)*perPage)
 	return out, total, rows.Err()
 }
 
-func (s *Store) ListAgentMessages(ctx context.Context, agentID string, page, perPage int) ([]model.Message, int, error) {
-	return s.ListInbox(ctx, agentID, MessageFilters{Page: page, PerPage: perPage})
-}
-
 func (s *Store) MarkMessageRead(ctx context.Context, messageID, agentID string) error {
 	now := nowUTC().Format(timeFormat)
 	tx, err := s.DB.BeginTx(ctx, nil)
@@ -322,6 +352,7 @@ func (s *Store) ClaimMessages(ctx context.Context, in ClaimMessagesInput) ([]Cla
 	where := `WHERE mr.status = 'pending'
   AND (mr.claim_expires_at IS NULL OR mr.claim_expires_at <= ?)
   AND (m.expires_at IS NULL OR m.expires_at > ?)
+  AND (m.deliver_at IS NULL OR m.deliver_at <= ?)
   AND (
     mr.agent_id = ?
     OR (
@@ -332,7 +363,7 @@ func (s *Store) ClaimMessages(ctx context.Context, in ClaimMessagesInput) ([]Cla
       )
     )
   )`
-	args := []any{nowTS, nowTS, in.AgentID, in.AgentID}
+	args := []any{nowTS, nowTS, nowTS, in.AgentID, in.AgentID}
 	if in.TopicID != "" {
 		where += " AND m.topic_id = ?"
 		args = append(args, in.TopicID)
@@ -346,13 +377,16 @@ func (s *Store) ClaimMessages(ctx context.Context, in ClaimMessagesInput) ([]Cla
 		args = append(args, in.Priority)
 	}
 
+	// Claim the most urgent work first, aged by how long it has waited,
+	// and the oldest first within a priority.
+	order, orderArgs := priorityOrder(now, in.PriorityAging)
 	rows, err := tx.QueryContext(ctx, `
 SELECT mr.message_id, mr.agent_id, m.queue_mode
 FROM message_receipts mr
 JOIN messages m ON m.id = mr.message_id
 `+where+`
-ORDER BY m.created_at ASC
-LIMIT ?`, append(args, in.Limit)...)
+ORDER BY `+order+`, COALESCE(m.deliver_at, m.created_at) ASC
+LIMIT ?`, append(append(args, orderArgs...), in.Limit)...)
 	if err != nil {
 		_ = tx.Rollback()
 		return nil, err
@@ -593,7 +627,7 @@ WHERE message_id = ?
 func (s *Store) getMessageForAgentTx(ctx context.Context, tx *sql.Tx, messageID, agentID string) (model.Message, error) {
 	row := tx.QueryRowContext(ctx, `
 SELECT m.id, m.from_agent_id, m.to_agent_id, m.topic_id, m.to_group_id, m.queue_mode, m.reply_to_id, m.content_type, m.content,
-       mr.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, mr.delivered_at, mr.read_at
+       mr.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, mr.delivered_at, mr.read_at, m.deliver_at
 FROM message_receipts mr
 JOIN messages m ON m.id = mr.message_id
 WHERE mr.message_id = ? AND mr.agent_id = ?`, messageID, agentID)
@@ -609,7 +643,7 @@ WITH RECURSIVE thread AS (
   JOIN thread t ON m.reply_to_id = t.id
 )
 SELECT m.id, m.from_agent_id, m.to_agent_id, m.topic_id, m.to_group_id, m.queue_mode, m.reply_to_id, m.content_type, m.content,
-       m.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, m.delivered_at, m.read_at
+       m.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, m.delivered_at, m.read_at, m.deliver_at
 FROM messages m
 JOIN thread t ON t.id = m.id
 ORDER BY m.created_at ASC`, messageID)
@@ -628,6 +662,70 @@ ORDER BY m.created_at ASC`, messageID)
 	return out, rows.Err()
 }
 
+// DispatchDueMessages marks up to limit scheduled messages that are due by
+// now as dispatched and returns them, earliest first. A message is only ever
+// returned once, so each is pushed to the broker a single time.
+func (s *Store) DispatchDueMessages(ctx context.Context, now time.Time, limit int) ([]model.Message, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	nowTS := now.UTC().Format(timeFormat)
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `
+SELECT id, from_agent_id, to_agent_id, topic_id, to_group_id, queue_mode, reply_to_id, content_type, content,
+       status, priority, tags, metadata, created_at, expires_at, delivered_at, read_at, deliver_at
+FROM messages
+WHERE deliver_at IS NOT NULL AND dispatched_at IS NULL AND deliver_at <= ?
+ORDER BY deliver_at ASC
+LIMIT ?`, nowTS, limit)
+	if err != nil {
+		return nil, err
+	}
+	var due []model.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, msg)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]model.Message, 0, len(due))
+	for _, msg := range due {
+		res, err := tx.ExecContext(ctx, "UPDATE messages SET dispatched_at = ? WHERE id = ? AND dispatched_at IS NULL", nowTS, msg.ID)
+		if err != nil {
+			return nil, err
+		}
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			out = append(out, msg)
+		}
+	}
+	return out, tx.Commit()
+}
+
+// CancelScheduledMessage deletes a scheduled message that is not due yet,
+// along with its receipts. It reports false when the message is not
+// scheduled or has already been delivered.
+func (s *Store) CancelScheduledMessage(ctx context.Context, id string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `
+DELETE FROM messages
+WHERE id = ? AND deliver_at > ? AND dispatched_at IS NULL`, id, nowUTC().Format(timeFormat))
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
 func (s *Store) PurgeExpired(ctx context.Context) (int64, error) {
 	now := nowUTC().Format(timeFormat)
 	_, _ = s.DB.ExecContext(ctx, "UPDATE message_receipts SET status = 'expired' WHERE message_id IN (SELECT id FROM messages WHERE expires_at IS NOT NULL AND expires_at <= ?)", now)
@@ -638,6 +736,88 @@ func (s *Store) PurgeExpired(ctx context.Context) (int64, error) {
 	return res.RowsAffected()
 }
 
+// CompactionReport counts the messages a compaction removed, by the rule
+// that removed them.
+type CompactionReport struct {
+	// Expired messages were past their own expires_at.
+	Expired int64 `json:"expired"`
+	// TTL messages outlived the ttl_seconds of their ttl topic.
+	TTL int64 `json:"ttl"`
+	// Delivered messages were in a topic with retention none and no
+	// recipient still waiting for them, or none at all.
+	Delivered int64 `json:"delivered"`
+}
+
+// Total is the number of messages removed.
+func (r CompactionReport) Total() int64 {
+	return r.Expired + r.TTL + r.Delivered
+}
+
+// CompactMessages purges messages according to each topic's retention policy
+// as of now: expired messages everywhere, messages of ttl topics delivered
+// longer ago than the topic's ttl, and messages of none topics once
+// delivered: when every recipient has acknowledged them, or as soon as they
+// are due when nobody received them. None-topic messages that ran out of
+// attempts into the dead-letter queue are kept until replayed or purged.
+// Like any delete, each purge is recorded as a sync tombstone and removes the
+// message from peers too.
+func (s *Store) CompactMessages(ctx context.Context, now time.Time) (CompactionReport, error) {
+	var report CompactionReport
+	expired, err := s.PurgeExpired(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Expired = expired
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, ttl_seconds FROM topics WHERE retention = 'ttl' AND ttl_seconds > 0")
+	if err != nil {
+		return report, err
+	}
+	ttls := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var ttl int64
+		if err := rows.Scan(&id, &ttl); err != nil {
+			rows.Close()
+			return report, err
+		}
+		ttls[id] = ttl
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return report, err
+	}
+	for topicID, ttl := range ttls {
+		cutoff := now.UTC().Add(-time.Duration(ttl) * time.Second).Format(timeFormat)
+		// A scheduled message's ttl runs from its delivery.
+		res, err := s.DB.ExecContext(ctx, "DELETE FROM messages WHERE topic_id = ? AND COALESCE(deliver_at, created_at) <= ?", topicID, cutoff)
+		if err != nil {
+			return report, err
+		}
+		n, _ := res.RowsAffected()
+		report.TTL += n
+	}
+
+	res, err := s.DB.ExecContext(ctx, `
+DELETE FROM messages
+WHERE topic_id IN (SELECT id FROM topics WHERE retention = 'none')
+  AND (deliver_at IS NULL OR dispatched_at IS NOT NULL)
+  AND NOT EXISTS (
+    SELECT 1 FROM message_receipts mr
+    WHERE mr.message_id = messages.id AND mr.status = 'dead_letter'
+  )
+  AND NOT EXISTS (
+    SELECT 1 FROM message_receipts mr
+    WHERE mr.message_id = messages.id AND mr.status != 'read'
+  )`)
+	if err != nil {
+		return report, err
+	}
+	report.Delivered, _ = res.RowsAffected()
+	return report, nil
+}
+
 func scanMessage(scanner interface {
 	Scan(dest ...any) error
 }) (model.Message, error) {
@@ -656,6 +836,7 @@ func scanMessage(scanner interface {
 		expiresAt sql.NullString
 		delivered sql.NullString
 		readAt    sql.NullString
+		deliverAt sql.NullString
 	)
 	if err := scanner.Scan(
 		&m.ID,
@@ -675,6 +856,7 @@ func scanMessage(scanner interface {
 		&expiresAt,
 		&delivered,
 		&readAt,
+		&deliverAt,
 	); err != nil {
 		return model.Message{}, err
 	}
@@ -699,26 +881,10 @@ func scanMessage(scanner interface {
 	m.ExpiresAt = parseTSPtr(expiresAt)
 	m.DeliveredAt = parseTSPtr(delivered)
 	m.ReadAt = parseTSPtr(readAt)
+	m.DeliverAt = parseTSPtr(deliverAt)
 	return m, nil
 }
 
-func (s *Store) RecipientsForTopic(ctx context.Context, topicID string) ([]string, error) {
-	rows, err := s.DB.QueryContext(ctx, "SELECT agent_id FROM subscriptions WHERE topic_id = ?", topicID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var out []string
-	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			return nil, err
-		}
-		out = append(out, id)
-	}
-	return out, rows.Err()
-}
-
 func (s *Store) TrimMessageVersions(ctx context.Context, max int) error {
 	if max <= 0 {
 		return nil
@@ -823,6 +989,7 @@ func (s *Store) AckMessages(ctx context.Context, agentID string, messageIDs []st
 		placeholders[i] = "?"
 		args = append(args, id)
 	}
+	args = append(args, now)
 
 	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
@@ -834,7 +1001,8 @@ UPDATE message_receipts
 SET status = 'read',
     read_at = coalesce(read_at, ?),
     delivered_at = coalesce(delivered_at, ?)
-WHERE agent_id = ? AND message_id IN (%s) AND status IN ('pending', 'delivered')`, strings.Join(placeholders, ","))
+WHERE agent_id = ? AND message_id IN (%s) AND status IN ('pending', 'delivered')
+  AND message_id NOT IN (SELECT id FROM messages WHERE deliver_at > ?)`, strings.Join(placeholders, ","))
 
 	res, err := tx.ExecContext(ctx, query, args...)
 	if err != nil {
@@ -878,9 +1046,10 @@ WHERE agent_id = ? AND status IN ('pending', 'delivered')
   AND message_id IN (
       SELECT m.id FROM messages m
       JOIN messages target ON target.id = ?
-      WHERE m.created_at <= target.created_at
+      WHERE COALESCE(m.deliver_at, m.created_at) <= COALESCE(target.deliver_at, target.created_at)
+        AND (m.deliver_at IS NULL OR m.deliver_at <= ?)
   )`
-	res, err := tx.ExecContext(ctx, query, now, now, agentID, upToMessageID)
+	res, err := tx.ExecContext(ctx, query, now, now, agentID, upToMessageID, now)
 	if err != nil {
 		_ = tx.Rollback()
 		return 0, err
@@ -917,6 +1086,9 @@ type GetInboxFilters struct {
 	LeaseSeconds  int
 	IncludeRead   bool
 	IncludeDead   bool
+	// PriorityAging raises a message one priority for every interval it
+	// has waited; 0 orders by priority alone.
+	PriorityAging time.Duration
 }
 
 func (s *Store) GetInboxMessagesAsync(ctx context.Context, agentID string, f GetInboxFilters) ([]model.Message, error) {
@@ -928,7 +1100,7 @@ func (s *Store) GetInboxMessagesAsync(ctx context.Context, agentID string, f Get
 	}
 	var cursorTime string
 	if f.CursorID != "" {
-		_ = s.DB.QueryRowContext(ctx, "SELECT created_at FROM messages WHERE id = ?", f.CursorID).Scan(&cursorTime)
+		_ = s.DB.QueryRowContext(ctx, "SELECT COALESCE(deliver_at, created_at) FROM messages WHERE id = ?", f.CursorID).Scan(&cursorTime)
 	}
 
 	tx, err := s.DB.BeginTx(ctx, nil)
@@ -936,8 +1108,11 @@ func (s *Store) GetInboxMessagesAsync(ctx context.Context, agentID string, f Get
 		return nil, err
 	}
 
-	args := []any{agentID, agentID}
-	where := `WHERE (mr.agent_id = ? OR (m.queue_mode = 1 AND mr.agent_id IS NULL AND EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = m.to_group_id AND gm.agent_id = ?)))`
+	// Scheduled messages take their place in the mailbox, and against the
+	// cursor, when they become due rather than when they were sent.
+	args := []any{agentID, agentID, nowUTC().Format(timeFormat)}
+	where := `WHERE (mr.agent_id = ? OR (m.queue_mode = 1 AND mr.agent_id IS NULL AND EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = m.to_group_id AND gm.agent_id = ?)))
+  AND (m.deliver_at IS NULL OR m.deliver_at <= ?)`
 
 	if f.IncludeRead {
 		if f.IncludeDead {
@@ -952,7 +1127,7 @@ func (s *Store) GetInboxMessagesAsync(ctx context.Context, agentID string, f Get
 	}
 
 	if cursorTime != "" {
-		where += " AND m.created_at > ?"
+		where += " AND COALESCE(m.deliver_at, m.created_at) > ?"
 		args = append(args, cursorTime)
 	}
 	if f.TopicID != "" {
@@ -968,17 +1143,17 @@ func (s *Store) GetInboxMessagesAsync(ctx context.Context, agentID string, f Get
 		args = append(args, f.Priority)
 	}
 
+	order, orderArgs := priorityOrder(nowUTC(), f.PriorityAging)
 	query := `
 SELECT m.id, m.from_agent_id, m.to_agent_id, m.topic_id, m.to_group_id, m.queue_mode, m.reply_to_id, m.content_type, m.content,
-       mr.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, mr.delivered_at, mr.read_at, mr.agent_id
+       mr.status, m.priority, m.tags, m.metadata, m.created_at, m.expires_at, mr.delivered_at, mr.read_at, m.deliver_at, mr.agent_id
 FROM message_receipts mr
 JOIN messages m ON m.id = mr.message_id
 ` + where + `
-ORDER BY 
-  CASE m.priority WHEN 'critical' THEN 1 WHEN 'high' THEN 2 WHEN 'normal' THEN 3 WHEN 'low' THEN 4 ELSE 5 END ASC,
-  m.created_at ASC
+ORDER BY ` + order + `,
+  COALESCE(m.deliver_at, m.created_at) ASC
 LIMIT ?`
-	args = append(args, f.Limit)
+	args = append(append(args, orderArgs...), f.Limit)
 
 	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -996,13 +1171,13 @@ LIMIT ?`
 		// scanMessage reads up to read_at.
 		// We have an extra mr.agent_id to check if it's unassigned queue mode.
 		var m model.Message
-		var toAgent, topic, toGroup, replyTo, expiresAt, deliveredAt, readAt sql.NullString
+		var toAgent, topic, toGroup, replyTo, expiresAt, deliveredAt, readAt, deliverAt sql.NullString
 		var qm int
 		var status, priority, tags, metadata, createdAt string
 
 		if err := rows.Scan(
 			&m.ID, &m.FromAgentID, &toAgent, &topic, &toGroup, &qm, &replyTo, &m.ContentType, &m.Content,
-			&status, &priority, &tags, &metadata, &createdAt, &expiresAt, &deliveredAt, &readAt, &mrAgentID,
+			&status, &priority, &tags, &metadata, &createdAt, &expiresAt, &deliveredAt, &readAt, &deliverAt, &mrAgentID,
 		); err != nil {
 			_ = tx.Rollback()
 			return nil, err
@@ -1028,6 +1203,7 @@ LIMIT ?`
 		m.ExpiresAt = parseTSPtr(expiresAt)
 		m.DeliveredAt = parseTSPtr(deliveredAt)
 		m.ReadAt = parseTSPtr(readAt)
+		m.DeliverAt = parseTSPtr(deliverAt)
 
 		matches = append(matches, match{msg: m, agentID: mrAgentID})
 	}
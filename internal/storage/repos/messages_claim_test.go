@@ -3,6 +3,7 @@ package repos
 import (
 	"context"
 	"path/filepath"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -252,6 +253,79 @@ func TestMessageClaimQueueGroupSingleWinner(t *testing.T) {
 	}
 }
 
+func TestClaimMessagesOrdersByPriorityWithAging(t *testing.T) {
+	ctx := context.Background()
+	store, cleanup := setupMessageClaimStore(t)
+	defer cleanup()
+
+	sender := createTestAgent(t, ctx, store, "sender")
+	recipient := createTestAgent(t, ctx, store, "recipient")
+
+	send := func(content string, priority model.MessagePriority, age time.Duration) string {
+		t.Helper()
+		id := createDirectMessage(t, ctx, store, sender.ID, recipient.ID, content)
+		if _, err := store.DB.ExecContext(ctx, "UPDATE messages SET priority = ?, created_at = ? WHERE id = ?",
+			string(priority), time.Now().UTC().Add(-age).Format(timeFormat), id); err != nil {
+			t.Fatalf("set priority: %v", err)
+		}
+		return id
+	}
+	stale := send("stale low", model.MessagePriorityLow, 20*time.Minute)
+	normal := send("normal", model.MessagePriorityNormal, time.Minute)
+	fresh := send("fresh low", model.MessagePriorityLow, 0)
+	critical := send("critical", model.MessagePriorityCritical, 0)
+
+	order := func(aging time.Duration) []string {
+		t.Helper()
+		inbox, _, err := store.ListInbox(ctx, recipient.ID, MessageFilters{PriorityAging: aging})
+		if err != nil {
+			t.Fatalf("list inbox: %v", err)
+		}
+		ids := make([]string, 0, len(inbox))
+		for _, msg := range inbox {
+			ids = append(ids, msg.ID)
+		}
+		return ids
+	}
+	// Within a priority the oldest comes first, as claims take them.
+	if got, want := order(0), []string{critical, normal, stale, fresh}; !slices.Equal(got, want) {
+		t.Fatalf("expected strict priority order %v, got %v", want, got)
+	}
+	// Waiting 20m at 5m aging lifts the stale low message past normal, but
+	// no further than high: a fresh critical message still comes first.
+	if got, want := order(5*time.Minute), []string{critical, stale, normal, fresh}; !slices.Equal(got, want) {
+		t.Fatalf("expected aged order %v, got %v", want, got)
+	}
+
+	// The agent's message history stays chronological, newest first.
+	history, _, err := store.ListAgentMessages(ctx, recipient.ID, 1, 50)
+	if err != nil || len(history) != 4 || history[2].ID != normal || history[3].ID != stale {
+		t.Fatalf("expected the history newest first, got %+v (%v)", history, err)
+	}
+
+	lag, err := store.PriorityLag(ctx, time.Now().UTC(), PriorityLagFilters{})
+	if err != nil {
+		t.Fatalf("priority lag: %v", err)
+	}
+	if len(lag) != 4 || lag[0].Priority != model.MessagePriorityCritical || lag[0].Pending != 1 || lag[1].Pending != 0 ||
+		lag[3].Pending != 2 || lag[3].OldestAgeSeconds < 19*60 {
+		t.Fatalf("unexpected priority lag: %+v", lag)
+	}
+
+	var claimed []string
+	for range 4 {
+		claims, err := store.ClaimMessages(ctx, ClaimMessagesInput{AgentID: recipient.ID, Limit: 1, PriorityAging: 5 * time.Minute})
+		if err != nil || len(claims) != 1 {
+			t.Fatalf("claim: %d (%v)", len(claims), err)
+		}
+		claimed = append(claimed, claims[0].Message.ID)
+	}
+	// Within a priority the oldest is claimed first.
+	if want := []string{critical, stale, normal, fresh}; !slices.Equal(claimed, want) {
+		t.Fatalf("expected claims in order %v, got %v", want, claimed)
+	}
+}
+
 func setupMessageClaimStore(t *testing.T) (*Store, func()) {
 	t.Helper()
 
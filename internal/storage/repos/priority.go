@@ -0,0 +1,112 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"opencortex/internal/model"
+)
+
+// priorityRankSQL ranks m.priority from 0 (critical) to 3 (low).
+const priorityRankSQL = "CASE m.priority WHEN 'critical' THEN 0 WHEN 'high' THEN 1 WHEN 'normal' THEN 2 ELSE 3 END"
+
+// priorities lists the message priorities from most to least urgent.
+var priorities = []model.MessagePriority{
+	model.MessagePriorityCritical,
+	model.MessagePriorityHigh,
+	model.MessagePriorityNormal,
+	model.MessagePriorityLow,
+}
+
+// priorityOrder returns an ORDER BY term, and its arguments, that puts the
+// most urgent messages first. With aging, a message ranks one priority
+// higher for every aging it has waited since it became visible, up to high:
+// only critical messages rank as critical, so a low message queued for two
+// agings ranks with high ones but still behind a fresh critical one.
+func priorityOrder(now time.Time, aging time.Duration) (string, []any) {
+	if aging <= 0 {
+		return priorityRankSQL, nil
+	}
+	waited := `CASE
+    WHEN COALESCE(m.deliver_at, m.created_at) <= ? THEN 2
+    WHEN COALESCE(m.deliver_at, m.created_at) <= ? THEN 1
+    ELSE 0 END`
+	args := make([]any, 0, 2)
+	for levels := 2; levels >= 1; levels-- {
+		args = append(args, now.Add(-time.Duration(levels)*aging).Format(timeFormat))
+	}
+	return "MIN(" + priorityRankSQL + ", MAX(1, " + priorityRankSQL + " - " + waited + "))", args
+}
+
+// PriorityLagFilters narrows lag metrics to a topic or group.
+type PriorityLagFilters struct {
+	TopicID string
+	GroupID string
+}
+
+// PriorityLag is the backlog of pending, visible receipts at one priority
+// and how long the oldest of them has been waiting.
+type PriorityLag struct {
+	Priority         model.MessagePriority `json:"priority"`
+	Pending          int                   `json:"pending"`
+	OldestPendingAt  *time.Time            `json:"oldest_pending_at,omitempty"`
+	OldestAgeSeconds float64               `json:"oldest_age_seconds"`
+}
+
+// PriorityLag reports the pending backlog for each priority, most urgent
+// first. Every priority is listed, with zero pending when it has none.
+func (s *Store) PriorityLag(ctx context.Context, now time.Time, f PriorityLagFilters) ([]PriorityLag, error) {
+	nowTS := now.UTC().Format(timeFormat)
+	where := `WHERE mr.status = 'pending'
+  AND (m.deliver_at IS NULL OR m.deliver_at <= ?)
+  AND (m.expires_at IS NULL OR m.expires_at > ?)`
+	args := []any{nowTS, nowTS}
+	if f.TopicID != "" {
+		where += " AND m.topic_id = ?"
+		args = append(args, f.TopicID)
+	}
+	if f.GroupID != "" {
+		where += " AND m.to_group_id = ?"
+		args = append(args, f.GroupID)
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+SELECT m.priority, COUNT(*), MIN(COALESCE(m.deliver_at, m.created_at))
+FROM message_receipts mr
+JOIN messages m ON m.id = mr.message_id
+`+where+`
+GROUP BY m.priority`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byPriority := map[model.MessagePriority]PriorityLag{}
+	for rows.Next() {
+		var (
+			lag      PriorityLag
+			priority string
+			oldest   sql.NullString
+		)
+		if err := rows.Scan(&priority, &lag.Pending, &oldest); err != nil {
+			return nil, err
+		}
+		lag.Priority = model.MessagePriority(priority)
+		lag.OldestPendingAt = parseTSPtr(oldest)
+		if lag.OldestPendingAt != nil {
+			lag.OldestAgeSeconds = max(0, now.Sub(*lag.OldestPendingAt).Seconds())
+		}
+		byPriority[lag.Priority] = lag
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]PriorityLag, 0, len(priorities))
+	for _, p := range priorities {
+		lag, ok := byPriority[p]
+		if !ok {
+			lag.Priority = p
+		}
+		out = append(out, lag)
+	}
+	return out, nil
+}
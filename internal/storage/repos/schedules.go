@@ -0,0 +1,284 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"opencortex/internal/model"
+)
+
+const (
+	// scheduleRunsKept is how many runs of each schedule the history keeps.
+	scheduleRunsKept = 100
+	// scheduleRunsFloor is how many of each schedule's latest runs outlive
+	// the age prune, so a schedule that rarely fires keeps its history.
+	scheduleRunsFloor = 10
+)
+
+type CreateMessageScheduleInput struct {
+	ID               string
+	Name             string
+	Cron             string
+	CreatedBy        string
+	ToAgentID        *string
+	TopicID          *string
+	ToGroupID        *string
+	ContentType      string
+	Content          string
+	Template         bool
+	Priority         model.MessagePriority
+	Tags             []string
+	Metadata         map[string]any
+	ExpiresInSeconds int
+	NextRunAt        *time.Time
+}
+
+const messageScheduleColumns = `id, name, cron, created_by, to_agent_id, topic_id, to_group_id, content_type, content, template, priority,
+       tags, metadata, expires_in_seconds, paused, last_run_at, next_run_at, created_at, updated_at`
+
+func (s *Store) CreateMessageSchedule(ctx context.Context, in CreateMessageScheduleInput) (model.MessageSchedule, error) {
+	if in.ContentType == "" {
+		in.ContentType = "text/plain"
+	}
+	if in.Priority == "" {
+		in.Priority = model.MessagePriorityNormal
+	}
+	now := nowUTC().Format(timeFormat)
+	_, err := s.DB.ExecContext(ctx, `
+INSERT INTO message_schedules(
+  id, name, cron, created_by, to_agent_id, topic_id, to_group_id, content_type, content, template, priority,
+  tags, metadata, expires_in_seconds, next_run_at, created_at, updated_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		in.ID, in.Name, in.Cron, in.CreatedBy, in.ToAgentID, in.TopicID, in.ToGroupID, in.ContentType, in.Content,
+		boolToInt(in.Template), string(in.Priority), toJSON(in.Tags), toJSON(in.Metadata), in.ExpiresInSeconds, formatTSPtr(in.NextRunAt), now, now,
+	)
+	if err != nil {
+		return model.MessageSchedule{}, err
+	}
+	return s.GetMessageSchedule(ctx, in.ID)
+}
+
+func (s *Store) GetMessageSchedule(ctx context.Context, id string) (model.MessageSchedule, error) {
+	row := s.DB.QueryRowContext(ctx, "SELECT "+messageScheduleColumns+" FROM message_schedules WHERE id = ?", id)
+	return scanMessageSchedule(row)
+}
+
+// ListMessageSchedules lists schedules newest first: those createdBy made,
+// or all of them when it is empty.
+func (s *Store) ListMessageSchedules(ctx context.Context, createdBy string, page, perPage int) ([]model.MessageSchedule, int, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if perPage <= 0 {
+		perPage = 50
+	}
+	where := "WHERE ? = '' OR created_by = ?"
+	var total int
+	if err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM message_schedules "+where, createdBy, createdBy).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+SELECT `+messageScheduleColumns+`
+FROM message_schedules
+`+where+`
+ORDER BY created_at DESC
+LIMIT ? OFFSET ?`, createdBy, createdBy, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var out []model.MessageSchedule
+	for rows.Next() {
+		sched, err := scanMessageSchedule(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, sched)
+	}
+	return out, total, rows.Err()
+}
+
+// ActiveMessageSchedules returns every schedule that is not paused.
+func (s *Store) ActiveMessageSchedules(ctx context.Context) ([]model.MessageSchedule, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+messageScheduleColumns+" FROM message_schedules WHERE paused = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.MessageSchedule
+	for rows.Next() {
+		sched, err := scanMessageSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sched)
+	}
+	return out, rows.Err()
+}
+
+// SetMessageSchedulePaused pauses or resumes a schedule and records when it
+// next runs, which is nil while paused.
+func (s *Store) SetMessageSchedulePaused(ctx context.Context, id string, paused bool, nextRunAt *time.Time) (model.MessageSchedule, error) {
+	res, err := s.DB.ExecContext(ctx, `
+UPDATE message_schedules
+SET paused = ?, next_run_at = ?, updated_at = ?
+WHERE id = ?`, boolToInt(paused), formatTSPtr(nextRunAt), nowUTC().Format(timeFormat), id)
+	if err != nil {
+		return model.MessageSchedule{}, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return model.MessageSchedule{}, sql.ErrNoRows
+	}
+	return s.GetMessageSchedule(ctx, id)
+}
+
+func (s *Store) DeleteMessageSchedule(ctx context.Context, id string) error {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM message_schedules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RecordMessageScheduleRun adds a run to the schedule's history, trimming
+// the oldest past scheduleRunsKept, and moves its next run time on.
+func (s *Store) RecordMessageScheduleRun(ctx context.Context, run model.MessageScheduleRun, nextRunAt *time.Time) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ranAt := run.RanAt.UTC().Format(timeFormat)
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO message_schedule_runs(id, schedule_id, scheduled_for, ran_at, message_id, error)
+VALUES (?, ?, ?, ?, ?, ?)`,
+		run.ID, run.ScheduleID, run.ScheduledFor.UTC().Format(timeFormat), ranAt, run.MessageID, run.Error,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+DELETE FROM message_schedule_runs
+WHERE schedule_id = ?
+  AND id NOT IN (
+    SELECT id FROM message_schedule_runs WHERE schedule_id = ? ORDER BY ran_at DESC LIMIT ?
+  )`, run.ScheduleID, run.ScheduleID, scheduleRunsKept); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE message_schedules
+SET last_run_at = ?, next_run_at = CASE WHEN paused = 1 THEN NULL ELSE ? END
+WHERE id = ?`, ranAt, formatTSPtr(nextRunAt), run.ScheduleID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListMessageScheduleRuns returns the schedule's most recent runs first.
+func (s *Store) ListMessageScheduleRuns(ctx context.Context, scheduleID string, limit int) ([]model.MessageScheduleRun, error) {
+	if limit <= 0 || limit > scheduleRunsKept {
+		limit = scheduleRunsKept
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+SELECT id, schedule_id, scheduled_for, ran_at, message_id, error
+FROM message_schedule_runs
+WHERE schedule_id = ?
+ORDER BY ran_at DESC
+LIMIT ?`, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.MessageScheduleRun
+	for rows.Next() {
+		var (
+			run          model.MessageScheduleRun
+			scheduledFor string
+			ranAt        string
+			messageID    sql.NullString
+			runErr       sql.NullString
+		)
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &scheduledFor, &ranAt, &messageID, &runErr); err != nil {
+			return nil, err
+		}
+		run.ScheduledFor = parseTS(scheduledFor)
+		run.RanAt = parseTS(ranAt)
+		if messageID.Valid {
+			run.MessageID = &messageID.String
+		}
+		if runErr.Valid {
+			run.Error = &runErr.String
+		}
+		out = append(out, run)
+	}
+	return out, rows.Err()
+}
+
+// PruneMessageScheduleRuns drops runs older than before, except each
+// schedule's latest scheduleRunsFloor. It works alongside the
+// scheduleRunsKept cap RecordMessageScheduleRun applies: the cap bounds a
+// busy schedule's history, the prune ages out a quiet one's down to the
+// floor.
+func (s *Store) PruneMessageScheduleRuns(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, `
+DELETE FROM message_schedule_runs
+WHERE ran_at < ?
+  AND id NOT IN (
+    SELECT id FROM (
+      SELECT id, ROW_NUMBER() OVER (PARTITION BY schedule_id ORDER BY ran_at DESC) AS n
+      FROM message_schedule_runs
+    ) WHERE n <= ?
+  )`, before.UTC().Format(timeFormat), scheduleRunsFloor)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanMessageSchedule(scanner interface {
+	Scan(dest ...any) error
+}) (model.MessageSchedule, error) {
+	var (
+		m         model.MessageSchedule
+		toAgentID sql.NullString
+		topicID   sql.NullString
+		toGroupID sql.NullString
+		template  int
+		priority  string
+		tags      string
+		metadata  string
+		paused    int
+		lastRunAt sql.NullString
+		nextRunAt sql.NullString
+		createdAt string
+		updatedAt string
+	)
+	if err := scanner.Scan(
+		&m.ID, &m.Name, &m.Cron, &m.CreatedBy, &toAgentID, &topicID, &toGroupID, &m.ContentType, &m.Content, &template, &priority,
+		&tags, &metadata, &m.ExpiresInSeconds, &paused, &lastRunAt, &nextRunAt, &createdAt, &updatedAt,
+	); err != nil {
+		return model.MessageSchedule{}, err
+	}
+	if toAgentID.Valid {
+		m.ToAgentID = &toAgentID.String
+	}
+	if topicID.Valid {
+		m.TopicID = &topicID.String
+	}
+	if toGroupID.Valid {
+		m.ToGroupID = &toGroupID.String
+	}
+	m.Priority = model.MessagePriority(priority)
+	m.Tags = fromJSON[[]string](tags)
+	m.Metadata = fromJSON[map[string]any](metadata)
+	m.Template = template == 1
+	m.Paused = paused == 1
+	m.LastRunAt = parseTSPtr(lastRunAt)
+	m.NextRunAt = parseTSPtr(nextRunAt)
+	m.CreatedAt = parseTS(createdAt)
+	m.UpdatedAt = parseTS(updatedAt)
+	return m, nil
+}
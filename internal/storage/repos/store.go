@@ -72,6 +72,13 @@ func parseTSPtr(s sql.NullString) *time.Time {
 	return &t
 }
 
+func formatTSPtr(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format(timeFormat), Valid: true}
+}
+
 func (s *Store) Count(ctx context.Context, table string) (int, error) {
 	var c int
 	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", table)
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
+	"opencortex/internal/broker"
 	"opencortex/internal/model"
 )
 
@@ -15,9 +17,16 @@ type CreateRemoteInput struct {
 	Direction  model.SyncDirection
 	Scope      model.SyncScope
 	ScopeIDs   []string
+	TopicIDs   []string
 	APIKeyHash string
-	Strategy   string
-	Schedule   *string
+	// APIKeyEnc is the API key encrypted with the node's sync key.
+	APIKeyEnc string
+	Strategy  string
+	Schedule  *string
+	// PeerPublicKey pins the key the remote's payloads must be signed with.
+	PeerPublicKey string
+	Live          bool
+	Filter        model.SyncFilter
 }
 
 func (s *Store) CreateRemote(ctx context.Context, in CreateRemoteInput) (model.SyncManifest, error) {
@@ -31,9 +40,9 @@ func (s *Store) CreateRemote(ctx context.Context, in CreateRemoteInput) (model.S
 		in.Strategy = "latest-wins"
 	}
 	_, err := s.DB.ExecContext(ctx, `
-INSERT INTO sync_manifests(id, remote_url, remote_name, direction, scope, scope_ids, api_key_hash, strategy, schedule, created_at)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		in.ID, in.RemoteURL, in.RemoteName, string(in.Direction), string(in.Scope), toJSON(in.ScopeIDs), in.APIKeyHash, in.Strategy, in.Schedule, nowUTC().Format(timeFormat),
+INSERT INTO sync_manifests(id, remote_url, remote_name, direction, scope, scope_ids, topic_ids, api_key_hash, api_key_enc, strategy, schedule, peer_public_key, live, filter, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		in.ID, in.RemoteURL, in.RemoteName, string(in.Direction), string(in.Scope), toJSON(in.ScopeIDs), toJSON(in.TopicIDs), in.APIKeyHash, in.APIKeyEnc, in.Strategy, in.Schedule, in.PeerPublicKey, boolToInt(in.Live), toJSON(in.Filter), nowUTC().Format(timeFormat),
 	)
 	if err != nil {
 		return model.SyncManifest{}, err
@@ -42,10 +51,7 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 }
 
 func (s *Store) ListRemotes(ctx context.Context) ([]model.SyncManifest, error) {
-	rows, err := s.DB.QueryContext(ctx, `
-SELECT id, remote_url, remote_name, direction, scope, scope_ids, last_sync_at, last_sync_ok, created_at
-FROM sync_manifests
-ORDER BY created_at DESC`)
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+manifestColumns+" FROM sync_manifests ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -62,50 +68,51 @@ ORDER BY created_at DESC`)
 }
 
 func (s *Store) GetRemote(ctx context.Context, name string) (model.SyncManifest, error) {
-	row := s.DB.QueryRowContext(ctx, `
-SELECT id, remote_url, remote_name, direction, scope, scope_ids, last_sync_at, last_sync_ok, created_at
-FROM sync_manifests
-WHERE remote_name = ?`, name)
+	row := s.DB.QueryRowContext(ctx, "SELECT "+manifestColumns+" FROM sync_manifests WHERE remote_name = ?", name)
 	return scanSyncManifest(row)
 }
 
 func (s *Store) GetRemoteWithAuth(ctx context.Context, name string) (model.SyncManifest, string, string, error) {
-	var (
-		m          model.SyncManifest
-		direction  string
-		scope      string
-		scopeIDs   string
-		lastSyncAt sql.NullString
-		lastSyncOK sql.NullInt64
-		createdAt  string
-		apiKeyHash string
-		strategy   string
-	)
-	err := s.DB.QueryRowContext(ctx, `
-SELECT id, remote_url, remote_name, direction, scope, scope_ids, last_sync_at, last_sync_ok, created_at, api_key_hash, strategy
-FROM sync_manifests
-WHERE remote_name = ?`, name).Scan(
-		&m.ID, &m.RemoteURL, &m.RemoteName, &direction, &scope, &scopeIDs,
-		&lastSyncAt, &lastSyncOK, &createdAt, &apiKeyHash, &strategy,
-	)
+	var apiKeyHash string
+	row := s.DB.QueryRowContext(ctx, "SELECT "+manifestColumns+", api_key_hash FROM sync_manifests WHERE remote_name = ?", name)
+	m, err := scanSyncManifest(row, &apiKeyHash)
 	if err != nil {
 		return model.SyncManifest{}, "", "", err
 	}
-	m.Direction = model.SyncDirection(direction)
-	m.Scope = model.SyncScope(scope)
-	m.ScopeIDs = fromJSON[[]string](scopeIDs)
-	if lastSyncAt.Valid {
-		t := parseTS(lastSyncAt.String)
-		if !t.IsZero() {
-			m.LastSyncAt = &t
-		}
+	return m, apiKeyHash, m.Strategy, nil
+}
+
+// UpdateRemote rewrites the settings of the remote named in.RemoteName. The
+// stored API key is kept when in.APIKeyHash is empty.
+func (s *Store) UpdateRemote(ctx context.Context, in CreateRemoteInput) (model.SyncManifest, error) {
+	if in.Direction == "" {
+		in.Direction = model.SyncDirectionBidirectional
 	}
-	if lastSyncOK.Valid {
-		ok := lastSyncOK.Int64 == 1
-		m.LastSyncOK = &ok
+	if in.Scope == "" {
+		in.Scope = model.SyncScopeFull
 	}
-	m.CreatedAt = parseTS(createdAt)
-	return m, apiKeyHash, strategy, nil
+	if in.Strategy == "" {
+		in.Strategy = "latest-wins"
+	}
+	query := `
+UPDATE sync_manifests
+SET remote_url = ?, direction = ?, scope = ?, scope_ids = ?, topic_ids = ?, strategy = ?, schedule = ?, peer_public_key = ?, live = ?, filter = ?`
+	args := []any{in.RemoteURL, string(in.Direction), string(in.Scope), toJSON(in.ScopeIDs), toJSON(in.TopicIDs), in.Strategy, in.Schedule, in.PeerPublicKey, boolToInt(in.Live), toJSON(in.Filter)}
+	if in.APIKeyHash != "" {
+		query += ", api_key_hash = ?, api_key_enc = ?"
+		args = append(args, in.APIKeyHash, in.APIKeyEnc)
+	}
+	if _, err := s.DB.ExecContext(ctx, query+" WHERE remote_name = ?", append(args, in.RemoteName)...); err != nil {
+		return model.SyncManifest{}, err
+	}
+	return s.GetRemote(ctx, in.RemoteName)
+}
+
+// GetRemoteAPIKey returns the hash and the encrypted form of the API key
+// stored for a remote. Both are empty when no key was stored.
+func (s *Store) GetRemoteAPIKey(ctx context.Context, name string) (hash string, enc string, err error) {
+	err = s.DB.QueryRowContext(ctx, "SELECT api_key_hash, api_key_enc FROM sync_manifests WHERE remote_name = ?", name).Scan(&hash, &enc)
+	return hash, enc, err
 }
 
 func (s *Store) DeleteRemote(ctx context.Context, name string) error {
@@ -138,8 +145,91 @@ WHERE id = ?`,
 func (s *Store) UpdateManifestSyncResult(ctx context.Context, manifestID string, ok bool) error {
 	_, err := s.DB.ExecContext(ctx, `
 UPDATE sync_manifests
-SET last_sync_at = ?, last_sync_ok = ?
-WHERE id = ?`, nowUTC().Format(timeFormat), boolToInt(ok), manifestID)
+SET last_sync_at = ?, last_sync_ok = ?,
+    failures = CASE WHEN ? THEN 0 ELSE failures END,
+    next_retry_at = CASE WHEN ? THEN NULL ELSE next_retry_at END,
+    last_error = CASE WHEN ? THEN NULL ELSE last_error END
+WHERE id = ?`, nowUTC().Format(timeFormat), boolToInt(ok), boolToInt(ok), boolToInt(ok), boolToInt(ok), manifestID)
+	return err
+}
+
+// RecordSyncFailure counts a failed sync and records when to retry it.
+func (s *Store) RecordSyncFailure(ctx context.Context, manifestID, errMsg string, nextRetryAt time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `
+UPDATE sync_manifests
+SET last_sync_at = ?, last_sync_ok = 0, failures = failures + 1, next_retry_at = ?, last_error = ?
+WHERE id = ?`, nowUTC().Format(timeFormat), nextRetryAt.UTC().Format(timeFormat), errMsg, manifestID)
+	return err
+}
+
+// SyncRun is the persisted plan of a push or pull that has not finished.
+// Plan is opaque to the store; the sync engine writes and reads it.
+type SyncRun struct {
+	ManifestID string
+	Direction  model.SyncDirection
+	ScopeKey   string
+	Plan       string
+	Cursor     int64
+	BatchSize  int
+	Acked      int
+	StartedAt  time.Time
+}
+
+// GetSyncRun returns the unfinished run for a remote and direction, or
+// sql.ErrNoRows when there is none.
+func (s *Store) GetSyncRun(ctx context.Context, manifestID string, direction model.SyncDirection) (SyncRun, error) {
+	var (
+		run     SyncRun
+		started string
+	)
+	err := s.DB.QueryRowContext(ctx, `
+SELECT manifest_id, direction, scope_key, plan, cursor, batch_size, acked, started_at
+FROM sync_runs
+WHERE manifest_id = ? AND direction = ?`, manifestID, string(direction)).Scan(
+		&run.ManifestID, &run.Direction, &run.ScopeKey, &run.Plan, &run.Cursor, &run.BatchSize, &run.Acked, &started,
+	)
+	if err != nil {
+		return SyncRun{}, err
+	}
+	run.StartedAt = parseTS(started)
+	return run, nil
+}
+
+// StartSyncRun records a new run, replacing any earlier one for the same
+// remote and direction.
+func (s *Store) StartSyncRun(ctx context.Context, run SyncRun) error {
+	now := nowUTC().Format(timeFormat)
+	_, err := s.DB.ExecContext(ctx, `
+INSERT OR REPLACE INTO sync_runs(manifest_id, direction, scope_key, plan, cursor, batch_size, acked, started_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ManifestID, string(run.Direction), run.ScopeKey, run.Plan, run.Cursor, run.BatchSize, run.Acked, now, now)
+	return err
+}
+
+// AckSyncBatch records that the first acked batches of a run are committed.
+func (s *Store) AckSyncBatch(ctx context.Context, manifestID string, direction model.SyncDirection, acked int) error {
+	_, err := s.DB.ExecContext(ctx, `
+UPDATE sync_runs SET acked = ?, updated_at = ?
+WHERE manifest_id = ? AND direction = ?`, acked, nowUTC().Format(timeFormat), manifestID, string(direction))
+	return err
+}
+
+func (s *Store) FinishSyncRun(ctx context.Context, manifestID string, direction model.SyncDirection) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM sync_runs WHERE manifest_id = ? AND direction = ?", manifestID, string(direction))
+	return err
+}
+
+// UpdateManifestCursor records the high-water mark reached by a successful
+// push or pull. Moving to a different scope resets the other cursor.
+func (s *Store) UpdateManifestCursor(ctx context.Context, manifestID string, direction model.SyncDirection, scopeKey string, cursor int64) error {
+	column, other := "push_cursor", "pull_cursor"
+	if direction == model.SyncDirectionPull {
+		column, other = other, column
+	}
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`
+UPDATE sync_manifests
+SET %[1]s = ?, %[2]s = CASE WHEN cursor_scope = ? THEN %[2]s ELSE 0 END, cursor_scope = ?
+WHERE id = ?`, column, other), cursor, scopeKey, scopeKey, manifestID)
 	return err
 }
 
@@ -175,15 +265,15 @@ LIMIT ?`, limit)
 	return out, rows.Err()
 }
 
-func (s *Store) CreateSyncConflict(ctx context.Context, manifestID, entityType, entityID, localChecksum, remoteChecksum, strategy string, localPayload, remotePayload map[string]any) (model.SyncConflict, error) {
+func (s *Store) CreateSyncConflict(ctx context.Context, manifestID, entityType, entityID, localChecksum, remoteChecksum, strategy string, localPayload, remotePayload map[string]any, diff string) (model.SyncConflict, error) {
 	id := newID()
 	now := nowUTC().Format(timeFormat)
 	_, err := s.DB.ExecContext(ctx, `
 INSERT INTO sync_conflicts(
   id, manifest_id, entity_type, entity_id, local_checksum, remote_checksum,
-  strategy, status, local_payload, remote_payload, created_at
-) VALUES (?, ?, ?, ?, ?, ?, ?, 'open', ?, ?, ?)`,
-		id, manifestID, entityType, entityID, localChecksum, remoteChecksum, strategy, toJSON(localPayload), toJSON(remotePayload), now,
+  strategy, status, local_payload, remote_payload, diff, created_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, 'open', ?, ?, ?, ?)`,
+		id, manifestID, entityType, entityID, localChecksum, remoteChecksum, strategy, toJSON(localPayload), toJSON(remotePayload), nullString(diff), now,
 	)
 	if err != nil {
 		return model.SyncConflict{}, err
@@ -193,7 +283,8 @@ INSERT INTO sync_conflicts(
 
 func (s *Store) GetSyncConflict(ctx context.Context, id string) (model.SyncConflict, error) {
 	row := s.DB.QueryRowContext(ctx, `
-SELECT id, manifest_id, entity_type, entity_id, local_checksum, remote_checksum, strategy, status, created_at, resolved_at
+SELECT id, manifest_id, entity_type, entity_id, local_checksum, remote_checksum, strategy, status,
+       local_payload, remote_payload, diff, note, created_at, resolved_at
 FROM sync_conflicts
 WHERE id = ?`, id)
 	return scanSyncConflict(row)
@@ -201,7 +292,8 @@ WHERE id = ?`, id)
 
 func (s *Store) ListOpenConflicts(ctx context.Context) ([]model.SyncConflict, error) {
 	rows, err := s.DB.QueryContext(ctx, `
-SELECT id, manifest_id, entity_type, entity_id, local_checksum, remote_checksum, strategy, status, created_at, resolved_at
+SELECT id, manifest_id, entity_type, entity_id, local_checksum, remote_checksum, strategy, status,
+       local_payload, remote_payload, diff, note, created_at, resolved_at
 FROM sync_conflicts
 WHERE status = 'open'
 ORDER BY created_at DESC`)
@@ -220,6 +312,16 @@ ORDER BY created_at DESC`)
 	return out, rows.Err()
 }
 
+// SetSyncConflictEvidence records what the two sides of a conflict held so a
+// reviewer can compare them.
+func (s *Store) SetSyncConflictEvidence(ctx context.Context, id, localChecksum, remoteChecksum string, localPayload, remotePayload map[string]any, diff string) error {
+	_, err := s.DB.ExecContext(ctx, `
+UPDATE sync_conflicts
+SET local_checksum = ?, remote_checksum = ?, local_payload = ?, remote_payload = ?, diff = ?
+WHERE id = ?`, localChecksum, remoteChecksum, toJSON(localPayload), toJSON(remotePayload), nullString(diff), id)
+	return err
+}
+
 func (s *Store) ResolveConflict(ctx context.Context, id, strategy, note string) error {
 	_, err := s.DB.ExecContext(ctx, `
 UPDATE sync_conflicts
@@ -228,24 +330,41 @@ WHERE id = ?`, strategy, note, nowUTC().Format(timeFormat), id)
 	return err
 }
 
+// manifestColumns lists the sync_manifests columns read by scanSyncManifest.
+const manifestColumns = "id, remote_url, remote_name, direction, scope, scope_ids, topic_ids, strategy, schedule, last_sync_at, last_sync_ok, push_cursor, pull_cursor, cursor_scope, failures, next_retry_at, last_error, peer_public_key, live, filter, created_at"
+
+// scanSyncManifest reads manifestColumns followed by any extra columns.
 func scanSyncManifest(scanner interface {
 	Scan(dest ...any) error
-}) (model.SyncManifest, error) {
+}, extra ...any) (model.SyncManifest, error) {
 	var (
-		m          model.SyncManifest
-		direction  string
-		scope      string
-		scopeIDs   string
-		lastSyncAt sql.NullString
-		lastSyncOK sql.NullInt64
-		createdAt  string
+		m           model.SyncManifest
+		direction   string
+		scope       string
+		scopeIDs    string
+		topicIDs    sql.NullString
+		schedule    sql.NullString
+		lastSyncAt  sql.NullString
+		lastSyncOK  sql.NullInt64
+		nextRetryAt sql.NullString
+		lastError   sql.NullString
+		filter      string
+		createdAt   string
 	)
-	if err := scanner.Scan(&m.ID, &m.RemoteURL, &m.RemoteName, &direction, &scope, &scopeIDs, &lastSyncAt, &lastSyncOK, &createdAt); err != nil {
+	dest := append([]any{&m.ID, &m.RemoteURL, &m.RemoteName, &direction, &scope, &scopeIDs, &topicIDs, &m.Strategy, &schedule, &lastSyncAt, &lastSyncOK, &m.PushCursor, &m.PullCursor, &m.CursorScope, &m.Failures, &nextRetryAt, &lastError, &m.PeerPublicKey, &m.Live, &filter, &createdAt}, extra...)
+	if err := scanner.Scan(dest...); err != nil {
 		return model.SyncManifest{}, err
 	}
 	m.Direction = model.SyncDirection(direction)
 	m.Scope = model.SyncScope(scope)
 	m.ScopeIDs = fromJSON[[]string](scopeIDs)
+	m.Filter = fromJSON[model.SyncFilter](filter)
+	if topicIDs.Valid {
+		m.TopicIDs = fromJSON[[]string](topicIDs.String)
+	}
+	if schedule.Valid {
+		m.Schedule = &schedule.String
+	}
 	if lastSyncAt.Valid {
 		t := parseTS(lastSyncAt.String)
 		if !t.IsZero() {
@@ -256,6 +375,15 @@ func scanSyncManifest(scanner interface {
 		v := lastSyncOK.Int64 == 1
 		m.LastSyncOK = &v
 	}
+	if nextRetryAt.Valid {
+		t := parseTS(nextRetryAt.String)
+		if !t.IsZero() {
+			m.NextRetryAt = &t
+		}
+	}
+	if lastError.Valid {
+		m.LastError = &lastError.String
+	}
 	m.CreatedAt = parseTS(createdAt)
 	return m, nil
 }
@@ -288,22 +416,37 @@ func scanSyncConflict(scanner interface {
 	Scan(dest ...any) error
 }) (model.SyncConflict, error) {
 	var (
-		c          model.SyncConflict
-		resolvedAt sql.NullString
-		createdAt  string
+		c             model.SyncConflict
+		localPayload  sql.NullString
+		remotePayload sql.NullString
+		diff          sql.NullString
+		note          sql.NullString
+		resolvedAt    sql.NullString
+		createdAt     string
 	)
-	if err := scanner.Scan(&c.ID, &c.ManifestID, &c.EntityType, &c.EntityID, &c.LocalChecksum, &c.RemoteChecksum, &c.Strategy, &c.Status, &createdAt, &resolvedAt); err != nil {
+	if err := scanner.Scan(
+		&c.ID, &c.ManifestID, &c.EntityType, &c.EntityID, &c.LocalChecksum, &c.RemoteChecksum, &c.Strategy, &c.Status,
+		&localPayload, &remotePayload, &diff, &note, &createdAt, &resolvedAt,
+	); err != nil {
 		return model.SyncConflict{}, err
 	}
+	if localPayload.Valid {
+		c.LocalPayload = fromJSON[map[string]any](localPayload.String)
+	}
+	if remotePayload.Valid {
+		c.RemotePayload = fromJSON[map[string]any](remotePayload.String)
+	}
+	c.Diff = diff.String
+	if note.Valid {
+		c.Note = &note.String
+	}
 	c.CreatedAt = parseTS(createdAt)
 	c.ResolvedAt = parseTSPtr(resolvedAt)
 	return c, nil
 }
 
 func (s *Store) ManifestByID(ctx context.Context, id string) (model.SyncManifest, error) {
-	row := s.DB.QueryRowContext(ctx, `
-SELECT id, remote_url, remote_name, direction, scope, scope_ids, last_sync_at, last_sync_ok, created_at
-FROM sync_manifests WHERE id = ?`, id)
+	row := s.DB.QueryRowContext(ctx, "SELECT "+manifestColumns+" FROM sync_manifests WHERE id = ?", id)
 	return scanSyncManifest(row)
 }
 
@@ -315,3 +458,689 @@ func (s *Store) AssertRemoteExists(ctx context.Context, name string) error {
 	}
 	return err
 }
+
+// EnsureSyncAgentTx makes sure an agent referenced by replicated data exists
+// locally. Unknown agents are recorded as inactive system placeholders so that
+// foreign keys on synced rows hold without granting the peer's agent access.
+func (s *Store) EnsureSyncAgentTx(ctx context.Context, tx *sql.Tx, agentID string) error {
+	if agentID == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+INSERT OR IGNORE INTO agents(id, name, type, api_key_hash, description, tags, status, metadata, created_at)
+VALUES (?, ?, 'system', ?, 'placeholder for an agent replicated from a sync peer', '["sync"]', 'inactive', '{}', ?)`,
+		agentID, "sync-"+agentID, "sync:"+agentID, nowUTC().Format(timeFormat))
+	return err
+}
+
+func (s *Store) GetKnowledgeTx(ctx context.Context, tx *sql.Tx, id string) (model.KnowledgeEntry, error) {
+	row := tx.QueryRowContext(ctx, `
+SELECT id, title, content, content_type, summary, tags, collection_id, created_by, updated_by, version,
+       checksum, is_pinned, visibility, source, metadata, created_at, updated_at
+FROM knowledge_entries
+WHERE id = ?`, id)
+	return scanKnowledge(row)
+}
+
+// UpsertSyncedKnowledgeTx writes a knowledge entry received from a peer. New
+// entries keep the peer's version number; existing entries advance the local
+// version. Either way a knowledge_versions row is recorded with changeNote.
+func (s *Store) UpsertSyncedKnowledgeTx(ctx context.Context, tx *sql.Tx, e model.KnowledgeEntry, changeNote string) error {
+	if e.ContentType == "" {
+		e.ContentType = "text/markdown"
+	}
+	if e.Visibility == "" {
+		e.Visibility = model.KnowledgeVisibilityPublic
+	}
+	if e.UpdatedBy == "" {
+		e.UpdatedBy = e.CreatedBy
+	}
+	for _, agentID := range []string{e.CreatedBy, e.UpdatedBy} {
+		if err := s.EnsureSyncAgentTx(ctx, tx, agentID); err != nil {
+			return err
+		}
+	}
+	if e.CollectionID != nil {
+		var exists int
+		err := tx.QueryRowContext(ctx, "SELECT 1 FROM collections WHERE id = ?", *e.CollectionID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			e.CollectionID = nil
+		} else if err != nil {
+			return err
+		}
+	}
+	updatedAt := e.UpdatedAt.UTC().Format(timeFormat)
+
+	var currentVersion int
+	err := tx.QueryRowContext(ctx, "SELECT version FROM knowledge_entries WHERE id = ?", e.ID).Scan(&currentVersion)
+	switch {
+	case err == sql.ErrNoRows:
+		if e.Version <= 0 {
+			e.Version = 1
+		}
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO knowledge_entries(
+  id, title, content, content_type, summary, tags, collection_id, created_by, updated_by, version,
+  checksum, is_pinned, visibility, source, metadata, created_at, updated_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?)`,
+			e.ID, e.Title, e.Content, e.ContentType, nullStringFromPtr(e.Summary), toJSON(e.Tags), e.CollectionID,
+			e.CreatedBy, e.UpdatedBy, e.Version, checksum(e.Content), string(e.Visibility), e.Source, toJSON(e.Metadata),
+			e.CreatedAt.UTC().Format(timeFormat), updatedAt,
+		)
+	case err != nil:
+		return err
+	default:
+		e.Version = currentVersion + 1
+		_, err = tx.ExecContext(ctx, `
+UPDATE knowledge_entries
+SET title = ?, content = ?, content_type = ?, summary = ?, tags = ?, collection_id = ?, updated_by = ?,
+    version = ?, checksum = ?, visibility = ?, source = ?, metadata = ?, updated_at = ?
+WHERE id = ?`,
+			e.Title, e.Content, e.ContentType, nullStringFromPtr(e.Summary), toJSON(e.Tags), e.CollectionID, e.UpdatedBy,
+			e.Version, checksum(e.Content), string(e.Visibility), e.Source, toJSON(e.Metadata), updatedAt, e.ID,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO knowledge_versions(id, knowledge_id, version, content, summary, changed_by, change_note, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		newID(), e.ID, e.Version, e.Content, nullStringFromPtr(e.Summary), e.UpdatedBy, nullString(changeNote), updatedAt)
+	return err
+}
+
+func (s *Store) GetCollectionTx(ctx context.Context, tx *sql.Tx, id string) (model.Collection, error) {
+	row := tx.QueryRowContext(ctx, `
+SELECT id, name, description, parent_id, created_by, is_public, metadata, created_at, updated_at
+FROM collections WHERE id = ?`, id)
+	return scanCollection(row)
+}
+
+// UpsertSyncedCollectionTx stores a collection received from a peer. A parent
+// unknown on this node, or one that would close a cycle, is dropped so the
+// collection lands at the root.
+func (s *Store) UpsertSyncedCollectionTx(ctx context.Context, tx *sql.Tx, c model.Collection) error {
+	if err := s.EnsureSyncAgentTx(ctx, tx, c.CreatedBy); err != nil {
+		return err
+	}
+	if c.ParentID != nil {
+		current := *c.ParentID
+		for current != "" {
+			if current == c.ID {
+				c.ParentID = nil
+				break
+			}
+			var parent sql.NullString
+			err := tx.QueryRowContext(ctx, "SELECT parent_id FROM collections WHERE id = ?", current).Scan(&parent)
+			if err == sql.ErrNoRows {
+				if current == *c.ParentID {
+					c.ParentID = nil
+				}
+				break
+			}
+			if err != nil {
+				return err
+			}
+			current = parent.String
+		}
+	}
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO collections(id, name, description, parent_id, created_by, is_public, metadata, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+  name = excluded.name,
+  description = excluded.description,
+  parent_id = excluded.parent_id,
+  is_public = excluded.is_public,
+  metadata = excluded.metadata,
+  updated_at = excluded.updated_at`,
+		c.ID, c.Name, c.Description, c.ParentID, c.CreatedBy, boolToInt(c.IsPublic), toJSON(c.Metadata),
+		c.CreatedAt.UTC().Format(timeFormat), c.UpdatedAt.UTC().Format(timeFormat),
+	)
+	return err
+}
+
+func (s *Store) GetTopicTx(ctx context.Context, tx *sql.Tx, id string) (model.Topic, error) {
+	row := tx.QueryRowContext(ctx, `
+SELECT id, name, description, retention, ttl_seconds, created_by, is_public, created_at
+FROM topics WHERE id = ?`, id)
+	return scanTopic(row)
+}
+
+func (s *Store) GetTopicByNameTx(ctx context.Context, tx *sql.Tx, name string) (model.Topic, error) {
+	row := tx.QueryRowContext(ctx, `
+SELECT id, name, description, retention, ttl_seconds, created_by, is_public, created_at
+FROM topics WHERE name = ?`, name)
+	return scanTopic(row)
+}
+
+// UpsertSyncedTopicTx writes a topic received from a peer, keeping its id.
+func (s *Store) UpsertSyncedTopicTx(ctx context.Context, tx *sql.Tx, t model.Topic) error {
+	if t.Retention == "" {
+		t.Retention = model.TopicRetentionPersistent
+	}
+	if err := s.EnsureSyncAgentTx(ctx, tx, t.CreatedBy); err != nil {
+		return err
+	}
+	ttl := sql.NullInt64{}
+	if t.TTLSeconds != nil {
+		ttl.Valid = true
+		ttl.Int64 = int64(*t.TTLSeconds)
+	}
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO topics(id, name, description, retention, ttl_seconds, created_by, is_public, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+  name = excluded.name,
+  description = excluded.description,
+  retention = excluded.retention,
+  ttl_seconds = excluded.ttl_seconds,
+  is_public = excluded.is_public`,
+		t.ID, t.Name, t.Description, string(t.Retention), ttl, t.CreatedBy, boolToInt(t.IsPublic), t.CreatedAt.UTC().Format(timeFormat),
+	)
+	return err
+}
+
+// InsertSyncedMessageTx stores a message received from a peer. Messages are
+// immutable, so an id that already exists is left untouched. It reports false
+// when the message was not stored because it already existed or because its
+// topic or group is unknown on this node. A stored message gets receipts for
+// its recipients on this node, as if it had been sent here.
+func (s *Store) InsertSyncedMessageTx(ctx context.Context, tx *sql.Tx, m model.Message) (bool, error) {
+	var exists int
+	err := tx.QueryRowContext(ctx, "SELECT 1 FROM messages WHERE id = ?", m.ID).Scan(&exists)
+	if err == nil {
+		return false, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+	if m.TopicID != nil {
+		if err := tx.QueryRowContext(ctx, "SELECT 1 FROM topics WHERE id = ?", *m.TopicID).Scan(&exists); err == sql.ErrNoRows {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if m.ToGroupID != nil {
+		err := tx.QueryRowContext(ctx, "SELECT 1 FROM groups WHERE id = ?", *m.ToGroupID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			m.ToGroupID = nil
+			m.QueueMode = false
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if m.ReplyToID != nil {
+		err := tx.QueryRowContext(ctx, "SELECT 1 FROM messages WHERE id = ?", *m.ReplyToID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			m.ReplyToID = nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if m.ToAgentID == nil && m.TopicID == nil && m.ToGroupID == nil {
+		return false, nil
+	}
+	if m.ContentType == "" {
+		m.ContentType = "text/plain"
+	}
+	if m.Priority == "" {
+		m.Priority = model.MessagePriorityNormal
+	}
+	recipients, queued, err := s.syncedRecipientsTx(ctx, tx, m)
+	if err != nil {
+		return false, err
+	}
+	m.QueueMode = m.QueueMode || queued
+	for _, agentID := range []*string{&m.FromAgentID, m.ToAgentID} {
+		if agentID == nil {
+			continue
+		}
+		if err := s.EnsureSyncAgentTx(ctx, tx, *agentID); err != nil {
+			return false, err
+		}
+	}
+	switch m.Status {
+	case model.MessageStatusPending, model.MessageStatusDelivered, model.MessageStatusRead, model.MessageStatusExpired:
+	default:
+		m.Status = model.MessageStatusPending
+	}
+	var expires, deliverAt, dispatched sql.NullString
+	if m.ExpiresAt != nil {
+		expires.Valid = true
+		expires.String = m.ExpiresAt.UTC().Format(timeFormat)
+	}
+	if m.DeliverAt != nil {
+		deliverAt.Valid = true
+		deliverAt.String = m.DeliverAt.UTC().Format(timeFormat)
+		// One that is already due is published on arrival, not by the
+		// scheduler.
+		if !m.DeliverAt.After(nowUTC()) {
+			dispatched.Valid = true
+			dispatched.String = nowUTC().Format(timeFormat)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO messages(
+  id, from_agent_id, to_agent_id, topic_id, to_group_id, queue_mode, reply_to_id, content_type, content, status, priority,
+  tags, metadata, created_at, expires_at, deliver_at, dispatched_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID, m.FromAgentID, m.ToAgentID, m.TopicID, m.ToGroupID, boolToInt(m.QueueMode), m.ReplyToID, m.ContentType,
+		m.Content, string(m.Status), string(m.Priority), toJSON(m.Tags), toJSON(m.Metadata),
+		m.CreatedAt.UTC().Format(timeFormat), expires, deliverAt, dispatched,
+	)
+	if err != nil {
+		return false, err
+	}
+	if m.Status == model.MessageStatusExpired {
+		return true, nil
+	}
+	if err := insertReceiptsTx(ctx, tx, m.ID, recipients, queued, nowUTC()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// syncedRecipientsTx fans a synced message out the way App.CreateMessage
+// does, to the agents on this node: the direct recipient unless it only
+// exists here as a sync placeholder, the topic's subscribers whose filter
+// it passes, and the members of a group that does not queue its messages.
+// queued reports a message the group's members claim instead.
+func (s *Store) syncedRecipientsTx(ctx context.Context, tx *sql.Tx, m model.Message) ([]string, bool, error) {
+	var recipients []string
+	if m.ToAgentID != nil {
+		var local int
+		err := tx.QueryRowContext(ctx, "SELECT 1 FROM agents WHERE id = ? AND api_key_hash != ?", *m.ToAgentID, "sync:"+*m.ToAgentID).Scan(&local)
+		if err == nil {
+			recipients = append(recipients, *m.ToAgentID)
+		} else if err != sql.ErrNoRows {
+			return nil, false, err
+		}
+	}
+	if m.TopicID != nil {
+		rows, err := tx.QueryContext(ctx, `
+SELECT agent_id, topic_id, filter, created_at
+FROM subscriptions WHERE topic_id = ?`, *m.TopicID)
+		if err != nil {
+			return nil, false, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			sub, err := scanSubscription(rows)
+			if err != nil {
+				return nil, false, err
+			}
+			// Like the live fan-out, a filter that cannot be read matches
+			// nothing.
+			if f, err := broker.ParseFilter(sub.Filter); err == nil && f.Match(m) {
+				recipients = append(recipients, sub.AgentID)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, false, err
+		}
+	}
+	if m.ToGroupID == nil {
+		return recipients, false, nil
+	}
+	var mode string
+	if err := tx.QueryRowContext(ctx, "SELECT mode FROM groups WHERE id = ?", *m.ToGroupID).Scan(&mode); err != nil {
+		return nil, false, err
+	}
+	if m.QueueMode || model.GroupMode(mode) == model.GroupModeQueue {
+		return recipients, true, nil
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT agent_id FROM group_members WHERE group_id = ?", *m.ToGroupID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var agentID string
+		if err := rows.Scan(&agentID); err != nil {
+			return nil, false, err
+		}
+		recipients = append(recipients, agentID)
+	}
+	return recipients, false, rows.Err()
+}
+
+// SyncedChecksums returns, per entity id, the checksum both this node and the
+// remote held after their last sync.
+func (s *Store) SyncedChecksums(ctx context.Context, manifestID, entityType string) (map[string]string, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+SELECT entity_id, checksum
+FROM sync_entity_state
+WHERE manifest_id = ? AND entity_type = ?`, manifestID, entityType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var id, sum string
+		if err := rows.Scan(&id, &sum); err != nil {
+			return nil, err
+		}
+		out[id] = sum
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) RecordSyncedChecksums(ctx context.Context, manifestID, entityType string, checksums map[string]string) error {
+	if len(checksums) == 0 {
+		return nil
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	now := nowUTC().Format(timeFormat)
+	for id, sum := range checksums {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO sync_entity_state(manifest_id, entity_type, entity_id, checksum, synced_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(manifest_id, entity_type, entity_id) DO UPDATE SET
+  checksum = excluded.checksum,
+  synced_at = excluded.synced_at
+WHERE sync_entity_state.checksum <> excluded.checksum`,
+			manifestID, entityType, id, sum, now)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ImportKnowledgeVersions records versions of knowledge entries brought in
+// from a sync bundle. Versions whose number or id is already taken, and those
+// of entries that do not exist here, are left out.
+func (s *Store) ImportKnowledgeVersions(ctx context.Context, versions []model.KnowledgeVersion) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if err := s.EnsureSyncAgentTx(ctx, tx, v.ChangedBy); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO knowledge_versions(id, knowledge_id, version, content, summary, changed_by, change_note, created_at)
+SELECT ?, ?, ?, ?, ?, ?, ?, ?
+WHERE EXISTS (SELECT 1 FROM knowledge_entries WHERE id = ?)
+ON CONFLICT DO NOTHING`,
+			v.ID, v.KnowledgeID, v.Version, v.Content, nullStringFromPtr(v.Summary), v.ChangedBy, nullStringFromPtr(v.ChangeNote),
+			v.CreatedAt.UTC().Format(timeFormat), v.KnowledgeID)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// KnowledgeVersionByChecksumTx finds the most recent version of an entry
+// whose content has the given checksum.
+func (s *Store) KnowledgeVersionByChecksumTx(ctx context.Context, tx *sql.Tx, knowledgeID, sum string) (model.KnowledgeVersion, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT id, knowledge_id, version, content, summary, changed_by, change_note, created_at
+FROM knowledge_versions
+WHERE knowledge_id = ?
+ORDER BY version DESC`, knowledgeID)
+	if err != nil {
+		return model.KnowledgeVersion{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v, err := scanKnowledgeVersion(rows)
+		if err != nil {
+			return model.KnowledgeVersion{}, err
+		}
+		if checksum(v.Content) == sum {
+			return v, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return model.KnowledgeVersion{}, err
+	}
+	return model.KnowledgeVersion{}, sql.ErrNoRows
+}
+
+// changeTimeFormat matches the timestamps the sync_changes triggers write, so
+// tombstone times compare correctly as text.
+const changeTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// tombstoneTables maps synced entity types to their tables.
+var tombstoneTables = map[string]string{
+	"collections": "collections",
+	"knowledge":   "knowledge_entries",
+	"topics":      "topics",
+	"messages":    "messages",
+}
+
+// NodeID returns the identifier this node stamps on its own tombstones.
+func (s *Store) NodeID(ctx context.Context) (string, error) {
+	var id string
+	err := s.DB.QueryRowContext(ctx, "SELECT node_id FROM sync_node WHERE id = 1").Scan(&id)
+	return id, err
+}
+
+// NodeKey returns this node's identifier with its public key and encrypted
+// private key, both empty until SetNodeKey has run.
+func (s *Store) NodeKey(ctx context.Context) (nodeID, publicKey, privateKeyEnc string, err error) {
+	err = s.DB.QueryRowContext(ctx, "SELECT node_id, public_key, private_key_enc FROM sync_node WHERE id = 1").Scan(&nodeID, &publicKey, &privateKeyEnc)
+	return nodeID, publicKey, privateKeyEnc, err
+}
+
+// SetNodeKey stores the node's key pair unless one is already stored, so two
+// processes creating a key at once agree on the first.
+func (s *Store) SetNodeKey(ctx context.Context, publicKey, privateKeyEnc string) error {
+	_, err := s.DB.ExecContext(ctx, "UPDATE sync_node SET public_key = ?, private_key_enc = ? WHERE id = 1 AND private_key_enc = ''", publicKey, privateKeyEnc)
+	return err
+}
+
+// SetEntityVersionTx stores the version vector of a synced entity. seq is
+// the entity's latest local change the vector accounts for.
+func (s *Store) SetEntityVersionTx(ctx context.Context, tx *sql.Tx, entityType, entityID, origin string, vector map[string]int64, seq int64) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO sync_versions(entity_type, entity_id, origin_node, vector, seq)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(entity_type, entity_id) DO UPDATE SET origin_node = excluded.origin_node, vector = excluded.vector, seq = excluded.seq`,
+		entityType, entityID, origin, toJSON(vector), seq)
+	return err
+}
+
+// ChangeSeqTx returns the sequence number of the latest change to an entity,
+// zero when it has none.
+func (s *Store) ChangeSeqTx(ctx context.Context, tx *sql.Tx, entityType, entityID string) (int64, error) {
+	var seq int64
+	err := tx.QueryRowContext(ctx, "SELECT seq FROM sync_changes WHERE entity_type = ? AND entity_id = ?", entityType, entityID).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// KnownClock returns the highest counter per node across the stored version
+// vectors: the changes of other nodes this node has seen.
+func (s *Store) KnownClock(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT vector FROM sync_versions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clock := map[string]int64{}
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		for node, n := range fromJSON[map[string]int64](raw) {
+			clock[node] = max(clock[node], n)
+		}
+	}
+	return clock, rows.Err()
+}
+
+// NotePeer records that the node nodeID was seen, merging clock into what it
+// is known to have seen. An empty remoteName keeps the stored one.
+func (s *Store) NotePeer(ctx context.Context, nodeID, remoteName string, clock map[string]int64) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var raw string
+	err = tx.QueryRowContext(ctx, "SELECT clock FROM sync_peers WHERE node_id = ?", nodeID).Scan(&raw)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	merged := fromJSON[map[string]int64](raw)
+	if merged == nil {
+		merged = map[string]int64{}
+	}
+	for node, n := range clock {
+		merged[node] = max(merged[node], n)
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO sync_peers(node_id, remote_name, clock, last_seen_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(node_id) DO UPDATE SET
+  remote_name = COALESCE(excluded.remote_name, sync_peers.remote_name),
+  clock = excluded.clock,
+  last_seen_at = excluded.last_seen_at`,
+		nodeID, nullString(remoteName), toJSON(merged), nowUTC().Format(timeFormat))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListPeers returns the nodes this node has exchanged with, most recently
+// seen first.
+func (s *Store) ListPeers(ctx context.Context) ([]model.SyncPeer, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT node_id, remote_name, clock, last_seen_at FROM sync_peers ORDER BY last_seen_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []model.SyncPeer{}
+	for rows.Next() {
+		var (
+			p                 model.SyncPeer
+			remote            sql.NullString
+			clock, lastSeenAt string
+		)
+		if err := rows.Scan(&p.NodeID, &remote, &clock, &lastSeenAt); err != nil {
+			return nil, err
+		}
+		if remote.Valid {
+			p.RemoteName = &remote.String
+		}
+		p.Clock = fromJSON[map[string]int64](clock)
+		p.LastSeenAt = parseTS(lastSeenAt)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// ListTombstones returns tombstones recorded after change sequence since,
+// limited to entityTypes when any are given.
+func (s *Store) ListTombstones(ctx context.Context, since int64, entityTypes []string) ([]model.Tombstone, error) {
+	query := `
+SELECT entity_type, entity_id, changed_at, origin_node
+FROM sync_changes
+WHERE op = 'delete' AND seq > ?`
+	args := []any{since}
+	if len(entityTypes) > 0 {
+		in, inArgs := inClause(entityTypes)
+		query += " AND entity_type IN (" + in + ")"
+		args = append(args, inArgs...)
+	}
+	rows, err := s.DB.QueryContext(ctx, query+" ORDER BY seq", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.Tombstone
+	for rows.Next() {
+		t, err := scanTombstone(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// TombstoneTx returns the tombstone for an entity, or sql.ErrNoRows when the
+// entity has not been deleted.
+func (s *Store) TombstoneTx(ctx context.Context, tx *sql.Tx, entityType, id string) (model.Tombstone, error) {
+	row := tx.QueryRowContext(ctx, `
+SELECT entity_type, entity_id, changed_at, origin_node
+FROM sync_changes
+WHERE entity_type = ? AND entity_id = ? AND op = 'delete'`, entityType, id)
+	return scanTombstone(row)
+}
+
+// ApplyTombstoneTx deletes an entity on behalf of a peer. The local tombstone
+// keeps the peer's deletion time and origin so it travels on unchanged. It
+// reports false when the entity was already tombstoned here.
+func (s *Store) ApplyTombstoneTx(ctx context.Context, tx *sql.Tx, t model.Tombstone) (bool, error) {
+	table, ok := tombstoneTables[t.EntityType]
+	if !ok {
+		return false, fmt.Errorf("unsupported entity type: %s", t.EntityType)
+	}
+	if _, err := s.TombstoneTx(ctx, tx, t.EntityType, t.EntityID); err == nil {
+		return false, nil
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE id = ?", t.EntityID); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE sync_seq SET value = value + 1 WHERE id = 1"); err != nil {
+		return false, err
+	}
+	_, err := tx.ExecContext(ctx, `
+INSERT OR REPLACE INTO sync_changes(entity_type, entity_id, seq, op, changed_at, origin_node)
+VALUES (?, ?, (SELECT value FROM sync_seq WHERE id = 1), 'delete', ?, ?)`,
+		t.EntityType, t.EntityID, t.DeletedAt.UTC().Format(changeTimeFormat), nullString(t.OriginNode))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PurgeTombstones drops tombstones for deletions older than before. A peer
+// that has not synced since then may bring those entities back.
+func (s *Store) PurgeTombstones(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM sync_changes WHERE op = 'delete' AND changed_at < ?", before.UTC().Format(changeTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanTombstone(scanner interface {
+	Scan(dest ...any) error
+}) (model.Tombstone, error) {
+	var (
+		t         model.Tombstone
+		deletedAt string
+		origin    sql.NullString
+	)
+	if err := scanner.Scan(&t.EntityType, &t.EntityID, &deletedAt, &origin); err != nil {
+		return model.Tombstone{}, err
+	}
+	t.DeletedAt = parseTS(deletedAt)
+	t.OriginNode = origin.String
+	return t, nil
+}
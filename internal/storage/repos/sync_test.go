@@ -0,0 +1,81 @@
+package repos
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"opencortex/internal/config"
+	"opencortex/internal/model"
+	"opencortex/internal/storage"
+)
+
+func TestSyncChangeSeqSurvivesUpsertsAndPurges(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(t.TempDir(), "sync-changes-test.db")
+
+	db, err := storage.Open(ctx, cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	if err := storage.Migrate(ctx, db); err != nil {
+		t.Fatalf("migrate db: %v", err)
+	}
+
+	store := New(db)
+	seqOf := func(id string) int64 {
+		t.Helper()
+		var seq int64
+		if err := db.QueryRowContext(ctx, "SELECT seq FROM sync_changes WHERE entity_type = 'topics' AND entity_id = ?", id).Scan(&seq); err != nil {
+			t.Fatalf("read seq for %s: %v", id, err)
+		}
+		return seq
+	}
+	upsert := func(topic model.Topic) {
+		t.Helper()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		if err := store.UpsertSyncedTopicTx(ctx, tx, topic); err != nil {
+			_ = tx.Rollback()
+			t.Fatalf("upsert topic: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+
+	topic := model.Topic{ID: newID(), Name: "first", CreatedBy: "peer-agent", CreatedAt: nowUTC()}
+	upsert(topic)
+	created := seqOf(topic.ID)
+
+	// An update arriving through ON CONFLICT must move the change forward.
+	topic.Name = "renamed"
+	upsert(topic)
+	if renamed := seqOf(topic.ID); renamed <= created {
+		t.Fatalf("expected rename seq above %d, got %d", created, renamed)
+	}
+
+	doomed := model.Topic{ID: newID(), Name: "doomed", CreatedBy: "peer-agent", CreatedAt: nowUTC()}
+	upsert(doomed)
+	if err := store.DeleteTopic(ctx, doomed.ID); err != nil {
+		t.Fatalf("delete topic: %v", err)
+	}
+	deleted := seqOf(doomed.ID)
+	if _, err := store.PurgeTombstones(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("purge tombstones: %v", err)
+	}
+
+	// Purging the newest tombstone must not hand its sequence number out again.
+	fresh := model.Topic{ID: newID(), Name: "fresh", CreatedBy: "peer-agent", CreatedAt: nowUTC()}
+	upsert(fresh)
+	if got := seqOf(fresh.ID); got <= deleted {
+		t.Fatalf("expected seq above purged %d, got %d", deleted, got)
+	}
+}
@@ -126,13 +126,67 @@ func (s *Store) DeleteTopic(ctx context.Context, id string) error {
 	return err
 }
 
+// Subscribe subscribes the agent to the topic. A nil filter keeps the filter
+// of an existing subscription; any other replaces it.
 func (s *Store) Subscribe(ctx context.Context, agentID, topicID string, filter map[string]any) error {
+	var raw sql.NullString
+	if filter != nil {
+		raw = sql.NullString{String: toJSON(filter), Valid: true}
+	}
 	_, err := s.DB.ExecContext(ctx, `
-INSERT OR IGNORE INTO subscriptions(agent_id, topic_id, filter, created_at)
-VALUES (?, ?, ?, ?)`, agentID, topicID, toJSON(filter), nowUTC().Format(timeFormat))
+INSERT INTO subscriptions(agent_id, topic_id, filter, created_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(agent_id, topic_id) DO UPDATE SET filter = COALESCE(excluded.filter, subscriptions.filter)`,
+		agentID, topicID, raw, nowUTC().Format(timeFormat))
 	return err
 }
 
+// TopicSubscriptions lists the subscriptions to a topic with their filters.
+func (s *Store) TopicSubscriptions(ctx context.Context, topicID string) ([]model.Subscription, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+SELECT agent_id, topic_id, filter, created_at
+FROM subscriptions WHERE topic_id = ?`, topicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sub)
+	}
+	return out, rows.Err()
+}
+
+// GetSubscription returns the agent's subscription to the topic.
+func (s *Store) GetSubscription(ctx context.Context, agentID, topicID string) (model.Subscription, error) {
+	row := s.DB.QueryRowContext(ctx, `
+SELECT agent_id, topic_id, filter, created_at
+FROM subscriptions WHERE agent_id = ? AND topic_id = ?`, agentID, topicID)
+	return scanSubscription(row)
+}
+
+func scanSubscription(scanner interface {
+	Scan(dest ...any) error
+}) (model.Subscription, error) {
+	var (
+		sub       model.Subscription
+		filter    sql.NullString
+		createdAt string
+	)
+	if err := scanner.Scan(&sub.AgentID, &sub.TopicID, &filter, &createdAt); err != nil {
+		return model.Subscription{}, err
+	}
+	if filter.Valid {
+		sub.Filter = fromJSON[map[string]any](filter.String)
+	}
+	sub.CreatedAt = parseTS(createdAt)
+	return sub, nil
+}
+
 func (s *Store) SubscribeAllAgents(ctx context.Context, topicID string) error {
 	_, err := s.DB.ExecContext(ctx, `
 INSERT OR IGNORE INTO subscriptions(agent_id, topic_id, filter, created_at)
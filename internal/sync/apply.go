@@ -0,0 +1,614 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"opencortex/internal/model"
+)
+
+// Entity is a manifest item together with the full body of the record it
+// describes. Exactly one of Collection, Knowledge, Topic or Message is set,
+// matching EntityType. BaseChecksum is the checksum both sides last agreed
+// on, used to find the common ancestor for a merge.
+type Entity struct {
+	ManifestItem
+	BaseChecksum string                `json:"base_checksum,omitempty"`
+	Collection   *model.Collection     `json:"collection,omitempty"`
+	Knowledge    *model.KnowledgeEntry `json:"knowledge,omitempty"`
+	Topic        *model.Topic          `json:"topic,omitempty"`
+	Message      *model.Message        `json:"message,omitempty"`
+}
+
+// ApplyConflict reports an incoming entity whose content differs from the
+// receiving node's copy. Resolution is the outcome picked by the conflict
+// strategy, from the receiver's point of view; manual means it is left open.
+// Existing carries the receiver's copy as it was before the apply.
+type ApplyConflict struct {
+	EntityType       string   `json:"entity_type"`
+	ID               string   `json:"id"`
+	ExistingChecksum string   `json:"existing_checksum"`
+	IncomingChecksum string   `json:"incoming_checksum"`
+	Resolution       Strategy `json:"resolution"`
+	Existing         *Entity  `json:"existing,omitempty"`
+}
+
+type ApplyResult struct {
+	Applied   int             `json:"applied"`
+	Deleted   int             `json:"deleted"`
+	Skipped   int             `json:"skipped"`
+	Conflicts []ApplyConflict `json:"conflicts"`
+	Resolved  []ApplyConflict `json:"resolved"`
+	// Batch acknowledges the pushed batch of that number.
+	Batch int `json:"batch,omitempty"`
+}
+
+// LoadEntities attaches the current local body to each manifest item. Items
+// whose record no longer exists are dropped.
+func (e *Engine) LoadEntities(ctx context.Context, items []ManifestItem) ([]Entity, error) {
+	out := make([]Entity, 0, len(items))
+	err := e.EachEntity(ctx, items, func(ent Entity) error {
+		out = append(out, ent)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EachEntity loads the manifest items one at a time and hands each to fn,
+// so a caller streaming them never holds every body at once. Items whose
+// record no longer exists are skipped.
+func (e *Engine) EachEntity(ctx context.Context, items []ManifestItem, fn func(Entity) error) error {
+	// Bodies go out with the version they are at here, not the one asked for.
+	items = slices.Clone(items)
+	for i := range items {
+		items[i].Origin, items[i].Vector = "", nil
+	}
+	if err := withVersions(ctx, e.DB, items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		ent := Entity{ManifestItem: item}
+		var err error
+		switch item.EntityType {
+		case "collections":
+			var c model.Collection
+			c, err = e.Store.GetCollection(ctx, item.ID)
+			ent.Collection = &c
+		case "knowledge":
+			var k model.KnowledgeEntry
+			k, err = e.Store.GetKnowledge(ctx, item.ID)
+			ent.Knowledge = &k
+		case "topics":
+			var t model.Topic
+			t, err = e.Store.GetTopicByID(ctx, item.ID)
+			ent.Topic = &t
+		case "messages":
+			var m model.Message
+			m, err = e.Store.GetMessageByID(ctx, item.ID)
+			ent.Message = &m
+		default:
+			return fmt.Errorf("unsupported entity type: %s", item.EntityType)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply upserts entities received from a peer in a single transaction.
+// Collections, parents first, and topics are written before knowledge and
+// messages so references resolve.
+// When an incoming entity differs from the local copy, strategy decides the
+// outcome with "local" meaning this node; only manual leaves the conflict open.
+// Messages new to this node are handed to Publish once committed.
+func (e *Engine) Apply(ctx context.Context, source string, strategy Strategy, entities []Entity) (ApplyResult, error) {
+	res := ApplyResult{Conflicts: []ApplyConflict{}, Resolved: []ApplyConflict{}}
+	ordered := make([]Entity, len(entities))
+	copy(ordered, entities)
+	depth := collectionDepths(entities)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, rj := applyRank(ordered[i].EntityType), applyRank(ordered[j].EntityType)
+		if ri != rj {
+			return ri < rj
+		}
+		if ordered[i].Collection != nil && ordered[j].Collection != nil {
+			return depth[ordered[i].ID] < depth[ordered[j].ID]
+		}
+		// Parents of reply chains must exist before their replies.
+		if ordered[i].Message != nil && ordered[j].Message != nil {
+			return ordered[i].Message.CreatedAt.Before(ordered[j].Message.CreatedAt)
+		}
+		return false
+	})
+
+	self, err := e.Store.NodeID(ctx)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	var received []model.Message
+	for _, ent := range ordered {
+		buried, err := e.buriedTx(ctx, tx, ent)
+		if err != nil {
+			_ = tx.Rollback()
+			return ApplyResult{}, err
+		}
+		if buried {
+			res.Skipped++
+			continue
+		}
+		local, found, err := localVersionTx(ctx, tx, self, ent)
+		if err != nil {
+			_ = tx.Rollback()
+			return ApplyResult{}, err
+		}
+		// A version already seen here, possibly by way of another peer, is
+		// skipped; one that includes the local copy replaces it outright.
+		order := Concurrent
+		if found && len(local.Vector) > 0 && len(ent.Vector) > 0 {
+			order = local.Vector.Compare(ent.Vector)
+		}
+		if order == After || order == Equal {
+			res.Skipped++
+			continue
+		}
+		use := strategy
+		if order == Before {
+			use = StrategyRemoteWins
+		}
+		applied, conflict, err := e.applyEntityTx(ctx, tx, source, use, ent)
+		if err != nil {
+			_ = tx.Rollback()
+			return ApplyResult{}, fmt.Errorf("apply %s %s: %w", ent.EntityType, ent.ID, err)
+		}
+		if order == Before && conflict != nil && conflict.Resolution == StrategyRemoteWins {
+			conflict = nil
+		}
+		// The local copy includes the incoming version only when it took
+		// its content; a copy kept or forked stays concurrent with it.
+		if len(ent.Vector) > 0 && (conflict == nil || conflict.Resolution == StrategyRemoteWins || conflict.Resolution == StrategyMerge) {
+			if err := e.recordVersionTx(ctx, tx, self, ent, local, found); err != nil {
+				_ = tx.Rollback()
+				return ApplyResult{}, err
+			}
+		}
+		if applied {
+			res.Applied++
+			if ent.Message != nil {
+				received = append(received, *ent.Message)
+			}
+		} else {
+			res.Skipped++
+		}
+		if conflict == nil {
+			continue
+		}
+		if conflict.Resolution == StrategyManual {
+			res.Conflicts = append(res.Conflicts, *conflict)
+		} else {
+			res.Resolved = append(res.Resolved, *conflict)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return ApplyResult{}, err
+	}
+	if e.Publish != nil {
+		for _, msg := range received {
+			e.Publish(ctx, msg)
+		}
+	}
+	return res, nil
+}
+
+// ApplyTombstones deletes entities a peer deleted, in one transaction.
+// Knowledge edited here after the deletion is kept, as is anything other
+// records still reference; both count as skipped.
+func (e *Engine) ApplyTombstones(ctx context.Context, tombstones []model.Tombstone) (deleted, skipped int, err error) {
+	ordered := make([]model.Tombstone, len(tombstones))
+	copy(ordered, tombstones)
+	// Dependents go first so a topic's messages are gone before the topic.
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return applyRank(ordered[i].EntityType) > applyRank(ordered[j].EntityType)
+	})
+
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, t := range ordered {
+		if t.EntityType == "knowledge" {
+			local, err := e.Store.GetKnowledgeTx(ctx, tx, t.EntityID)
+			if err == nil && local.UpdatedAt.After(t.DeletedAt) {
+				skipped++
+				continue
+			}
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				_ = tx.Rollback()
+				return 0, 0, err
+			}
+		}
+		ok, err := e.Store.ApplyTombstoneTx(ctx, tx, t)
+		if err != nil && strings.Contains(err.Error(), "FOREIGN KEY") {
+			skipped++
+			continue
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, 0, fmt.Errorf("apply tombstone %s %s: %w", t.EntityType, t.EntityID, err)
+		}
+		if ok {
+			deleted++
+		} else {
+			skipped++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return deleted, skipped, nil
+}
+
+// buriedTx reports whether ent was deleted here after the change it carries.
+// Only knowledge edited after its deletion comes back.
+func (e *Engine) buriedTx(ctx context.Context, tx *sql.Tx, ent Entity) (bool, error) {
+	t, err := e.Store.TombstoneTx(ctx, tx, ent.EntityType, ent.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if ent.Knowledge != nil && ent.Knowledge.UpdatedAt.After(t.DeletedAt) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (e *Engine) applyEntityTx(ctx context.Context, tx *sql.Tx, source string, strategy Strategy, ent Entity) (bool, *ApplyConflict, error) {
+	switch ent.EntityType {
+	case "collections":
+		if ent.Collection == nil {
+			return false, nil, nil
+		}
+		incoming := *ent.Collection
+		incoming.ID = ent.ID
+		local, err := e.Store.GetCollectionTx(ctx, tx, incoming.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil, e.Store.UpsertSyncedCollectionTx(ctx, tx, incoming)
+		}
+		if err != nil {
+			return false, nil, err
+		}
+		localChecksum := simpleChecksum(collectionText(local))
+		incomingChecksum := simpleChecksum(collectionText(incoming))
+		if localChecksum == incomingChecksum {
+			return false, nil, nil
+		}
+		conflict := &ApplyConflict{
+			EntityType:       ent.EntityType,
+			ID:               ent.ID,
+			ExistingChecksum: localChecksum,
+			IncomingChecksum: incomingChecksum,
+			Resolution: resolveOutcome(strategy, ConflictInput{
+				LocalUpdatedAt:  local.UpdatedAt,
+				RemoteUpdatedAt: incoming.UpdatedAt,
+			}),
+			Existing: &Entity{
+				ManifestItem: ManifestItem{EntityType: ent.EntityType, ID: local.ID, Checksum: localChecksum, UpdatedAt: local.UpdatedAt.Format(time.RFC3339Nano)},
+				Collection:   &local,
+			},
+		}
+		// A collection cannot be forked or merged field by field.
+		switch conflict.Resolution {
+		case StrategyFork:
+			conflict.Resolution = StrategyLocalWins
+		case StrategyMerge:
+			conflict.Resolution = StrategyManual
+		}
+		if conflict.Resolution != StrategyRemoteWins {
+			return false, conflict, nil
+		}
+		return true, conflict, e.Store.UpsertSyncedCollectionTx(ctx, tx, incoming)
+	case "topics":
+		if ent.Topic == nil {
+			return false, nil, nil
+		}
+		incoming := *ent.Topic
+		incoming.ID = ent.ID
+		byName, err := e.Store.GetTopicByNameTx(ctx, tx, incoming.Name)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, nil, err
+		}
+		if err == nil && byName.ID != incoming.ID {
+			// Another topic already owns the name; no strategy can apply this.
+			conflict := topicConflict(ent, byName)
+			conflict.Resolution = StrategyManual
+			return false, conflict, nil
+		}
+		local, err := e.Store.GetTopicTx(ctx, tx, incoming.ID)
+		switch {
+		case err == nil && local.Name == incoming.Name:
+			return false, nil, nil
+		case err == nil:
+			conflict := topicConflict(ent, local)
+			// Topics carry no modification time and cannot be forked.
+			conflict.Resolution = resolveOutcome(strategy, ConflictInput{})
+			if conflict.Resolution == StrategyFork {
+				conflict.Resolution = StrategyLocalWins
+			}
+			if conflict.Resolution != StrategyRemoteWins {
+				return false, conflict, nil
+			}
+			return true, conflict, e.Store.UpsertSyncedTopicTx(ctx, tx, incoming)
+		case !errors.Is(err, sql.ErrNoRows):
+			return false, nil, err
+		}
+		return true, nil, e.Store.UpsertSyncedTopicTx(ctx, tx, incoming)
+	case "knowledge":
+		if ent.Knowledge == nil {
+			return false, nil, nil
+		}
+		incoming := *ent.Knowledge
+		incoming.ID = ent.ID
+		local, err := e.Store.GetKnowledgeTx(ctx, tx, incoming.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil, e.Store.UpsertSyncedKnowledgeTx(ctx, tx, incoming, "synced from "+source)
+		}
+		if err != nil {
+			return false, nil, err
+		}
+		incomingChecksum := simpleChecksum(incoming.Content)
+		if local.Checksum == incomingChecksum {
+			return false, nil, nil
+		}
+		conflict := &ApplyConflict{
+			EntityType:       ent.EntityType,
+			ID:               ent.ID,
+			ExistingChecksum: local.Checksum,
+			IncomingChecksum: incomingChecksum,
+			Resolution: resolveOutcome(strategy, ConflictInput{
+				LocalUpdatedAt:  local.UpdatedAt,
+				RemoteUpdatedAt: incoming.UpdatedAt,
+			}),
+			Existing: &Entity{
+				ManifestItem: ManifestItem{EntityType: ent.EntityType, ID: local.ID, Checksum: local.Checksum, UpdatedAt: local.UpdatedAt.Format(time.RFC3339Nano)},
+				Knowledge:    &local,
+			},
+		}
+		switch conflict.Resolution {
+		case StrategyRemoteWins:
+			return true, conflict, e.Store.UpsertSyncedKnowledgeTx(ctx, tx, incoming, "synced from "+source)
+		case StrategyFork:
+			applied, err := e.forkKnowledgeTx(ctx, tx, source, incoming, incomingChecksum)
+			return applied, conflict, err
+		case StrategyMerge:
+			merged, ok, err := e.mergeKnowledgeTx(ctx, tx, local, incoming.Content, ent.BaseChecksum)
+			if err != nil || !ok {
+				conflict.Resolution = StrategyManual
+				return false, conflict, err
+			}
+			incoming.Content = merged
+			incoming.UpdatedAt = time.Now().UTC()
+			return true, conflict, e.Store.UpsertSyncedKnowledgeTx(ctx, tx, incoming, "merged with changes synced from "+source)
+		default:
+			return false, conflict, nil
+		}
+	case "messages":
+		if ent.Message == nil {
+			return false, nil, nil
+		}
+		incoming := *ent.Message
+		incoming.ID = ent.ID
+		applied, err := e.Store.InsertSyncedMessageTx(ctx, tx, incoming)
+		return applied, nil, err
+	default:
+		return false, nil, fmt.Errorf("unsupported entity type: %s", ent.EntityType)
+	}
+}
+
+// forkKnowledgeTx keeps the local entry and stores the incoming copy next to
+// it as a new entry. The sibling id is derived from the original id and the
+// incoming checksum so repeated syncs of the same divergence fork only once.
+func (e *Engine) forkKnowledgeTx(ctx context.Context, tx *sql.Tx, source string, incoming model.KnowledgeEntry, incomingChecksum string) (bool, error) {
+	forkID := uuid.NewSHA1(uuid.NameSpaceOID, []byte(incoming.ID+":"+incomingChecksum)).String()
+	if _, err := e.Store.GetKnowledgeTx(ctx, tx, forkID); err == nil {
+		return false, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	metadata := make(map[string]any, len(incoming.Metadata)+1)
+	for k, v := range incoming.Metadata {
+		metadata[k] = v
+	}
+	metadata["forked_from"] = incoming.ID
+	fork := incoming
+	fork.ID = forkID
+	fork.Title = fmt.Sprintf("%s (fork from %s)", incoming.Title, source)
+	fork.Metadata = metadata
+	fork.Version = 1
+	return true, e.Store.UpsertSyncedKnowledgeTx(ctx, tx, fork, "forked from "+incoming.ID+" during sync from "+source)
+}
+
+// mergeKnowledgeTx three-way merges incoming content into the local entry.
+// The common ancestor is the local version whose content has baseChecksum;
+// without one, or when both sides changed the same lines, ok is false.
+func (e *Engine) mergeKnowledgeTx(ctx context.Context, tx *sql.Tx, local model.KnowledgeEntry, incoming, baseChecksum string) (string, bool, error) {
+	if baseChecksum == "" {
+		return "", false, nil
+	}
+	base, err := e.Store.KnowledgeVersionByChecksumTx(ctx, tx, local.ID, baseChecksum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	merged, ok := Merge3(base.Content, local.Content, incoming)
+	return merged, ok, nil
+}
+
+// agreedChecksums lists the knowledge checksums both sides hold after an
+// apply: entities applied without dispute or settled in the incoming copy's
+// favour.
+func agreedChecksums(entities []Entity, res ApplyResult) map[string]string {
+	disputed := map[string]bool{}
+	for _, c := range res.Conflicts {
+		disputed[c.EntityType+":"+c.ID] = true
+	}
+	for _, c := range res.Resolved {
+		if c.Resolution != StrategyRemoteWins {
+			disputed[c.EntityType+":"+c.ID] = true
+		}
+	}
+	out := map[string]string{}
+	for i := range entities {
+		ent := &entities[i]
+		if ent.EntityType != "knowledge" || ent.Knowledge == nil || disputed[ent.EntityType+":"+ent.ID] {
+			continue
+		}
+		out[ent.ID] = simpleChecksum(entityText(ent))
+	}
+	return out
+}
+
+// unchangedChecksums lists local knowledge items the diff found identical on
+// both sides.
+func unchangedChecksums(local []ManifestItem, need, have []ManifestItem) map[string]string {
+	differs := map[string]bool{}
+	for _, it := range need {
+		differs[it.EntityType+":"+it.ID] = true
+	}
+	for _, it := range have {
+		differs[it.EntityType+":"+it.ID] = true
+	}
+	out := map[string]string{}
+	for _, it := range local {
+		if it.EntityType == "knowledge" && !differs[it.EntityType+":"+it.ID] {
+			out[it.ID] = it.Checksum
+		}
+	}
+	return out
+}
+
+// withBaseChecksums stamps knowledge entities with the checksum last agreed
+// with the remote.
+func withBaseChecksums(entities []Entity, agreed map[string]string) {
+	for i := range entities {
+		if entities[i].EntityType == "knowledge" {
+			entities[i].BaseChecksum = agreed[entities[i].ID]
+		}
+	}
+}
+
+func topicConflict(ent Entity, local model.Topic) *ApplyConflict {
+	return &ApplyConflict{
+		EntityType:       ent.EntityType,
+		ID:               ent.ID,
+		ExistingChecksum: simpleChecksum(local.Name),
+		IncomingChecksum: simpleChecksum(ent.Topic.Name),
+		Existing: &Entity{
+			ManifestItem: ManifestItem{EntityType: ent.EntityType, ID: local.ID, Checksum: simpleChecksum(local.Name), UpdatedAt: local.CreatedAt.Format(time.RFC3339Nano)},
+			Topic:        &local,
+		},
+	}
+}
+
+// entityPayload flattens the body of an entity for storage on a conflict.
+func entityPayload(ent *Entity) map[string]any {
+	if ent == nil {
+		return nil
+	}
+	var body any
+	switch {
+	case ent.Collection != nil:
+		body = ent.Collection
+	case ent.Knowledge != nil:
+		body = ent.Knowledge
+	case ent.Topic != nil:
+		body = ent.Topic
+	case ent.Message != nil:
+		body = ent.Message
+	default:
+		return nil
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil
+	}
+	var out map[string]any
+	_ = json.Unmarshal(raw, &out)
+	return out
+}
+
+// entityText is the part of an entity that its checksum covers.
+func entityText(ent *Entity) string {
+	switch {
+	case ent == nil:
+		return ""
+	case ent.Collection != nil:
+		return collectionText(*ent.Collection)
+	case ent.Knowledge != nil:
+		return ent.Knowledge.Content
+	case ent.Topic != nil:
+		return ent.Topic.Name
+	case ent.Message != nil:
+		return ent.Message.Content
+	default:
+		return ""
+	}
+}
+
+func applyRank(entityType string) int {
+	switch entityType {
+	case "collections":
+		return 0
+	case "topics":
+		return 1
+	case "knowledge":
+		return 2
+	default:
+		return 3
+	}
+}
+
+// collectionDepths returns how many ancestors each incoming collection has
+// within the batch, so parents can be applied before their children.
+func collectionDepths(entities []Entity) map[string]int {
+	parents := map[string]string{}
+	for _, ent := range entities {
+		if ent.Collection != nil && ent.Collection.ParentID != nil {
+			parents[ent.ID] = *ent.Collection.ParentID
+		}
+	}
+	depth := make(map[string]int, len(parents))
+	for id := range parents {
+		d := 0
+		for cur, ok := parents[id]; ok && d <= len(parents); cur, ok = parents[cur] {
+			d++
+		}
+		depth[id] = d
+	}
+	return depth
+}
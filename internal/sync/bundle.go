@@ -0,0 +1,314 @@
+package syncer
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"opencortex/internal/model"
+	"opencortex/internal/storage/repos"
+)
+
+// BundleFormat identifies an offline sync bundle. A bundle is a signed,
+// gzip-compressed stream, written with StreamWriter, holding everything a
+// pull would fetch from the exporting node so machines without a network
+// path to it can sync by file.
+const BundleFormat = "opencortex-sync-bundle/1"
+
+// bundleRemotePrefix marks the remote URL of a remote fed only by bundles.
+const bundleRemotePrefix = "bundle:"
+
+// Bundle is the content of a bundle file. Manifest lists every entity in
+// scope as on the exporting node; Entities carries their bodies and History
+// the earlier versions of the knowledge among them.
+type Bundle struct {
+	Format        string                   `json:"format"`
+	NodeID        string                   `json:"node_id"`
+	KeyID         string                   `json:"key_id,omitempty"`
+	Scope         string                   `json:"scope"`
+	CollectionIDs []string                 `json:"collection_ids,omitempty"`
+	TopicIDs      []string                 `json:"topic_ids,omitempty"`
+	CreatedAt     time.Time                `json:"created_at"`
+	Manifest      []ManifestItem           `json:"manifest"`
+	Entities      []Entity                 `json:"entities"`
+	History       []model.KnowledgeVersion `json:"history"`
+	Tombstones    []model.Tombstone        `json:"tombstones"`
+}
+
+// ImportOptions controls how a bundle is imported. Remote names the remote
+// the import is recorded against; when empty, a remote for the exporting
+// node is used, created on its first import.
+type ImportOptions struct {
+	Remote           string
+	RequireSignature bool
+}
+
+// IsBundleRemote reports whether a remote is fed by bundles rather than
+// reached over the network.
+func IsBundleRemote(manifest model.SyncManifest) bool {
+	return strings.HasPrefix(manifest.RemoteURL, bundleRemotePrefix)
+}
+
+// ExportBundle writes a bundle of everything in scope to w, signed with the
+// node's identity when it has one. Bodies are loaded one at a time. A failure
+// part way ends the file with an error frame so it is never imported as whole.
+func (e *Engine) ExportBundle(ctx context.Context, w io.Writer, scope model.SyncScope, sel Selection) error {
+	if scope == "" {
+		scope = model.SyncScopeFull
+	}
+	nodeID, err := e.Store.NodeID(ctx)
+	if err != nil {
+		return err
+	}
+	items, err := BuildManifest(ctx, e.DB, scope, sel)
+	if err != nil {
+		return err
+	}
+	tombstones, err := e.Store.ListTombstones(ctx, 0, ScopeEntityTypes(scope, sel))
+	if err != nil {
+		return err
+	}
+	head := Bundle{Format: BundleFormat, NodeID: nodeID, Scope: string(scope), CreatedAt: time.Now().UTC()}
+	stream := NewStreamWriter(w, true)
+	if e.Identity != nil {
+		id, err := e.Identity(ctx)
+		if err != nil {
+			return err
+		}
+		head.KeyID = id.KeyID()
+		stream.Sign(id, "bundle")
+	}
+	err = e.writeBundle(ctx, stream, head, sel, items, tombstones)
+	if err != nil {
+		_ = stream.Fail(err.Error())
+		return err
+	}
+	return stream.Close()
+}
+
+func (e *Engine) writeBundle(ctx context.Context, stream *StreamWriter, head Bundle, sel Selection, items []ManifestItem, tombstones []model.Tombstone) error {
+	if err := stream.Head(head); err != nil {
+		return err
+	}
+	for _, id := range sel.CollectionIDs {
+		if err := stream.Elem("collection_ids", id); err != nil {
+			return err
+		}
+	}
+	for _, id := range sel.TopicIDs {
+		if err := stream.Elem("topic_ids", id); err != nil {
+			return err
+		}
+	}
+	for _, item := range items {
+		if err := stream.Elem("manifest", item); err != nil {
+			return err
+		}
+	}
+	err := e.EachEntity(ctx, items, func(ent Entity) error {
+		if err := stream.Elem("entities", ent); err != nil {
+			return err
+		}
+		if ent.Knowledge == nil {
+			return nil
+		}
+		versions, err := e.Store.KnowledgeHistory(ctx, ent.ID)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if err := stream.Elem("history", v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range tombstones {
+		if err := stream.Elem("tombstones", t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadBundle decodes a bundle file. The returned Signed is checked against
+// the key pinned for the exporting node.
+func ReadBundle(r io.Reader) (Bundle, Signed, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return Bundle{}, Signed{}, fmt.Errorf("not a sync bundle: %w", err)
+	}
+	defer zr.Close()
+	var b Bundle
+	signed, err := ReadStream(zr, &b)
+	if err != nil {
+		return Bundle{}, Signed{}, fmt.Errorf("read bundle: %w", err)
+	}
+	if b.Format != BundleFormat {
+		return Bundle{}, Signed{}, fmt.Errorf("unsupported bundle format %q", b.Format)
+	}
+	signed.KeyID = b.KeyID
+	return b, signed, nil
+}
+
+// ImportBundle applies a bundle the way a pull from the exporting node would:
+// its deletions first, then the entities it holds that this node lacks or
+// holds differently, settled by the remote's conflict strategy and recorded
+// as sync conflicts against it. Knowledge new to this node also gets its
+// earlier versions.
+func (e *Engine) ImportBundle(ctx context.Context, r io.Reader, opts ImportOptions) (model.SyncLog, error) {
+	b, signed, err := ReadBundle(r)
+	if err != nil {
+		return model.SyncLog{}, err
+	}
+	self, err := e.Store.NodeID(ctx)
+	if err != nil {
+		return model.SyncLog{}, err
+	}
+	if b.NodeID == self {
+		return model.SyncLog{}, errors.New("bundle was exported by this node")
+	}
+	manifest, err := e.bundleRemote(ctx, opts.Remote, b.NodeID)
+	if err != nil {
+		return model.SyncLog{}, err
+	}
+	log, err := e.Store.CreateSyncLog(ctx, manifest.ID, model.SyncDirectionPull)
+	if err != nil {
+		return model.SyncLog{}, err
+	}
+	pulled, conflicts := 0, 0
+	failed := func(err error) (model.SyncLog, error) {
+		e.auditRejection(ctx, manifest, err)
+		msg := err.Error()
+		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, pulled, conflicts, &msg)
+		_ = e.Store.UpdateManifestSyncResult(ctx, manifest.ID, false)
+		return model.SyncLog{}, err
+	}
+	switch {
+	case manifest.PeerPublicKey != "":
+		err = VerifyPayload(manifest.PeerPublicKey, "bundle", signed)
+	case opts.RequireSignature:
+		err = fmt.Errorf("%w: remote %s pins no key to check the bundle against", ErrBadSignature, manifest.RemoteName)
+	}
+	if err != nil {
+		return failed(err)
+	}
+
+	if pulled, _, err = e.ApplyTombstones(ctx, b.Tombstones); err != nil {
+		return failed(err)
+	}
+	scope := model.SyncScope(b.Scope)
+	_, have, err := e.Diff(ctx, scope, Selection{CollectionIDs: b.CollectionIDs, TopicIDs: b.TopicIDs}, b.Manifest)
+	if err != nil {
+		return failed(err)
+	}
+	local, err := LookupManifest(ctx, e.DB, have)
+	if err != nil {
+		return failed(err)
+	}
+	wanted := make(map[string]bool, len(have))
+	for _, it := range have {
+		wanted[it.EntityType+":"+it.ID] = true
+	}
+	var entities []Entity
+	fresh := map[string]bool{}
+	for _, ent := range b.Entities {
+		k := ent.EntityType + ":" + ent.ID
+		if !wanted[k] {
+			continue
+		}
+		entities = append(entities, ent)
+		if _, ok := local[k]; !ok && ent.Knowledge != nil {
+			fresh[ent.ID] = true
+		}
+	}
+
+	agreed, err := e.Store.SyncedChecksums(ctx, manifest.ID, "knowledge")
+	if err != nil {
+		return failed(err)
+	}
+	withBaseChecksums(entities, agreed)
+	applyRes, err := e.Apply(ctx, "bundle from "+manifest.RemoteName, Strategy(manifest.Strategy), entities)
+	if err != nil {
+		return failed(err)
+	}
+	e.recordConflicts(ctx, manifest.ID, manifest.Strategy, model.SyncDirectionPull, applyRes, entities)
+	e.recordAgreed(ctx, manifest.ID, agreedChecksums(entities, applyRes))
+	pulled += applyRes.Applied
+	conflicts += len(applyRes.Conflicts)
+
+	var history []model.KnowledgeVersion
+	for _, v := range b.History {
+		if fresh[v.KnowledgeID] {
+			history = append(history, v)
+		}
+	}
+	if err := e.Store.ImportKnowledgeVersions(ctx, history); err != nil {
+		return failed(err)
+	}
+	return e.finish(ctx, manifest, log.ID, repos.SyncRun{}, 0, pulled, conflicts)
+}
+
+// bundleRemote returns the remote a bundle from nodeID is imported against:
+// the named one, or the one kept for the node's bundles.
+func (e *Engine) bundleRemote(ctx context.Context, name, nodeID string) (model.SyncManifest, error) {
+	if name != "" {
+		manifest, err := e.Store.GetRemote(ctx, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.SyncManifest{}, fmt.Errorf("remote %s not found", name)
+		}
+		return manifest, err
+	}
+	name = "bundle-" + nodeID[:min(len(nodeID), 12)]
+	manifest, err := e.Store.GetRemote(ctx, name)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return manifest, err
+	}
+	return e.Store.CreateRemote(ctx, repos.CreateRemoteInput{
+		ID:         uuid.NewString(),
+		RemoteURL:  bundleRemotePrefix + nodeID,
+		RemoteName: name,
+		Direction:  model.SyncDirectionPull,
+	})
+}
+
+// storedEntity rebuilds an entity from the payload kept on a conflict, for
+// remotes whose copy cannot be fetched again.
+func storedEntity(entityType, id string, payload map[string]any) (*Entity, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	ent := &Entity{ManifestItem: ManifestItem{EntityType: entityType, ID: id}}
+	switch entityType {
+	case "collections":
+		err = json.Unmarshal(raw, &ent.Collection)
+	case "knowledge":
+		err = json.Unmarshal(raw, &ent.Knowledge)
+	case "topics":
+		err = json.Unmarshal(raw, &ent.Topic)
+	case "messages":
+		err = json.Unmarshal(raw, &ent.Message)
+	default:
+		return nil, fmt.Errorf("unsupported entity type: %s", entityType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ent.Checksum = simpleChecksum(entityText(ent))
+	return ent, nil
+}
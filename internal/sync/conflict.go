@@ -10,6 +10,7 @@ const (
 	StrategyLatestWins Strategy = "latest-wins"
 	StrategyManual     Strategy = "manual"
 	StrategyFork       Strategy = "fork"
+	StrategyMerge      Strategy = "merge"
 )
 
 type ConflictInput struct {
@@ -19,7 +20,7 @@ type ConflictInput struct {
 
 func ResolveStrategy(strategy Strategy, in ConflictInput) Strategy {
 	switch strategy {
-	case StrategyLocalWins, StrategyRemoteWins, StrategyManual, StrategyFork:
+	case StrategyLocalWins, StrategyRemoteWins, StrategyManual, StrategyFork, StrategyMerge:
 		return strategy
 	case StrategyLatestWins:
 		if in.LocalUpdatedAt.After(in.RemoteUpdatedAt) || in.LocalUpdatedAt.Equal(in.RemoteUpdatedAt) {
@@ -30,3 +31,26 @@ func ResolveStrategy(strategy Strategy, in ConflictInput) Strategy {
 		return StrategyLatestWins
 	}
 }
+
+// Mirror returns the strategy as seen from the other side of a sync, where
+// local and remote are swapped.
+func (s Strategy) Mirror() Strategy {
+	switch s {
+	case StrategyLocalWins:
+		return StrategyRemoteWins
+	case StrategyRemoteWins:
+		return StrategyLocalWins
+	default:
+		return s
+	}
+}
+
+// resolveOutcome narrows a configured strategy to the action to take for one
+// conflict: local-wins, remote-wins, fork, merge or manual.
+func resolveOutcome(strategy Strategy, in ConflictInput) Strategy {
+	got := ResolveStrategy(strategy, in)
+	if got == StrategyLatestWins {
+		got = ResolveStrategy(StrategyLatestWins, in)
+	}
+	return got
+}
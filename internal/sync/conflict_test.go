@@ -46,7 +46,24 @@ func TestResolveStrategyExplicitAndDefault(t *testing.T) {
 	if got := ResolveStrategy(StrategyFork, in); got != StrategyFork {
 		t.Fatalf("expected fork passthrough, got %s", got)
 	}
+	if got := ResolveStrategy(StrategyMerge, in); got != StrategyMerge {
+		t.Fatalf("expected merge passthrough, got %s", got)
+	}
 	if got := ResolveStrategy(Strategy("unknown"), in); got != StrategyLatestWins {
 		t.Fatalf("expected default latest-wins, got %s", got)
 	}
 }
+
+func TestStrategyMirror(t *testing.T) {
+	if got := StrategyLocalWins.Mirror(); got != StrategyRemoteWins {
+		t.Fatalf("expected remote-wins, got %s", got)
+	}
+	if got := StrategyRemoteWins.Mirror(); got != StrategyLocalWins {
+		t.Fatalf("expected local-wins, got %s", got)
+	}
+	for _, s := range []Strategy{StrategyLatestWins, StrategyManual, StrategyFork, StrategyMerge} {
+		if got := s.Mirror(); got != s {
+			t.Fatalf("expected %s to be symmetric, got %s", s, got)
+		}
+	}
+}
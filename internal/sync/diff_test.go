@@ -0,0 +1,60 @@
+package syncer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "# Title\n\nalpha\nbeta\ngamma\n"
+	to := "# Title\n\nalpha\nBETA\ngamma\ndelta\n"
+	got := UnifiedDiff("local", "remote", from, to)
+	want := strings.Join([]string{
+		"--- local",
+		"+++ remote",
+		"@@ -1,5 +1,6 @@",
+		" # Title",
+		" ",
+		" alpha",
+		"-beta",
+		"+BETA",
+		" gamma",
+		"+delta",
+		"",
+	}, "\n")
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+	if UnifiedDiff("a", "b", from, from) != "" {
+		t.Fatalf("expected empty diff for equal inputs")
+	}
+}
+
+func TestUnifiedDiffSplitsDistantHunks(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 20; i++ {
+		line := strings.Repeat("x", i+1)
+		a = append(a, line)
+		b = append(b, line)
+	}
+	b[1] = "changed-top"
+	b[18] = "changed-bottom"
+	got := UnifiedDiff("a", "b", strings.Join(a, "\n"), strings.Join(b, "\n"))
+	if n := strings.Count(got, "@@ -"); n != 2 {
+		t.Fatalf("expected two hunks, got %d:\n%s", n, got)
+	}
+	if !strings.Contains(got, "@@ -1,5 +1,5 @@") || !strings.Contains(got, "@@ -16,5 +16,5 @@") {
+		t.Fatalf("unexpected hunk headers:\n%s", got)
+	}
+}
+
+func TestDiffLinesEmptySides(t *testing.T) {
+	ops := diffLines(nil, []string{"a", "b"})
+	if len(ops) != 2 || ops[0].Kind != diffInsert || ops[1].Kind != diffInsert {
+		t.Fatalf("expected two inserts, got %+v", ops)
+	}
+	ops = diffLines([]string{"a"}, nil)
+	if len(ops) != 1 || ops[0].Kind != diffDelete {
+		t.Fatalf("expected one delete, got %+v", ops)
+	}
+}
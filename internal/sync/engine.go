@@ -7,7 +7,11 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"opencortex/internal/auth"
 	"opencortex/internal/model"
 	"opencortex/internal/storage/repos"
 )
@@ -16,6 +20,22 @@ type Engine struct {
 	DB        *sql.DB
 	Store     *repos.Store
 	Transport *Transport
+	// BatchSize bounds the entities exchanged per request.
+	BatchSize int
+	// Backoff spaces out retries of a remote whose syncs keep failing.
+	Backoff RetryPolicy
+	// RemoteKey looks up the stored API key for a remote when a push or pull
+	// is not given one.
+	RemoteKey func(ctx context.Context, remoteName string) (string, error)
+	// Identity returns the key this node signs its requests with. Requests
+	// go unsigned when it is nil.
+	Identity func(ctx context.Context) (auth.Identity, error)
+	// Changes subscribes to local writes worth forwarding at once over live
+	// links; the returned func unsubscribes. Live links only receive while it
+	// is nil.
+	Changes func() (<-chan struct{}, func())
+	// Publish hands messages newly received from a peer to local listeners.
+	Publish func(ctx context.Context, msg model.Message)
 }
 
 func NewEngine(db *sql.DB, store *repos.Store) *Engine {
@@ -23,11 +43,13 @@ func NewEngine(db *sql.DB, store *repos.Store) *Engine {
 		DB:        db,
 		Store:     store,
 		Transport: NewTransport(),
+		BatchSize: 200,
+		Backoff:   RetryPolicy{Base: 30 * time.Second, Max: time.Hour},
 	}
 }
 
-func (e *Engine) Diff(ctx context.Context, scope model.SyncScope, scopeIDs []string, remoteItems []ManifestItem) (need []ManifestItem, have []ManifestItem, err error) {
-	local, err := BuildManifest(ctx, e.DB, scope, scopeIDs)
+func (e *Engine) Diff(ctx context.Context, scope model.SyncScope, sel Selection, remoteItems []ManifestItem) (need []ManifestItem, have []ManifestItem, err error) {
+	local, err := BuildManifest(ctx, e.DB, scope, sel)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -47,7 +69,16 @@ func (e *Engine) Diff(ctx context.Context, scope model.SyncScope, scopeIDs []str
 			need = append(need, l)
 			continue
 		}
-		if r.Checksum != l.Checksum {
+		if r.Checksum == l.Checksum {
+			continue
+		}
+		// A copy that already includes the other is only sent one way.
+		switch versionOrder(l, r) {
+		case After:
+			need = append(need, l)
+		case Before:
+			have = append(have, r)
+		default:
 			need = append(need, l)
 			have = append(have, r)
 		}
@@ -57,10 +88,163 @@ func (e *Engine) Diff(ctx context.Context, scope model.SyncScope, scopeIDs []str
 			have = append(have, r)
 		}
 	}
+	have, err = e.withoutBuried(ctx, have)
+	if err != nil {
+		return nil, nil, err
+	}
+	return need, have, nil
+}
+
+// withoutBuried drops items this node deleted after the change they carry,
+// so a peer is not asked to send them back.
+func (e *Engine) withoutBuried(ctx context.Context, items []ManifestItem) ([]ManifestItem, error) {
+	if len(items) == 0 {
+		return items, nil
+	}
+	buried, err := buriedItems(ctx, e.DB, items)
+	if err != nil {
+		return nil, err
+	}
+	out := items[:0:0]
+	for _, it := range items {
+		if !buried[it.EntityType+":"+it.ID] {
+			out = append(out, it)
+		}
+	}
+	return out, nil
+}
+
+// SyncOptions selects what a push or pull covers. Unless Force is set, only
+// entities changed since the remote's last successful sync are exchanged.
+// An empty scope and selection fall back to the remote's configuration.
+type SyncOptions struct {
+	Scope model.SyncScope
+	Selection
+	Force bool
+}
+
+// withDefaults fills an unset scope and selection from the remote's manifest.
+// The remote's filter always applies.
+func (o SyncOptions) withDefaults(manifest model.SyncManifest) SyncOptions {
+	o.Filter = manifest.Filter
+	if o.Scope == "" {
+		o.Scope = manifest.Scope
+	}
+	if len(o.CollectionIDs) == 0 && len(o.TopicIDs) == 0 {
+		o.CollectionIDs = manifest.ScopeIDs
+		o.TopicIDs = manifest.TopicIDs
+		// Remotes added before topic ids were stored kept them in scope_ids.
+		if o.Scope == model.SyncScopeTopics && len(o.TopicIDs) == 0 {
+			o.CollectionIDs, o.TopicIDs = nil, o.CollectionIDs
+		}
+	}
+	return o
+}
+
+// scopeKey identifies the scope a cursor was taken for, so cursors are not
+// reused after the scope, its ids or the filter change.
+func (o SyncOptions) scopeKey() string {
+	key := string(o.Scope) + ":" + sortedJoin(o.CollectionIDs)
+	if len(o.TopicIDs) > 0 {
+		key += "|" + sortedJoin(o.TopicIDs)
+	}
+	if f := filterKey(o.Filter); f != "" {
+		key += "#" + f
+	}
+	return key
+}
+
+func sortedJoin(ids []string) string {
+	ids = append([]string(nil), ids...)
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// cursors returns the push and pull cursors that apply to opts.
+func (o SyncOptions) cursors(manifest model.SyncManifest) (push, pull int64) {
+	if o.Force || manifest.CursorScope != o.scopeKey() {
+		return 0, 0
+	}
+	return manifest.PushCursor, manifest.PullCursor
+}
+
+// DiffSince compares a caller's partial manifest against local state. Only
+// the given items are checked for "have", and "need" lists the local changes
+// after since that the caller does not already hold.
+func (e *Engine) DiffSince(ctx context.Context, scope model.SyncScope, sel Selection, remoteItems []ManifestItem, since int64) (need []ManifestItem, have []ManifestItem, err error) {
+	changed, err := BuildManifestSince(ctx, e.DB, scope, sel, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	local, err := LookupManifest(ctx, e.DB, remoteItems)
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteMap := make(map[string]ManifestItem, len(remoteItems))
+	for _, r := range remoteItems {
+		k := r.EntityType + ":" + r.ID
+		remoteMap[k] = r
+		l, ok := local[k]
+		if !ok || l.Checksum != r.Checksum && !descends(l, r) {
+			have = append(have, r)
+		}
+	}
+	for _, l := range changed {
+		if r, ok := remoteMap[l.EntityType+":"+l.ID]; ok && (r.Checksum == l.Checksum || descends(r, l)) {
+			continue
+		}
+		need = append(need, l)
+	}
+	have, err = e.withoutBuried(ctx, have)
+	if err != nil {
+		return nil, nil, err
+	}
 	return need, have, nil
 }
 
-func (e *Engine) Push(ctx context.Context, remoteName string, apiKey string, scope model.SyncScope, scopeIDs []string) (model.SyncLog, error) {
+// diff asks the remote what to exchange. Once the remote has handed out a
+// pull cursor the exchange is incremental; a forced or first sync compares
+// full manifests, which peers without cursor support also understand.
+func (e *Engine) diff(ctx context.Context, manifest model.SyncManifest, peer Peer, opts SyncOptions, items []ManifestItem) (DiffResponse, error) {
+	req := DiffRequest{
+		Scope:         string(opts.Scope),
+		CollectionIDs: opts.CollectionIDs,
+		TopicIDs:      opts.TopicIDs,
+		Items:         items,
+	}
+	push, pull := opts.cursors(manifest)
+	if push > 0 || pull > 0 {
+		req.Since = &pull
+	}
+	return e.Transport.Diff(ctx, peer, req)
+}
+
+// apiKey returns the given key, falling back to the one stored for the remote.
+func (e *Engine) apiKey(ctx context.Context, remoteName, given string) (string, error) {
+	if given != "" || e.RemoteKey == nil {
+		return given, nil
+	}
+	return e.RemoteKey(ctx, remoteName)
+}
+
+// peer describes the remote for the transport: where it is, the key to
+// present, the key its answers must be signed with and ours to sign with.
+func (e *Engine) peer(ctx context.Context, manifest model.SyncManifest, apiKey string) (Peer, error) {
+	if IsBundleRemote(manifest) {
+		return Peer{}, fmt.Errorf("remote %s is synced by bundle files; use sync import", manifest.RemoteName)
+	}
+	peer := Peer{URL: manifest.RemoteURL, APIKey: apiKey, PublicKey: manifest.PeerPublicKey}
+	if e.Identity != nil {
+		id, err := e.Identity(ctx)
+		if err != nil {
+			return Peer{}, err
+		}
+		peer.Identity = &id
+	}
+	return peer, nil
+}
+
+func (e *Engine) Push(ctx context.Context, remoteName string, apiKey string, opts SyncOptions) (model.SyncLog, error) {
 	manifest, _, strategy, err := e.Store.GetRemoteWithAuth(ctx, remoteName)
 	if err != nil {
 		return model.SyncLog{}, err
@@ -69,54 +253,98 @@ func (e *Engine) Push(ctx context.Context, remoteName string, apiKey string, sco
 	if err != nil {
 		return model.SyncLog{}, err
 	}
-
-	items, err := BuildManifest(ctx, e.DB, scope, scopeIDs)
+	opts = opts.withDefaults(manifest)
+	key, err := e.apiKey(ctx, remoteName, apiKey)
+	if err == nil && key == "" {
+		err = errors.New("remote api key is required for push")
+	}
 	if err != nil {
-		msg := err.Error()
-		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, 0, 0, &msg)
-		return model.SyncLog{}, err
+		return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
 	}
-	key := apiKey
-	if key == "" {
-		msg := "remote api key is required for push"
-		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, 0, 0, &msg)
-		return model.SyncLog{}, errors.New(msg)
+	peer, err := e.peer(ctx, manifest, key)
+	if err != nil {
+		return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
 	}
-	diffRes, err := e.Transport.Diff(ctx, manifest.RemoteURL, key, DiffRequest{
-		Scope: string(scope),
-		Items: items,
-	})
+
+	run, plan, err := e.resumeRun(ctx, manifest, model.SyncDirectionPush, opts)
 	if err != nil {
-		msg := err.Error()
-		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, 0, 0, &msg)
-		return model.SyncLog{}, err
+		return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+	}
+	if run.StartedAt.IsZero() {
+		since, _ := opts.cursors(manifest)
+		high, err := ChangeCursor(ctx, e.DB)
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+		}
+		items, err := BuildManifestSince(ctx, e.DB, opts.Scope, opts.Selection, since)
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+		}
+		tombstones, err := e.Store.ListTombstones(ctx, since, ScopeEntityTypes(opts.Scope, opts.Selection))
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+		}
+		diffRes, err := e.diff(ctx, manifest, peer, opts, items)
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+		}
+		e.notePeer(ctx, manifest, peer, diffRes.Need)
+		// The peer reports in "have" what we hold that it lacks or holds
+		// differently. A peer that hands out no cursor predates incremental
+		// sync; keep sending it full manifests.
+		plan = runPlan{
+			Items:      diffRes.Have,
+			Tombstones: tombstones,
+			Unchanged:  unchangedChecksums(items, diffRes.Need, diffRes.Have),
+		}
+		if diffRes.Cursor == 0 {
+			high = 0
+		}
+		if run, err = e.startRun(ctx, manifest, model.SyncDirectionPush, opts, plan, high); err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+		}
 	}
 
-	conflicts := 0
-	for _, item := range diffRes.Have {
-		conflicts++
-		_, _ = e.Store.CreateSyncConflict(ctx, manifest.ID, item.EntityType, item.ID, "", item.Checksum, strategy, nil, nil)
-	}
-	_, err = e.Transport.Push(ctx, manifest.RemoteURL, key, map[string]any{
-		"remote": remoteName,
-		"scope":  string(scope),
-		"items":  diffRes.Need,
-	})
-	if err != nil {
-		msg := err.Error()
-		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, 0, conflicts, &msg)
-		return model.SyncLog{}, err
+	agreed, err := e.Store.SyncedChecksums(ctx, manifest.ID, "knowledge")
+	if err != nil {
+		return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
 	}
-	status := model.SyncStatusSuccess
-	if conflicts > 0 {
-		status = model.SyncStatusPartial
+	pushed, conflicts := 0, 0
+	total := max(batchCount(len(plan.Items), run.BatchSize), 1)
+	for n := run.Acked + 1; n <= total; n++ {
+		entities, err := e.LoadEntities(ctx, batchOf(plan.Items, n, run.BatchSize))
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, pushed, 0, conflicts, err)
+		}
+		entities = FilterEntities(opts.Filter, entities)
+		withBaseChecksums(entities, agreed)
+		req := PushRequest{
+			Remote:   remoteName,
+			Scope:    string(opts.Scope),
+			Strategy: Strategy(strategy).Mirror(),
+			Items:    entities,
+		}
+		if n == 1 {
+			req.Tombstones = plan.Tombstones
+		}
+		applyRes, err := e.Transport.Push(ctx, peer, req, n, total)
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, pushed, 0, conflicts, err)
+		}
+		e.recordConflicts(ctx, manifest.ID, strategy, model.SyncDirectionPush, applyRes, entities)
+		e.notePeer(ctx, manifest, peer, heldItems(entities, applyRes))
+		e.recordAgreed(ctx, manifest.ID, agreedChecksums(entities, applyRes))
+		pushed += applyRes.Applied + applyRes.Deleted
+		conflicts += len(applyRes.Conflicts)
+		if err := e.Store.AckSyncBatch(ctx, manifest.ID, model.SyncDirectionPush, n); err != nil {
+			return e.fail(ctx, manifest, log.ID, pushed, 0, conflicts, err)
+		}
 	}
-	_ = e.Store.CompleteSyncLog(ctx, log.ID, status, len(diffRes.Need), 0, conflicts, nil)
-	_ = e.Store.UpdateManifestSyncResult(ctx, manifest.ID, true)
-	return e.Store.GetSyncLog(ctx, log.ID)
+	e.recordAgreed(ctx, manifest.ID, plan.Unchanged)
+	return e.finish(ctx, manifest, log.ID, run, pushed, 0, conflicts)
 }
 
-func (e *Engine) Pull(ctx context.Context, remoteName string, apiKey string, scope model.SyncScope, scopeIDs []string) (model.SyncLog, error) {
+func (e *Engine) Pull(ctx context.Context, remoteName string, apiKey string, opts SyncOptions) (model.SyncLog, error) {
 	manifest, _, strategy, err := e.Store.GetRemoteWithAuth(ctx, remoteName)
 	if err != nil {
 		return model.SyncLog{}, err
@@ -125,59 +353,131 @@ func (e *Engine) Pull(ctx context.Context, remoteName string, apiKey string, sco
 	if err != nil {
 		return model.SyncLog{}, err
 	}
-	items, err := BuildManifest(ctx, e.DB, scope, scopeIDs)
+	opts = opts.withDefaults(manifest)
+	key, err := e.apiKey(ctx, remoteName, apiKey)
+	if err == nil && key == "" {
+		err = errors.New("remote api key is required for pull")
+	}
 	if err != nil {
-		msg := err.Error()
-		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, 0, 0, &msg)
-		return model.SyncLog{}, err
+		return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
 	}
-	key := apiKey
-	if key == "" {
-		msg := "remote api key is required for pull"
-		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, 0, 0, &msg)
-		return model.SyncLog{}, errors.New(msg)
+	peer, err := e.peer(ctx, manifest, key)
+	if err != nil {
+		return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
 	}
-	diffRes, err := e.Transport.Diff(ctx, manifest.RemoteURL, key, DiffRequest{
-		Scope: string(scope),
-		Items: items,
-	})
+
+	pulled := 0
+	run, plan, err := e.resumeRun(ctx, manifest, model.SyncDirectionPull, opts)
 	if err != nil {
-		msg := err.Error()
-		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, 0, 0, &msg)
-		return model.SyncLog{}, err
+		return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+	}
+	if run.StartedAt.IsZero() {
+		// Local changes not yet pushed let the peer spot concurrent edits.
+		since, _ := opts.cursors(manifest)
+		items, err := BuildManifestSince(ctx, e.DB, opts.Scope, opts.Selection, since)
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+		}
+		diffRes, err := e.diff(ctx, manifest, peer, opts, items)
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+		}
+		e.notePeer(ctx, manifest, peer, diffRes.Need)
+		if pulled, _, err = e.ApplyTombstones(ctx, diffRes.Tombstones); err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, 0, 0, err)
+		}
+		// Changes the peer made since our cursor may include what we pushed to
+		// it; skip those we already hold or deleted. The peer reports in
+		// "need" what it holds that we lack or hold differently.
+		need, err := e.withoutLocalCopies(ctx, diffRes.Need)
+		if err == nil {
+			need, err = dropProtected(ctx, e.DB, opts.Filter, need)
+		}
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, pulled, 0, err)
+		}
+		plan = runPlan{
+			Items:     need,
+			Unchanged: unchangedChecksums(items, diffRes.Need, diffRes.Have),
+		}
+		if run, err = e.startRun(ctx, manifest, model.SyncDirectionPull, opts, plan, diffRes.Cursor); err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, pulled, 0, err)
+		}
 	}
 
-	_, err = e.Transport.Pull(ctx, manifest.RemoteURL, key, map[string]any{
-		"remote": remoteName,
-		"scope":  string(scope),
-		"items":  diffRes.Have,
-	})
+	agreed, err := e.Store.SyncedChecksums(ctx, manifest.ID, "knowledge")
 	if err != nil {
-		msg := err.Error()
-		_ = e.Store.CompleteSyncLog(ctx, log.ID, model.SyncStatusFailed, 0, 0, 0, &msg)
-		return model.SyncLog{}, err
+		return e.fail(ctx, manifest, log.ID, 0, pulled, 0, err)
 	}
 	conflicts := 0
-	for _, item := range diffRes.Have {
-		_, _ = e.Store.CreateSyncConflict(ctx, manifest.ID, item.EntityType, item.ID, "", item.Checksum, strategy, nil, nil)
-		conflicts++
+	total := batchCount(len(plan.Items), run.BatchSize)
+	for n := run.Acked + 1; n <= total; n++ {
+		entities, err := e.Transport.Pull(ctx, peer, PullRequest{
+			Remote:        remoteName,
+			Scope:         string(opts.Scope),
+			CollectionIDs: opts.CollectionIDs,
+			TopicIDs:      opts.TopicIDs,
+			Items:         batchOf(plan.Items, n, run.BatchSize),
+		})
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, pulled, conflicts, err)
+		}
+		withBaseChecksums(entities, agreed)
+		applyRes, err := e.Apply(ctx, "remote "+remoteName, Strategy(strategy), entities)
+		if err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, pulled, conflicts, err)
+		}
+		e.recordConflicts(ctx, manifest.ID, strategy, model.SyncDirectionPull, applyRes, entities)
+		e.recordAgreed(ctx, manifest.ID, agreedChecksums(entities, applyRes))
+		pulled += applyRes.Applied
+		conflicts += len(applyRes.Conflicts)
+		if err := e.Store.AckSyncBatch(ctx, manifest.ID, model.SyncDirectionPull, n); err != nil {
+			return e.fail(ctx, manifest, log.ID, 0, pulled, conflicts, err)
+		}
+	}
+	e.recordAgreed(ctx, manifest.ID, plan.Unchanged)
+	return e.finish(ctx, manifest, log.ID, run, 0, pulled, conflicts)
+}
+
+// withoutLocalCopies drops items whose content already matches ours or
+// whose version ours already includes.
+func (e *Engine) withoutLocalCopies(ctx context.Context, items []ManifestItem) ([]ManifestItem, error) {
+	local, err := LookupManifest(ctx, e.DB, items)
+	if err != nil {
+		return nil, err
 	}
-	status := model.SyncStatusSuccess
-	if conflicts > 0 {
-		status = model.SyncStatusPartial
+	out := items[:0:0]
+	for _, it := range items {
+		if l, ok := local[it.EntityType+":"+it.ID]; ok && (l.Checksum == it.Checksum || descends(l, it)) {
+			continue
+		}
+		out = append(out, it)
+	}
+	return e.withoutBuried(ctx, out)
+}
+
+// heldItems lists the pushed entities the peer now holds: all but those left
+// in open conflicts.
+func heldItems(entities []Entity, res ApplyResult) []ManifestItem {
+	open := make(map[string]bool, len(res.Conflicts))
+	for _, c := range res.Conflicts {
+		open[c.EntityType+":"+c.ID] = true
+	}
+	out := make([]ManifestItem, 0, len(entities))
+	for _, ent := range entities {
+		if !open[ent.EntityType+":"+ent.ID] {
+			out = append(out, ent.ManifestItem)
+		}
 	}
-	_ = e.Store.CompleteSyncLog(ctx, log.ID, status, 0, len(diffRes.Have), conflicts, nil)
-	_ = e.Store.UpdateManifestSyncResult(ctx, manifest.ID, true)
-	return e.Store.GetSyncLog(ctx, log.ID)
+	return out
 }
 
-func (e *Engine) ResolveConflict(ctx context.Context, conflictID string, strategy string, note string) error {
-	switch Strategy(strategy) {
-	case StrategyLocalWins, StrategyRemoteWins, StrategyLatestWins, StrategyFork, StrategyManual:
-	default:
-		return fmt.Errorf("invalid strategy")
+// recordAgreed remembers the knowledge checksums both sides now hold, which
+// later serve as merge bases.
+func (e *Engine) recordAgreed(ctx context.Context, manifestID string, sets ...map[string]string) {
+	for _, checksums := range sets {
+		_ = e.Store.RecordSyncedChecksums(ctx, manifestID, "knowledge", checksums)
 	}
-	return e.Store.ResolveConflict(ctx, conflictID, strategy, note)
 }
 
 func simpleChecksum(v string) string {
@@ -0,0 +1,251 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"opencortex/internal/model"
+)
+
+// Redacted replaces the value of a redacted field in what a remote receives.
+const Redacted = "[redacted]"
+
+// RedactableFields lists the knowledge fields a remote's filter can redact.
+var RedactableFields = []string{"title", "content", "summary", "source", "tags", "metadata"}
+
+// ValidateFilter checks that a filter only names fields it can redact and
+// visibility levels that exist.
+func ValidateFilter(f model.SyncFilter) error {
+	for _, field := range f.RedactFields {
+		if !slices.Contains(RedactableFields, field) {
+			return fmt.Errorf("cannot redact %q; redactable fields are %s", field, strings.Join(RedactableFields, ", "))
+		}
+	}
+	for _, v := range f.ExcludeVisibilities {
+		switch model.KnowledgeVisibility(v) {
+		case model.KnowledgeVisibilityPublic, model.KnowledgeVisibilityRestricted:
+		default:
+			return fmt.Errorf("unknown visibility %q", v)
+		}
+	}
+	return nil
+}
+
+// StrictestFilter combines filters so that whatever any of them holds back
+// is held back, for sending to a node none of them is known to apply to.
+func StrictestFilter(filters ...model.SyncFilter) model.SyncFilter {
+	var out model.SyncFilter
+	for _, f := range filters {
+		out.ExcludeTags = appendNew(out.ExcludeTags, f.ExcludeTags)
+		out.ExcludeVisibilities = appendNew(out.ExcludeVisibilities, f.ExcludeVisibilities)
+		out.ExcludeMetadataKeys = appendNew(out.ExcludeMetadataKeys, f.ExcludeMetadataKeys)
+		out.RedactFields = appendNew(out.RedactFields, f.RedactFields)
+	}
+	return out
+}
+
+func appendNew(dst, values []string) []string {
+	for _, v := range values {
+		if !slices.Contains(dst, v) {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
+
+// filterEmpty reports whether the filter lets everything through untouched.
+func filterEmpty(f model.SyncFilter) bool {
+	return len(f.ExcludeTags) == 0 && len(f.ExcludeVisibilities) == 0 && len(f.ExcludeMetadataKeys) == 0 && len(f.RedactFields) == 0
+}
+
+// filterKey identifies the filter in a scope key, so a changed filter sends
+// everything again under the new rules.
+func filterKey(f model.SyncFilter) string {
+	if filterEmpty(f) {
+		return ""
+	}
+	b, _ := json.Marshal(f)
+	return simpleChecksum(string(b))[:12]
+}
+
+// excludes reports whether knowledge with these tags and visibility must not
+// be sent.
+func excludes(f model.SyncFilter, tags []string, visibility model.KnowledgeVisibility) bool {
+	if slices.Contains(f.ExcludeVisibilities, string(visibility)) {
+		return true
+	}
+	for _, tag := range tags {
+		if slices.Contains(f.ExcludeTags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactsContent reports whether the content of sent knowledge is blanked,
+// which changes its checksum.
+func redactsContent(f model.SyncFilter) bool {
+	return slices.Contains(f.RedactFields, "content")
+}
+
+// filteredChecksum is the checksum of knowledge content as sent under f.
+func filteredChecksum(f model.SyncFilter, checksum string) string {
+	if redactsContent(f) {
+		return simpleChecksum(Redacted)
+	}
+	return checksum
+}
+
+// FilterEntity applies the filter to an entity about to be sent. It reports
+// false when the entity must be held back and otherwise lists what was
+// stripped or redacted from it.
+func FilterEntity(f model.SyncFilter, ent *Entity) (bool, []string) {
+	if ent.Knowledge == nil || filterEmpty(f) {
+		return true, nil
+	}
+	k := *ent.Knowledge
+	if excludes(f, k.Tags, k.Visibility) {
+		return false, nil
+	}
+	var changed []string
+	if len(f.ExcludeMetadataKeys) > 0 {
+		kept := make(map[string]any, len(k.Metadata))
+		for key, v := range k.Metadata {
+			if slices.Contains(f.ExcludeMetadataKeys, key) {
+				changed = append(changed, "metadata."+key)
+				continue
+			}
+			kept[key] = v
+		}
+		k.Metadata = kept
+	}
+	for _, field := range f.RedactFields {
+		switch field {
+		case "title":
+			k.Title = Redacted
+		case "content":
+			k.Content = Redacted
+			k.Checksum = simpleChecksum(Redacted)
+			ent.Checksum = k.Checksum
+		case "summary":
+			if k.Summary == nil {
+				continue
+			}
+			k.Summary = ptr(Redacted)
+		case "source":
+			if k.Source == nil {
+				continue
+			}
+			k.Source = ptr(Redacted)
+		case "tags":
+			if len(k.Tags) == 0 {
+				continue
+			}
+			k.Tags = []string{}
+		case "metadata":
+			if len(k.Metadata) == 0 {
+				continue
+			}
+			k.Metadata = map[string]any{}
+		default:
+			continue
+		}
+		changed = append(changed, field)
+	}
+	slices.Sort(changed)
+	ent.Knowledge = &k
+	return true, changed
+}
+
+// FilterEntities applies the filter to entities about to be sent, dropping
+// those it holds back.
+func FilterEntities(f model.SyncFilter, entities []Entity) []Entity {
+	if filterEmpty(f) {
+		return entities
+	}
+	out := entities[:0]
+	for _, ent := range entities {
+		if keep, _ := FilterEntity(f, &ent); keep {
+			out = append(out, ent)
+		}
+	}
+	return out
+}
+
+// dropProtected drops incoming knowledge whose local copy the filter
+// keeps from the remote: an entry never sent, or one whose content the
+// remote only holds redacted, is not overwritten by the remote's copy.
+func dropProtected(ctx context.Context, db *sql.DB, f model.SyncFilter, items []ManifestItem) ([]ManifestItem, error) {
+	if len(f.ExcludeTags) == 0 && len(f.ExcludeVisibilities) == 0 && !redactsContent(f) {
+		return items, nil
+	}
+	var ids []string
+	for _, it := range items {
+		if it.EntityType == "knowledge" {
+			ids = append(ids, it.ID)
+		}
+	}
+	protected := map[string]bool{}
+	for start := 0; start < len(ids); start += lookupBatch {
+		batch := ids[start:min(start+lookupBatch, len(ids))]
+		rows, err := db.QueryContext(ctx, "SELECT id, tags, visibility FROM knowledge_entries WHERE id IN ("+placeholders(len(batch))+")", stringArgs(batch)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, tags, visibility string
+			if err := rows.Scan(&id, &tags, &visibility); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			var list []string
+			_ = json.Unmarshal([]byte(tags), &list)
+			protected[id] = redactsContent(f) || excludes(f, list, model.KnowledgeVisibility(visibility))
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := items[:0:0]
+	for _, it := range items {
+		if it.EntityType == "knowledge" && protected[it.ID] {
+			continue
+		}
+		out = append(out, it)
+	}
+	return out, nil
+}
+
+// WithoutProtected drops the incoming entities whose local copy the filter
+// keeps from the peer they came from; see dropProtected.
+func (e *Engine) WithoutProtected(ctx context.Context, f model.SyncFilter, entities []Entity) ([]Entity, error) {
+	items := make([]ManifestItem, 0, len(entities))
+	for _, ent := range entities {
+		items = append(items, ent.ManifestItem)
+	}
+	kept, err := dropProtected(ctx, e.DB, f, items)
+	if err != nil || len(kept) == len(items) {
+		return entities, err
+	}
+	allowed := make(map[string]bool, len(kept))
+	for _, it := range kept {
+		allowed[it.EntityType+":"+it.ID] = true
+	}
+	out := make([]Entity, 0, len(kept))
+	for _, ent := range entities {
+		if allowed[ent.EntityType+":"+ent.ID] {
+			out = append(out, ent)
+		}
+	}
+	return out, nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,506 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"opencortex/internal/model"
+)
+
+// LivePath is the peer endpoint a live link is dialled at.
+const LivePath = "/api/v1/sync/live"
+
+// Kinds of frame sent over a live link.
+const (
+	LiveHelloFrame   = "hello"
+	LiveChangesFrame = "changes"
+	LiveAckFrame     = "ack"
+	LiveErrorFrame   = "error"
+)
+
+// MaxFrameBytes caps a frame read from a live link and a sync request body
+// read whole. It leaves room for a full batch of large entries.
+const MaxFrameBytes = 64 << 20
+
+// liveTimeout bounds how long a live link waits on its peer: for a frame or
+// pong to arrive and for sent changes to be acknowledged.
+const liveTimeout = time.Minute
+
+// LiveHello opens a live link. The dialling node names the scope exchanged,
+// its direction from its own point of view and where the peer starts sending
+// its changes from: the dialler's pull cursor. Strategy is how the dialler
+// would have conflicts in what it sends settled, from the peer's point of
+// view; the peer applies its own strategy for the dialler instead.
+type LiveHello struct {
+	Remote        string              `json:"remote,omitempty"`
+	Scope         string              `json:"scope"`
+	CollectionIDs []string            `json:"collection_ids,omitempty"`
+	TopicIDs      []string            `json:"topic_ids,omitempty"`
+	Direction     model.SyncDirection `json:"direction"`
+	Strategy      Strategy            `json:"strategy,omitempty"`
+	Since         int64               `json:"since"`
+}
+
+// LiveFrame is one message on a live link. A changes frame carries a batch
+// of changes signed like a pushed batch and, on the last batch of a round,
+// the sender's change cursor; the receiver answers with an ack of the same
+// Seq holding what it applied.
+type LiveFrame struct {
+	Type      string       `json:"type"`
+	Seq       int64        `json:"seq,omitempty"`
+	Hello     *LiveHello   `json:"hello,omitempty"`
+	Changes   *PushRequest `json:"changes,omitempty"`
+	Cursor    int64        `json:"cursor,omitempty"`
+	Result    *ApplyResult `json:"result,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	KeyID     string       `json:"key_id,omitempty"`
+	Signature string       `json:"signature,omitempty"`
+}
+
+// LiveSession runs one end of a live link. Each end forwards its changes as
+// they are written and applies the other's; the two differ only in what
+// they record.
+type LiveSession struct {
+	Engine *Engine
+	Conn   *websocket.Conn
+	// Remote is the remote the link was dialled for. Exchanges are recorded
+	// against it as a push and pull would be; it is zero on the answering end.
+	Remote model.SyncManifest
+	// Options is the scope this end forwards.
+	Options SyncOptions
+	// Send forwards the changes made here after Since, to be settled on the
+	// peer with PeerStrategy.
+	Send         bool
+	Since        int64
+	PeerStrategy Strategy
+	// Strategy settles conflicts in the changes received; Source names the
+	// peer in forks.
+	Strategy Strategy
+	Source   string
+	// PeerNode is the node id of the peer, when it gave one.
+	PeerNode string
+	// Verify checks the signature of changes received; nil accepts all.
+	Verify func(Signed) error
+
+	writeMu sync.Mutex
+}
+
+// Run serves the link until it drops or ctx is done.
+func (s *LiveSession) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = s.Conn.Close()
+	}()
+	acks := make(chan LiveFrame, 1)
+	errc := make(chan error, 3)
+	go func() { errc <- s.read(ctx, acks) }()
+	go func() { errc <- s.keepAlive(ctx) }()
+	if s.Send {
+		go func() { errc <- s.send(ctx, acks) }()
+	}
+	return <-errc
+}
+
+func (s *LiveSession) write(frame LiveFrame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_ = s.Conn.SetWriteDeadline(time.Now().Add(liveTimeout))
+	return s.Conn.WriteJSON(frame)
+}
+
+// keepAlive pings the peer so a link that silently died is noticed.
+func (s *LiveSession) keepAlive(ctx context.Context) error {
+	ticker := time.NewTicker(liveTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			s.writeMu.Lock()
+			err := s.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveTimeout))
+			s.writeMu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// read applies the changes the peer sends and hands its acks to the sender.
+func (s *LiveSession) read(ctx context.Context, acks chan<- LiveFrame) error {
+	extend := func(string) error {
+		return s.Conn.SetReadDeadline(time.Now().Add(liveTimeout))
+	}
+	_ = extend("")
+	s.Conn.SetPongHandler(extend)
+	for {
+		var frame LiveFrame
+		if err := s.Conn.ReadJSON(&frame); err != nil {
+			return err
+		}
+		_ = extend("")
+		switch frame.Type {
+		case LiveChangesFrame:
+			res, err := s.receive(ctx, frame)
+			if err != nil {
+				_ = s.write(LiveFrame{Type: LiveErrorFrame, Seq: frame.Seq, Error: err.Error()})
+				return err
+			}
+			if err := s.write(LiveFrame{Type: LiveAckFrame, Seq: frame.Seq, Result: &res}); err != nil {
+				return err
+			}
+		case LiveAckFrame:
+			select {
+			case acks <- frame:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case LiveErrorFrame:
+			return fmt.Errorf("live peer: %s", frame.Error)
+		default:
+			return fmt.Errorf("unexpected live frame %q", frame.Type)
+		}
+	}
+}
+
+// receive applies a batch of the peer's changes like an inbound push.
+func (s *LiveSession) receive(ctx context.Context, frame LiveFrame) (ApplyResult, error) {
+	e := s.Engine
+	req := frame.Changes
+	if req == nil {
+		return ApplyResult{}, errors.New("changes frame carries no changes")
+	}
+	if s.Verify != nil {
+		signed, err := JSONSigned(frame.KeyID, frame.Signature, req)
+		if err == nil {
+			err = s.Verify(signed)
+		}
+		if err != nil {
+			if s.Remote.ID != "" {
+				e.auditRejection(ctx, s.Remote, err)
+			}
+			return ApplyResult{}, err
+		}
+	}
+	items, err := e.WithoutProtected(ctx, s.Options.Filter, req.Items)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	if s.Remote.ID != "" {
+		agreed, err := e.Store.SyncedChecksums(ctx, s.Remote.ID, "knowledge")
+		if err != nil {
+			return ApplyResult{}, err
+		}
+		withBaseChecksums(items, agreed)
+	}
+	deleted, skipped, err := e.ApplyTombstones(ctx, req.Tombstones)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	res, err := e.Apply(ctx, s.Source, s.Strategy, items)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	res.Deleted = deleted
+	res.Skipped += skipped + len(req.Items) - len(items)
+	held := make([]ManifestItem, 0, len(items))
+	for _, ent := range items {
+		held = append(held, ent.ManifestItem)
+	}
+	s.notePeer(ctx, held)
+	if s.Remote.ID != "" {
+		e.recordConflicts(ctx, s.Remote.ID, s.Remote.Strategy, model.SyncDirectionPull, res, items)
+		e.recordAgreed(ctx, s.Remote.ID, agreedChecksums(items, res))
+		if frame.Cursor > 0 {
+			_ = e.Store.UpdateManifestCursor(ctx, s.Remote.ID, model.SyncDirectionPull, s.Options.scopeKey(), frame.Cursor)
+		}
+	}
+	return res, nil
+}
+
+// send forwards the changes made here whenever the engine reports new ones.
+func (s *LiveSession) send(ctx context.Context, acks <-chan LiveFrame) error {
+	var changed <-chan struct{}
+	if s.Engine.Changes != nil {
+		ch, stop := s.Engine.Changes()
+		defer stop()
+		changed = ch
+	}
+	since, seq := s.Since, int64(0)
+	for {
+		next, err := s.flush(ctx, acks, since, &seq)
+		if err != nil {
+			return err
+		}
+		since = next
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// flush sends the changes made after since in batches, each acknowledged
+// before the next, and returns the cursor they were read up to.
+func (s *LiveSession) flush(ctx context.Context, acks <-chan LiveFrame, since int64, seq *int64) (int64, error) {
+	e := s.Engine
+	high, err := ChangeCursor(ctx, e.DB)
+	if err != nil || high <= since {
+		return since, err
+	}
+	items, err := BuildManifestSince(ctx, e.DB, s.Options.Scope, s.Options.Selection, since)
+	if err != nil {
+		return since, err
+	}
+	tombstones, err := e.Store.ListTombstones(ctx, since, ScopeEntityTypes(s.Options.Scope, s.Options.Selection))
+	if err != nil {
+		return since, err
+	}
+	var agreed map[string]string
+	if s.Remote.ID != "" {
+		if agreed, err = e.Store.SyncedChecksums(ctx, s.Remote.ID, "knowledge"); err != nil {
+			return since, err
+		}
+	}
+	size := max(e.BatchSize, 1)
+	total := max(batchCount(len(items), size), 1)
+	for n := 1; n <= total; n++ {
+		entities, err := e.LoadEntities(ctx, batchOf(items, n, size))
+		if err != nil {
+			return since, err
+		}
+		entities = FilterEntities(s.Options.Filter, entities)
+		withBaseChecksums(entities, agreed)
+		req := PushRequest{
+			Remote:   s.Remote.RemoteName,
+			Scope:    string(s.Options.Scope),
+			Strategy: s.PeerStrategy,
+			Items:    entities,
+		}
+		if n == 1 {
+			req.Tombstones = tombstones
+		}
+		if len(req.Items) == 0 && len(req.Tombstones) == 0 {
+			continue
+		}
+		*seq++
+		frame := LiveFrame{Type: LiveChangesFrame, Seq: *seq, Changes: &req}
+		if n == total {
+			frame.Cursor = high
+		}
+		if e.Identity != nil {
+			id, err := e.Identity(ctx)
+			if err != nil {
+				return since, err
+			}
+			frame.KeyID = id.KeyID()
+			if frame.Signature, err = SignPayload(id, "push request", req); err != nil {
+				return since, err
+			}
+		}
+		if err := s.write(frame); err != nil {
+			return since, err
+		}
+		var ack LiveFrame
+		select {
+		case ack = <-acks:
+		case <-time.After(liveTimeout):
+			return since, errors.New("live peer did not acknowledge changes")
+		case <-ctx.Done():
+			return since, ctx.Err()
+		}
+		if ack.Seq != frame.Seq || ack.Result == nil {
+			return since, fmt.Errorf("live peer acknowledged changes %d, sent %d", ack.Seq, frame.Seq)
+		}
+		s.notePeer(ctx, heldItems(entities, *ack.Result))
+		if s.Remote.ID != "" {
+			e.recordConflicts(ctx, s.Remote.ID, s.Remote.Strategy, model.SyncDirectionPush, *ack.Result, entities)
+			e.recordAgreed(ctx, s.Remote.ID, agreedChecksums(entities, *ack.Result))
+		}
+	}
+	if s.Remote.ID != "" {
+		_ = e.Store.UpdateManifestCursor(ctx, s.Remote.ID, model.SyncDirectionPush, s.Options.scopeKey(), high)
+	}
+	return high, nil
+}
+
+func (s *LiveSession) notePeer(ctx context.Context, items []ManifestItem) {
+	if s.PeerNode != "" {
+		_ = s.Engine.Store.NotePeer(ctx, s.PeerNode, s.Remote.RemoteName, ClockOf(items))
+	}
+}
+
+// ReadLiveHello reads the frame opening a live link and what its signature
+// covers.
+func ReadLiveHello(conn *websocket.Conn) (LiveHello, Signed, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(liveTimeout))
+	var frame LiveFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		return LiveHello{}, Signed{}, err
+	}
+	if frame.Type != LiveHelloFrame || frame.Hello == nil {
+		return LiveHello{}, Signed{}, fmt.Errorf("expected live hello, got %q", frame.Type)
+	}
+	signed, err := JSONSigned(frame.KeyID, frame.Signature, frame.Hello)
+	return *frame.Hello, signed, err
+}
+
+// DialLive opens a live link to the peer, authenticated and signed like any
+// sync request.
+func (t *Transport) DialLive(ctx context.Context, peer Peer) (*websocket.Conn, error) {
+	endpoint := strings.TrimSuffix(peer.URL, "/") + LivePath
+	switch {
+	case strings.HasPrefix(endpoint, "https://"):
+		endpoint = "wss://" + strings.TrimPrefix(endpoint, "https://")
+	case strings.HasPrefix(endpoint, "http://"):
+		endpoint = "ws://" + strings.TrimPrefix(endpoint, "http://")
+	}
+	header := http.Header{}
+	if peer.APIKey != "" {
+		header.Set("Authorization", "Bearer "+peer.APIKey)
+	}
+	if peer.Identity != nil {
+		header.Set(NodeHeader, peer.Identity.NodeID)
+		header.Set(KeyIDHeader, peer.Identity.KeyID())
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: t.Client.Timeout}
+	conn, resp, err := dialer.DialContext(ctx, endpoint, header)
+	if err != nil {
+		if resp != nil {
+			return nil, statusError{Code: resp.StatusCode}
+		}
+		return nil, err
+	}
+	if node := resp.Header.Get(NodeHeader); node != "" {
+		t.setNode(peer.URL, node)
+	}
+	conn.SetReadLimit(MaxFrameBytes)
+	return conn, nil
+}
+
+// RunLive keeps a live link to the remote until ctx is done. Each round
+// first catches up with a batch sync, so whatever changed while the link
+// was down is exchanged, then holds the link until it drops. Rounds that
+// fail are retried with backoff, and the failure is recorded on the remote
+// as a failed sync would be.
+func (e *Engine) RunLive(ctx context.Context, remoteName string) {
+	failures := 0
+	for {
+		linked, manifest, err := e.liveRound(ctx, remoteName)
+		if ctx.Err() != nil {
+			return
+		}
+		if linked {
+			failures = 0
+		}
+		failures++
+		delay := e.Backoff.Delay(failures)
+		// A failed catch-up is recorded by the push or pull that failed;
+		// manifest is only set once the round got past it.
+		if manifest.ID != "" {
+			_ = e.Store.RecordSyncFailure(ctx, manifest.ID, "live link: "+err.Error(), time.Now().Add(delay))
+		}
+		if sleep(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// liveRound runs one catch-up and link, reporting whether the link was up
+// and, once the catch-up succeeded, the remote it ran for.
+func (e *Engine) liveRound(ctx context.Context, remoteName string) (bool, model.SyncManifest, error) {
+	manifest, err := e.Store.GetRemote(ctx, remoteName)
+	if err != nil {
+		return false, model.SyncManifest{}, err
+	}
+	if err := e.SyncRemote(ctx, manifest); err != nil {
+		return false, model.SyncManifest{}, err
+	}
+	// Reread for the cursors the catch-up moved.
+	manifest, _, strategy, err := e.Store.GetRemoteWithAuth(ctx, remoteName)
+	if err != nil {
+		return false, model.SyncManifest{}, err
+	}
+	key, err := e.apiKey(ctx, remoteName, "")
+	if err != nil {
+		return false, manifest, err
+	}
+	peer, err := e.peer(ctx, manifest, key)
+	if err != nil {
+		return false, manifest, err
+	}
+	conn, err := e.Transport.DialLive(ctx, peer)
+	if err != nil {
+		return false, manifest, err
+	}
+	opts := SyncOptions{}.withDefaults(manifest)
+	push, pull := opts.cursors(manifest)
+	hello := LiveHello{
+		Remote:        remoteName,
+		Scope:         string(opts.Scope),
+		CollectionIDs: opts.CollectionIDs,
+		TopicIDs:      opts.TopicIDs,
+		Direction:     manifest.Direction,
+		Strategy:      Strategy(strategy).Mirror(),
+		Since:         pull,
+	}
+	frame := LiveFrame{Type: LiveHelloFrame, Hello: &hello}
+	if peer.Identity != nil {
+		frame.KeyID = peer.Identity.KeyID()
+		if frame.Signature, err = SignPayload(*peer.Identity, "live request", hello); err != nil {
+			_ = conn.Close()
+			return false, manifest, err
+		}
+	}
+	if err := conn.WriteJSON(frame); err != nil {
+		_ = conn.Close()
+		return false, manifest, err
+	}
+	session := &LiveSession{
+		Engine:       e,
+		Conn:         conn,
+		Remote:       manifest,
+		Options:      opts,
+		Send:         manifest.Direction != model.SyncDirectionPull,
+		Since:        push,
+		PeerStrategy: Strategy(strategy).Mirror(),
+		Strategy:     Strategy(strategy),
+		Source:       "remote " + remoteName,
+		PeerNode:     e.Transport.PeerNode(peer.URL),
+	}
+	if manifest.PeerPublicKey != "" {
+		session.Verify = func(signed Signed) error {
+			return VerifyPayload(manifest.PeerPublicKey, "push request", signed)
+		}
+	}
+	return true, manifest, session.Run(ctx)
+}
+
+// SyncRemote runs the push and pull the remote's direction calls for, with
+// its stored scope.
+func (e *Engine) SyncRemote(ctx context.Context, remote model.SyncManifest) error {
+	opts := SyncOptions{}
+	var err error
+	switch remote.Direction {
+	case model.SyncDirectionPush:
+		_, err = e.Push(ctx, remote.RemoteName, "", opts)
+	case model.SyncDirectionPull:
+		_, err = e.Pull(ctx, remote.RemoteName, "", opts)
+	default:
+		if _, err = e.Push(ctx, remote.RemoteName, "", opts); err == nil {
+			_, err = e.Pull(ctx, remote.RemoteName, "", opts)
+		}
+	}
+	return err
+}
@@ -3,7 +3,10 @@ package syncer
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"opencortex/internal/model"
 )
@@ -13,54 +16,324 @@ type ManifestItem struct {
 	ID         string `json:"id"`
 	Checksum   string `json:"checksum"`
 	UpdatedAt  string `json:"updated_at"`
+	// Origin is the node that created the entity and Vector the version the
+	// checksum belongs to. Peers that predate version vectors leave both out.
+	Origin string        `json:"origin,omitempty"`
+	Vector VersionVector `json:"vector,omitempty"`
 }
 
-func BuildManifest(ctx context.Context, db *sql.DB, scope model.SyncScope, scopeIDs []string) ([]ManifestItem, error) {
+// lookupBatch bounds the number of ids bound into a single IN clause.
+const lookupBatch = 500
+
+// Selection limits a scoped manifest to listed collections and topics. The
+// collections scope covers the listed collections, their descendants and
+// the knowledge in all of them and, when
+// topics are listed too, those topics and their messages. The topics scope
+// covers the listed topics (all when none are listed) with their messages
+// and, when collections are listed too, those collections' knowledge.
+// Filter holds back or redacts knowledge for the remote it is sent to.
+type Selection struct {
+	CollectionIDs []string
+	TopicIDs      []string
+	Filter        model.SyncFilter
+}
+
+// entityTypes lists what scope covers under the selection, in apply order.
+func (sel Selection) entityTypes(scope model.SyncScope) []string {
 	switch scope {
 	case model.SyncScopeFull:
-		var out []ManifestItem
-		items, err := fromKnowledge(ctx, db, "")
+		return []string{"collections", "topics", "knowledge", "messages"}
+	case model.SyncScopeCollections:
+		if len(sel.TopicIDs) > 0 {
+			return []string{"collections", "topics", "knowledge", "messages"}
+		}
+		return []string{"collections", "knowledge"}
+	case model.SyncScopeTopics:
+		if len(sel.CollectionIDs) > 0 {
+			return []string{"collections", "topics", "knowledge", "messages"}
+		}
+		return []string{"topics", "messages"}
+	case model.SyncScopeMessages:
+		return []string{"messages"}
+	default:
+		return nil
+	}
+}
+
+func BuildManifest(ctx context.Context, db *sql.DB, scope model.SyncScope, sel Selection) ([]ManifestItem, error) {
+	return BuildManifestSince(ctx, db, scope, sel, 0)
+}
+
+// BuildManifestSince lists the entities in scope whose latest change in the
+// sync_changes log is newer than since. A since of zero lists everything.
+func BuildManifestSince(ctx context.Context, db *sql.DB, scope model.SyncScope, sel Selection, since int64) ([]ManifestItem, error) {
+	types := sel.entityTypes(scope)
+	if types == nil {
+		return nil, fmt.Errorf("unsupported scope: %s", scope)
+	}
+	if scope == model.SyncScopeFull {
+		sel = Selection{Filter: sel.Filter}
+	} else if len(sel.CollectionIDs) > 0 {
+		ids, err := expandCollections(ctx, db, sel.CollectionIDs)
 		if err != nil {
 			return nil, err
 		}
-		out = append(out, items...)
-		items, err = fromTopics(ctx, db)
+		sel.CollectionIDs = ids
+	}
+	var out []ManifestItem
+	for _, entityType := range types {
+		var (
+			items []ManifestItem
+			err   error
+		)
+		switch entityType {
+		case "collections":
+			if scope == model.SyncScopeFull {
+				items, err = fromCollections(ctx, db, nil, since)
+			} else if len(sel.CollectionIDs) > 0 {
+				items, err = fromCollections(ctx, db, sel.CollectionIDs, since)
+			}
+		case "knowledge":
+			if scope == model.SyncScopeFull {
+				items, err = fromKnowledge(ctx, db, "", since, sel.Filter)
+				break
+			}
+			for _, id := range sel.CollectionIDs {
+				var more []ManifestItem
+				more, err = fromKnowledge(ctx, db, id, since, sel.Filter)
+				if err != nil {
+					break
+				}
+				items = append(items, more...)
+			}
+		case "topics":
+			items, err = fromTopics(ctx, db, sel.TopicIDs, since)
+		case "messages":
+			items, err = fromMessages(ctx, db, sel.TopicIDs, since)
+		}
 		if err != nil {
 			return nil, err
 		}
 		out = append(out, items...)
-		items, err = fromMessages(ctx, db, "")
-		if err != nil {
+	}
+	return out, withVersions(ctx, db, out)
+}
+
+// withVersions stamps items with the local versions of their entities.
+func withVersions(ctx context.Context, db *sql.DB, items []ManifestItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	self, err := nodeID(ctx, db)
+	if err != nil {
+		return err
+	}
+	return attachVersions(ctx, db, self, items)
+}
+
+// expandCollections adds every descendant of the given collections. The given
+// ids are kept even when they do not exist here yet.
+func expandCollections(ctx context.Context, db *sql.DB, ids []string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+WITH RECURSIVE tree(id) AS (
+  SELECT id FROM collections WHERE id IN (`+placeholders(len(ids))+`)
+  UNION
+  SELECT c.id FROM collections c JOIN tree t ON c.parent_id = t.id
+)
+SELECT id FROM tree`, stringArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		out = append(out, items...)
-		return out, nil
-	case model.SyncScopeCollections:
-		var out []ManifestItem
-		for _, id := range scopeIDs {
-			items, err := fromKnowledge(ctx, db, id)
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out, rows.Err()
+}
+
+// ChangeCursor returns the last sequence number handed out to the change log.
+// Read it before building a manifest so changes made meanwhile are picked up
+// next time.
+func ChangeCursor(ctx context.Context, db *sql.DB) (int64, error) {
+	var seq int64
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(value), 0) FROM sync_seq").Scan(&seq)
+	return seq, err
+}
+
+// LookupManifest returns the local manifest entries for the given items keyed
+// by entity type and id. Items that do not exist locally are absent.
+func LookupManifest(ctx context.Context, db *sql.DB, items []ManifestItem) (map[string]ManifestItem, error) {
+	ids := map[string][]string{}
+	for _, it := range items {
+		ids[it.EntityType] = append(ids[it.EntityType], it.ID)
+	}
+	out := make(map[string]ManifestItem, len(items))
+	for entityType, all := range ids {
+		if entityType == "collections" {
+			for start := 0; start < len(all); start += lookupBatch {
+				batch := all[start:min(start+lookupBatch, len(all))]
+				items, err := queryCollections(ctx, db, "SELECT t.id, t.name, t.description, t.parent_id, t.is_public, t.metadata, t.updated_at FROM collections t WHERE t.id IN ("+placeholders(len(batch))+")", stringArgs(batch))
+				if err != nil {
+					return nil, err
+				}
+				for _, item := range items {
+					out[entityType+":"+item.ID] = item
+				}
+			}
+			continue
+		}
+		var query string
+		switch entityType {
+		case "knowledge":
+			query = "SELECT id, checksum, updated_at FROM knowledge_entries WHERE id IN (%s)"
+		case "topics":
+			query = "SELECT id, name, created_at FROM topics WHERE id IN (%s)"
+		case "messages":
+			query = "SELECT id, content, created_at FROM messages WHERE id IN (%s)"
+		default:
+			continue
+		}
+		for start := 0; start < len(all); start += lookupBatch {
+			batch := all[start:min(start+lookupBatch, len(all))]
+			rows, err := db.QueryContext(ctx, fmt.Sprintf(query, placeholders(len(batch))), stringArgs(batch)...)
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				item := ManifestItem{EntityType: entityType}
+				var value string
+				if err := rows.Scan(&item.ID, &value, &item.UpdatedAt); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				item.Checksum = value
+				if entityType != "knowledge" {
+					item.Checksum = simpleChecksum(value)
+				}
+				out[entityType+":"+item.ID] = item
+			}
+			err = rows.Err()
+			rows.Close()
 			if err != nil {
 				return nil, err
 			}
-			out = append(out, items...)
 		}
-		return out, nil
-	case model.SyncScopeTopics:
-		return fromTopics(ctx, db)
-	case model.SyncScopeMessages:
-		return fromMessages(ctx, db, "")
-	default:
-		return nil, fmt.Errorf("unsupported scope: %s", scope)
 	}
+	found := make([]ManifestItem, 0, len(out))
+	for _, it := range out {
+		found = append(found, it)
+	}
+	if err := withVersions(ctx, db, found); err != nil {
+		return nil, err
+	}
+	for _, it := range found {
+		out[it.EntityType+":"+it.ID] = it
+	}
+	return out, nil
+}
+
+// changedSince joins a table aliased t to the change log and keeps rows
+// changed after since.
+func changedSince(entityType string, since int64) (join string, where string, args []any) {
+	join = " JOIN sync_changes c ON c.entity_type = '" + entityType + "' AND c.entity_id = t.id"
+	if since > 0 {
+		return join, "c.seq > ?", []any{since}
+	}
+	return join, "", nil
+}
+
+func fromCollections(ctx context.Context, db *sql.DB, collectionIDs []string, since int64) ([]ManifestItem, error) {
+	join, where, args := changedSince("collections", since)
+	query := "SELECT t.id, t.name, t.description, t.parent_id, t.is_public, t.metadata, t.updated_at FROM collections t" + join
+	var conds []string
+	if where != "" {
+		conds = append(conds, where)
+	}
+	if len(collectionIDs) > 0 {
+		conds = append(conds, "t.id IN ("+placeholders(len(collectionIDs))+")")
+		args = append(args, stringArgs(collectionIDs)...)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return queryCollections(ctx, db, query+" ORDER BY c.seq", args)
+}
+
+func queryCollections(ctx context.Context, db *sql.DB, query string, args []any) ([]ManifestItem, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ManifestItem
+	for rows.Next() {
+		var (
+			c        model.Collection
+			parentID sql.NullString
+			isPublic int
+			metadata string
+			item     ManifestItem
+		)
+		item.EntityType = "collections"
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &parentID, &isPublic, &metadata, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			c.ParentID = &parentID.String
+		}
+		c.IsPublic = isPublic == 1
+		_ = json.Unmarshal([]byte(metadata), &c.Metadata)
+		item.ID = c.ID
+		item.Checksum = simpleChecksum(collectionText(c))
+		out = append(out, item)
+	}
+	return out, rows.Err()
 }
 
-func fromKnowledge(ctx context.Context, db *sql.DB, collectionID string) ([]ManifestItem, error) {
-	query := "SELECT id, checksum, updated_at FROM knowledge_entries"
-	args := []any{}
+// collectionText renders the synced fields of a collection; its checksum is
+// the collection's manifest checksum and conflicts diff it line by line.
+func collectionText(c model.Collection) string {
+	parent := ""
+	if c.ParentID != nil {
+		parent = *c.ParentID
+	}
+	metadata, _ := json.Marshal(c.Metadata)
+	return fmt.Sprintf("name: %s\ndescription: %s\nparent_id: %s\nis_public: %t\nmetadata: %s\n",
+		c.Name, c.Description, parent, c.IsPublic, metadata)
+}
+
+// fromKnowledge lists knowledge as the filter lets it be sent: entries it
+// holds back are left out and checksums are those of the content sent.
+func fromKnowledge(ctx context.Context, db *sql.DB, collectionID string, since int64, filter model.SyncFilter) ([]ManifestItem, error) {
+	join, where, args := changedSince("knowledge", since)
+	query := "SELECT t.id, t.checksum, t.updated_at, t.tags, t.visibility FROM knowledge_entries t" + join
+	var conds []string
+	if where != "" {
+		conds = append(conds, where)
+	}
 	if collectionID != "" {
-		query += " WHERE collection_id = ?"
+		conds = append(conds, "t.collection_id = ?")
 		args = append(args, collectionID)
 	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY c.seq"
 	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
@@ -68,18 +341,43 @@ func fromKnowledge(ctx context.Context, db *sql.DB, collectionID string) ([]Mani
 	defer rows.Close()
 	var out []ManifestItem
 	for rows.Next() {
-		var item ManifestItem
+		var (
+			item             ManifestItem
+			tags, visibility string
+		)
 		item.EntityType = "knowledge"
-		if err := rows.Scan(&item.ID, &item.Checksum, &item.UpdatedAt); err != nil {
+		if err := rows.Scan(&item.ID, &item.Checksum, &item.UpdatedAt, &tags, &visibility); err != nil {
 			return nil, err
 		}
+		if !filterEmpty(filter) {
+			var list []string
+			_ = json.Unmarshal([]byte(tags), &list)
+			if excludes(filter, list, model.KnowledgeVisibility(visibility)) {
+				continue
+			}
+			item.Checksum = filteredChecksum(filter, item.Checksum)
+		}
 		out = append(out, item)
 	}
 	return out, rows.Err()
 }
 
-func fromTopics(ctx context.Context, db *sql.DB) ([]ManifestItem, error) {
-	rows, err := db.QueryContext(ctx, "SELECT id, name, created_at FROM topics")
+func fromTopics(ctx context.Context, db *sql.DB, topicIDs []string, since int64) ([]ManifestItem, error) {
+	join, where, args := changedSince("topics", since)
+	query := "SELECT t.id, t.name, t.created_at FROM topics t" + join
+	var conds []string
+	if where != "" {
+		conds = append(conds, where)
+	}
+	if len(topicIDs) > 0 {
+		conds = append(conds, "t.id IN ("+placeholders(len(topicIDs))+")")
+		args = append(args, stringArgs(topicIDs)...)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY c.seq"
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -98,13 +396,21 @@ func fromTopics(ctx context.Context, db *sql.DB) ([]ManifestItem, error) {
 	return out, rows.Err()
 }
 
-func fromMessages(ctx context.Context, db *sql.DB, topicID string) ([]ManifestItem, error) {
-	query := "SELECT id, content, created_at FROM messages"
-	args := []any{}
-	if topicID != "" {
-		query += " WHERE topic_id = ?"
-		args = append(args, topicID)
+func fromMessages(ctx context.Context, db *sql.DB, topicIDs []string, since int64) ([]ManifestItem, error) {
+	join, where, args := changedSince("messages", since)
+	query := "SELECT t.id, t.content, t.created_at FROM messages t" + join
+	var conds []string
+	if where != "" {
+		conds = append(conds, where)
 	}
+	if len(topicIDs) > 0 {
+		conds = append(conds, "t.topic_id IN ("+placeholders(len(topicIDs))+")")
+		args = append(args, stringArgs(topicIDs)...)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY c.seq"
 	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
@@ -123,3 +429,77 @@ func fromMessages(ctx context.Context, db *sql.DB, topicID string) ([]ManifestIt
 	}
 	return out, rows.Err()
 }
+
+// buriedItems reports which items this node deleted after the change the item
+// describes. Knowledge edited after its deletion is not buried and comes back;
+// topics and messages stay deleted.
+func buriedItems(ctx context.Context, db *sql.DB, items []ManifestItem) (map[string]bool, error) {
+	ids := map[string][]ManifestItem{}
+	for _, it := range items {
+		ids[it.EntityType] = append(ids[it.EntityType], it)
+	}
+	out := map[string]bool{}
+	for entityType, all := range ids {
+		for start := 0; start < len(all); start += lookupBatch {
+			batch := all[start:min(start+lookupBatch, len(all))]
+			args := []any{entityType}
+			byID := make(map[string]ManifestItem, len(batch))
+			for _, it := range batch {
+				args = append(args, it.ID)
+				byID[it.ID] = it
+			}
+			rows, err := db.QueryContext(ctx, "SELECT entity_id, changed_at FROM sync_changes WHERE op = 'delete' AND entity_type = ? AND entity_id IN ("+placeholders(len(batch))+")", args...)
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				var id, deletedAt string
+				if err := rows.Scan(&id, &deletedAt); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				if entityType == "knowledge" && parseTime(byID[id].UpdatedAt).After(parseTime(deletedAt)) {
+					continue
+				}
+				out[entityType+":"+id] = true
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// ScopeEntityTypes lists the entity types a scope covers under sel; nil
+// means all.
+func ScopeEntityTypes(scope model.SyncScope, sel Selection) []string {
+	if scope == model.SyncScopeFull || scope == "" {
+		return nil
+	}
+	return sel.entityTypes(scope)
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func stringArgs(values []string) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+// parseTime reads the timestamps stored in manifest items and the change log.
+func parseTime(v string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,152 @@
+package syncer
+
+import (
+	"sort"
+	"strings"
+)
+
+// hunk replaces base lines [Start, End) with Lines.
+type hunk struct {
+	Start int
+	End   int
+	Lines []string
+	side  int
+}
+
+func hunksFrom(ops []diffOp, side int) []hunk {
+	var out []hunk
+	var cur *hunk
+	i := 0
+	for _, op := range ops {
+		if op.Kind == diffEqual {
+			if cur != nil {
+				out = append(out, *cur)
+				cur = nil
+			}
+			i++
+			continue
+		}
+		if cur == nil {
+			cur = &hunk{Start: i, End: i, side: side}
+		}
+		if op.Kind == diffDelete {
+			i++
+			cur.End = i
+		} else {
+			cur.Lines = append(cur.Lines, op.Text)
+		}
+	}
+	if cur != nil {
+		out = append(out, *cur)
+	}
+	return out
+}
+
+func hunksOverlap(a, b hunk) bool {
+	if a.Start == b.Start {
+		return true
+	}
+	if max(a.Start, b.Start) < min(a.End, b.End) {
+		return true
+	}
+	// A pure insertion conflicts with an edit that spans its position.
+	if a.Start == a.End && b.Start < a.Start && a.Start < b.End {
+		return true
+	}
+	if b.Start == b.End && a.Start < b.Start && b.Start < a.End {
+		return true
+	}
+	return false
+}
+
+func sameHunk(a, b hunk) bool {
+	if a.Start != b.Start || a.End != b.End || len(a.Lines) != len(b.Lines) {
+		return false
+	}
+	for i := range a.Lines {
+		if a.Lines[i] != b.Lines[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge3 performs a line-level three-way merge of local and remote against
+// their common ancestor base. Changes made on only one side are taken as is,
+// identical changes on both sides are taken once, and ok is false when the
+// two sides changed overlapping regions differently.
+func Merge3(base, local, remote string) (merged string, ok bool) {
+	if local == remote {
+		return local, true
+	}
+	if local == base {
+		return remote, true
+	}
+	if remote == base {
+		return local, true
+	}
+	baseLines := splitLines(base)
+	all := append(hunksFrom(diffLines(baseLines, splitLines(local)), 0), hunksFrom(diffLines(baseLines, splitLines(remote)), 1)...)
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Start != all[j].Start {
+			return all[i].Start < all[j].Start
+		}
+		return all[i].side < all[j].side
+	})
+
+	// Group hunks that touch each other and decide each group on its own.
+	var chosen []hunk
+	for i := 0; i < len(all); {
+		group := []hunk{all[i]}
+		end := all[i].End
+		j := i + 1
+		for j < len(all) {
+			overlaps := all[j].Start < end
+			for _, g := range group {
+				if hunksOverlap(g, all[j]) {
+					overlaps = true
+					break
+				}
+			}
+			if !overlaps {
+				break
+			}
+			group = append(group, all[j])
+			end = max(end, all[j].End)
+			j++
+		}
+		i = j
+
+		sides := map[int][]hunk{}
+		for _, h := range group {
+			sides[h.side] = append(sides[h.side], h)
+		}
+		if len(sides) == 1 {
+			chosen = append(chosen, group...)
+			continue
+		}
+		if len(sides[0]) != len(sides[1]) {
+			return "", false
+		}
+		for k := range sides[0] {
+			if !sameHunk(sides[0][k], sides[1][k]) {
+				return "", false
+			}
+		}
+		chosen = append(chosen, sides[0]...)
+	}
+
+	var out []string
+	pos := 0
+	for _, h := range chosen {
+		out = append(out, baseLines[pos:h.Start]...)
+		out = append(out, h.Lines...)
+		pos = h.End
+	}
+	out = append(out, baseLines[pos:]...)
+	merged = strings.Join(out, "\n")
+	if len(out) > 0 && (strings.HasSuffix(local, "\n") || strings.HasSuffix(remote, "\n")) {
+		merged += "\n"
+	}
+	return merged, true
+}
@@ -0,0 +1,49 @@
+package syncer
+
+import "testing"
+
+func TestMerge3CombinesDisjointEdits(t *testing.T) {
+	base := "# Guide\n\nintro\n\n## Setup\nstep one\n\n## Usage\nrun it\n"
+	local := "# Guide\n\nintro, revised\n\n## Setup\nstep one\n\n## Usage\nrun it\n"
+	remote := "# Guide\n\nintro\n\n## Setup\nstep one\n\n## Usage\nrun it\nrun it again\n"
+	got, ok := Merge3(base, local, remote)
+	if !ok {
+		t.Fatalf("expected clean merge")
+	}
+	want := "# Guide\n\nintro, revised\n\n## Setup\nstep one\n\n## Usage\nrun it\nrun it again\n"
+	if got != want {
+		t.Fatalf("unexpected merge:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestMerge3TakesIdenticalChangesOnce(t *testing.T) {
+	base := "a\nb\nc\n"
+	both := "a\nB\nc\n"
+	got, ok := Merge3(base, both, both)
+	if !ok || got != both {
+		t.Fatalf("expected %q, got %q ok=%v", both, got, ok)
+	}
+	got, ok = Merge3(base, "a\nB\nc\nd\n", "x\na\nB\nc\n")
+	if !ok || got != "x\na\nB\nc\nd\n" {
+		t.Fatalf("unexpected merge %q ok=%v", got, ok)
+	}
+}
+
+func TestMerge3ReportsOverlappingHunks(t *testing.T) {
+	base := "a\nb\nc\n"
+	if _, ok := Merge3(base, "a\nlocal\nc\n", "a\nremote\nc\n"); ok {
+		t.Fatalf("expected overlapping edits to conflict")
+	}
+	if _, ok := Merge3(base, "a\nb\nlocal\nc\n", "a\nb\nremote\nc\n"); ok {
+		t.Fatalf("expected insertions at the same point to conflict")
+	}
+}
+
+func TestMerge3OneSidedChange(t *testing.T) {
+	if got, ok := Merge3("a\n", "a\n", "a\nb\n"); !ok || got != "a\nb\n" {
+		t.Fatalf("expected remote change, got %q", got)
+	}
+	if got, ok := Merge3("a\n", "", "a\n"); !ok || got != "" {
+		t.Fatalf("expected local deletion, got %q", got)
+	}
+}
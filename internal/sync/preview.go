@@ -0,0 +1,292 @@
+package syncer
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"opencortex/internal/model"
+)
+
+// Kinds of change a preview reports.
+const (
+	ChangeCreate   = "create"
+	ChangeUpdate   = "update"
+	ChangeDelete   = "delete"
+	ChangeConflict = "conflict"
+	// ChangeExcluded marks knowledge the remote's filter holds back.
+	ChangeExcluded = "excluded"
+)
+
+// PlannedChange is one entity a push or pull would write on the receiving
+// side. For a conflict, Resolution is the outcome the remote's strategy
+// would pick, from this node's point of view; manual means it would be left
+// open. Redacted lists the fields and metadata keys the remote's filter
+// blanks out or strips from what is sent.
+type PlannedChange struct {
+	EntityType string   `json:"entity_type"`
+	ID         string   `json:"id"`
+	Title      string   `json:"title,omitempty"`
+	Action     string   `json:"action"`
+	Resolution Strategy `json:"resolution,omitempty"`
+	Redacted   []string `json:"redacted,omitempty"`
+}
+
+// Preview is what a push or pull would change, worked out by asking the
+// remote but writing nothing on either side.
+type Preview struct {
+	Remote    string              `json:"remote"`
+	Direction model.SyncDirection `json:"direction"`
+	Creates   int                 `json:"creates"`
+	Updates   int                 `json:"updates"`
+	Deletes   int                 `json:"deletes"`
+	Conflicts int                 `json:"conflicts"`
+	Excluded  int                 `json:"excluded"`
+	Changes   []PlannedChange     `json:"changes"`
+}
+
+func (p *Preview) add(c PlannedChange) {
+	switch c.Action {
+	case ChangeCreate:
+		p.Creates++
+	case ChangeUpdate:
+		p.Updates++
+	case ChangeDelete:
+		p.Deletes++
+	case ChangeConflict:
+		p.Conflicts++
+	case ChangeExcluded:
+		p.Excluded++
+	default:
+		return
+	}
+	p.Changes = append(p.Changes, c)
+}
+
+// Preview works out what a push or pull with the remote would change. It
+// runs the same diff as the sync, then compares the copies each side holds
+// of what would be sent: the remote's are fetched, not written.
+func (e *Engine) Preview(ctx context.Context, direction model.SyncDirection, remoteName, apiKey string, opts SyncOptions) (Preview, error) {
+	manifest, _, strategy, err := e.Store.GetRemoteWithAuth(ctx, remoteName)
+	if err != nil {
+		return Preview{}, err
+	}
+	opts = opts.withDefaults(manifest)
+	key, err := e.apiKey(ctx, remoteName, apiKey)
+	if err == nil && key == "" {
+		err = fmt.Errorf("remote api key is required for %s", direction)
+	}
+	if err != nil {
+		return Preview{}, err
+	}
+	peer, err := e.peer(ctx, manifest, key)
+	if err != nil {
+		return Preview{}, err
+	}
+	since, _ := opts.cursors(manifest)
+	items, err := BuildManifestSince(ctx, e.DB, opts.Scope, opts.Selection, since)
+	if err != nil {
+		return Preview{}, err
+	}
+	diffRes, err := e.diff(ctx, manifest, peer, opts, items)
+	if err != nil {
+		e.auditRejection(ctx, manifest, err)
+		return Preview{}, err
+	}
+	fetch := func(items []ManifestItem) ([]Entity, error) {
+		size := max(e.BatchSize, 1)
+		var out []Entity
+		for n := 1; n <= batchCount(len(items), size); n++ {
+			got, err := e.Transport.Pull(ctx, peer, PullRequest{
+				Remote:        remoteName,
+				Scope:         string(opts.Scope),
+				CollectionIDs: opts.CollectionIDs,
+				TopicIDs:      opts.TopicIDs,
+				Items:         batchOf(items, n, size),
+			})
+			if err != nil {
+				e.auditRejection(ctx, manifest, err)
+				return nil, err
+			}
+			out = append(out, got...)
+		}
+		return out, nil
+	}
+
+	out := Preview{Remote: remoteName, Direction: direction, Changes: []PlannedChange{}}
+	var incoming, existing []Entity
+	var tombstones []model.Tombstone
+	titles, redacted := map[string]string{}, map[string][]string{}
+	// The receiver's strategy sees our side as remote on a pull and as
+	// local on a push.
+	receiver := Strategy(strategy)
+	if direction == model.SyncDirectionPush {
+		receiver = receiver.Mirror()
+		if tombstones, err = e.Store.ListTombstones(ctx, since, ScopeEntityTypes(opts.Scope, opts.Selection)); err != nil {
+			return Preview{}, err
+		}
+		if incoming, err = e.LoadEntities(ctx, diffRes.Have); err != nil {
+			return Preview{}, err
+		}
+		// Titles are shown as they are here, not as redacted.
+		kept := incoming[:0]
+		for _, ent := range incoming {
+			k, title := ent.EntityType+":"+ent.ID, entityTitle(&ent)
+			if keep, fields := FilterEntity(opts.Filter, &ent); keep {
+				titles[k], redacted[k] = title, fields
+				kept = append(kept, ent)
+			}
+		}
+		incoming = kept
+		if err := e.previewExcluded(ctx, &out, opts, since, items); err != nil {
+			return Preview{}, err
+		}
+		if existing, err = fetch(slices.Concat(diffRes.Have, tombstoneItems(tombstones))); err != nil {
+			return Preview{}, err
+		}
+	} else {
+		tombstones = diffRes.Tombstones
+		need, err := e.withoutLocalCopies(ctx, diffRes.Need)
+		if err == nil {
+			need, err = dropProtected(ctx, e.DB, opts.Filter, need)
+		}
+		if err != nil {
+			return Preview{}, err
+		}
+		if incoming, err = fetch(need); err != nil {
+			return Preview{}, err
+		}
+		if existing, err = e.LoadEntities(ctx, slices.Concat(need, tombstoneItems(tombstones))); err != nil {
+			return Preview{}, err
+		}
+	}
+
+	held := make(map[string]*Entity, len(existing))
+	for i := range existing {
+		held[existing[i].EntityType+":"+existing[i].ID] = &existing[i]
+	}
+	for _, t := range tombstones {
+		ent, ok := held[t.EntityType+":"+t.EntityID]
+		// Knowledge edited after its deletion is kept.
+		if !ok || ent.Knowledge != nil && ent.Knowledge.UpdatedAt.After(t.DeletedAt) {
+			continue
+		}
+		out.add(PlannedChange{EntityType: t.EntityType, ID: t.EntityID, Title: entityTitle(ent), Action: ChangeDelete})
+	}
+	for _, ent := range incoming {
+		action, resolution := predictChange(ent, held[ent.EntityType+":"+ent.ID], receiver)
+		if direction == model.SyncDirectionPush {
+			resolution = resolution.Mirror()
+		}
+		out.add(PlannedChange{
+			EntityType: ent.EntityType,
+			ID:         ent.ID,
+			Title:      cmp.Or(titles[ent.EntityType+":"+ent.ID], entityTitle(&ent)),
+			Action:     action,
+			Resolution: resolution,
+			Redacted:   redacted[ent.EntityType+":"+ent.ID],
+		})
+	}
+	return out, nil
+}
+
+// previewExcluded lists the knowledge changed since the push cursor that the
+// remote's filter holds back; sent lists what the push would consider.
+func (e *Engine) previewExcluded(ctx context.Context, out *Preview, opts SyncOptions, since int64, sent []ManifestItem) error {
+	if filterEmpty(opts.Filter) {
+		return nil
+	}
+	unfiltered := opts.Selection
+	unfiltered.Filter = model.SyncFilter{}
+	all, err := BuildManifestSince(ctx, e.DB, opts.Scope, unfiltered, since)
+	if err != nil {
+		return err
+	}
+	considered := make(map[string]bool, len(sent))
+	for _, it := range sent {
+		considered[it.EntityType+":"+it.ID] = true
+	}
+	var held []ManifestItem
+	for _, it := range all {
+		if it.EntityType == "knowledge" && !considered["knowledge:"+it.ID] {
+			held = append(held, it)
+		}
+	}
+	entities, err := e.LoadEntities(ctx, held)
+	if err != nil {
+		return err
+	}
+	for _, ent := range entities {
+		out.add(PlannedChange{EntityType: ent.EntityType, ID: ent.ID, Title: entityTitle(&ent), Action: ChangeExcluded})
+	}
+	return nil
+}
+
+// predictChange tells what applying incoming over the receiver's existing
+// copy would do under the receiver's strategy, following applyEntityTx. An
+// empty action means the receiver would keep its copy untouched.
+func predictChange(incoming Entity, existing *Entity, strategy Strategy) (string, Strategy) {
+	if existing == nil {
+		return ChangeCreate, ""
+	}
+	if incoming.Message != nil || simpleChecksum(entityText(existing)) == simpleChecksum(entityText(&incoming)) {
+		return "", ""
+	}
+	switch versionOrder(existing.ManifestItem, incoming.ManifestItem) {
+	case After, Equal:
+		return "", ""
+	case Before:
+		return ChangeUpdate, ""
+	}
+	var outcome Strategy
+	switch {
+	case incoming.Topic != nil:
+		outcome = resolveOutcome(strategy, ConflictInput{})
+		if outcome == StrategyFork {
+			outcome = StrategyLocalWins
+		}
+	case incoming.Collection != nil:
+		outcome = resolveOutcome(strategy, ConflictInput{LocalUpdatedAt: entityUpdatedAt(existing), RemoteUpdatedAt: entityUpdatedAt(&incoming)})
+		switch outcome {
+		case StrategyFork:
+			outcome = StrategyLocalWins
+		case StrategyMerge:
+			outcome = StrategyManual
+		}
+	default:
+		outcome = resolveOutcome(strategy, ConflictInput{LocalUpdatedAt: entityUpdatedAt(existing), RemoteUpdatedAt: entityUpdatedAt(&incoming)})
+	}
+	return ChangeConflict, outcome
+}
+
+func tombstoneItems(tombstones []model.Tombstone) []ManifestItem {
+	out := make([]ManifestItem, 0, len(tombstones))
+	for _, t := range tombstones {
+		out = append(out, ManifestItem{EntityType: t.EntityType, ID: t.EntityID})
+	}
+	return out
+}
+
+// entityTitle names an entity for people: its title or name, or the first
+// line of a message.
+func entityTitle(ent *Entity) string {
+	switch {
+	case ent == nil:
+		return ""
+	case ent.Collection != nil:
+		return ent.Collection.Name
+	case ent.Knowledge != nil:
+		return ent.Knowledge.Title
+	case ent.Topic != nil:
+		return ent.Topic.Name
+	case ent.Message != nil:
+		line, _, _ := strings.Cut(strings.TrimSpace(ent.Message.Content), "\n")
+		if r := []rune(line); len(r) > 80 {
+			line = string(r[:80]) + "…"
+		}
+		return line
+	}
+	return ""
+}
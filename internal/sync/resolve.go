@@ -0,0 +1,276 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"opencortex/internal/model"
+)
+
+// ResolveConflict settles a recorded conflict. The remote copy is fetched
+// again when the strategy needs it, the chosen outcome is written locally as a
+// new version whose change note names the conflict, and the conflict row keeps
+// both checksums, both payloads and a diff of what was chosen between.
+func (e *Engine) ResolveConflict(ctx context.Context, conflictID string, strategy string, note string, apiKey string) (model.SyncConflict, error) {
+	chosen := Strategy(strategy)
+	switch chosen {
+	case StrategyLocalWins, StrategyRemoteWins, StrategyLatestWins, StrategyFork, StrategyManual, StrategyMerge:
+	default:
+		return model.SyncConflict{}, fmt.Errorf("invalid strategy")
+	}
+	conflict, err := e.Store.GetSyncConflict(ctx, conflictID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.SyncConflict{}, fmt.Errorf("conflict not found")
+	}
+	if err != nil {
+		return model.SyncConflict{}, err
+	}
+	if conflict.Status == "resolved" {
+		return model.SyncConflict{}, fmt.Errorf("conflict already resolved")
+	}
+	item := ManifestItem{EntityType: conflict.EntityType, ID: conflict.EntityID}
+
+	var local *Entity
+	locals, err := e.LoadEntities(ctx, []ManifestItem{item})
+	if err != nil {
+		return model.SyncConflict{}, err
+	}
+	if len(locals) > 0 {
+		local = &locals[0]
+	}
+
+	var remote *Entity
+	if chosen != StrategyLocalWins && chosen != StrategyManual {
+		manifest, err := e.Store.ManifestByID(ctx, conflict.ManifestID)
+		if err != nil {
+			return model.SyncConflict{}, err
+		}
+		remote, err = e.fetchRemoteCopy(ctx, manifest, conflict, item, apiKey)
+		if err != nil {
+			return model.SyncConflict{}, err
+		}
+	}
+
+	localChecksum, remoteChecksum := conflict.LocalChecksum, conflict.RemoteChecksum
+	if local != nil {
+		localChecksum = simpleChecksum(entityText(local))
+	}
+	if remote != nil {
+		remoteChecksum = simpleChecksum(entityText(remote))
+	}
+	diff := conflict.Diff
+	if local != nil && remote != nil {
+		diff = UnifiedDiff("local", "remote", entityText(local), entityText(remote))
+	}
+	if err := e.Store.SetSyncConflictEvidence(ctx, conflict.ID, localChecksum, remoteChecksum,
+		firstPayload(entityPayload(local), conflict.LocalPayload),
+		firstPayload(entityPayload(remote), conflict.RemotePayload),
+		diff,
+	); err != nil {
+		return model.SyncConflict{}, err
+	}
+
+	outcome := chosen
+	if chosen == StrategyLatestWins {
+		in := ConflictInput{}
+		if local != nil {
+			in.LocalUpdatedAt = entityUpdatedAt(local)
+		}
+		if remote != nil {
+			in.RemoteUpdatedAt = entityUpdatedAt(remote)
+		}
+		outcome = resolveOutcome(chosen, in)
+	}
+	var winner *Entity
+	switch outcome {
+	case StrategyRemoteWins, StrategyFork:
+		if remote == nil {
+			return model.SyncConflict{}, errors.New("remote copy no longer exists")
+		}
+		winner = remote
+	case StrategyLocalWins:
+		winner = local
+	case StrategyMerge:
+		winner, err = e.mergeResolution(ctx, conflict, local, remote)
+		if err != nil {
+			return model.SyncConflict{}, err
+		}
+	}
+	changeNote := fmt.Sprintf("resolved sync conflict %s with %s", conflict.ID, outcome)
+	if note != "" {
+		changeNote += ": " + note
+	}
+	if err := e.applyResolution(ctx, outcome, winner, changeNote); err != nil {
+		return model.SyncConflict{}, err
+	}
+	// A decision other than a fork supersedes both copies on every node.
+	if winner != nil && outcome != StrategyFork && remote != nil && len(remote.Vector) > 0 {
+		if err := e.includeVersion(ctx, item, remote.Vector); err != nil {
+			return model.SyncConflict{}, err
+		}
+	}
+	if outcome == StrategyRemoteWins && remote != nil && remote.EntityType == "knowledge" {
+		e.recordAgreed(ctx, conflict.ManifestID, map[string]string{remote.ID: remoteChecksum})
+	}
+	if err := e.Store.ResolveConflict(ctx, conflict.ID, string(outcome), note); err != nil {
+		return model.SyncConflict{}, err
+	}
+	return e.Store.GetSyncConflict(ctx, conflict.ID)
+}
+
+// fetchRemoteCopy fetches the remote's current copy of a conflicting entity.
+// Remotes fed by bundles cannot be asked again, so their copy is the one the
+// conflict recorded.
+func (e *Engine) fetchRemoteCopy(ctx context.Context, manifest model.SyncManifest, conflict model.SyncConflict, item ManifestItem, apiKey string) (*Entity, error) {
+	if IsBundleRemote(manifest) {
+		return storedEntity(conflict.EntityType, conflict.EntityID, conflict.RemotePayload)
+	}
+	apiKey, err := e.apiKey(ctx, manifest.RemoteName, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, errors.New("remote api key is required to fetch the remote copy")
+	}
+	peer, err := e.peer(ctx, manifest, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	remotes, err := e.Transport.Pull(ctx, peer, PullRequest{
+		Remote: manifest.RemoteName,
+		Scope:  string(manifest.Scope),
+		Items:  []ManifestItem{item},
+	})
+	if err != nil {
+		e.auditRejection(ctx, manifest, err)
+		return nil, err
+	}
+	if len(remotes) == 0 {
+		return nil, nil
+	}
+	return &remotes[0], nil
+}
+
+// mergeResolution three-way merges the remote copy into the local one using
+// the checksum last agreed with the conflict's remote as the ancestor.
+func (e *Engine) mergeResolution(ctx context.Context, conflict model.SyncConflict, local, remote *Entity) (*Entity, error) {
+	if local == nil || remote == nil || local.Knowledge == nil || remote.Knowledge == nil {
+		return nil, errors.New("merge needs a local and a remote knowledge entry")
+	}
+	agreed, err := e.Store.SyncedChecksums(ctx, conflict.ManifestID, conflict.EntityType)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	merged, ok, err := e.mergeKnowledgeTx(ctx, tx, *local.Knowledge, remote.Knowledge.Content, agreed[conflict.EntityID])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("cannot merge: no common ancestor or overlapping changes")
+	}
+	entry := *remote.Knowledge
+	entry.Content = merged
+	return &Entity{ManifestItem: remote.ManifestItem, Knowledge: &entry}, nil
+}
+
+// applyResolution writes the winning copy. Resolved writes are stamped with
+// the current time so the decision propagates on the next latest-wins sync.
+func (e *Engine) applyResolution(ctx context.Context, outcome Strategy, winner *Entity, changeNote string) error {
+	if winner == nil {
+		return nil
+	}
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	switch {
+	case winner.Knowledge != nil:
+		entry := *winner.Knowledge
+		entry.UpdatedAt = time.Now().UTC()
+		if outcome == StrategyFork {
+			_, err = e.forkKnowledgeTx(ctx, tx, "conflict resolution", entry, simpleChecksum(entry.Content))
+		} else {
+			err = e.Store.UpsertSyncedKnowledgeTx(ctx, tx, entry, changeNote)
+		}
+	case winner.Topic != nil && outcome == StrategyRemoteWins:
+		err = e.Store.UpsertSyncedTopicTx(ctx, tx, *winner.Topic)
+	case winner.Collection != nil && outcome == StrategyRemoteWins:
+		c := *winner.Collection
+		c.UpdatedAt = time.Now().UTC()
+		err = e.Store.UpsertSyncedCollectionTx(ctx, tx, c)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordConflicts stores the conflicts reported by an apply as sync_conflicts
+// rows from this node's point of view. counterpart holds the bodies of the
+// side that did not apply: what we sent on push, what we received on pull.
+// Conflicts a strategy already settled are stored as resolved so the decision
+// stays auditable.
+func (e *Engine) recordConflicts(ctx context.Context, manifestID, strategy string, direction model.SyncDirection, res ApplyResult, counterpart []Entity) {
+	bodies := make(map[string]*Entity, len(counterpart))
+	for i := range counterpart {
+		bodies[counterpart[i].EntityType+":"+counterpart[i].ID] = &counterpart[i]
+	}
+	record := func(c ApplyConflict) (model.SyncConflict, error) {
+		localSum, remoteSum := c.ExistingChecksum, c.IncomingChecksum
+		local, remote := c.Existing, bodies[c.EntityType+":"+c.ID]
+		if direction == model.SyncDirectionPush {
+			// The peer applied the push, so its existing copy is our remote side.
+			localSum, remoteSum = remoteSum, localSum
+			local, remote = remote, local
+		}
+		diff := ""
+		if local != nil && remote != nil {
+			diff = UnifiedDiff("local", "remote", entityText(local), entityText(remote))
+		}
+		return e.Store.CreateSyncConflict(ctx, manifestID, c.EntityType, c.ID, localSum, remoteSum, strategy, entityPayload(local), entityPayload(remote), diff)
+	}
+	for _, c := range res.Conflicts {
+		_, _ = record(c)
+	}
+	for _, c := range res.Resolved {
+		row, err := record(c)
+		if err != nil {
+			continue
+		}
+		outcome := c.Resolution
+		if direction == model.SyncDirectionPush {
+			outcome = outcome.Mirror()
+		}
+		_ = e.Store.ResolveConflict(ctx, row.ID, string(outcome), fmt.Sprintf("resolved automatically during %s by %s", direction, strategy))
+	}
+}
+
+func entityUpdatedAt(ent *Entity) time.Time {
+	switch {
+	case ent.Collection != nil:
+		return ent.Collection.UpdatedAt
+	case ent.Knowledge != nil:
+		return ent.Knowledge.UpdatedAt
+	case ent.Topic != nil:
+		return ent.Topic.CreatedAt
+	case ent.Message != nil:
+		return ent.Message.CreatedAt
+	}
+	return time.Time{}
+}
+
+func firstPayload(a, b map[string]any) map[string]any {
+	if a != nil {
+		return a
+	}
+	return b
+}
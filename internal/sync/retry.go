@@ -0,0 +1,79 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy describes exponential backoff with jitter. Attempts bounds the
+// tries of a single request, the first included.
+type RetryPolicy struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+// Delay returns the wait before retry n, counting from 1. The delay doubles
+// with each retry up to Max and is drawn from its upper half so that peers
+// failing together do not retry in lockstep.
+func (p RetryPolicy) Delay(n int) time.Duration {
+	if p.Base <= 0 {
+		return 0
+	}
+	d := p.Base
+	for i := 1; i < n && (p.Max <= 0 || d < p.Max); i++ {
+		d *= 2
+	}
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int64N(int64(d-half)+1))
+}
+
+// statusError is an HTTP error status returned by a peer.
+type statusError struct {
+	Code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("remote status %d", e.Code)
+}
+
+// remoteError is an error the peer reported in its response envelope.
+type remoteError struct {
+	Detail any
+}
+
+func (e remoteError) Error() string {
+	return fmt.Sprintf("remote error: %v", e.Detail)
+}
+
+// retryable reports whether a failed request may succeed if sent again:
+// network failures, truncated responses, throttling and server errors.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrBadSignature) {
+		return false
+	}
+	var status statusError
+	if errors.As(err, &status) {
+		return status.Code == 429 || status.Code >= 500
+	}
+	var remote remoteError
+	return !errors.As(err, &remote)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,50 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelayGrowsWithJitterUpToMax(t *testing.T) {
+	p := RetryPolicy{Base: 100 * time.Millisecond, Max: time.Second}
+	for n, ceiling := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	} {
+		for i := 0; i < 50; i++ {
+			d := p.Delay(n)
+			if d < ceiling/2 || d > ceiling {
+				t.Fatalf("Delay(%d) = %v, want within [%v, %v]", n, d, ceiling/2, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryableErrors(t *testing.T) {
+	ctx := context.Background()
+	cases := map[string]struct {
+		err  error
+		want bool
+	}{
+		"network":      {errors.New("connection reset by peer"), true},
+		"unavailable":  {statusError{Code: 503}, true},
+		"throttled":    {statusError{Code: 429}, true},
+		"unauthorized": {statusError{Code: 401}, false},
+		"rejected":     {remoteError{Detail: "invalid request body"}, false},
+	}
+	for name, tc := range cases {
+		if got := retryable(ctx, tc.err); got != tc.want {
+			t.Fatalf("%s: retryable = %v, want %v", name, got, tc.want)
+		}
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if retryable(canceled, errors.New("connection reset by peer")) {
+		t.Fatal("expected no retry once the context is done")
+	}
+}
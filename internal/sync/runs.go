@@ -0,0 +1,114 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"opencortex/internal/model"
+	"opencortex/internal/storage/repos"
+)
+
+// runPlan is what a push or pull exchanges, saved before the first batch so
+// an interrupted run resumes after its last acknowledged batch instead of
+// starting over.
+type runPlan struct {
+	Items      []ManifestItem    `json:"items"`
+	Tombstones []model.Tombstone `json:"tombstones,omitempty"`
+	// Unchanged holds the knowledge checksums both sides already agreed on.
+	Unchanged map[string]string `json:"unchanged,omitempty"`
+}
+
+// resumeRun returns the unfinished run for the remote and direction when it
+// was planned for the same scope. A zero run means there is none to resume.
+func (e *Engine) resumeRun(ctx context.Context, manifest model.SyncManifest, direction model.SyncDirection, opts SyncOptions) (repos.SyncRun, runPlan, error) {
+	if opts.Force {
+		return repos.SyncRun{}, runPlan{}, nil
+	}
+	run, err := e.Store.GetSyncRun(ctx, manifest.ID, direction)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repos.SyncRun{}, runPlan{}, nil
+	}
+	if err != nil {
+		return repos.SyncRun{}, runPlan{}, err
+	}
+	var plan runPlan
+	if run.ScopeKey != opts.scopeKey() || run.BatchSize <= 0 || json.Unmarshal([]byte(run.Plan), &plan) != nil {
+		return repos.SyncRun{}, runPlan{}, nil
+	}
+	return run, plan, nil
+}
+
+// startRun saves a new plan. cursor is where the remote's cursor moves once
+// every batch is acknowledged; zero leaves it unchanged.
+func (e *Engine) startRun(ctx context.Context, manifest model.SyncManifest, direction model.SyncDirection, opts SyncOptions, plan runPlan, cursor int64) (repos.SyncRun, error) {
+	body, err := json.Marshal(plan)
+	if err != nil {
+		return repos.SyncRun{}, err
+	}
+	run := repos.SyncRun{
+		ManifestID: manifest.ID,
+		Direction:  direction,
+		ScopeKey:   opts.scopeKey(),
+		Plan:       string(body),
+		Cursor:     cursor,
+		BatchSize:  max(e.BatchSize, 1),
+		StartedAt:  time.Now().UTC(),
+	}
+	return run, e.Store.StartSyncRun(ctx, run)
+}
+
+// finish records a run whose batches were all acknowledged.
+func (e *Engine) finish(ctx context.Context, manifest model.SyncManifest, logID string, run repos.SyncRun, pushed, pulled, conflicts int) (model.SyncLog, error) {
+	if run.Cursor > 0 {
+		_ = e.Store.UpdateManifestCursor(ctx, manifest.ID, run.Direction, run.ScopeKey, run.Cursor)
+	}
+	_ = e.Store.FinishSyncRun(ctx, manifest.ID, run.Direction)
+	status := model.SyncStatusSuccess
+	if conflicts > 0 {
+		status = model.SyncStatusPartial
+	}
+	_ = e.Store.CompleteSyncLog(ctx, logID, status, pushed, pulled, conflicts, nil)
+	_ = e.Store.UpdateManifestSyncResult(ctx, manifest.ID, true)
+	return e.Store.GetSyncLog(ctx, logID)
+}
+
+// fail records a failed run and schedules the next retry of the remote with
+// exponential backoff. Acknowledged batches stay committed for the retry.
+func (e *Engine) fail(ctx context.Context, manifest model.SyncManifest, logID string, pushed, pulled, conflicts int, err error) (model.SyncLog, error) {
+	e.auditRejection(ctx, manifest, err)
+	msg := err.Error()
+	_ = e.Store.CompleteSyncLog(ctx, logID, model.SyncStatusFailed, pushed, pulled, conflicts, &msg)
+	next := time.Now().Add(e.Backoff.Delay(manifest.Failures + 1))
+	_ = e.Store.RecordSyncFailure(ctx, manifest.ID, msg, next)
+	return model.SyncLog{}, err
+}
+
+// auditRejection records a peer answer refused for its signature.
+func (e *Engine) auditRejection(ctx context.Context, manifest model.SyncManifest, err error) {
+	if !errors.Is(err, ErrBadSignature) {
+		return
+	}
+	_ = e.Store.AddAuditLog(ctx, model.AuditLog{
+		ID:         uuid.NewString(),
+		Action:     "sync.signature_rejected",
+		Resource:   "sync_manifest",
+		ResourceID: &manifest.ID,
+		Metadata:   map[string]any{"remote": manifest.RemoteName, "direction": "outbound", "error": err.Error()},
+		CreatedAt:  time.Now().UTC(),
+	})
+}
+
+func batchCount(n, size int) int {
+	return (n + size - 1) / size
+}
+
+// batchOf returns batch n, counting from 1, of items split into size.
+func batchOf(items []ManifestItem, n, size int) []ManifestItem {
+	start := min((n-1)*size, len(items))
+	return items[start:min(start+size, len(items))]
+}
@@ -4,86 +4,164 @@ import (
 	"context"
 	"log"
 	"sync"
+	"time"
 
-	"github.com/robfig/cron/v3"
-
-	"opencortex/internal/config"
-	"opencortex/internal/model"
+	"opencortex/internal/schedule"
 )
 
+// Scheduler runs push and pull for every stored remote that has a schedule,
+// and keeps a live link open to every live one. Remotes come from
+// sync_manifests, so Reload picks up remotes added, changed or removed at
+// runtime.
 type Scheduler struct {
-	cron     *cron.Cron
+	jobs     *schedule.Jobs
 	engine   *Engine
 	mu       sync.Mutex
 	inflight map[string]struct{}
+	retries  map[string]*time.Timer
+	links    map[string]liveLink
+	stopped  bool
+}
+
+type liveLink struct {
+	spec   string
+	cancel context.CancelFunc
 }
 
 func NewScheduler(engine *Engine) *Scheduler {
-	return &Scheduler{
-		cron:     cron.New(),
+	s := &Scheduler{
 		engine:   engine,
 		inflight: map[string]struct{}{},
+		retries:  map[string]*time.Timer{},
+		links:    map[string]liveLink{},
 	}
+	s.jobs = schedule.New("sync schedule", s.runRemote)
+	return s
 }
 
-func (s *Scheduler) Register(remotes []config.Remote) error {
+// Reload schedules the stored remotes, replacing entries whose schedule
+// changed and dropping those of remotes that are gone or unscheduled. Live
+// links are started, restarted or stopped the same way.
+func (s *Scheduler) Reload(ctx context.Context) error {
+	remotes, err := s.engine.Store.ListRemotes(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	want := make(map[string]string, len(remotes))
+	live := map[string]string{}
 	for _, r := range remotes {
-		spec := r.Sync.Schedule
-		if spec == "" {
-			continue
+		if r.Schedule != nil && *r.Schedule != "" {
+			want[r.RemoteName] = *r.Schedule
 		}
-		remote := r
-		_, err := s.cron.AddFunc(spec, func() {
-			s.runRemote(remote)
-		})
-		if err != nil {
-			return err
+		if r.Live && !IsBundleRemote(r) {
+			live[r.RemoteName] = r.RemoteURL + " " + string(r.Direction)
 		}
 	}
+	s.reloadLinks(live)
+	s.jobs.Set(want)
 	return nil
 }
 
+// Watch reloads whenever changed fires, until ctx is done.
+func (s *Scheduler) Watch(ctx context.Context, changed <-chan struct{}) {
+	s.jobs.Watch(ctx, changed, s.Reload)
+}
+
 func (s *Scheduler) Start() {
-	s.cron.Start()
+	s.mu.Lock()
+	s.stopped = false
+	s.mu.Unlock()
+	s.jobs.Start()
 }
 
 func (s *Scheduler) Stop() context.Context {
-	return s.cron.Stop()
+	s.mu.Lock()
+	s.stopped = true
+	for name, timer := range s.retries {
+		timer.Stop()
+		delete(s.retries, name)
+	}
+	for name, link := range s.links {
+		link.cancel()
+		delete(s.links, name)
+	}
+	s.mu.Unlock()
+	return s.jobs.Stop()
+}
+
+// retryAt runs the remote again at its next retry time, ahead of its next
+// scheduled run.
+func (s *Scheduler) retryAt(name string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if timer, ok := s.retries[name]; ok {
+		timer.Stop()
+	}
+	s.retries[name] = time.AfterFunc(time.Until(at), func() {
+		s.mu.Lock()
+		delete(s.retries, name)
+		s.mu.Unlock()
+		s.runRemote(name)
+	})
 }
 
-func (s *Scheduler) runRemote(remote config.Remote) {
+func (s *Scheduler) runRemote(name string) {
 	s.mu.Lock()
-	if _, ok := s.inflight[remote.Name]; ok {
+	if _, ok := s.inflight[name]; ok {
 		s.mu.Unlock()
 		return
 	}
-	s.inflight[remote.Name] = struct{}{}
+	s.inflight[name] = struct{}{}
 	s.mu.Unlock()
 	defer func() {
 		s.mu.Lock()
-		delete(s.inflight, remote.Name)
+		delete(s.inflight, name)
 		s.mu.Unlock()
 	}()
 
-	scope := model.SyncScope(remote.Sync.Scope)
-	if scope == "" {
-		scope = model.SyncScopeFull
+	ctx := context.Background()
+	remote, err := s.engine.Store.GetRemote(ctx, name)
+	if err != nil {
+		log.Printf("sync remote %s: %v", name, err)
+		return
 	}
-	switch model.SyncDirection(remote.Sync.Direction) {
-	case model.SyncDirectionPush:
-		if _, err := s.engine.Push(context.Background(), remote.Name, remote.Key, scope, remote.Sync.CollectionIDs); err != nil {
-			log.Printf("sync push failed for %s: %v", remote.Name, err)
-		}
-	case model.SyncDirectionPull:
-		if _, err := s.engine.Pull(context.Background(), remote.Name, remote.Key, scope, remote.Sync.CollectionIDs); err != nil {
-			log.Printf("sync pull failed for %s: %v", remote.Name, err)
-		}
-	default:
-		if _, err := s.engine.Push(context.Background(), remote.Name, remote.Key, scope, remote.Sync.CollectionIDs); err != nil {
-			log.Printf("sync push failed for %s: %v", remote.Name, err)
+	// A failing remote is left alone until its backoff has passed.
+	if remote.NextRetryAt != nil && time.Now().Before(*remote.NextRetryAt) {
+		return
+	}
+	if err = s.engine.SyncRemote(ctx, remote); err == nil {
+		return
+	}
+	log.Printf("sync failed for %s: %v", name, err)
+	if remote, err = s.engine.Store.GetRemote(ctx, name); err == nil && remote.NextRetryAt != nil {
+		s.retryAt(name, *remote.NextRetryAt)
+	}
+}
+
+// reloadLinks runs a live link for each remote in want, keyed by name with
+// what the link depends on, restarting those whose remote changed. The
+// caller holds s.mu.
+func (s *Scheduler) reloadLinks(want map[string]string) {
+	for name, link := range s.links {
+		if want[name] != link.spec {
+			link.cancel()
+			delete(s.links, name)
 		}
-		if _, err := s.engine.Pull(context.Background(), remote.Name, remote.Key, scope, remote.Sync.CollectionIDs); err != nil {
-			log.Printf("sync pull failed for %s: %v", remote.Name, err)
+	}
+	if s.stopped {
+		return
+	}
+	for name, spec := range want {
+		if _, ok := s.links[name]; ok {
+			continue
 		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.links[name] = liveLink{spec: spec, cancel: cancel}
+		go s.engine.RunLive(ctx, name)
 	}
 }
@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"reflect"
+
+	"opencortex/internal/auth"
+)
+
+// KeyIDHeader names the key a sync payload is signed with. A payload sent
+// as plain JSON carries its signature in SignatureHeader; a streamed one in
+// its closing frame, since the signature is only known once the last
+// element is written.
+const (
+	KeyIDHeader     = "X-Sync-Key-Id"
+	SignatureHeader = "X-Sync-Signature"
+)
+
+// ErrBadSignature marks a payload rejected because it was not signed by the
+// key pinned for its sender.
+var ErrBadSignature = errors.New("sync payload signature rejected")
+
+// Signed is what a received payload's signature is checked against.
+type Signed struct {
+	KeyID     string
+	Digest    []byte
+	Signature string
+}
+
+// digest hashes a payload frame by frame, so a stream can be hashed as it is
+// written or read and a whole payload gives the same sum.
+type digest struct {
+	h hash.Hash
+}
+
+func newDigest() *digest {
+	return &digest{h: sha256.New()}
+}
+
+func (d *digest) add(field string, value []byte) {
+	d.h.Write([]byte(field))
+	d.h.Write([]byte{0})
+	d.h.Write(value)
+	d.h.Write([]byte{'\n'})
+}
+
+func (d *digest) sum() []byte {
+	return d.h.Sum(nil)
+}
+
+// payloadDigest hashes v as the frames StreamWriter.Write would send.
+func payloadDigest(v any) ([]byte, error) {
+	d := newDigest()
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	b, err := json.Marshal(payloadHead(rv))
+	if err != nil {
+		return nil, err
+	}
+	d.add("", b)
+	for _, f := range streamFields(rv.Type()) {
+		list := rv.Field(f.index)
+		for i := range list.Len() {
+			b, err := json.Marshal(list.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			d.add(f.name, b)
+		}
+	}
+	return d.sum(), nil
+}
+
+// signedMessage binds a digest to its purpose, the endpoint and whether it
+// was the request or the response, so a signed payload is not accepted
+// anywhere else.
+func signedMessage(purpose string, sum []byte) []byte {
+	return []byte("opencortex-sync-v1\n" + purpose + "\n" + hex.EncodeToString(sum))
+}
+
+func signDigest(id auth.Identity, purpose string, sum []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(id.PrivateKey, signedMessage(purpose, sum)))
+}
+
+// SignPayload signs v for purpose.
+func SignPayload(id auth.Identity, purpose string, v any) (string, error) {
+	sum, err := payloadDigest(v)
+	if err != nil {
+		return "", err
+	}
+	return signDigest(id, purpose, sum), nil
+}
+
+// JSONSigned describes a payload received as plain JSON and decoded into v.
+func JSONSigned(keyID, signature string, v any) (Signed, error) {
+	sum, err := payloadDigest(v)
+	if err != nil {
+		return Signed{}, err
+	}
+	return Signed{KeyID: keyID, Digest: sum, Signature: signature}, nil
+}
+
+// VerifyPayload checks a received payload against the pinned public key. An
+// unsigned payload, or one signed with any other key, fails with
+// ErrBadSignature.
+func VerifyPayload(pinned, purpose string, signed Signed) error {
+	pub, err := auth.ParsePublicKey(pinned)
+	if err != nil {
+		return fmt.Errorf("%w: pinned key: %v", ErrBadSignature, err)
+	}
+	if signed.Signature == "" {
+		return fmt.Errorf("%w: payload is not signed", ErrBadSignature)
+	}
+	if signed.KeyID != auth.KeyID(pub) {
+		return fmt.Errorf("%w: signed with key %q, pinned %q", ErrBadSignature, signed.KeyID, auth.KeyID(pub))
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil || !ed25519.Verify(pub, signedMessage(purpose, signed.Digest), sig) {
+		return fmt.Errorf("%w: signature does not match", ErrBadSignature)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"opencortex/internal/auth"
+)
+
+func TestSignedPayloadsVerifyOnlyUnderThePinnedKey(t *testing.T) {
+	key, err := auth.GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := auth.GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := auth.Identity{NodeID: "a", PrivateKey: key}
+	payload := PullResponse{Items: []Entity{{ManifestItem: ManifestItem{EntityType: "knowledge", ID: "k1", Checksum: "c1"}}}}
+
+	// A stream and the same payload sent as JSON carry the same signature.
+	var buf bytes.Buffer
+	s := NewStreamWriter(&buf, false)
+	s.Sign(id, "pull response")
+	if err := s.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.String()
+	var got PullResponse
+	streamed, err := ReadStream(strings.NewReader(raw), &got)
+	if err != nil {
+		t.Fatalf("ReadStream: %v", err)
+	}
+	streamed.KeyID = id.KeyID()
+	if err := VerifyPayload(id.PublicKey(), "pull response", streamed); err != nil {
+		t.Fatalf("expected the stream to verify: %v", err)
+	}
+	sig, err := SignPayload(id, "pull response", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := JSONSigned(id.KeyID(), sig, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyPayload(id.PublicKey(), "pull response", plain); err != nil {
+		t.Fatalf("expected the JSON payload to verify: %v", err)
+	}
+
+	otherID := auth.Identity{PrivateKey: other}
+	tampered, _ := ReadStream(strings.NewReader(strings.Replace(raw, `"c1"`, `"c2"`, 1)), &PullResponse{})
+	tampered.KeyID = id.KeyID()
+	for name, check := range map[string]error{
+		"tampered":      VerifyPayload(id.PublicKey(), "pull response", tampered),
+		"other purpose": VerifyPayload(id.PublicKey(), "push request", streamed),
+		"other key":     VerifyPayload(otherID.PublicKey(), "pull response", streamed),
+		"unsigned":      VerifyPayload(id.PublicKey(), "pull response", Signed{KeyID: id.KeyID(), Digest: streamed.Digest}),
+	} {
+		if !errors.Is(check, ErrBadSignature) {
+			t.Fatalf("%s: expected ErrBadSignature, got %v", name, check)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package syncer
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"opencortex/internal/auth"
+)
+
+// StreamContentType marks a sync payload sent as an NDJSON stream instead of
+// a single JSON document. Peers advertise that they read it by listing it in
+// Accept and answer streamed when the caller does the same, so nodes that
+// predate streaming keep exchanging plain JSON.
+const StreamContentType = "application/x-ndjson"
+
+// streamFrame is one line of a stream. The first frame carries the payload
+// with its list fields left empty, each following frame one element of the
+// list named by Field. A stream ends with an End frame, carrying the
+// signature of a signed stream, or an Error frame when the sender failed
+// part way, so truncation is never taken for success.
+type streamFrame struct {
+	Field     string          `json:"field,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	End       bool            `json:"end,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+}
+
+// StreamWriter writes a payload frame by frame, gzip-compressed when asked.
+type StreamWriter struct {
+	enc    *json.Encoder
+	zw     *gzip.Writer
+	digest *digest
+	// signer and purpose sign the stream when set.
+	signer  *auth.Identity
+	purpose string
+}
+
+func NewStreamWriter(w io.Writer, compress bool) *StreamWriter {
+	s := &StreamWriter{digest: newDigest()}
+	if compress {
+		s.zw = gzip.NewWriter(w)
+		w = s.zw
+	}
+	s.enc = json.NewEncoder(w)
+	return s
+}
+
+// Sign makes Close sign the stream for purpose with id.
+func (s *StreamWriter) Sign(id auth.Identity, purpose string) {
+	s.signer = &id
+	s.purpose = purpose
+}
+
+// Head writes v with its list fields emptied; their elements follow as Elem.
+func (s *StreamWriter) Head(v any) error {
+	return s.frame("", payloadHead(reflect.Indirect(reflect.ValueOf(v))))
+}
+
+// Elem writes one element of the list field named field.
+func (s *StreamWriter) Elem(field string, v any) error {
+	return s.frame(field, v)
+}
+
+// Write writes v whole: its head, then every element of its list fields.
+func (s *StreamWriter) Write(v any) error {
+	if err := s.Head(v); err != nil {
+		return err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	for _, f := range streamFields(rv.Type()) {
+		list := rv.Field(f.index)
+		for i := range list.Len() {
+			if err := s.Elem(f.name, list.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Fail ends the stream with an error the reader returns.
+func (s *StreamWriter) Fail(msg string) error {
+	if err := s.enc.Encode(streamFrame{Error: msg}); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// Close ends the stream.
+func (s *StreamWriter) Close() error {
+	end := streamFrame{End: true}
+	if s.signer != nil {
+		end.Signature = signDigest(*s.signer, s.purpose, s.digest.sum())
+	}
+	if err := s.enc.Encode(end); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *StreamWriter) frame(field string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.digest.add(field, b)
+	return s.enc.Encode(streamFrame{Field: field, Value: b})
+}
+
+func (s *StreamWriter) flush() error {
+	if s.zw != nil {
+		return s.zw.Close()
+	}
+	return nil
+}
+
+// ReadStream decodes a stream into v, a pointer to a struct, appending each
+// element frame to the list field it names. Frames for fields v does not
+// have are skipped so newer senders can add lists. The returned Signed holds
+// the stream's digest and signature; the key id travels in KeyIDHeader.
+func ReadStream(r io.Reader, v any) (Signed, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return Signed{}, fmt.Errorf("stream target must be a struct pointer, got %T", v)
+	}
+	target := rv.Elem()
+	lists := map[string]int{}
+	for _, f := range streamFields(target.Type()) {
+		lists[f.name] = f.index
+	}
+	d := newDigest()
+	dec := json.NewDecoder(r)
+	for first := true; ; first = false {
+		var frame streamFrame
+		if err := dec.Decode(&frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				return Signed{}, io.ErrUnexpectedEOF
+			}
+			return Signed{}, err
+		}
+		if frame.Error == "" && !frame.End {
+			d.add(frame.Field, frame.Value)
+		}
+		switch {
+		case frame.Error != "":
+			return Signed{}, remoteError{Detail: frame.Error}
+		case frame.End:
+			return Signed{Digest: d.sum(), Signature: frame.Signature}, nil
+		case first:
+			if frame.Field != "" {
+				return Signed{}, errors.New("stream does not start with a head frame")
+			}
+			if err := json.Unmarshal(frame.Value, v); err != nil {
+				return Signed{}, err
+			}
+		default:
+			index, ok := lists[frame.Field]
+			if !ok {
+				continue
+			}
+			list := target.Field(index)
+			elem := reflect.New(list.Type().Elem())
+			if err := json.Unmarshal(frame.Value, elem.Interface()); err != nil {
+				return Signed{}, err
+			}
+			list.Set(reflect.Append(list, elem.Elem()))
+		}
+	}
+}
+
+// payloadHead returns a copy of the payload struct rv with its list fields
+// emptied.
+func payloadHead(rv reflect.Value) any {
+	head := reflect.New(rv.Type()).Elem()
+	head.Set(rv)
+	for _, f := range streamFields(rv.Type()) {
+		head.Field(f.index).SetZero()
+	}
+	return head.Interface()
+}
+
+type streamField struct {
+	name  string
+	index int
+}
+
+// streamFields lists the slice fields of a payload struct by JSON name.
+func streamFields(t reflect.Type) []streamField {
+	var out []streamField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() || f.Type.Kind() != reflect.Slice || f.Type.Elem().Kind() == reflect.Uint8 {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out = append(out, streamField{name: name, index: i})
+	}
+	return out
+}
+
+// IsStream reports whether the message with header h carries a stream.
+func IsStream(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == StreamContentType
+}
+
+// AcceptsStream reports whether the request with header h takes a streamed
+// answer.
+func AcceptsStream(h http.Header) bool {
+	return headerLists(h, "Accept", StreamContentType)
+}
+
+// AcceptsGzip reports whether the request with header h takes a
+// gzip-compressed answer.
+func AcceptsGzip(h http.Header) bool {
+	return headerLists(h, "Accept-Encoding", "gzip")
+}
+
+// OpenBody undoes the content encoding of a message body.
+func OpenBody(h http.Header, body io.Reader) (io.ReadCloser, error) {
+	switch enc := strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case "gzip":
+		return gzip.NewReader(body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+}
+
+func headerLists(h http.Header, key, want string) bool {
+	for _, v := range h.Values(key) {
+		for part := range strings.SplitSeq(v, ",") {
+			token, _, _ := strings.Cut(part, ";")
+			if strings.EqualFold(strings.TrimSpace(token), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"opencortex/internal/model"
+)
+
+func TestStreamRoundTripsPayloadLists(t *testing.T) {
+	in := DiffResponse{
+		Need:       []ManifestItem{{EntityType: "knowledge", ID: "k1", Checksum: "a"}},
+		Have:       []ManifestItem{{EntityType: "topics", ID: "t1"}, {EntityType: "messages", ID: "m1"}},
+		Tombstones: []model.Tombstone{{EntityType: "knowledge", EntityID: "k2"}},
+		Cursor:     42,
+	}
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		s := NewStreamWriter(&buf, compress)
+		if err := s.Write(in); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		h := http.Header{}
+		if compress {
+			h.Set("Content-Encoding", "gzip")
+		}
+		body, err := OpenBody(h, &buf)
+		if err != nil {
+			t.Fatalf("OpenBody: %v", err)
+		}
+		var out DiffResponse
+		if _, err := ReadStream(body, &out); err != nil {
+			t.Fatalf("ReadStream: %v", err)
+		}
+		if out.Cursor != 42 || len(out.Need) != 1 || len(out.Have) != 2 || out.Have[1].ID != "m1" || len(out.Tombstones) != 1 {
+			t.Fatalf("compress=%v: round trip lost data: %+v", compress, out)
+		}
+	}
+}
+
+func TestStreamReportsTruncationAndSenderFailure(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStreamWriter(&buf, false)
+	if err := s.Head(PullResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Elem("items", Entity{ManifestItem: ManifestItem{ID: "k1"}}); err != nil {
+		t.Fatal(err)
+	}
+	truncated := buf.String()
+	var out PullResponse
+	if _, err := ReadStream(bytes.NewBufferString(truncated), &out); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected a truncated stream to fail, got %v", err)
+	}
+
+	if err := s.Fail("load failed"); err != nil {
+		t.Fatal(err)
+	}
+	var remote remoteError
+	if _, err := ReadStream(&buf, &PullResponse{}); !errors.As(err, &remote) {
+		t.Fatalf("expected the sender's error, got %v", err)
+	}
+}
@@ -4,95 +4,353 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"opencortex/internal/auth"
+	"opencortex/internal/model"
 )
 
 type Transport struct {
 	Client *http.Client
+	// Retry governs how often a failed request is resent before the sync
+	// gives up on it.
+	Retry RetryPolicy
+	// Stream sends requests as gzip-compressed NDJSON to peers that have
+	// answered in that format, and asks every peer to answer in it.
+	Stream bool
+
+	mu sync.Mutex
+	// streams records the peers, by URL, known to read streamed requests.
+	streams map[string]bool
+	// nodes records the node id each peer, by URL, answered with.
+	nodes map[string]string
+}
+
+// BatchHeader numbers a pushed batch as "n/total". The peer acknowledges
+// the batch by echoing n in its response.
+const BatchHeader = "X-Sync-Batch"
+
+// NodeHeader carries the node id of the sender of a sync request or answer.
+// Peers that send it read version vectors in JSON requests; the others are
+// sent manifest items without them, as their decoders reject unknown fields.
+const NodeHeader = "X-Sync-Node"
+
+// Peer is the far end of a sync request.
+type Peer struct {
+	URL    string
+	APIKey string
+	// PublicKey, when set, is the pinned key the peer's answers must be
+	// signed with.
+	PublicKey string
+	// Identity signs the requests sent to the peer; nil sends them unsigned.
+	Identity *auth.Identity
+}
+
+// syncPaths maps each kind of sync request to the peer endpoint serving it.
+// The kind also names the request and response in their signatures.
+var syncPaths = map[string]string{
+	"diff": "/api/v1/sync/diff",
+	"push": "/api/v1/sync/push/inbound",
+	"pull": "/api/v1/sync/pull/inbound",
 }
 
 func NewTransport() *Transport {
 	return &Transport{
 		Client: &http.Client{Timeout: 30 * time.Second},
+		Retry:  RetryPolicy{Attempts: 4, Base: 500 * time.Millisecond, Max: 8 * time.Second},
+		Stream: true,
 	}
 }
 
+// DiffRequest compares the caller's manifest with the peer's, limited to the
+// same scope and selection on both sides. When Since is set, Items holds
+// only the caller's recent changes and the peer answers with its own changes
+// after that cursor instead of a full comparison.
 type DiffRequest struct {
-	Scope  string         `json:"scope"`
-	Items  []ManifestItem `json:"items"`
-	Remote string         `json:"remote,omitempty"`
+	Scope         string         `json:"scope"`
+	CollectionIDs []string       `json:"collection_ids,omitempty"`
+	TopicIDs      []string       `json:"topic_ids,omitempty"`
+	Items         []ManifestItem `json:"items"`
+	Remote        string         `json:"remote,omitempty"`
+	Since         *int64         `json:"since,omitempty"`
 }
 
+// DiffResponse carries the peer's change cursor at the time of the diff; the
+// caller resumes from it on its next pull. Tombstones lists the peer's
+// deletions in scope, since the request's cursor when one was given.
 type DiffResponse struct {
-	Need []ManifestItem `json:"need"`
-	Have []ManifestItem `json:"have"`
+	Need       []ManifestItem    `json:"need"`
+	Have       []ManifestItem    `json:"have"`
+	Tombstones []model.Tombstone `json:"tombstones,omitempty"`
+	Cursor     int64             `json:"cursor,omitempty"`
 }
 
-func (t *Transport) Diff(ctx context.Context, remoteURL, apiKey string, req DiffRequest) (DiffResponse, error) {
-	endpoint := strings.TrimSuffix(remoteURL, "/") + "/api/v1/sync/diff"
+func (t *Transport) Diff(ctx context.Context, peer Peer, req DiffRequest) (DiffResponse, error) {
 	var res DiffResponse
-	if err := t.do(ctx, endpoint, apiKey, req, &res); err != nil {
+	if err := t.do(ctx, peer, "diff", nil, req, &res); err != nil {
 		return DiffResponse{}, err
 	}
 	return res, nil
 }
 
-func (t *Transport) Push(ctx context.Context, remoteURL, apiKey string, payload map[string]any) (map[string]any, error) {
-	endpoint := strings.TrimSuffix(remoteURL, "/") + "/api/v1/sync/push"
-	out := map[string]any{}
-	if err := t.do(ctx, endpoint, apiKey, payload, &out); err != nil {
-		return nil, err
+// PushRequest carries entities and deletions to a peer. Strategy is the conflict strategy
+// the sender asks for, already expressed from the peer's point of view. It
+// is only advisory: the peer settles conflicts by its own strategy for the
+// sender.
+type PushRequest struct {
+	Remote     string            `json:"remote,omitempty"`
+	Scope      string            `json:"scope"`
+	Strategy   Strategy          `json:"strategy,omitempty"`
+	Items      []Entity          `json:"items"`
+	Tombstones []model.Tombstone `json:"tombstones,omitempty"`
+}
+
+// Push delivers entity bodies to the peer's inbound endpoint, which applies
+// them and reports what it could not apply. A batch numbered n of total is
+// acknowledged when the peer echoes n; peers that predate batching answer
+// without a number and their success stands as the acknowledgement.
+func (t *Transport) Push(ctx context.Context, peer Peer, req PushRequest, n, total int) (ApplyResult, error) {
+	header := http.Header{}
+	if total > 0 {
+		header.Set(BatchHeader, fmt.Sprintf("%d/%d", n, total))
+	}
+	var res ApplyResult
+	if err := t.do(ctx, peer, "push", header, req, &res); err != nil {
+		return ApplyResult{}, err
+	}
+	if total > 0 && res.Batch != 0 && res.Batch != n {
+		return ApplyResult{}, fmt.Errorf("peer acknowledged batch %d, sent %d", res.Batch, n)
 	}
-	return out, nil
+	return res, nil
+}
+
+type PullRequest struct {
+	Remote        string         `json:"remote,omitempty"`
+	Scope         string         `json:"scope"`
+	CollectionIDs []string       `json:"collection_ids,omitempty"`
+	TopicIDs      []string       `json:"topic_ids,omitempty"`
+	Items         []ManifestItem `json:"items"`
 }
 
-func (t *Transport) Pull(ctx context.Context, remoteURL, apiKey string, payload map[string]any) (map[string]any, error) {
-	endpoint := strings.TrimSuffix(remoteURL, "/") + "/api/v1/sync/pull"
-	out := map[string]any{}
-	if err := t.do(ctx, endpoint, apiKey, payload, &out); err != nil {
+type PullResponse struct {
+	Items []Entity `json:"items"`
+}
+
+// Pull asks the peer for the full records behind the requested manifest items.
+func (t *Transport) Pull(ctx context.Context, peer Peer, req PullRequest) ([]Entity, error) {
+	var res PullResponse
+	if err := t.do(ctx, peer, "pull", nil, req, &res); err != nil {
 		return nil, err
 	}
-	return out, nil
+	return res.Items, nil
+}
+
+// do posts reqBody to the peer's endpoint for kind, resending it with
+// backoff while failures look transient. A streamed request the peer rejects
+// as malformed means the peer no longer reads streams; it is resent as plain
+// JSON.
+func (t *Transport) do(ctx context.Context, peer Peer, kind string, header http.Header, reqBody any, out any) error {
+	endpoint := strings.TrimSuffix(peer.URL, "/") + syncPaths[kind]
+	attempts := max(t.Retry.Attempts, 1)
+	for attempt := 1; ; attempt++ {
+		streamed := t.streaming(peer.URL)
+		signed, streamedReply, err := t.send(ctx, peer, endpoint, kind, header, streamed, reqBody, out)
+		var status statusError
+		if streamed && errors.As(err, &status) && (status.Code == http.StatusBadRequest || status.Code == http.StatusUnsupportedMediaType) {
+			t.setStreaming(peer.URL, false)
+			attempt--
+			continue
+		}
+		if err == nil && peer.PublicKey != "" {
+			err = VerifyPayload(peer.PublicKey, kind+" response", signed)
+		}
+		if err == nil && streamedReply && !streamed {
+			t.setStreaming(peer.URL, true)
+		}
+		if err == nil || attempt >= attempts || !retryable(ctx, err) {
+			return err
+		}
+		if err := sleep(ctx, t.Retry.Delay(attempt)); err != nil {
+			return err
+		}
+	}
 }
 
-func (t *Transport) do(ctx context.Context, endpoint, apiKey string, reqBody any, out any) error {
-	b, err := json.Marshal(reqBody)
+// send posts one request and returns what the answer's signature covers and
+// whether the peer answered streamed.
+func (t *Transport) send(ctx context.Context, peer Peer, endpoint, kind string, header http.Header, streamed bool, reqBody any, out any) (Signed, bool, error) {
+	if !streamed && t.PeerNode(peer.URL) == "" {
+		reqBody = withoutVersions(reqBody)
+	}
+	var signature string
+	if peer.Identity != nil && !streamed {
+		var err error
+		if signature, err = SignPayload(*peer.Identity, kind+" request", reqBody); err != nil {
+			return Signed{}, false, err
+		}
+	}
+	body, err := requestBody(reqBody, streamed, peer.Identity, kind+" request")
 	if err != nil {
-		return err
+		return Signed{}, false, err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
+	defer body.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
 	if err != nil {
-		return err
+		return Signed{}, false, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if streamed {
+		req.Header.Set("Content-Type", StreamContentType)
+		req.Header.Set("Content-Encoding", "gzip")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
+	if peer.Identity != nil {
+		req.Header.Set(NodeHeader, peer.Identity.NodeID)
+		req.Header.Set(KeyIDHeader, peer.Identity.KeyID())
+		if signature != "" {
+			req.Header.Set(SignatureHeader, signature)
+		}
+	}
+	if t.Stream {
+		req.Header.Set("Accept", StreamContentType+", application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if peer.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+peer.APIKey)
 	}
 	resp, err := t.Client.Do(req)
 	if err != nil {
-		return err
+		return Signed{}, false, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("remote status %d", resp.StatusCode)
+		return Signed{}, false, statusError{Code: resp.StatusCode}
+	}
+	respBody, err := OpenBody(resp.Header, resp.Body)
+	if err != nil {
+		return Signed{}, false, err
+	}
+	defer respBody.Close()
+	if node := resp.Header.Get(NodeHeader); node != "" {
+		t.setNode(peer.URL, node)
+	}
+	keyID := resp.Header.Get(KeyIDHeader)
+	if IsStream(resp.Header) {
+		signed, err := ReadStream(respBody, out)
+		signed.KeyID = keyID
+		return signed, true, err
 	}
 	var envelope struct {
-		OK    bool `json:"ok"`
-		Data  any  `json:"data"`
-		Error any  `json:"error"`
+		OK    bool            `json:"ok"`
+		Data  json.RawMessage `json:"data"`
+		Error any             `json:"error"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
-		return err
+	if err := json.NewDecoder(respBody).Decode(&envelope); err != nil {
+		return Signed{}, false, err
 	}
 	if !envelope.OK {
-		return fmt.Errorf("remote error: %v", envelope.Error)
+		return Signed{}, false, remoteError{Detail: envelope.Error}
 	}
-	body, err := json.Marshal(envelope.Data)
-	if err != nil {
-		return err
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return Signed{}, false, err
+	}
+	signed, err := JSONSigned(keyID, resp.Header.Get(SignatureHeader), out)
+	return signed, false, err
+}
+
+// requestBody encodes a request as JSON or, streamed, as gzip-compressed
+// NDJSON written while the request is sent rather than buffered first and
+// signed by id when given.
+func requestBody(v any, streamed bool, id *auth.Identity, purpose string) (io.ReadCloser, error) {
+	if !streamed {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		s := NewStreamWriter(pw, true)
+		if id != nil {
+			s.Sign(*id, purpose)
+		}
+		err := s.Write(v)
+		if err == nil {
+			err = s.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+func (t *Transport) streaming(remoteURL string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Stream && t.streams[remoteURL]
+}
+
+func (t *Transport) setStreaming(remoteURL string, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.streams == nil {
+		t.streams = map[string]bool{}
+	}
+	t.streams[remoteURL] = ok
+}
+
+// PeerNode returns the node id the peer at remoteURL last answered with,
+// empty until it has answered with one.
+func (t *Transport) PeerNode(remoteURL string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.nodes[remoteURL]
+}
+
+func (t *Transport) setNode(remoteURL, node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.nodes == nil {
+		t.nodes = map[string]string{}
+	}
+	t.nodes[remoteURL] = node
+}
+
+// withoutVersions returns a copy of a request with the origin and version
+// vector of its items left out, for peers that predate them.
+func withoutVersions(v any) any {
+	strip := func(items []ManifestItem) []ManifestItem {
+		out := make([]ManifestItem, len(items))
+		for i, it := range items {
+			it.Origin, it.Vector = "", nil
+			out[i] = it
+		}
+		return out
+	}
+	switch req := v.(type) {
+	case DiffRequest:
+		req.Items = strip(req.Items)
+		return req
+	case PullRequest:
+		req.Items = strip(req.Items)
+		return req
+	case PushRequest:
+		items := make([]Entity, len(req.Items))
+		for i, ent := range req.Items {
+			ent.Origin, ent.Vector = "", nil
+			items[i] = ent
+		}
+		req.Items = items
+		return req
 	}
-	return json.Unmarshal(body, out)
+	return v
 }
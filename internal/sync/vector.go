@@ -0,0 +1,269 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"opencortex/internal/model"
+)
+
+// VersionVector counts, per node id, the latest change of that node an
+// entity version includes. A node's counter is its change sequence at the
+// time of the change. Comparing the vectors of two copies tells whether one
+// already includes the other, so a version that went around a mesh of peers
+// is recognised as seen instead of bouncing back as a conflict.
+type VersionVector map[string]int64
+
+// Order is how one version relates to another.
+type Order int
+
+const (
+	// Concurrent versions each hold changes the other lacks, or cannot be
+	// compared because a side carries no vector.
+	Concurrent Order = iota
+	Equal
+	// Before means the version is included in the other.
+	Before
+	// After means the version includes the other.
+	After
+)
+
+// Compare orders v against o.
+func (v VersionVector) Compare(o VersionVector) Order {
+	older, newer := false, false
+	for node, n := range v {
+		switch m := o[node]; {
+		case n > m:
+			newer = true
+		case n < m:
+			older = true
+		}
+	}
+	for node, m := range o {
+		if _, ok := v[node]; !ok && m > 0 {
+			older = true
+		}
+	}
+	switch {
+	case older && newer:
+		return Concurrent
+	case older:
+		return Before
+	case newer:
+		return After
+	default:
+		return Equal
+	}
+}
+
+// Merge returns the vector holding the changes of both.
+func (v VersionVector) Merge(o VersionVector) VersionVector {
+	out := make(VersionVector, max(len(v), len(o)))
+	for node, n := range v {
+		out[node] = n
+	}
+	for node, n := range o {
+		out[node] = max(out[node], n)
+	}
+	return out
+}
+
+// versionOrder orders a copy against another. Copies without a vector, from
+// peers that predate them, count as concurrent so their checksums decide.
+func versionOrder(a, b ManifestItem) Order {
+	if len(a.Vector) == 0 || len(b.Vector) == 0 {
+		return Concurrent
+	}
+	return a.Vector.Compare(b.Vector)
+}
+
+// descends reports whether copy a is known to include copy b.
+func descends(a, b ManifestItem) bool {
+	o := versionOrder(a, b)
+	return o == After || o == Equal
+}
+
+// ClockOf returns the highest counter per node across the items' vectors:
+// what a node holding all of them has seen.
+func ClockOf(items []ManifestItem) VersionVector {
+	clock := VersionVector{}
+	for _, it := range items {
+		for node, n := range it.Vector {
+			clock[node] = max(clock[node], n)
+		}
+	}
+	return clock
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// localVersion is the version of an entity as this node holds it.
+type localVersion struct {
+	Origin string
+	Vector VersionVector
+}
+
+// entityVersions returns the current versions of the given entities of one
+// type that exist here. A local change after the stored vector adds this
+// node's counter; entities never synced start from that alone.
+func entityVersions(ctx context.Context, q queryer, self, entityType string, ids []string) (map[string]localVersion, error) {
+	out := make(map[string]localVersion, len(ids))
+	for start := 0; start < len(ids); start += lookupBatch {
+		batch := ids[start:min(start+lookupBatch, len(ids))]
+		rows, err := q.QueryContext(ctx, `
+SELECT c.entity_id, c.seq, COALESCE(v.origin_node, ''), COALESCE(v.vector, '{}'), COALESCE(v.seq, 0)
+FROM sync_changes c
+LEFT JOIN sync_versions v ON v.entity_type = c.entity_type AND v.entity_id = c.entity_id
+WHERE c.entity_type = ? AND c.op = 'upsert' AND c.entity_id IN (`+placeholders(len(batch))+`)`,
+			append([]any{entityType}, stringArgs(batch)...)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				id, origin, raw  string
+				changeSeq, known int64
+			)
+			if err := rows.Scan(&id, &changeSeq, &origin, &raw, &known); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			vector := VersionVector{}
+			if err := json.Unmarshal([]byte(raw), &vector); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("version vector of %s %s: %w", entityType, id, err)
+			}
+			if changeSeq > known {
+				vector[self] = changeSeq
+			}
+			if origin == "" {
+				origin = self
+			}
+			out[id] = localVersion{Origin: origin, Vector: vector}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// attachVersions stamps each item with the local version of its entity.
+func attachVersions(ctx context.Context, q queryer, self string, items []ManifestItem) error {
+	ids := map[string][]string{}
+	for _, it := range items {
+		ids[it.EntityType] = append(ids[it.EntityType], it.ID)
+	}
+	versions := make(map[string]map[string]localVersion, len(ids))
+	for entityType, all := range ids {
+		v, err := entityVersions(ctx, q, self, entityType, all)
+		if err != nil {
+			return err
+		}
+		versions[entityType] = v
+	}
+	for i := range items {
+		if v, ok := versions[items[i].EntityType][items[i].ID]; ok {
+			items[i].Origin, items[i].Vector = v.Origin, v.Vector
+		}
+	}
+	return nil
+}
+
+// nodeID returns this node's id as stamped into version vectors.
+func nodeID(ctx context.Context, db *sql.DB) (string, error) {
+	var id string
+	err := db.QueryRowContext(ctx, "SELECT node_id FROM sync_node WHERE id = 1").Scan(&id)
+	return id, err
+}
+
+// localVersionTx returns the version of the local copy of ent, if any.
+func localVersionTx(ctx context.Context, tx *sql.Tx, self string, ent Entity) (localVersion, bool, error) {
+	versions, err := entityVersions(ctx, tx, self, ent.EntityType, []string{ent.ID})
+	if err != nil {
+		return localVersion{}, false, err
+	}
+	v, ok := versions[ent.ID]
+	return v, ok, nil
+}
+
+// recordVersionTx stores the version of an entity after an incoming copy was
+// applied or settled: the local version merged with the incoming one. It is
+// stored against the entity's latest change so the write is not taken for a
+// local edit.
+func (e *Engine) recordVersionTx(ctx context.Context, tx *sql.Tx, self string, ent Entity, local localVersion, found bool) error {
+	origin := local.Origin
+	if !found {
+		origin = ent.Origin
+	}
+	if origin == "" {
+		origin = self
+	}
+	seq, err := e.Store.ChangeSeqTx(ctx, tx, ent.EntityType, ent.ID)
+	if err != nil {
+		return err
+	}
+	return e.Store.SetEntityVersionTx(ctx, tx, ent.EntityType, ent.ID, origin, local.Vector.Merge(ent.Vector), seq)
+}
+
+// includeVersion records that the local copy of ent, just rewritten here,
+// includes the version of remote. The rewrite itself counts as a local edit.
+func (e *Engine) includeVersion(ctx context.Context, ent ManifestItem, remote VersionVector) error {
+	self, err := e.Store.NodeID(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	local, found, err := localVersionTx(ctx, tx, self, Entity{ManifestItem: ent})
+	if err != nil {
+		return err
+	}
+	origin := local.Origin
+	if !found || origin == "" {
+		origin = self
+	}
+	if err := e.Store.SetEntityVersionTx(ctx, tx, ent.EntityType, ent.ID, origin, local.Vector.Merge(remote), 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Clock returns what this node has seen: the highest counter per node across
+// the versions it holds, with its own change cursor as its own counter.
+func (e *Engine) Clock(ctx context.Context) (string, VersionVector, error) {
+	self, err := e.Store.NodeID(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	known, err := e.Store.KnownClock(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	cursor, err := ChangeCursor(ctx, e.DB)
+	if err != nil {
+		return "", nil, err
+	}
+	clock := VersionVector(known)
+	clock[self] = max(clock[self], cursor)
+	return self, clock, nil
+}
+
+// notePeer records what the node behind a remote is known to hold, once it
+// has told us its id.
+func (e *Engine) notePeer(ctx context.Context, manifest model.SyncManifest, peer Peer, items []ManifestItem) {
+	node := e.Transport.PeerNode(peer.URL)
+	if node == "" {
+		return
+	}
+	_ = e.Store.NotePeer(ctx, node, manifest.RemoteName, ClockOf(items))
+}
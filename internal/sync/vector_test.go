@@ -0,0 +1,49 @@
+package syncer
+
+import "testing"
+
+func TestVersionVectorCompare(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b VersionVector
+		want Order
+	}{
+		{"equal", VersionVector{"a": 3, "b": 1}, VersionVector{"a": 3, "b": 1}, Equal},
+		{"behind on one node", VersionVector{"a": 2}, VersionVector{"a": 3}, Before},
+		{"missing a node", VersionVector{"a": 3}, VersionVector{"a": 3, "c": 1}, Before},
+		{"ahead", VersionVector{"a": 3, "c": 1}, VersionVector{"a": 3}, After},
+		{"concurrent", VersionVector{"a": 4, "b": 1}, VersionVector{"a": 3, "b": 2}, Concurrent},
+		{"zero counters", VersionVector{"a": 1, "b": 0}, VersionVector{"a": 1}, Equal},
+	}
+	for _, tc := range cases {
+		if got := tc.a.Compare(tc.b); got != tc.want {
+			t.Errorf("%s: Compare = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestVersionVectorMergeIncludesBoth(t *testing.T) {
+	a := VersionVector{"a": 4, "b": 1}
+	b := VersionVector{"a": 3, "b": 2, "c": 5}
+	m := a.Merge(b)
+	if m.Compare(a) != After || m.Compare(b) != After {
+		t.Fatalf("merge %v should include both inputs", m)
+	}
+	if m["a"] != 4 || m["b"] != 2 || m["c"] != 5 {
+		t.Fatalf("unexpected merge %v", m)
+	}
+	if a["b"] != 1 {
+		t.Fatalf("merge must not modify its receiver, got %v", a)
+	}
+}
+
+func TestDiffOrderSkipsVersionsWithoutVectors(t *testing.T) {
+	newer := ManifestItem{Vector: VersionVector{"a": 2}}
+	legacy := ManifestItem{}
+	if versionOrder(newer, legacy) != Concurrent || descends(newer, legacy) {
+		t.Fatalf("an item without a vector must compare as concurrent")
+	}
+	if !descends(newer, ManifestItem{Vector: VersionVector{"a": 1}}) {
+		t.Fatalf("expected the newer item to include the older one")
+	}
+}